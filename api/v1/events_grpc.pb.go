@@ -0,0 +1,177 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             (unknown)
+// source: api/v1/events.proto
+
+package eventtailerv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// EventTailerClient is the client API for EventTailer service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type EventTailerClient interface {
+	// Subscribe streams the events processed from now on which match the
+	// filter. Clients that cannot keep up miss events.
+	Subscribe(ctx context.Context, in *EventFilter, opts ...grpc.CallOption) (EventTailer_SubscribeClient, error)
+	// ListRecent returns the most recent events which match the filter, the
+	// oldest first.
+	ListRecent(ctx context.Context, in *ListRecentRequest, opts ...grpc.CallOption) (*ListRecentResponse, error)
+}
+
+type eventTailerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewEventTailerClient(cc grpc.ClientConnInterface) EventTailerClient {
+	return &eventTailerClient{cc}
+}
+
+func (c *eventTailerClient) Subscribe(ctx context.Context, in *EventFilter, opts ...grpc.CallOption) (EventTailer_SubscribeClient, error) {
+	stream, err := c.cc.NewStream(ctx, &EventTailer_ServiceDesc.Streams[0], "/eventtailer.v1.EventTailer/Subscribe", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &eventTailerSubscribeClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type EventTailer_SubscribeClient interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+type eventTailerSubscribeClient struct {
+	grpc.ClientStream
+}
+
+func (x *eventTailerSubscribeClient) Recv() (*Event, error) {
+	m := new(Event)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *eventTailerClient) ListRecent(ctx context.Context, in *ListRecentRequest, opts ...grpc.CallOption) (*ListRecentResponse, error) {
+	out := new(ListRecentResponse)
+	err := c.cc.Invoke(ctx, "/eventtailer.v1.EventTailer/ListRecent", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// EventTailerServer is the server API for EventTailer service.
+// All implementations must embed UnimplementedEventTailerServer
+// for forward compatibility
+type EventTailerServer interface {
+	// Subscribe streams the events processed from now on which match the
+	// filter. Clients that cannot keep up miss events.
+	Subscribe(*EventFilter, EventTailer_SubscribeServer) error
+	// ListRecent returns the most recent events which match the filter, the
+	// oldest first.
+	ListRecent(context.Context, *ListRecentRequest) (*ListRecentResponse, error)
+	mustEmbedUnimplementedEventTailerServer()
+}
+
+// UnimplementedEventTailerServer must be embedded to have forward compatible implementations.
+type UnimplementedEventTailerServer struct {
+}
+
+func (UnimplementedEventTailerServer) Subscribe(*EventFilter, EventTailer_SubscribeServer) error {
+	return status.Errorf(codes.Unimplemented, "method Subscribe not implemented")
+}
+func (UnimplementedEventTailerServer) ListRecent(context.Context, *ListRecentRequest) (*ListRecentResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListRecent not implemented")
+}
+func (UnimplementedEventTailerServer) mustEmbedUnimplementedEventTailerServer() {}
+
+// UnsafeEventTailerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to EventTailerServer will
+// result in compilation errors.
+type UnsafeEventTailerServer interface {
+	mustEmbedUnimplementedEventTailerServer()
+}
+
+func RegisterEventTailerServer(s grpc.ServiceRegistrar, srv EventTailerServer) {
+	s.RegisterService(&EventTailer_ServiceDesc, srv)
+}
+
+func _EventTailer_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(EventFilter)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(EventTailerServer).Subscribe(m, &eventTailerSubscribeServer{stream})
+}
+
+type EventTailer_SubscribeServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+type eventTailerSubscribeServer struct {
+	grpc.ServerStream
+}
+
+func (x *eventTailerSubscribeServer) Send(m *Event) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _EventTailer_ListRecent_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRecentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EventTailerServer).ListRecent(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/eventtailer.v1.EventTailer/ListRecent",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EventTailerServer).ListRecent(ctx, req.(*ListRecentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// EventTailer_ServiceDesc is the grpc.ServiceDesc for EventTailer service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var EventTailer_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "eventtailer.v1.EventTailer",
+	HandlerType: (*EventTailerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListRecent",
+			Handler:    _EventTailer_ListRecent_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Subscribe",
+			Handler:       _EventTailer_Subscribe_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "api/v1/events.proto",
+}
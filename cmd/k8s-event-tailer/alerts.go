@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var (
+	alertsFiringGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "alerts_firing",
+		Help: "Number of firing alerts by rule",
+	}, []string{"rule"})
+
+	alertNotifyErrorsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "alert_notify_errors_total",
+		Help: "Number of failed alert notifications by notifier and error class",
+	}, []string{"notifier", "class"})
+)
+
+// alert is a rule firing for a single involved object
+type alert struct {
+	rule      string
+	namespace string
+	kind      string
+	name      string
+	// event is the latest matching event
+	event *corev1.Event
+	// logs are the pod logs captured for the latest event which had any
+	logs string
+	// snapshot describes the involved object at the latest event which had
+	// a snapshot taken
+	snapshot string
+	// count is the number of matching events since the alert fired
+	count    int
+	started  time.Time
+	lastSeen time.Time
+}
+
+// Key identifies the alert across notifications, e.g. for deduplication
+func (a *alert) Key() string {
+	return fmt.Sprintf("%s/%s/%s/%s", a.rule, a.namespace, a.kind, a.name)
+}
+
+// alertNotifier is told when alerts start and stop firing
+type alertNotifier interface {
+	Name() string
+	Fire(ctx context.Context, a *alert) error
+	Resolve(ctx context.Context, a *alert) error
+}
+
+// alertManager fires an alert per rule and involved object on the first
+// matching event and resolves it once no matching event was seen for
+// resolveAfter.
+type alertManager struct {
+	rules        []*eventRule
+	notifiers    []alertNotifier
+	resolveAfter time.Duration
+	logger       zerolog.Logger
+
+	mu     sync.Mutex
+	alerts map[string]*alert
+	// notified holds the notifiers which know about a firing alert, so
+	// failed notifications are retried on the next matching event
+	notified map[string]map[string]bool
+}
+
+func newAlertManager(rules []*eventRule, notifiers []alertNotifier, resolveAfter time.Duration) *alertManager {
+	return &alertManager{
+		rules:        rules,
+		notifiers:    notifiers,
+		resolveAfter: resolveAfter,
+		logger:       log.With().Str("component", "alerts").Logger(),
+		alerts:       map[string]*alert{},
+		notified:     map[string]map[string]bool{},
+	}
+}
+
+// Subscribe evaluates the rules for every published event and resolves
+// alerts until ctx is done.
+func (m *alertManager) Subscribe(ctx context.Context, bus *eventBus) {
+	bus.Subscribe("alerts", subscriberOptions{}, func(record eventRecord) {
+		m.handle(ctx, record)
+	})
+	go m.runResolver(ctx)
+}
+
+func (m *alertManager) handle(ctx context.Context, record eventRecord) {
+	event := record.event
+	for _, rule := range m.rules {
+		if !rule.Matches(event) {
+			continue
+		}
+		a := &alert{
+			rule:      rule.name,
+			namespace: event.InvolvedObject.Namespace,
+			kind:      event.InvolvedObject.Kind,
+			name:      event.InvolvedObject.Name,
+		}
+		key := a.Key()
+
+		m.mu.Lock()
+		if existing, ok := m.alerts[key]; ok {
+			a = existing
+		} else {
+			a.started = time.Now()
+			m.alerts[key] = a
+			m.notified[key] = map[string]bool{}
+			alertsFiringGauge.WithLabelValues(rule.name).Inc()
+			m.logger.Info().Str("alert", key).Msg("Alert firing")
+		}
+		a.event = event
+		if record.logs != "" {
+			a.logs = record.logs
+		}
+		if record.snapshot != "" {
+			a.snapshot = record.snapshot
+		}
+		a.count++
+		a.lastSeen = time.Now()
+		snapshot := *a
+		m.mu.Unlock()
+
+		for _, notifier := range m.notifiers {
+			if m.isNotified(key, notifier) {
+				continue
+			}
+			if err := notifier.Fire(ctx, &snapshot); err != nil {
+				m.notifyFailed(notifier, key, err)
+				continue
+			}
+			m.setNotified(key, notifier)
+		}
+	}
+}
+
+func (m *alertManager) isNotified(key string, notifier alertNotifier) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.notified[key][notifier.Name()]
+}
+
+func (m *alertManager) setNotified(key string, notifier alertNotifier) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if notified, ok := m.notified[key]; ok {
+		notified[notifier.Name()] = true
+	}
+}
+
+func (m *alertManager) notifyFailed(notifier alertNotifier, key string, err error) {
+	class := classifyError(err)
+	alertNotifyErrorsCounter.WithLabelValues(notifier.Name(), class).Inc()
+	errorReports.CaptureError("alert/"+notifier.Name()+"/"+class, err, map[string]string{"notifier": notifier.Name(), "class": class})
+	m.logger.Error().Err(err).Str("notifier", notifier.Name()).Str("alert", key).Str("class", class).Msg("Could not notify alert")
+}
+
+// runResolver resolves alerts without matching events for resolveAfter
+func (m *alertManager) runResolver(ctx context.Context) {
+	interval := m.resolveAfter / 10
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		m.mu.Lock()
+		var resolved []*alert
+		var notified []map[string]bool
+		for key, a := range m.alerts {
+			if time.Since(a.lastSeen) >= m.resolveAfter {
+				resolved = append(resolved, a)
+				notified = append(notified, m.notified[key])
+				delete(m.alerts, key)
+				delete(m.notified, key)
+				alertsFiringGauge.WithLabelValues(a.rule).Dec()
+			}
+		}
+		m.mu.Unlock()
+
+		for i, a := range resolved {
+			m.logger.Info().Str("alert", a.Key()).Msg("Alert resolved")
+			for _, notifier := range m.notifiers {
+				if !notified[i][notifier.Name()] {
+					continue
+				}
+				if err := notifier.Resolve(ctx, a); err != nil {
+					m.notifyFailed(notifier, a.Key(), err)
+				}
+			}
+		}
+	}
+}
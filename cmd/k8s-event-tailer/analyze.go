@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	defaultAnalyzeTop     = 10
+	analyzeListPageSize   = 500
+	analyzeRequestTimeout = 5 * time.Minute
+)
+
+// eventFrequency counts the occurrences of events sharing a key
+type eventFrequency struct {
+	key      string
+	events   int
+	count    int64
+	warnings int64
+	last     time.Time
+}
+
+// eventFrequencies aggregates events by a key
+type eventFrequencies map[string]*eventFrequency
+
+func (f eventFrequencies) add(key string, event *corev1.Event) {
+	freq, ok := f[key]
+	if !ok {
+		freq = &eventFrequency{key: key}
+		f[key] = freq
+	}
+	count := int64(event.Count)
+	if count < 1 {
+		count = 1
+	}
+	freq.events++
+	freq.count += count
+	if event.Type == corev1.EventTypeWarning {
+		freq.warnings += count
+	}
+	if t := eventTime(event); t.After(freq.last) {
+		freq.last = t
+	}
+}
+
+// top returns the n keys with the most occurrences
+func (f eventFrequencies) top(n int) []*eventFrequency {
+	sorted := make([]*eventFrequency, 0, len(f))
+	for _, freq := range f {
+		sorted = append(sorted, freq)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].count != sorted[j].count {
+			return sorted[i].count > sorted[j].count
+		}
+		return sorted[i].key < sorted[j].key
+	})
+	if n > 0 && len(sorted) > n {
+		sorted = sorted[:n]
+	}
+	return sorted
+}
+
+// analyze lists the stored events once and prints how often they occurred
+// by reason, involved object and namespace
+func analyze() {
+	clientset := getKubeClient()
+	ctx, cancel := context.WithTimeout(context.Background(), analyzeRequestTimeout)
+	defer cancel()
+
+	var events []corev1.Event
+	for _, namespace := range watchedNamespaces() {
+		list, err := listEvents(ctx, clientset, namespace)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Could not list events")
+		}
+		events = append(events, list...)
+	}
+
+	since := time.Now().Add(-*analyzeSince)
+	reasons, objects, namespaces := eventFrequencies{}, eventFrequencies{}, eventFrequencies{}
+	total := 0
+	for i := range events {
+		event := &events[i]
+		if *analyzeSince > 0 && eventTime(event).Before(since) {
+			continue
+		}
+		total++
+		reasons.add(event.Type+" "+event.Reason, event)
+		objects.add(eventObject(event), event)
+		namespaces.add(event.Namespace, event)
+	}
+
+	out := os.Stdout
+	fmt.Fprintf(out, "%d of %d stored events", total, len(events))
+	if *analyzeSince > 0 {
+		fmt.Fprintf(out, " seen in the last %s", *analyzeSince)
+	}
+	fmt.Fprintln(out)
+	for _, table := range []struct {
+		heading string
+		freqs   eventFrequencies
+	}{
+		{"REASON", reasons},
+		{"OBJECT", objects},
+		{"NAMESPACE", namespaces},
+	} {
+		fmt.Fprintln(out)
+		printFrequencies(out, table.heading, table.freqs.top(*analyzeTop))
+	}
+}
+
+func printFrequencies(out io.Writer, heading string, freqs []*eventFrequency) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "%s\tCOUNT\tWARNINGS\tEVENTS\tLAST SEEN\n", heading)
+	for _, freq := range freqs {
+		key := freq.key
+		if key == "" {
+			key = "(cluster)"
+		}
+		lastSeen := "-"
+		if !freq.last.IsZero() {
+			lastSeen = time.Since(freq.last).Round(time.Second).String() + " ago"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%s\n", key, freq.count, freq.warnings, freq.events, lastSeen)
+	}
+	w.Flush()
+}
+
+// listEvents lists all events of namespace page by page
+func listEvents(ctx context.Context, clientset kubernetes.Interface, namespace string) ([]corev1.Event, error) {
+	var events []corev1.Event
+	opts := metav1.ListOptions{Limit: analyzeListPageSize}
+	for {
+		list, err := clientset.CoreV1().Events(namespace).List(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, list.Items...)
+		if list.Continue == "" {
+			return events, nil
+		}
+		opts.Continue = list.Continue
+	}
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	bolt "go.etcd.io/bbolt"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	archiveFile          = "events.db"
+	archiveFlushInterval = time.Second
+	archivePruneInterval = time.Hour
+	// archivePruneBatch bounds the events deleted in one transaction, which
+	// holds up writing new events
+	archivePruneBatch = 10000
+
+	defaultArchiveRetention = "168h"
+)
+
+// Buckets of the archive database
+var (
+	// archiveEventsBucket holds the JSON events by archiveKey, which makes
+	// it the index of the event time
+	archiveEventsBucket = []byte("events")
+	// the namespace and reason indexes have the value, a zero byte and the
+	// archiveKey of each event as keys, without values
+	archiveNamespacesBucket = []byte("namespaces")
+	archiveReasonsBucket    = []byte("reasons")
+)
+
+// eventArchive persists the published events in a BoltDB database, so that
+// they survive restarts and the short TTL of events in the API server, and
+// serves them on /api/v1/archive. Events are stored in the order of their
+// time and indexed by namespace and reason, so that queries only read the
+// matching events. Events are deleted after the retention time.
+//
+// With a cipher, the events are encrypted and the indexes hold keyed hashes
+// of the namespaces and reasons.
+type eventArchive struct {
+	db        *bolt.DB
+	retention time.Duration
+	cipher    *archiveCipher
+	logger    zerolog.Logger
+
+	mu sync.Mutex
+	// pending are the events not written yet, they are written together
+	// every second since every transaction syncs the database file
+	pending []*corev1.Event
+	closed  bool
+}
+
+// archiveList is a page of archived events, the oldest first
+type archiveList struct {
+	Items []jsonEvent `json:"items"`
+	// Continue is passed as continue to get the next page, empty on the
+	// last page
+	Continue string `json:"continue,omitempty"`
+}
+
+func newEventArchive(dir string, retention time.Duration, cipher *archiveCipher) (*eventArchive, error) {
+	if retention <= 0 {
+		return nil, fmt.Errorf("archive retention must be positive")
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	// the database is locked while open, another tailer using it must not
+	// block the start forever
+	db, err := bolt.Open(filepath.Join(dir, archiveFile), 0o640, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", filepath.Join(dir, archiveFile), err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{archiveEventsBucket, archiveNamespacesBucket, archiveReasonsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &eventArchive{
+		db:        db,
+		retention: retention,
+		cipher:    cipher,
+		logger:    log.With().Str("component", "archive").Logger(),
+	}, nil
+}
+
+// Subscribe archives the events published on bus. Events are not dropped
+// when the disk is slow, the database is closed once the bus is.
+func (a *eventArchive) Subscribe(bus *eventBus) {
+	bus.Subscribe("archive", subscriberOptions{onClose: a.close}, func(record eventRecord) {
+		if err := a.add(record.event); err != nil {
+			a.logger.Error().Err(err).Msg("Could not archive event")
+		}
+	})
+}
+
+// Run writes the archived events every second and deletes expired events
+// every hour until ctx is done.
+func (a *eventArchive) Run(ctx context.Context) {
+	a.logger.Info().Str("file", a.db.Path()).Dur("retention", a.retention).Bool("encrypted", a.cipher != nil).Msg("Archiving events")
+	a.prune(time.Now())
+	flush := time.NewTicker(archiveFlushInterval)
+	defer flush.Stop()
+	prune := time.NewTicker(archivePruneInterval)
+	defer prune.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-flush.C:
+			if err := a.flush(); err != nil {
+				a.logger.Error().Err(err).Msg("Could not write archive")
+			}
+		case now := <-prune.C:
+			a.prune(now)
+		}
+	}
+}
+
+func (a *eventArchive) add(event *corev1.Event) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return errors.New("archive closed")
+	}
+	a.pending = append(a.pending, event)
+	return nil
+}
+
+// flush writes the pending events in one transaction
+func (a *eventArchive) flush() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.writePending()
+}
+
+func (a *eventArchive) writePending() error {
+	if len(a.pending) == 0 || a.closed {
+		return nil
+	}
+	events := a.pending
+	a.pending = nil
+	return a.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(archiveEventsBucket)
+		for _, event := range events {
+			data, err := a.encode(event)
+			if err != nil {
+				return err
+			}
+			seq, err := bucket.NextSequence()
+			if err != nil {
+				return err
+			}
+			key := archiveKey(eventTime(event), seq)
+			if err := bucket.Put(key, data); err != nil {
+				return err
+			}
+			if err := tx.Bucket(archiveNamespacesBucket).Put(a.indexKey(event.Namespace, key), []byte{}); err != nil {
+				return err
+			}
+			if err := tx.Bucket(archiveReasonsBucket).Put(a.indexKey(event.Reason, key), []byte{}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (a *eventArchive) close() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.writePending(); err != nil {
+		a.logger.Error().Err(err).Msg("Could not write archive")
+	}
+	a.closed = true
+	if err := a.db.Close(); err != nil {
+		a.logger.Error().Err(err).Msg("Could not close archive")
+	}
+}
+
+func (a *eventArchive) encode(event *corev1.Event) ([]byte, error) {
+	data, err := json.Marshal(event)
+	if err != nil || a.cipher == nil {
+		return data, err
+	}
+	return a.cipher.Seal(data)
+}
+
+// decode reads encrypted events and, as the key may be configured later,
+// plain ones
+func (a *eventArchive) decode(data []byte) (*corev1.Event, error) {
+	if sealed(data) {
+		if a.cipher == nil {
+			return nil, errors.New("event is encrypted, --archive-encryption-key-file is not set")
+		}
+		var err error
+		if data, err = a.cipher.Open(data); err != nil {
+			return nil, err
+		}
+	}
+	event := &corev1.Event{}
+	if err := json.Unmarshal(data, event); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+// archiveKey returns the key of an event at t, unix nanoseconds followed by
+// seq, big-endian so that keys sort by time
+func archiveKey(t time.Time, seq uint64) []byte {
+	key := make([]byte, 16)
+	if nanos := t.UnixNano(); nanos > 0 {
+		binary.BigEndian.PutUint64(key, uint64(nanos))
+	}
+	binary.BigEndian.PutUint64(key[8:], seq)
+	return key
+}
+
+// indexKey returns the key of the index entry of value for the event key
+func (a *eventArchive) indexKey(value string, key []byte) []byte {
+	if a.cipher != nil {
+		value = string(a.cipher.Hash(value))
+	}
+	indexKey := make([]byte, 0, len(value)+1+len(key))
+	indexKey = append(indexKey, value...)
+	indexKey = append(indexKey, 0)
+	return append(indexKey, key...)
+}
+
+// prune deletes the events older than the retention time, in batches so
+// that writing is not held up for long
+func (a *eventArchive) prune(now time.Time) {
+	cutoff := archiveKey(now.Add(-a.retention), 0)
+	pruned := 0
+	for {
+		var n int
+		err := a.db.Update(func(tx *bolt.Tx) error {
+			bucket := tx.Bucket(archiveEventsBucket)
+			var keys, values [][]byte
+			c := bucket.Cursor()
+			for k, v := c.First(); k != nil && bytes.Compare(k, cutoff) < 0 && len(keys) < archivePruneBatch; k, v = c.Next() {
+				// keys and values must not be used once the bucket changes
+				keys = append(keys, append([]byte(nil), k...))
+				values = append(values, append([]byte(nil), v...))
+			}
+			for i, key := range keys {
+				if event, err := a.decode(values[i]); err == nil {
+					if err := tx.Bucket(archiveNamespacesBucket).Delete(a.indexKey(event.Namespace, key)); err != nil {
+						return err
+					}
+					if err := tx.Bucket(archiveReasonsBucket).Delete(a.indexKey(event.Reason, key)); err != nil {
+						return err
+					}
+				}
+				if err := bucket.Delete(key); err != nil {
+					return err
+				}
+			}
+			n = len(keys)
+			return nil
+		})
+		if err != nil {
+			a.logger.Error().Err(err).Msg("Could not delete expired events")
+			return
+		}
+		pruned += n
+		if n < archivePruneBatch {
+			break
+		}
+	}
+	if pruned > 0 {
+		a.logger.Debug().Int("events", pruned).Msg("Deleted expired events")
+	}
+}
+
+// scan calls fn with the archived events from key start on, the oldest
+// first, until fn returns false. With namespaces or reasons in filter, only
+// the events of their index are read.
+func (a *eventArchive) scan(tx *bolt.Tx, filter streamFilter, start []byte, fn func(key []byte, event *corev1.Event) bool) error {
+	events := tx.Bucket(archiveEventsBucket)
+	visit := func(key, data []byte) bool {
+		event, err := a.decode(data)
+		if err != nil {
+			a.logger.Warn().Err(err).Msg("Skipping invalid archived event")
+			return true
+		}
+		return fn(key, event)
+	}
+
+	index, values := tx.Bucket(archiveNamespacesBucket), filter.namespaces
+	if len(values) == 0 {
+		index, values = tx.Bucket(archiveReasonsBucket), filter.reasons
+	}
+	if len(values) == 0 {
+		c := events.Cursor()
+		for k, v := c.Seek(start); k != nil; k, v = c.Next() {
+			if !visit(k, v) {
+				break
+			}
+		}
+		return nil
+	}
+
+	// merge the index entries of the values in the order of their keys
+	cursors := make([]*archiveIndexCursor, 0, len(values))
+	for value := range values {
+		c := &archiveIndexCursor{cursor: index.Cursor(), prefix: a.indexKey(value, nil)}
+		c.seek(start)
+		cursors = append(cursors, c)
+	}
+	for {
+		var next *archiveIndexCursor
+		for _, c := range cursors {
+			if c.key != nil && (next == nil || bytes.Compare(c.key, next.key) < 0) {
+				next = c
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		if data := events.Get(next.key); data != nil && !visit(next.key, data) {
+			return nil
+		}
+		next.next()
+	}
+}
+
+// archiveIndexCursor iterates over the archive keys of one indexed value
+type archiveIndexCursor struct {
+	cursor *bolt.Cursor
+	prefix []byte
+	// key is the current archive key, nil at the end
+	key []byte
+}
+
+func (c *archiveIndexCursor) seek(start []byte) {
+	k, _ := c.cursor.Seek(append(c.prefix[:len(c.prefix):len(c.prefix)], start...))
+	c.set(k)
+}
+
+func (c *archiveIndexCursor) next() {
+	k, _ := c.cursor.Next()
+	c.set(k)
+}
+
+func (c *archiveIndexCursor) set(k []byte) {
+	c.key = nil
+	if bytes.HasPrefix(k, c.prefix) {
+		c.key = k[len(c.prefix):]
+	}
+}
+
+// handler serves the archived events matching the query parameters of
+// parseEventQuery and until, a duration or RFC 3339 time, the oldest first
+// in pages of limit events. The continue value of a page is passed as
+// continue to get the next one.
+func (a *eventArchive) handler(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	now := time.Now()
+	query, err := parseEventQuery(params, now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var until time.Time
+	if value := params.Get("until"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil {
+			until = now.Add(-d)
+		} else if until, err = time.Parse(time.RFC3339, value); err != nil {
+			http.Error(w, "until must be a duration or an RFC 3339 time", http.StatusBadRequest)
+			return
+		}
+	}
+	limit := defaultStoreListLimit
+	if value := params.Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > maxStoreListLimit {
+			http.Error(w, "limit must be between 1 and "+strconv.Itoa(maxStoreListLimit), http.StatusBadRequest)
+			return
+		}
+	}
+	// the continue value is the key of the next event
+	var start []byte
+	if value := params.Get("continue"); value != "" {
+		if start, err = hex.DecodeString(value); err != nil || len(start) != 16 {
+			http.Error(w, "invalid continue", http.StatusBadRequest)
+			return
+		}
+	}
+	if !query.since.IsZero() {
+		if first := archiveKey(query.since, 0); bytes.Compare(first, start) > 0 {
+			start = first
+		}
+	}
+	if err := a.flush(); err != nil {
+		a.logger.Error().Err(err).Msg("Could not write archive")
+	}
+
+	list := archiveList{Items: []jsonEvent{}}
+	err = a.db.View(func(tx *bolt.Tx) error {
+		return a.scan(tx, query.filter, start, func(key []byte, event *corev1.Event) bool {
+			if !until.IsZero() && eventTime(event).After(until) {
+				// the keys are in the order of the event times
+				return false
+			}
+			if !query.Matches(event) {
+				return true
+			}
+			if len(list.Items) == limit {
+				list.Continue = hex.EncodeToString(key)
+				return false
+			}
+			list.Items = append(list.Items, newJSONEvent(event))
+			return true
+		})
+	})
+	if err != nil {
+		a.logger.Error().Err(err).Msg("Could not read archive")
+		http.Error(w, "could not read archive", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json; charset=UTF-8")
+	if err := json.NewEncoder(w).Encode(list); err != nil {
+		log.Error().Err(err).Msg("Could not write archived events")
+	}
+}
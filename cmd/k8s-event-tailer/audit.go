@@ -0,0 +1,135 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// openAuditLog opens the audit log file for appending, - is stdout
+func openAuditLog(path string) (io.WriteCloser, error) {
+	if path == "-" {
+		return nopWriteCloser{os.Stdout}, nil
+	}
+	return os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// auditedPathPrefixes are the endpoints exposing cluster data or admin
+// functionality. Health checks and metrics are not audited.
+var auditedPathPrefixes = []string{"/store", "/api/", "/debug/"}
+
+// auditLogger writes one structured record per audited HTTP request
+type auditLogger struct {
+	logger zerolog.Logger
+	// all audits every request, not just the sensitive endpoints
+	all bool
+}
+
+func newAuditLogger(out io.Writer, all bool) *auditLogger {
+	return &auditLogger{
+		logger: zerolog.New(out).With().Timestamp().Str("stream", "audit").Logger(),
+		all:    all,
+	}
+}
+
+func (a *auditLogger) audited(path string) bool {
+	if a.all {
+		return true
+	}
+	for _, prefix := range auditedPathPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware wraps next, auditing the requests to audited paths
+func (a *auditLogger) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.audited(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		outcome := "success"
+		if rec.status >= 400 {
+			outcome = "failure"
+		}
+		a.logger.Log().
+			Str("client", clientAddress(r)).
+			Str("user", requestUser(r)).
+			Str("method", r.Method).
+			Str("path", r.URL.Path).
+			Str("params", r.URL.RawQuery).
+			Str("userAgent", r.UserAgent()).
+			Int("status", rec.status).
+			Int64("bytes", rec.bytes).
+			Dur("duration", time.Since(start)).
+			Str("outcome", outcome).
+			Send()
+	})
+}
+
+// clientAddress returns the client IP, preferring the first address in
+// X-Forwarded-For when behind a proxy
+func clientAddress(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// requestUser returns the basic auth user name of the request, if any
+func requestUser(r *http.Request) string {
+	user, _, _ := r.BasicAuth()
+	return user
+}
+
+// statusRecorder captures the status code and size of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Flush keeps streaming responses working through the recorder
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// minReauthInterval limits how often credentials are reloaded when the API
+// server keeps rejecting them
+const minReauthInterval = 10 * time.Second
+
+// restClient returns the client used for new list/watch requests
+func (ew *EventWatcher) restClient() rest.Interface {
+	return ew.kubeClient().CoreV1().RESTClient()
+}
+
+// kubeClient returns the client used for new requests
+func (ew *EventWatcher) kubeClient() kubernetes.Interface {
+	ew.clientMu.Lock()
+	defer ew.clientMu.Unlock()
+	return ew.client
+}
+
+// onUnauthorized reports that the API server rejected the credentials of
+// shard, which usually means that a token expired or was rotated.
+func (ew *EventWatcher) onUnauthorized(shard *informerShard) {
+	ew.authFailures.WithLabelValues(shard.name).Inc()
+	select {
+	case ew.authFailed <- shard:
+	default:
+		// a refresh is pending already
+	}
+}
+
+// runReauth reloads the credentials from the kubeconfig whenever a watch
+// fails with unauthorized and restarts the failed informer with them. Exec
+// and OIDC credentials refresh themselves once expired; this also covers
+// tokens rotated in the kubeconfig itself and reconnects right away instead
+// of waiting for the reflector's backoff. The informer resumes watching from
+// its last resourceVersion, so no event is delivered twice.
+func (ew *EventWatcher) runReauth(ctx context.Context) {
+	var last time.Time
+	for {
+		var shard *informerShard
+		select {
+		case shard = <-ew.authFailed:
+		case <-ctx.Done():
+			return
+		}
+		if wait := minReauthInterval - time.Since(last); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return
+			}
+		}
+		last = time.Now()
+
+		switched := false
+		if ew.newClient != nil {
+			client, err := ew.newClient()
+			if err != nil {
+				ew.logger.Error().Err(err).Msg("Could not reload credentials")
+				continue
+			}
+			ew.clientMu.Lock()
+			switched = apiServerHost(client) != apiServerHost(ew.client)
+			ew.client = client
+			ew.clientMu.Unlock()
+		}
+
+		ew.shardsMu.Lock()
+		current := ew.shards[shard.name] == shard
+		ew.shardsMu.Unlock()
+		switch {
+		case !current:
+		case switched:
+			// the versions of the old API server don't apply
+			shard.logger.Info().Msg("Credentials rejected, relisting events with the reloaded kubeconfig of another API server")
+			ew.relistShard(ctx, shard)
+		default:
+			shard.logger.Info().Msg("Credentials rejected, restarting informer with reloaded credentials")
+			ew.restartShard(ctx, shard)
+		}
+	}
+}
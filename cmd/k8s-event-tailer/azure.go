@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	azureAuthorityURL     = "https://login.microsoftonline.com"
+	azureMonitorScope     = "https://monitor.azure.com//.default"
+	azureLogsAPIVersion   = "2023-01-01"
+	defaultAzureLogStream = "Custom-KubernetesEvents_CL"
+	// azureTokenRefreshMargin renews tokens before they expire
+	azureTokenRefreshMargin = 5 * time.Minute
+)
+
+// azureLogsOptions configures the Azure Monitor Logs Ingestion sink
+type azureLogsOptions struct {
+	// endpoint is the logs ingestion endpoint of the data collection
+	// endpoint or rule, e.g. https://my-dce.westeurope-1.ingest.monitor.azure.com
+	endpoint string
+	// ruleID is the immutable ID of the data collection rule
+	ruleID string
+	stream string
+	// tenantID and clientID identify the app registration or managed
+	// identity. The secret is not needed with workload identity, where
+	// AZURE_FEDERATED_TOKEN_FILE is set.
+	tenantID     string
+	clientID     string
+	clientSecret string
+	tls          *tlsPolicy
+}
+
+// azureLogsSink sends events to a Log Analytics workspace table through a
+// data collection rule, authenticating with Microsoft Entra ID.
+type azureLogsSink struct {
+	opts   azureLogsOptions
+	client *http.Client
+	url    string
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// azureLogRecord is a row of the custom table. The table needs a
+// TimeGenerated column, the others are mapped by the data collection rule.
+type azureLogRecord struct {
+	TimeGenerated time.Time `json:"TimeGenerated"`
+	jsonEvent
+}
+
+func newAzureLogsSink(opts azureLogsOptions) (*azureLogsSink, error) {
+	if opts.ruleID == "" || opts.tenantID == "" || opts.clientID == "" {
+		return nil, fmt.Errorf("azure logs ingestion needs a data collection rule ID, tenant ID and client ID")
+	}
+	if opts.clientSecret == "" && os.Getenv("AZURE_FEDERATED_TOKEN_FILE") == "" {
+		return nil, fmt.Errorf("azure logs ingestion needs a client secret or workload identity")
+	}
+	if opts.stream == "" {
+		opts.stream = defaultAzureLogStream
+	}
+	return &azureLogsSink{
+		opts:   opts,
+		client: opts.tls.HTTPClient(30 * time.Second),
+		url: fmt.Sprintf("%s/dataCollectionRules/%s/streams/%s?api-version=%s",
+			strings.TrimSuffix(opts.endpoint, "/"), url.PathEscape(opts.ruleID), url.PathEscape(opts.stream), azureLogsAPIVersion),
+	}, nil
+}
+
+func (s *azureLogsSink) Name() string {
+	return "azure-logs"
+}
+
+func (s *azureLogsSink) Write(event *corev1.Event) error {
+	return s.WriteBatch([]*corev1.Event{event})
+}
+
+func (s *azureLogsSink) WriteBatch(events []*corev1.Event) error {
+	ctx := context.Background()
+	token, err := s.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+	records := make([]azureLogRecord, 0, len(events))
+	for _, event := range events {
+		record := azureLogRecord{jsonEvent: newJSONEvent(event)}
+		record.TimeGenerated = record.Timestamp
+		records = append(records, record)
+	}
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+token)
+	err = doJSON(ctx, s.client, http.MethodPost, s.url, header, records, nil)
+	if isHTTPStatus(err, http.StatusUnauthorized) {
+		// fetch a new token on retry
+		s.mu.Lock()
+		s.token = ""
+		s.mu.Unlock()
+	}
+	return err
+}
+
+// accessToken returns a cached token, requesting a new one from Entra ID
+// with the client credentials flow when it is about to expire
+func (s *azureLogsSink) accessToken(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && time.Until(s.expires) > azureTokenRefreshMargin {
+		return s.token, nil
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", s.opts.clientID)
+	form.Set("scope", azureMonitorScope)
+	if file := os.Getenv("AZURE_FEDERATED_TOKEN_FILE"); file != "" && s.opts.clientSecret == "" {
+		// workload identity, the projected token is rotated by the kubelet
+		assertion, err := os.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		form.Set("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
+		form.Set("client_assertion", strings.TrimSpace(string(assertion)))
+	} else {
+		form.Set("client_secret", s.opts.clientSecret)
+	}
+
+	tokenURL := fmt.Sprintf("%s/%s/oauth2/v2.0/token", azureAuthorityURL, url.PathEscape(s.opts.tenantID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return "", fmt.Errorf("could not get Entra ID token: %w", err)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	s.token = token.AccessToken
+	s.expires = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	return s.token, nil
+}
+
+func (s *azureLogsSink) Close() error {
+	return nil
+}
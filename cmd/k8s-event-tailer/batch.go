@@ -0,0 +1,86 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// batcher collects events and flushes them once maxSize events are collected
+// or interval has passed, whatever comes first.
+type batcher struct {
+	maxSize  int
+	interval time.Duration
+	flush    func([]*corev1.Event)
+
+	mu      sync.Mutex
+	flushMu sync.Mutex
+	events  []*corev1.Event
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func newBatcher(maxSize int, interval time.Duration, flush func([]*corev1.Event)) *batcher {
+	if maxSize < 1 {
+		maxSize = 1
+	}
+	b := &batcher{
+		maxSize:  maxSize,
+		interval: interval,
+		flush:    flush,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+func (b *batcher) run() {
+	defer close(b.done)
+	if b.interval <= 0 {
+		<-b.stop
+		return
+	}
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.Flush()
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+// Add queues an event, flushing the batch if it is full.
+func (b *batcher) Add(event *corev1.Event) {
+	b.mu.Lock()
+	b.events = append(b.events, event)
+	full := len(b.events) >= b.maxSize
+	b.mu.Unlock()
+	if full {
+		b.Flush()
+	}
+}
+
+// Flush writes out all collected events. Batches are written one at a time.
+func (b *batcher) Flush() {
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+	b.mu.Lock()
+	events := b.events
+	b.events = nil
+	b.mu.Unlock()
+	if len(events) > 0 {
+		b.flush(events)
+	}
+}
+
+// Close stops the flush timer and writes out the remaining events.
+func (b *batcher) Close() {
+	close(b.stop)
+	<-b.done
+	b.Flush()
+}
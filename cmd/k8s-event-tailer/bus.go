@@ -0,0 +1,146 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const defaultSubscriberBufferSize = 100
+
+var busDroppedCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "bus_subscriber_dropped_total",
+	Help: "Number of events dropped because a lossy subscriber could not keep up",
+}, []string{"subscriber"})
+
+// subscriberOptions configures a subscription on the event bus
+type subscriberOptions struct {
+	// bufferSize is the number of records buffered for the subscriber
+	bufferSize int
+	// lossy subscribers drop records when their buffer is full instead of
+	// blocking the publisher
+	lossy bool
+	// onClose is called after the last record has been handled
+	onClose func()
+}
+
+type subscription struct {
+	name    string
+	items   chan eventRecord
+	opts    subscriberOptions
+	dropped prometheus.Counter
+	memory  *memoryAccount
+}
+
+// eventBus fans out processed events to independent subscribers. Every
+// subscriber handles records in its own goroutine.
+type eventBus struct {
+	mu          sync.RWMutex
+	subscribers map[*subscription]struct{}
+	closed      bool
+	wg          sync.WaitGroup
+	memory      *memoryBudget
+}
+
+func newEventBus(memory *memoryBudget) *eventBus {
+	return &eventBus{
+		subscribers: map[*subscription]struct{}{},
+		memory:      memory,
+	}
+}
+
+// Subscribe calls handle for every record published from now on. It returns
+// nil if the bus is already closed.
+func (b *eventBus) Subscribe(name string, opts subscriberOptions, handle func(eventRecord)) *subscription {
+	if opts.bufferSize < 1 {
+		opts.bufferSize = defaultSubscriberBufferSize
+	}
+	sub := &subscription{
+		name:    name,
+		items:   make(chan eventRecord, opts.bufferSize),
+		opts:    opts,
+		dropped: busDroppedCounter.WithLabelValues(name),
+		memory:  b.memory.Account("subscriber-" + name),
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil
+	}
+	b.subscribers[sub] = struct{}{}
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		defer errorReports.Recover()
+		for record := range sub.items {
+			sub.memory.Release(record.size)
+			handle(record)
+		}
+		if sub.opts.onClose != nil {
+			sub.opts.onClose()
+		}
+	}()
+	return sub
+}
+
+// Unsubscribe stops delivering records to sub. Records already buffered are
+// still handled.
+func (b *eventBus) Unsubscribe(sub *subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subscribers[sub]; !ok {
+		return
+	}
+	delete(b.subscribers, sub)
+	close(sub.items)
+}
+
+// Publish hands the record to all subscribers. It blocks while a non-lossy
+// subscriber's buffer is full.
+func (b *eventBus) Publish(record eventRecord) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for sub := range b.subscribers {
+		sub.publish(record)
+	}
+}
+
+func (sub *subscription) publish(record eventRecord) {
+	if sub.opts.lossy {
+		select {
+		case sub.items <- record:
+		default:
+			sub.dropped.Inc()
+			drops.Add(dropCauseSlowSubscriber, sub.name, 1)
+			return
+		}
+	} else {
+		sub.items <- record
+	}
+	sub.memory.Add(record.size)
+
+	// evict the oldest records while the memory budget is exceeded
+	for sub.memory.OverBudget() {
+		select {
+		case old := <-sub.items:
+			sub.memory.Evicted(old.size)
+		default:
+			return
+		}
+	}
+}
+
+// Close unsubscribes everybody and waits until all buffered records have
+// been handled.
+func (b *eventBus) Close() {
+	b.mu.Lock()
+	b.closed = true
+	for sub := range b.subscribers {
+		delete(b.subscribers, sub)
+		close(sub.items)
+	}
+	b.mu.Unlock()
+	b.wg.Wait()
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog/log"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	defaultMaxEventLabelSets = 2000
+	// overflowLabelValue replaces the label values of events beyond the
+	// label set limit
+	overflowLabelValue = "other"
+)
+
+// eventCounterLabels are the labels of the informer event counters
+var eventCounterLabels = []string{"namespace", "reason", "type", "kind"}
+
+var eventLabelOverflowCounter = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "informer_events_label_overflow_total",
+	Help: "Number of events counted with overflow labels because the label set limit was reached",
+})
+
+// labelLimiter caps the label sets of the event counters. Namespaces of
+// short-lived CI runs or reasons of misbehaving controllers could otherwise
+// create series without bound. Once limit label sets have been seen, events
+// with new ones are counted with namespace, reason and kind set to "other",
+// the type is kept as it has only a few values.
+type labelLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	seen   map[[4]string]struct{}
+	warned bool
+}
+
+// newLabelLimiter returns a limiter for limit label sets, zero does not
+// limit them
+func newLabelLimiter(limit int) *labelLimiter {
+	return &labelLimiter{limit: limit, seen: map[[4]string]struct{}{}}
+}
+
+// values returns the label values to count event with. A nil limiter does
+// not limit.
+func (l *labelLimiter) values(event *corev1.Event) []string {
+	labels := [4]string{event.Namespace, event.Reason, event.Type, event.InvolvedObject.Kind}
+	if l == nil || l.limit <= 0 {
+		return labels[:]
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.seen[labels]; ok {
+		return labels[:]
+	}
+	if len(l.seen) < l.limit {
+		l.seen[labels] = struct{}{}
+		return labels[:]
+	}
+	eventLabelOverflowCounter.Inc()
+	if !l.warned {
+		l.warned = true
+		log.Warn().Int("limit", l.limit).Msg("Event counter label set limit reached, new label sets are counted as other")
+	}
+	return []string{overflowLabelValue, overflowLabelValue, event.Type, overflowLabelValue}
+}
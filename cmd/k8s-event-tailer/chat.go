@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Helpers formatting events for chat and incident tools
+
+// eventObject returns the involved object as kind namespace/name
+func eventObject(event *corev1.Event) string {
+	obj := event.InvolvedObject
+	if obj.Namespace == "" {
+		return obj.Kind + " " + obj.Name
+	}
+	return obj.Kind + " " + obj.Namespace + "/" + obj.Name
+}
+
+// eventTitle returns a one line summary, e.g. "Warning BackOff: Pod default/web-0"
+func eventTitle(event *corev1.Event) string {
+	return fmt.Sprintf("%s %s: %s", event.Type, event.Reason, eventObject(event))
+}
+
+// eventTime returns the time the event was last seen
+func eventTime(event *corev1.Event) time.Time {
+	switch {
+	case !event.LastTimestamp.IsZero():
+		return event.LastTimestamp.Time
+	case !event.EventTime.IsZero():
+		return event.EventTime.Time
+	}
+	return event.CreationTimestamp.Time
+}
+
+// eventText returns the event as plain text
+func eventText(event *corev1.Event) string {
+	return eventTitle(event) + "\n" + event.Message
+}
+
+// eventMarkdown returns the event as markdown
+func eventMarkdown(event *corev1.Event) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "**%s %s**: %s `%s`\n\n", event.Type, event.Reason, event.InvolvedObject.Kind, objectName(event))
+	fmt.Fprintf(&b, "%s\n\n", event.Message)
+	fmt.Fprintf(&b, "_count %d, last seen %s_", event.Count, eventTime(event).UTC().Format(time.RFC3339))
+	return b.String()
+}
+
+// eventHTML returns the event as HTML
+func eventHTML(event *corev1.Event) string {
+	return fmt.Sprintf("<b>%s %s</b>: %s <code>%s</code><br/>%s",
+		html.EscapeString(event.Type), html.EscapeString(event.Reason),
+		html.EscapeString(event.InvolvedObject.Kind), html.EscapeString(objectName(event)),
+		html.EscapeString(event.Message))
+}
+
+func objectName(event *corev1.Event) string {
+	if event.InvolvedObject.Namespace == "" {
+		return event.InvolvedObject.Name
+	}
+	return event.InvolvedObject.Namespace + "/" + event.InvolvedObject.Name
+}
+
+// chatAttachment is a Slack-style message attachment, which is understood by
+// Rocket.Chat and Mattermost as well
+type chatAttachment struct {
+	Fallback  string      `json:"fallback,omitempty"`
+	Color     string      `json:"color,omitempty"`
+	Title     string      `json:"title"`
+	Text      string      `json:"text"`
+	Fields    []chatField `json:"fields,omitempty"`
+	Timestamp int64       `json:"ts,omitempty"`
+}
+
+type chatField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+// eventColor returns red for warnings and green otherwise
+func eventColor(event *corev1.Event) string {
+	if event.Type == corev1.EventTypeWarning {
+		return "#d00000"
+	}
+	return "#2eb886"
+}
+
+// eventAttachment returns the event as an attachment
+func eventAttachment(event *corev1.Event) chatAttachment {
+	fields := []chatField{
+		{Title: "Type", Value: event.Type, Short: true},
+		{Title: "Reason", Value: event.Reason, Short: true},
+		{Title: "Object", Value: eventObject(event), Short: true},
+		{Title: "Count", Value: fmt.Sprint(event.Count), Short: true},
+	}
+	if event.Source.Component != "" {
+		fields = append(fields, chatField{Title: "Source", Value: event.Source.Component, Short: true})
+	}
+	return chatAttachment{
+		Fallback:  eventText(event),
+		Color:     eventColor(event),
+		Title:     eventTitle(event),
+		Text:      event.Message,
+		Fields:    fields,
+		Timestamp: eventTime(event).Unix(),
+	}
+}
+
+// routeChannel returns the channel of the first route matching event, empty
+// if none matches
+func routeChannel(routes []*eventRule, event *corev1.Event) string {
+	for _, route := range routes {
+		if route.Matches(event) {
+			return route.name
+		}
+	}
+	return ""
+}
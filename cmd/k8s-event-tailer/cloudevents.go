@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	defaultCloudEventsSource = "k8s-event-tailer"
+	cloudEventsTypePrefix    = "io.k8s.event."
+)
+
+// cloudEventsSink posts events as CloudEvents in binary content mode, which
+// is accepted by Knative brokers and Argo Events webhook event sources.
+type cloudEventsSink struct {
+	url    string
+	source string
+	client *http.Client
+}
+
+func newCloudEventsSink(url, source string, policy *tlsPolicy) *cloudEventsSink {
+	return &cloudEventsSink{
+		url:    url,
+		source: source,
+		client: policy.HTTPClient(30 * time.Second),
+	}
+}
+
+func (s *cloudEventsSink) Name() string {
+	return "cloudevents"
+}
+
+// Write posts the event with the JSON representation as data. The type is
+// io.k8s.event.warning or io.k8s.event.normal, the subject identifies the
+// involved object as namespace/kind/name.
+func (s *cloudEventsSink) Write(event *corev1.Event) error {
+	data, err := json.Marshal(newJSONEvent(event))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, s.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	obj := event.InvolvedObject
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Ce-Specversion", "1.0")
+	// the resource version changes with every update of the event, so
+	// receivers can deduplicate retries
+	req.Header.Set("Ce-Id", string(event.UID)+"-"+event.ResourceVersion)
+	req.Header.Set("Ce-Source", s.source)
+	req.Header.Set("Ce-Type", cloudEventsTypePrefix+strings.ToLower(event.Type))
+	req.Header.Set("Ce-Subject", obj.Namespace+"/"+obj.Kind+"/"+obj.Name)
+	req.Header.Set("Ce-Time", eventTime(event).UTC().Format(time.RFC3339))
+	req.Header.Set("Ce-Namespace", event.Namespace)
+	req.Header.Set("Ce-Reason", event.Reason)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp)
+}
+
+func (s *cloudEventsSink) Close() error {
+	return nil
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/alecthomas/kingpin.v2"
+	"sigs.k8s.io/yaml"
+)
+
+var configReloadsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "config_reloads_total",
+	Help: "Number of times the config file changed, by result",
+}, []string{"result"})
+
+// reloadableFlags are applied when the config file changes, all other flags
+// take effect after a restart. They configure the filters and the built-in
+// sinks, which are rebuilt on every reload.
+var reloadableFlags = stringSet([]string{
+	"exclude-namespace", "include-reason", "exclude-reason", "event-type", "match", "exclude", "match-field",
+	"sink-filter", "sink-max-retries", "sink-retries-per-minute", "sink-batch-size", "sink-batch-interval",
+	"output-file",
+	"matrix-homeserver", "matrix-room", "matrix-token",
+	"webex-webhook-url", "webex-token", "webex-room",
+	"rocketchat-webhook-url", "rocketchat-route",
+	"mattermost-webhook-url", "mattermost-username", "mattermost-route",
+	"gelf-address", "logstash-address",
+	"syslog-address", "syslog-facility", "syslog-severity", "syslog-app-name",
+	"azure-logs-endpoint", "azure-logs-dcr-id", "azure-logs-stream", "azure-tenant-id", "azure-client-id", "azure-client-secret",
+	"cloudevents-url", "cloudevents-source", "falcosidekick-url", "googlechat-webhook-url",
+	"slack-webhook-url", "teams-webhook-url",
+	"notify-filter", "notify-batch-size", "notify-batch-interval", "notify-messages-per-minute",
+	"eventbridge-bus", "eventbridge-source", "aws-region",
+	"webhook-url", "webhook-header", "webhook-template", "webhook-signing-secret-file", "webhook-signature-header", "webhook-queue-size",
+	"pulsar-url", "pulsar-topic", "pulsar-token",
+	"elasticsearch-url", "elasticsearch-index", "elasticsearch-index-template",
+	"elasticsearch-username", "elasticsearch-password", "elasticsearch-api-key",
+	"loki-url", "loki-label", "loki-event-label", "loki-tenant", "loki-username", "loki-password", "loki-queue-size",
+	"nats-address", "nats-subject", "nats-jetstream", "nats-token", "nats-username", "nats-password",
+	"opensearch-url", "opensearch-index", "opensearch-username", "opensearch-password",
+	"opensearch-aws-sigv4", "opensearch-aws-service",
+	"s3-bucket", "s3-endpoint", "s3-prefix", "s3-flush-interval",
+	"windows-eventlog", "windows-eventlog-source", "windows-eventlog-error-reason",
+})
+
+// enumsFlags are the repeatable enum flags. kingpin does not expose their
+// values, so they are reset through the variables.
+var enumsFlags = map[string]*[]string{
+	"event-type":       eventTypes,
+	"match-field":      matchFieldNames,
+	"loki-event-label": lokiDerivedLabels,
+}
+
+// configFile is a config file with the values of the flags it sets, as they
+// would be given on the command line
+type configFile map[string][]string
+
+// readConfigFile reads a YAML or JSON file whose keys are the long names of
+// the global flags. A value is a scalar, a list for repeatable flags or a
+// map for KEY=VALUE flags, e.g.
+//
+//	namespace: default
+//	exclude-namespace: [kube-system, kube-public]
+//	sink-filter:
+//	  slack: type=Warning
+func readConfigFile(path string) (configFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	config := configFile{}
+	for name, value := range raw {
+		values, err := configValues(name, value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		config[name] = values
+	}
+	return config, nil
+}
+
+// configValues converts the config value of the flag name to the values it
+// would get on the command line
+func configValues(name string, value interface{}) ([]string, error) {
+	flag := kingpin.CommandLine.GetFlag(name)
+	if flag == nil || name == "config" || name == "help" || name == "version" {
+		return nil, fmt.Errorf("unknown flag %q", name)
+	}
+	repeatable := isCumulative(flag.Model().Value)
+	var values []string
+	switch value := value.(type) {
+	case nil:
+	case []interface{}:
+		if !repeatable {
+			return nil, fmt.Errorf("flag %q takes a single value", name)
+		}
+		for _, item := range value {
+			s, err := configScalar(name, item)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, s)
+		}
+	case map[string]interface{}:
+		if !repeatable {
+			return nil, fmt.Errorf("flag %q takes a single value", name)
+		}
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			s, err := configScalar(name, value[key])
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, key+"="+s)
+		}
+	default:
+		s, err := configScalar(name, value)
+		if err != nil {
+			return nil, err
+		}
+		values = []string{s}
+	}
+	return values, nil
+}
+
+func configScalar(name string, value interface{}) (string, error) {
+	switch value := value.(type) {
+	case string:
+		return value, nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	default:
+		return "", fmt.Errorf("flag %q: unsupported value %v", name, value)
+	}
+}
+
+func isCumulative(value kingpin.Value) bool {
+	cumulative, ok := value.(interface{ IsCumulative() bool })
+	return ok && cumulative.IsCumulative()
+}
+
+func isBoolFlag(value kingpin.Value) bool {
+	boolFlag, ok := value.(interface{ IsBoolFlag() bool })
+	return ok && boolFlag.IsBoolFlag()
+}
+
+// args returns the config as command line arguments, leaving out the flags
+// in skip
+func (c configFile) args(skip map[string]bool) []string {
+	names := c.names()
+	sort.Strings(names)
+	var args []string
+	for _, name := range names {
+		if skip[name] {
+			continue
+		}
+		for _, value := range c[name] {
+			if isBoolFlag(kingpin.CommandLine.GetFlag(name).Model().Value) {
+				if value == "true" {
+					args = append(args, "--"+name)
+				} else {
+					args = append(args, "--no-"+name)
+				}
+				continue
+			}
+			args = append(args, "--"+name+"="+value)
+		}
+	}
+	return args
+}
+
+// commandLineFlags returns the names of the global flags given in args
+func commandLineFlags(args []string) map[string]bool {
+	shorts := map[string]string{}
+	for _, flag := range kingpin.CommandLine.Model().Flags {
+		if flag.Short != 0 {
+			shorts[string(flag.Short)] = flag.Name
+		}
+	}
+	given := map[string]bool{}
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		switch {
+		case strings.HasPrefix(arg, "--"):
+			name := strings.SplitN(arg[2:], "=", 2)[0]
+			if kingpin.CommandLine.GetFlag(name) == nil && strings.HasPrefix(name, "no-") {
+				name = strings.TrimPrefix(name, "no-")
+			}
+			given[name] = true
+		case strings.HasPrefix(arg, "-") && len(arg) > 1:
+			if name, ok := shorts[arg[1:2]]; ok {
+				given[name] = true
+			}
+		}
+	}
+	return given
+}
+
+// configFilePath returns the value of --config in args
+func configFilePath(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if arg == "--config" && i+1 < len(args) {
+			return args[i+1]
+		}
+		if strings.HasPrefix(arg, "--config=") {
+			return strings.TrimPrefix(arg, "--config=")
+		}
+	}
+	return ""
+}
+
+// withConfigFile returns args preceded by the flags of the config file given
+// with --config. Flags on the command line take precedence over the file.
+func withConfigFile(args []string) ([]string, configFile, error) {
+	path := configFilePath(args)
+	if path == "" {
+		return args, nil, nil
+	}
+	config, err := readConfigFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return append(config.args(commandLineFlags(args)), args...), config, nil
+}
+
+// resetFlag sets the flag back to its default, or the value of its
+// environment variable
+func resetFlag(flag *kingpin.FlagClause) error {
+	model := flag.Model()
+	if values, ok := enumsFlags[model.Name]; ok {
+		*values = nil
+	} else if getter, ok := model.Value.(kingpin.Getter); ok && reflect.ValueOf(getter.Get()).Kind() == reflect.Ptr {
+		// lists, the variable itself is replaced as sinks may still use it
+		target := reflect.ValueOf(getter.Get()).Elem()
+		target.Set(reflect.Zero(target.Type()))
+	} else {
+		target := reflect.ValueOf(model.Value)
+		if target.Kind() != reflect.Ptr || !target.Elem().CanSet() {
+			return fmt.Errorf("flag %q cannot be reset", model.Name)
+		}
+		switch target.Elem().Kind() {
+		case reflect.Map:
+			target.Elem().Set(reflect.MakeMap(target.Elem().Type()))
+		case reflect.Struct:
+			// single enums, always overwritten by their default
+		default:
+			target.Elem().Set(reflect.Zero(target.Elem().Type()))
+		}
+	}
+
+	values := model.Default
+	if flag.HasEnvarValue() {
+		values = []string{flag.GetEnvarValue()}
+		if isCumulative(model.Value) {
+			values = flag.GetSplitEnvarValue()
+		}
+	}
+	for _, value := range values {
+		if err := model.Value.Set(value); err != nil {
+			return fmt.Errorf("flag %q: %w", model.Name, err)
+		}
+	}
+	return nil
+}
+
+// setFlags resets the flags in names and sets them to their values in
+// config
+func setFlags(names []string, config configFile) error {
+	for _, name := range names {
+		flag := kingpin.CommandLine.GetFlag(name)
+		if err := resetFlag(flag); err != nil {
+			return err
+		}
+		for _, value := range config[name] {
+			if err := flag.Model().Value.Set(value); err != nil {
+				return fmt.Errorf("flag %q: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// configReloader applies changes of the config file. The reloadable flags
+// are updated and apply rebuilds the filters and sinks from them.
+type configReloader struct {
+	path     string
+	interval time.Duration
+	// config is the currently applied config file
+	config configFile
+	// commandLine are the flags given on the command line, the config file
+	// does not override them
+	commandLine map[string]bool
+	apply       func() error
+}
+
+// run polls the config file every interval. A broken file or configuration
+// is logged and keeps the current one.
+func (r *configReloader) run(ctx context.Context) {
+	if r.path == "" || r.interval <= 0 {
+		return
+	}
+	logger := log.With().Str("component", "config").Str("config", r.path).Logger()
+	last, _, err := filesDigest([]string{r.path})
+	if err != nil {
+		logger.Warn().Err(err).Msg("Could not read the config file, changes are not detected")
+		return
+	}
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		digest, _, err := filesDigest([]string{r.path})
+		if err != nil || digest == last {
+			if err != nil {
+				logger.Debug().Err(err).Msg("Could not read the config file")
+			}
+			continue
+		}
+		last = digest
+		if err := r.reload(); err != nil {
+			configReloadsCounter.WithLabelValues("failure").Inc()
+			logger.Error().Err(err).Msg("Config file changed but is invalid, keeping the current configuration")
+			continue
+		}
+		configReloadsCounter.WithLabelValues("success").Inc()
+	}
+}
+
+// reload reads the config file and applies the changed reloadable flags. If
+// that fails, the flags are set back to their previous values.
+func (r *configReloader) reload() error {
+	config, err := readConfigFile(r.path)
+	if err != nil {
+		return err
+	}
+	var changed, restart []string
+	for name := range stringSet(append(config.names(), r.config.names()...)) {
+		if r.commandLine[name] || reflect.DeepEqual(config[name], r.config[name]) {
+			continue
+		}
+		if reloadableFlags[name] {
+			changed = append(changed, name)
+		} else {
+			restart = append(restart, name)
+		}
+	}
+	sort.Strings(changed)
+	sort.Strings(restart)
+	if len(restart) > 0 {
+		log.Warn().Strs("flags", restart).Msg("Config file changes of these flags take effect after a restart")
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+
+	err = setFlags(changed, config)
+	if err == nil {
+		err = r.apply()
+	}
+	if err != nil {
+		if revertErr := setFlags(changed, r.config); revertErr != nil {
+			log.Error().Err(revertErr).Msg("Could not restore the previous flags")
+		}
+		return err
+	}
+	for _, name := range restart {
+		// keep the running values, so that they are not reported again
+		if values, ok := r.config[name]; ok {
+			config[name] = values
+		} else {
+			delete(config, name)
+		}
+	}
+	r.config = config
+	log.Info().Strs("flags", changed).Msg("Config file reloaded")
+	return nil
+}
+
+func (c configFile) names() []string {
+	names := make([]string, 0, len(c))
+	for name := range c {
+		names = append(names, name)
+	}
+	return names
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"embed"
+	"net/http"
+)
+
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+// dashboardPages maps paths to the pages of the dashboard
+var dashboardPages = map[string]string{
+	"/":     "dashboard/index.html",
+	"/live": "dashboard/live.html",
+}
+
+// dashboardHandler serves the pages of the embedded dashboard
+func dashboardHandler(w http.ResponseWriter, r *http.Request) {
+	name, ok := dashboardPages[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	page, err := dashboardFiles.ReadFile(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write(page)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+)
+
+// deduplicatorFlushInterval is how often ended windows are summed up
+const deduplicatorFlushInterval = time.Second
+
+var deduplicatedCounter = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "events_deduplicated_total",
+	Help: "Number of events suppressed as repetitions of an event with the same object, reason and message",
+})
+
+// deduplicator suppresses events repeating an event of the same involved
+// object with the same reason and message within a window, like the
+// updates of a BackOff event while a pod is crash looping. The first event
+// passes and opens the window, the repetitions are summed up in a copy of
+// the last one once the window ends.
+type deduplicator struct {
+	window  time.Duration
+	mu      sync.Mutex
+	windows map[dedupKey]*dedupEntry
+}
+
+type dedupKey struct {
+	namespace, kind, name, reason, message string
+}
+
+type dedupEntry struct {
+	start    time.Time
+	repeated int32
+	// last is the last suppressed event, seen at lastSeen
+	last     *corev1.Event
+	lastSeen time.Time
+}
+
+func newDeduplicator(window time.Duration) *deduplicator {
+	if window <= 0 {
+		return nil
+	}
+	return &deduplicator{
+		window:  window,
+		windows: map[dedupKey]*dedupEntry{},
+	}
+}
+
+// Allow reports whether event is not a repetition within the window
+func (d *deduplicator) Allow(event *corev1.Event, now time.Time) bool {
+	if d == nil {
+		return true
+	}
+	ref := event.InvolvedObject
+	key := dedupKey{ref.Namespace, ref.Kind, ref.Name, event.Reason, event.Message}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	w, ok := d.windows[key]
+	if !ok || (now.Sub(w.start) >= d.window && w.repeated == 0) {
+		d.windows[key] = &dedupEntry{start: now}
+		return true
+	}
+	// repetitions after the window ended are added to its summary, which is
+	// due with the next flush
+	w.repeated++
+	w.last, w.lastSeen = event, now
+	deduplicatedCounter.Inc()
+	return false
+}
+
+// Flush returns a summary for every ended window with repetitions and
+// forgets the ended windows, or all windows if all is set
+func (d *deduplicator) Flush(now time.Time, all bool) []*corev1.Event {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var summaries []*corev1.Event
+	for key, w := range d.windows {
+		if !all && now.Sub(w.start) < d.window {
+			continue
+		}
+		if w.repeated > 0 {
+			summaries = append(summaries, dedupSummary(w, now))
+		}
+		delete(d.windows, key)
+	}
+	return summaries
+}
+
+// dedupSummary copies the last repetition with a new identity, so that sinks
+// do not take it for an update of the event
+func dedupSummary(w *dedupEntry, now time.Time) *corev1.Event {
+	summary := w.last.DeepCopy()
+	summary.ObjectMeta = metav1.ObjectMeta{
+		Name:              fmt.Sprintf("%s.%x", w.last.InvolvedObject.Name, now.UnixNano()),
+		Namespace:         w.last.Namespace,
+		UID:               uuid.NewUUID(),
+		CreationTimestamp: metav1.NewTime(now),
+	}
+	summary.Message = fmt.Sprintf("%s (repeated %d times in %s)", w.last.Message, w.repeated,
+		w.lastSeen.Sub(w.start).Round(time.Second))
+	summary.FirstTimestamp = metav1.NewTime(w.start)
+	return summary
+}
+
+// runDeduplicator queues the summaries of repeated events until ctx is done,
+// including those of all open windows on shutdown
+func (ew *EventWatcher) runDeduplicator(ctx context.Context) {
+	if ew.deduplicator == nil {
+		return
+	}
+	ticker := time.NewTicker(deduplicatorFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			ew.queueRepetitions(now, false)
+		case <-ctx.Done():
+			ew.queueRepetitions(time.Now(), true)
+			return
+		}
+	}
+}
+
+func (ew *EventWatcher) queueRepetitions(now time.Time, all bool) {
+	for _, event := range ew.deduplicator.Flush(now, all) {
+		ew.queue.Push(eventRecord{event: event, message: eventUpdatedMessage})
+		ew.namespaceStats.record(event, eventUpdatedMessage)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog/log"
+)
+
+// Causes for which an event is not delivered everywhere
+const (
+	dropCauseOld            = "old"
+	dropCauseFiltered       = "filtered"
+	dropCauseRateLimited    = "rate_limited"
+	dropCauseDeduplicated   = "deduplicated"
+	dropCauseQueueFull      = "queue_full"
+	dropCauseMemoryBudget   = "memory_budget"
+	dropCauseSlowSubscriber = "slow_subscriber"
+	dropCauseSinkFailed     = "sink_failed"
+	dropCauseStandby        = "standby"
+)
+
+var droppedEventsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "events_dropped_total",
+	Help: "Number of events not delivered to all destinations, by cause",
+}, []string{"cause"})
+
+// drops is the ledger of all skipped and lost events
+var drops = newDropLedger()
+
+// dropStats summarizes the drops of a single cause
+type dropStats struct {
+	Count uint64    `json:"count"`
+	Last  time.Time `json:"last"`
+	// By breaks the count down by the component which dropped the
+	// events, e.g. the sink or subscriber
+	By map[string]uint64 `json:"by,omitempty"`
+}
+
+// dropLedger counts every event skipped or lost on its way through the
+// pipeline, so that operators can tell whether and why an event was lost.
+type dropLedger struct {
+	mu     sync.Mutex
+	since  time.Time
+	causes map[string]*dropStats
+}
+
+func newDropLedger() *dropLedger {
+	return &dropLedger{
+		since:  time.Now(),
+		causes: map[string]*dropStats{},
+	}
+}
+
+// Add records n events dropped for cause by component, which may be empty
+func (l *dropLedger) Add(cause, component string, n int) {
+	droppedEventsCounter.WithLabelValues(cause).Add(float64(n))
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	stats, ok := l.causes[cause]
+	if !ok {
+		stats = &dropStats{}
+		l.causes[cause] = stats
+	}
+	stats.Count += uint64(n)
+	stats.Last = time.Now().UTC()
+	if component != "" {
+		if stats.By == nil {
+			stats.By = map[string]uint64{}
+		}
+		stats.By[component] += uint64(n)
+	}
+}
+
+// dropReport is served by /api/v1/drops
+type dropReport struct {
+	Since  time.Time            `json:"since"`
+	Total  uint64               `json:"total"`
+	Causes map[string]dropStats `json:"causes"`
+}
+
+func (l *dropLedger) report() dropReport {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	report := dropReport{Since: l.since.UTC(), Causes: map[string]dropStats{}}
+	for cause, stats := range l.causes {
+		copied := *stats
+		if stats.By != nil {
+			copied.By = map[string]uint64{}
+			for component, n := range stats.By {
+				copied.By[component] = n
+			}
+		}
+		report.Causes[cause] = copied
+		report.Total += stats.Count
+	}
+	return report
+}
+
+func dropsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json; charset=UTF-8")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(drops.report()); err != nil {
+		log.Error().Err(err).Msg("Could not write drop report")
+	}
+}
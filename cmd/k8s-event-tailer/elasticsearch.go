@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	corev1 "k8s.io/api/core/v1"
+
+	"k8s-event-tailer/pkg/extension"
+)
+
+const (
+	defaultElasticsearchIndex         = `k8s-events-{{.Timestamp.Format "2006.01.02"}}`
+	defaultElasticsearchIndexTemplate = "k8s-events"
+)
+
+// elasticsearchOptions configures the Elasticsearch sink
+type elasticsearchOptions struct {
+	url string
+	// index is a template executed with the JSON event, so that indices can
+	// roll over by the event date
+	index string
+	// indexTemplate names the index template with the event mapping
+	// installed for the indices, empty leaves the mapping to Elasticsearch
+	indexTemplate string
+	username      string
+	password      string
+	apiKey        string
+	tls           *tlsPolicy
+}
+
+// elasticsearchSink indexes events with the bulk API. Before the first
+// write, an index template maps the event fields, so that e.g. reasons are
+// keywords which Kibana can aggregate and messages are searchable text.
+// Documents have the same IDs as with the OpenSearch sink.
+type elasticsearchSink struct {
+	url           string
+	index         *template.Template
+	indexTemplate string
+	indexPattern  string
+	header        http.Header
+	client        *http.Client
+
+	mu                sync.Mutex
+	templateInstalled bool
+}
+
+func newElasticsearchSink(opts elasticsearchOptions) (*elasticsearchSink, error) {
+	if opts.url == "" {
+		return nil, fmt.Errorf("elasticsearch URL missing")
+	}
+	tmpl, err := template.New("index").Option("missingkey=error").Parse(opts.index)
+	if err != nil {
+		return nil, fmt.Errorf("invalid elasticsearch index template: %w", err)
+	}
+	header := http.Header{}
+	switch {
+	case opts.apiKey != "":
+		header.Set("Authorization", "ApiKey "+opts.apiKey)
+	case opts.username != "":
+		credentials := base64.StdEncoding.EncodeToString([]byte(opts.username + ":" + opts.password))
+		header.Set("Authorization", "Basic "+credentials)
+	}
+	// indices created by the index name template start with its literal
+	// prefix
+	pattern := opts.index
+	if i := strings.Index(pattern, "{{"); i >= 0 {
+		pattern = pattern[:i] + "*"
+	}
+	return &elasticsearchSink{
+		url:           strings.TrimSuffix(opts.url, "/"),
+		index:         tmpl,
+		indexTemplate: opts.indexTemplate,
+		indexPattern:  pattern,
+		header:        header,
+		client:        opts.tls.HTTPClient(30 * time.Second),
+	}, nil
+}
+
+func (s *elasticsearchSink) Name() string {
+	return "elasticsearch"
+}
+
+func (s *elasticsearchSink) Write(event *corev1.Event) error {
+	return s.WriteBatch([]*corev1.Event{event})
+}
+
+// WriteBatch sends the events with one bulk request, each to the index of
+// its date
+func (s *elasticsearchSink) WriteBatch(events []*corev1.Event) error {
+	ctx := context.Background()
+	if err := s.ensureIndexTemplate(ctx); err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, event := range events {
+		data := newJSONEvent(event)
+		var index strings.Builder
+		if err := s.index.Execute(&index, data); err != nil {
+			return extension.Permanent(fmt.Errorf("could not render elasticsearch index: %w", err))
+		}
+		action := map[string]interface{}{
+			"create": map[string]string{
+				"_index": strings.ToLower(index.String()),
+				"_id":    string(event.UID) + "-" + event.ResourceVersion,
+			},
+		}
+		if err := enc.Encode(action); err != nil {
+			return err
+		}
+		if err := enc.Encode(openSearchDocument{Timestamp: data.Timestamp, jsonEvent: data}); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url+"/_bulk", bytes.NewReader(body.Bytes()))
+	if err != nil {
+		return err
+	}
+	for key, values := range s.header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+	return checkBulkResponse(resp.Body, len(events))
+}
+
+// ensureIndexTemplate installs the index template once. Failures are
+// retried with the next write, so that Elasticsearch does not have to be
+// up when the tailer starts.
+func (s *elasticsearchSink) ensureIndexTemplate(ctx context.Context) error {
+	if s.indexTemplate == "" {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.templateInstalled {
+		return nil
+	}
+	err := doJSON(ctx, s.client, http.MethodPut, s.url+"/_index_template/"+s.indexTemplate, s.header,
+		elasticsearchMapping(s.indexPattern), nil)
+	if err != nil {
+		return fmt.Errorf("could not install elasticsearch index template: %w", err)
+	}
+	log.Info().Str("template", s.indexTemplate).Str("pattern", s.indexPattern).Msg("Installed elasticsearch index template")
+	s.templateInstalled = true
+	return nil
+}
+
+// elasticsearchMapping returns the index template mapping the JSON
+// event for indices matching pattern
+func elasticsearchMapping(pattern string) map[string]interface{} {
+	keyword := map[string]string{"type": "keyword"}
+	date := map[string]string{"type": "date"}
+	return map[string]interface{}{
+		"index_patterns": []string{pattern},
+		"template": map[string]interface{}{
+			"mappings": map[string]interface{}{
+				"properties": map[string]interface{}{
+					"@timestamp": date,
+					"timestamp":  date,
+					"namespace":  keyword,
+					"name":       keyword,
+					"uid":        keyword,
+					"type":       keyword,
+					"reason":     keyword,
+					"message": map[string]interface{}{
+						"type":   "text",
+						"fields": map[string]interface{}{"keyword": map[string]interface{}{"type": "keyword", "ignore_above": 1024}},
+					},
+					"count": map[string]string{"type": "integer"},
+					"involvedObject": map[string]interface{}{
+						"properties": map[string]interface{}{
+							"kind":       keyword,
+							"namespace":  keyword,
+							"name":       keyword,
+							"uid":        keyword,
+							"apiVersion": keyword,
+							"fieldPath":  keyword,
+						},
+					},
+					"source": map[string]interface{}{
+						"properties": map[string]interface{}{
+							"component": keyword,
+							"host":      keyword,
+						},
+					},
+					"firstTimestamp": date,
+					"lastTimestamp":  date,
+				},
+			},
+		},
+	}
+}
+
+func (s *elasticsearchSink) Close() error {
+	return nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxPooledBufferSize keeps unusually large buffers out of the pool
+const maxPooledBufferSize = 64 << 10
+
+// ANSI colors used by zerolog's ConsoleWriter
+const (
+	colorGreen    = 32
+	colorCyan     = 36
+	colorDarkGray = 90
+)
+
+type lineBuffer struct {
+	b []byte
+}
+
+var lineBufferPool = sync.Pool{
+	New: func() interface{} {
+		return &lineBuffer{b: make([]byte, 0, 1024)}
+	},
+}
+
+func getLineBuffer() *lineBuffer {
+	buf := lineBufferPool.Get().(*lineBuffer)
+	buf.b = buf.b[:0]
+	return buf
+}
+
+// Write appends p, so that templates can be executed into the buffer
+func (buf *lineBuffer) Write(p []byte) (int, error) {
+	buf.b = append(buf.b, p...)
+	return len(p), nil
+}
+
+func putLineBuffer(buf *lineBuffer) {
+	if cap(buf.b) <= maxPooledBufferSize {
+		lineBufferPool.Put(buf)
+	}
+}
+
+// consoleEncoder renders event records like zerolog's ConsoleWriter does.
+// ConsoleWriter decodes every JSON log line into a map before printing it,
+// which dominates allocations on busy clusters. The encoder appends the
+// fields straight into a pooled buffer instead.
+type consoleEncoder struct {
+	noColor bool
+}
+
+// Encode appends the console line for record to b. Fields are sorted by name,
+// as ConsoleWriter does.
+func (e consoleEncoder) Encode(b []byte, record eventRecord, now time.Time) []byte {
+	if record.diff != nil {
+		return e.encodeDiff(b, record, now)
+	}
+	event := record.event
+
+	b = e.appendColorStart(b, colorDarkGray)
+	b = now.AppendFormat(b, time.RFC3339)
+	b = e.appendColorEnd(b)
+	b = append(b, ' ')
+	b = e.appendColorStart(b, colorGreen)
+	b = append(b, "INF"...)
+	b = e.appendColorEnd(b)
+	b = append(b, ' ')
+	b = append(b, record.message...)
+
+	b = e.appendKey(b, "age")
+	b = appendDuration(b, now.Sub(event.LastTimestamp.Time).Round(time.Second))
+	b = e.appendKey(b, "component")
+	b = append(b, "watcher"...)
+	b = e.appendKey(b, "count")
+	b = strconv.AppendInt(b, int64(event.Count), 10)
+	b = e.appendKey(b, "eventMsg")
+	b = appendValue(b, event.Message)
+	b = e.appendKey(b, "lastTimestamp")
+	b = event.LastTimestamp.UTC().AppendFormat(b, time.RFC3339)
+	if record.logs != "" {
+		b = e.appendKey(b, "logs")
+		b = appendValue(b, record.logs)
+	}
+	b = e.appendKey(b, "name")
+	b = appendValue(b, event.Name)
+	b = e.appendKey(b, "namespace")
+	b = appendValue(b, event.Namespace)
+	b = e.appendKey(b, "version")
+	b = appendValue(b, event.ResourceVersion)
+	return append(b, '\n')
+}
+
+func (e consoleEncoder) appendKey(b []byte, key string) []byte {
+	b = append(b, ' ')
+	b = e.appendColorStart(b, colorCyan)
+	b = append(b, key...)
+	b = append(b, '=')
+	return e.appendColorEnd(b)
+}
+
+func (e consoleEncoder) appendColorStart(b []byte, color int) []byte {
+	if e.noColor {
+		return b
+	}
+	b = append(b, "\x1b["...)
+	b = strconv.AppendInt(b, int64(color), 10)
+	return append(b, 'm')
+}
+
+func (e consoleEncoder) appendColorEnd(b []byte) []byte {
+	if e.noColor {
+		return b
+	}
+	return append(b, "\x1b[0m"...)
+}
+
+// appendValue appends s, quoted if it contains characters which would make
+// the line ambiguous
+func appendValue(b []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x20 || s[i] > 0x7e || s[i] == ' ' || s[i] == '\\' || s[i] == '"' {
+			return strconv.AppendQuote(b, s)
+		}
+	}
+	return append(b, s...)
+}
+
+// appendDuration appends d in the format of time.Duration.String for
+// durations rounded to seconds, without allocating
+func appendDuration(b []byte, d time.Duration) []byte {
+	if d < 0 {
+		b = append(b, '-')
+		d = -d
+	}
+	if d < time.Second {
+		return append(b, "0s"...)
+	}
+	h := int64(d / time.Hour)
+	m := int64(d % time.Hour / time.Minute)
+	s := int64(d % time.Minute / time.Second)
+	if h > 0 {
+		b = strconv.AppendInt(b, h, 10)
+		b = append(b, 'h')
+	}
+	if h > 0 || m > 0 {
+		b = strconv.AppendInt(b, m, 10)
+		b = append(b, 'm')
+	}
+	b = strconv.AppendInt(b, s, 10)
+	return append(b, 's')
+}
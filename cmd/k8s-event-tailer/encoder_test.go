@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func benchmarkRecord(now time.Time) eventRecord {
+	return eventRecord{
+		event: &corev1.Event{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "web-7d4b9c8f6-x2kzq.16f6b2c1a3e4d5f6",
+				Namespace:       "default",
+				ResourceVersion: "123456",
+			},
+			Message:       "Successfully pulled image \"nginx:1.23\" in 1.2s",
+			LastTimestamp: metav1.NewTime(now.Add(-90 * time.Second)),
+			Count:         3,
+		},
+		message: "ADDED",
+	}
+}
+
+// logConsoleWriter logs record the way event lines were written before
+// consoleEncoder, through zerolog's ConsoleWriter
+func logConsoleWriter(logger zerolog.Logger, record eventRecord, now time.Time) {
+	event := record.event
+	logger.Info().
+		Str("namespace", event.Namespace).
+		Str("name", event.Name).
+		Str("version", event.ResourceVersion).
+		Str("eventMsg", event.Message).
+		Str("lastTimestamp", event.LastTimestamp.UTC().Format(time.RFC3339)).
+		Str("age", now.Sub(event.LastTimestamp.Time).Round(time.Second).String()).
+		Int32("count", event.Count).
+		Msg(record.message)
+}
+
+func TestConsoleEncoderMatchesConsoleWriter(t *testing.T) {
+	now := time.Date(2022, 6, 10, 0, 10, 24, 0, time.Local)
+	record := benchmarkRecord(now)
+	defer func(f func() time.Time) { zerolog.TimestampFunc = f }(zerolog.TimestampFunc)
+	zerolog.TimestampFunc = func() time.Time { return now }
+
+	for _, noColor := range []bool{false, true} {
+		var want bytes.Buffer
+		logger := zerolog.New(zerolog.ConsoleWriter{Out: &want, NoColor: noColor, TimeFormat: time.RFC3339}).
+			With().Timestamp().Str("component", "watcher").Logger()
+		logConsoleWriter(logger, record, now)
+
+		got := consoleEncoder{noColor: noColor}.Encode(nil, record, now)
+		if string(got) != want.String() {
+			t.Errorf("noColor=%v:\n got %q\nwant %q", noColor, got, want.String())
+		}
+	}
+}
+
+func BenchmarkConsoleWriter(b *testing.B) {
+	now := time.Now()
+	record := benchmarkRecord(now)
+	logger := zerolog.New(zerolog.ConsoleWriter{Out: io.Discard, TimeFormat: time.RFC3339}).
+		With().Timestamp().Str("component", "watcher").Logger()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		logConsoleWriter(logger, record, now)
+	}
+}
+
+func BenchmarkConsoleEncoder(b *testing.B) {
+	now := time.Now()
+	record := benchmarkRecord(now)
+	var encoder consoleEncoder
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf := getLineBuffer()
+		buf.b = encoder.Encode(buf.b, record, now)
+		putLineBuffer(buf)
+	}
+}
+
+func BenchmarkEventLogger(b *testing.B) {
+	record := benchmarkRecord(time.Now())
+	el := &eventLogger{out: io.Discard}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		el.logEvent(record)
+	}
+}
+
+func BenchmarkEventLoggerJSON(b *testing.B) {
+	record := benchmarkRecord(time.Now())
+	el := &eventLogger{out: io.Discard, json: true}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		el.logEvent(record)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rs/zerolog/log"
+)
+
+// archiveMagic starts every encrypted archive object and carries the format
+// version
+var archiveMagic = []byte("KETENC1\n")
+
+// archiveCipher encrypts archive objects with AES-256-GCM before they are
+// written to disk or object storage. An encrypted object consists of
+// archiveMagic, a random nonce and the sealed content, with archiveMagic as
+// additional data.
+type archiveCipher struct {
+	aead cipher.AEAD
+	// hashKey is derived from the key for Hash
+	hashKey []byte
+}
+
+// newArchiveCipher reads a 256 bit key from keyFile. The key may be stored
+// raw, hex or base64 encoded, e.g. generated with `openssl rand -hex 32`.
+// It returns nil if keyFile is empty.
+func newArchiveCipher(keyFile string) (*archiveCipher, error) {
+	if keyFile == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := decodeArchiveKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive key %s: %w", keyFile, err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("index"))
+	return &archiveCipher{aead: aead, hashKey: mac.Sum(nil)}, nil
+}
+
+func decodeArchiveKey(data []byte) ([]byte, error) {
+	if len(data) == 32 {
+		return data, nil
+	}
+	text := string(bytes.TrimSpace(data))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	return nil, errors.New("expected 32 bytes, raw, hex or base64 encoded")
+}
+
+// Seal returns the encrypted object for content
+func (c *archiveCipher) Seal(content []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(archiveMagic)+len(nonce)+len(content)+c.aead.Overhead())
+	out = append(out, archiveMagic...)
+	out = append(out, nonce...)
+	return c.aead.Seal(out, nonce, content, archiveMagic), nil
+}
+
+// Hash returns a keyed hash of value, which indexes encrypted objects by
+// value without revealing it
+func (c *archiveCipher) Hash(value string) []byte {
+	mac := hmac.New(sha256.New, c.hashKey)
+	mac.Write([]byte(value))
+	return mac.Sum(nil)
+}
+
+// sealed reports whether object was encrypted by Seal
+func sealed(object []byte) bool {
+	return bytes.HasPrefix(object, archiveMagic)
+}
+
+// Open decrypts an object created by Seal
+func (c *archiveCipher) Open(object []byte) ([]byte, error) {
+	if !sealed(object) {
+		return nil, errors.New("not an encrypted archive object")
+	}
+	object = object[len(archiveMagic):]
+	if len(object) < c.aead.NonceSize() {
+		return nil, errors.New("truncated archive object")
+	}
+	nonce, sealed := object[:c.aead.NonceSize()], object[c.aead.NonceSize():]
+	return c.aead.Open(nil, nonce, sealed, archiveMagic)
+}
+
+// decrypt writes the archive object read from stdin decrypted to stdout,
+// e.g. to read an encrypted S3 object
+func decrypt() {
+	cipher, err := newArchiveCipher(*archiveKeyFile)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Could not read the archive encryption key")
+	}
+	if cipher == nil {
+		log.Fatal().Msg("--archive-encryption-key-file is required")
+	}
+	object, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Could not read the archive object")
+	}
+	content, err := cipher.Open(object)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Could not decrypt the archive object")
+	}
+	if _, err := os.Stdout.Write(content); err != nil {
+		log.Fatal().Err(err).Send()
+	}
+}
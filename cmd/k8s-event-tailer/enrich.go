@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog/log"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+// enrichmentAnnotation holds the objectEnrichment of the involved object on
+// enriched events, so that sinks and plugins get it with the event
+const enrichmentAnnotation = "k8s-event-tailer/involved-object"
+
+var enrichmentsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "event_enrichments_total",
+	Help: "Number of events of enriched kinds by result: found, or missing if the involved object was not cached",
+}, []string{"result"})
+
+// objectEnrichment describes the involved object of an event
+type objectEnrichment struct {
+	Labels map[string]string `json:"labels,omitempty"`
+	Owners []jsonOwnerRef    `json:"owners,omitempty"`
+	// NodeName is the node of pods
+	NodeName string `json:"nodeName,omitempty"`
+	// Controller is the top-level controller of the object, e.g. the
+	// Deployment of a pod, nil if no controller owns it
+	Controller *jsonOwnerRef `json:"controller,omitempty"`
+}
+
+type jsonOwnerRef struct {
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	Controller bool   `json:"controller,omitempty"`
+}
+
+// enrichResource is a resource --enrich can cache
+type enrichResource struct {
+	// kind is the kind of the involved objects it looks up
+	kind       string
+	namespaced bool
+	object     runtime.Object
+	client     func(kubernetes.Interface) rest.Interface
+}
+
+var enrichResources = map[string]enrichResource{
+	"pods": {kind: "Pod", namespaced: true, object: &corev1.Pod{}, client: func(c kubernetes.Interface) rest.Interface {
+		return c.CoreV1().RESTClient()
+	}},
+	"nodes": {kind: "Node", object: &corev1.Node{}, client: func(c kubernetes.Interface) rest.Interface {
+		return c.CoreV1().RESTClient()
+	}},
+	"deployments": {kind: "Deployment", namespaced: true, object: &appsv1.Deployment{}, client: func(c kubernetes.Interface) rest.Interface {
+		return c.AppsV1().RESTClient()
+	}},
+	"replicasets": {kind: "ReplicaSet", namespaced: true, object: &appsv1.ReplicaSet{}, client: func(c kubernetes.Interface) rest.Interface {
+		return c.AppsV1().RESTClient()
+	}},
+	"jobs": {kind: "Job", namespaced: true, object: &batchv1.Job{}, client: func(c kubernetes.Interface) rest.Interface {
+		return c.BatchV1().RESTClient()
+	}},
+}
+
+// maxOwnerChain bounds the owner chain, owner references may form a cycle
+const maxOwnerChain = 10
+
+// objectEnricher adds the labels, owners, node and top-level controller of
+// the involved object to events. The objects of the enriched resources are cached by informers,
+// stripped down to these fields, so that events are not delayed by lookups
+// and the API server is not queried for every event.
+type objectEnricher struct {
+	resources []string
+	// stores hold the cached objects by kind, one per watched namespace
+	stores map[string][]cache.Store
+}
+
+// newObjectEnricher returns the enricher of the resources in list, like
+// pods,nodes, nil if there are none
+func newObjectEnricher(list []string) (*objectEnricher, error) {
+	e := &objectEnricher{stores: map[string][]cache.Store{}}
+	seen := map[string]bool{}
+	for _, item := range list {
+		for _, resource := range strings.Split(item, ",") {
+			resource = strings.ToLower(strings.TrimSpace(resource))
+			if resource == "" || seen[resource] {
+				continue
+			}
+			if _, ok := enrichResources[resource]; !ok {
+				return nil, fmt.Errorf("unknown resource %q, expected one of %s", resource, strings.Join(enrichResourceNames(), ", "))
+			}
+			seen[resource] = true
+			e.resources = append(e.resources, resource)
+		}
+	}
+	if len(e.resources) == 0 {
+		return nil, nil
+	}
+	return e, nil
+}
+
+func enrichResourceNames() []string {
+	names := make([]string, 0, len(enrichResources))
+	for name := range enrichResources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Start caches the objects of the resources in namespaces until ctx is done.
+// It returns once they have been listed, so that the first events are
+// enriched as well.
+func (e *objectEnricher) Start(ctx context.Context, client kubernetes.Interface, namespaces []string) {
+	if e == nil {
+		return
+	}
+	var synced []cache.InformerSynced
+	for _, name := range e.resources {
+		resource := enrichResources[name]
+		watched := namespaces
+		if !resource.namespaced || len(watched) == 0 {
+			watched = []string{corev1.NamespaceAll}
+		}
+		for _, namespace := range watched {
+			watchlist := cache.NewListWatchFromClient(resource.client(client), name, namespace, fields.Everything())
+			store, controller := cache.NewTransformingInformer(watchlist, resource.object, 0,
+				cache.ResourceEventHandlerFuncs{}, stripEnrichedObject)
+			e.stores[resource.kind] = append(e.stores[resource.kind], store)
+			go controller.Run(ctx.Done())
+			synced = append(synced, controller.HasSynced)
+		}
+	}
+	if !cache.WaitForCacheSync(ctx.Done(), synced...) {
+		return
+	}
+	log.Info().Strs("resources", e.resources).Msg("Cached objects for enrichment")
+}
+
+// stripEnrichedObject keeps only the fields of objects enrichment needs
+func stripEnrichedObject(obj interface{}) (interface{}, error) {
+	if _, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		return obj, nil
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, err
+	}
+	stripped := metav1.ObjectMeta{
+		Name:            accessor.GetName(),
+		Namespace:       accessor.GetNamespace(),
+		UID:             accessor.GetUID(),
+		ResourceVersion: accessor.GetResourceVersion(),
+		Labels:          accessor.GetLabels(),
+		OwnerReferences: accessor.GetOwnerReferences(),
+	}
+	if pod, ok := obj.(*corev1.Pod); ok {
+		return &corev1.Pod{ObjectMeta: stripped, Spec: corev1.PodSpec{NodeName: pod.Spec.NodeName}}, nil
+	}
+	return &metav1.PartialObjectMetadata{ObjectMeta: stripped}, nil
+}
+
+// lookup returns the cached object of kind, nil if it is not cached
+func (e *objectEnricher) lookup(kind, namespace, name string) metav1.Object {
+	key := name
+	if namespace != "" {
+		key = namespace + "/" + name
+	}
+	for _, store := range e.stores[kind] {
+		if obj, ok, _ := store.GetByKey(key); ok {
+			if accessor, err := meta.Accessor(obj); err == nil {
+				return accessor
+			}
+		}
+	}
+	return nil
+}
+
+// Enrich returns a copy of event with the enrichmentAnnotation, or event
+// itself if its involved object is not cached
+func (e *objectEnricher) Enrich(event *corev1.Event) *corev1.Event {
+	if e == nil {
+		return event
+	}
+	ref := event.InvolvedObject
+	if _, ok := e.stores[ref.Kind]; !ok {
+		return event
+	}
+	obj := e.lookup(ref.Kind, ref.Namespace, ref.Name)
+	// a recreated object with the same name is not the one of the event
+	if obj == nil || (ref.UID != "" && ref.UID != obj.GetUID()) {
+		enrichmentsCounter.WithLabelValues("missing").Inc()
+		return event
+	}
+	enrichmentsCounter.WithLabelValues("found").Inc()
+	enrichment := objectEnrichment{Labels: obj.GetLabels()}
+	for _, owner := range obj.GetOwnerReferences() {
+		enrichment.Owners = append(enrichment.Owners, jsonOwnerRef{
+			Kind:       owner.Kind,
+			Name:       owner.Name,
+			Controller: owner.Controller != nil && *owner.Controller,
+		})
+	}
+	if pod, ok := obj.(*corev1.Pod); ok {
+		enrichment.NodeName = pod.Spec.NodeName
+	}
+	enrichment.Controller = e.topController(obj)
+	data, err := json.Marshal(enrichment)
+	if err != nil {
+		return event
+	}
+	// the event belongs to the informer, don't modify it
+	enriched := *event
+	enriched.Annotations = make(map[string]string, len(event.Annotations)+1)
+	for key, value := range event.Annotations {
+		enriched.Annotations[key] = value
+	}
+	enriched.Annotations[enrichmentAnnotation] = string(data)
+	return &enriched
+}
+
+// topController follows the controller owner references of obj through the
+// cached objects, e.g. from a pod to its ReplicaSet and Deployment. The chain
+// ends at an owner which is not cached, like the StatefulSet of a pod, or
+// the ReplicaSet of a pod without --enrich=replicasets. It returns nil if no
+// controller owns obj.
+func (e *objectEnricher) topController(obj metav1.Object) *jsonOwnerRef {
+	var top *jsonOwnerRef
+	namespace := obj.GetNamespace()
+	for i := 0; i < maxOwnerChain; i++ {
+		owner := metav1.GetControllerOfNoCopy(obj)
+		if owner == nil {
+			break
+		}
+		top = &jsonOwnerRef{Kind: owner.Kind, Name: owner.Name, Controller: true}
+		if obj = e.lookup(owner.Kind, namespace, owner.Name); obj == nil || obj.GetUID() != owner.UID {
+			break
+		}
+	}
+	return top
+}
+
+// eventEnrichment returns the enrichment of an enriched event, nil otherwise
+func eventEnrichment(event *corev1.Event) *objectEnrichment {
+	data, ok := event.Annotations[enrichmentAnnotation]
+	if !ok {
+		return nil
+	}
+	enrichment := &objectEnrichment{}
+	if err := json.Unmarshal([]byte(data), enrichment); err != nil {
+		return nil
+	}
+	return enrichment
+}
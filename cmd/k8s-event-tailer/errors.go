@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	"k8s-event-tailer/pkg/extension"
+)
+
+// Error classes used in logs and metrics
+const (
+	errorClassRetryable = "retryable"
+	errorClassPermanent = "permanent"
+)
+
+// classifyError tells transient failures, which are worth retrying, apart from
+// permanent ones, which usually point to a misconfiguration.
+func classifyError(err error) string {
+	switch {
+	case extension.IsPermanent(err):
+		return errorClassPermanent
+	case errors.Is(err, context.Canceled):
+		return errorClassPermanent
+	case apierrors.IsUnauthorized(err),
+		apierrors.IsForbidden(err),
+		apierrors.IsNotFound(err),
+		apierrors.IsBadRequest(err),
+		apierrors.IsInvalid(err),
+		apierrors.IsMethodNotSupported(err),
+		apierrors.IsNotAcceptable(err),
+		apierrors.IsUnsupportedMediaType(err):
+		return errorClassPermanent
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return errorClassPermanent
+	}
+	// timeouts, throttling, server errors and anything unknown
+	return errorClassRetryable
+}
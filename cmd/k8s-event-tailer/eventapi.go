@@ -0,0 +1,173 @@
+package main
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	eventsv1 "k8s.io/api/events/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// APIs events can be watched with
+const (
+	eventAPIAuto     = "auto"
+	eventAPICoreV1   = "core/v1"
+	eventAPIEventsV1 = "events.k8s.io/v1"
+)
+
+var eventAPIs = []string{eventAPIAuto, eventAPICoreV1, eventAPIEventsV1}
+
+// resolveEventAPI picks events.k8s.io/v1 in auto mode if the API server
+// serves it, core/v1 otherwise
+func (ew *EventWatcher) resolveEventAPI() string {
+	if ew.eventAPI != eventAPIAuto && ew.eventAPI != "" {
+		return ew.eventAPI
+	}
+	ew.clientMu.Lock()
+	client := ew.client
+	ew.clientMu.Unlock()
+	resources, err := client.Discovery().ServerResourcesForGroupVersion(eventsv1.SchemeGroupVersion.String())
+	if err != nil {
+		ew.logger.Info().Err(err).Msgf("Using the %s event API, %s is not available", eventAPICoreV1, eventAPIEventsV1)
+		return eventAPICoreV1
+	}
+	for _, resource := range resources.APIResources {
+		if resource.Name == "events" {
+			ew.logger.Info().Msgf("Using the %s event API", eventAPIEventsV1)
+			return eventAPIEventsV1
+		}
+	}
+	ew.logger.Info().Msgf("Using the %s event API, %s does not serve events", eventAPICoreV1, eventAPIEventsV1)
+	return eventAPICoreV1
+}
+
+// eventsListerWatcher lists and watches the events in namespace matching the
+// field and label selectors with the resolved event API. events.k8s.io/v1
+// events are converted to core/v1, so the rest of the pipeline handles both
+// alike.
+func (ew *EventWatcher) eventsListerWatcher(namespace string) cache.ListerWatcher {
+	ew.clientMu.Lock()
+	client := ew.client
+	ew.clientMu.Unlock()
+	fieldSelector := ew.fieldSelector
+	if ew.eventAPI == eventAPIEventsV1 {
+		fieldSelector = eventsV1FieldSelector(fieldSelector)
+	}
+	selectors := func(options *metav1.ListOptions) {
+		options.FieldSelector = fieldSelector
+		options.LabelSelector = ew.labelSelector
+	}
+	if ew.eventAPI == eventAPIEventsV1 {
+		return &eventsV1ListerWatcher{
+			ListerWatcher: cache.NewFilteredListWatchFromClient(client.EventsV1().RESTClient(), "events", namespace, selectors),
+		}
+	}
+	return cache.NewFilteredListWatchFromClient(client.CoreV1().RESTClient(), "events", namespace, selectors)
+}
+
+// eventsV1FieldSelector renames the core/v1 fields of selector to their
+// events.k8s.io/v1 names, so that the same selector works with both APIs
+func eventsV1FieldSelector(selector string) string {
+	if selector == "" {
+		return ""
+	}
+	requirements, err := fields.ParseSelector(selector)
+	if err != nil {
+		return selector
+	}
+	renamed, err := requirements.Transform(func(field, value string) (string, string, error) {
+		if strings.HasPrefix(field, "involvedObject.") {
+			field = "regarding." + strings.TrimPrefix(field, "involvedObject.")
+		}
+		return field, value, nil
+	})
+	if err != nil {
+		return selector
+	}
+	return renamed.String()
+}
+
+// eventsV1ListerWatcher converts the events.k8s.io/v1 events of lists and
+// watches to core/v1 events
+type eventsV1ListerWatcher struct {
+	cache.ListerWatcher
+}
+
+func (lw *eventsV1ListerWatcher) List(options metav1.ListOptions) (runtime.Object, error) {
+	obj, err := lw.ListerWatcher.List(options)
+	if err != nil {
+		return nil, err
+	}
+	list, ok := obj.(*eventsv1.EventList)
+	if !ok {
+		return obj, nil
+	}
+	converted := &corev1.EventList{ListMeta: list.ListMeta, Items: make([]corev1.Event, len(list.Items))}
+	for i := range list.Items {
+		converted.Items[i] = *coreEvent(&list.Items[i])
+	}
+	return converted, nil
+}
+
+func (lw *eventsV1ListerWatcher) Watch(options metav1.ListOptions) (watch.Interface, error) {
+	w, err := lw.ListerWatcher.Watch(options)
+	if err != nil {
+		return nil, err
+	}
+	return watch.Filter(w, func(in watch.Event) (watch.Event, bool) {
+		if event, ok := in.Object.(*eventsv1.Event); ok {
+			in.Object = coreEvent(event)
+		}
+		return in, true
+	}), nil
+}
+
+// coreEvent converts an events.k8s.io/v1 event to core/v1. Events reported
+// with the new API only have an event time, which is used for the count and
+// timestamps they lack.
+func coreEvent(event *eventsv1.Event) *corev1.Event {
+	converted := &corev1.Event{
+		ObjectMeta:          event.ObjectMeta,
+		InvolvedObject:      event.Regarding,
+		Related:             event.Related,
+		Reason:              event.Reason,
+		Message:             event.Note,
+		Type:                event.Type,
+		Action:              event.Action,
+		EventTime:           event.EventTime,
+		FirstTimestamp:      event.DeprecatedFirstTimestamp,
+		LastTimestamp:       event.DeprecatedLastTimestamp,
+		Count:               event.DeprecatedCount,
+		ReportingController: event.ReportingController,
+		ReportingInstance:   event.ReportingInstance,
+		Source: corev1.EventSource{
+			Component: event.DeprecatedSource.Component,
+			Host:      event.DeprecatedSource.Host,
+		},
+	}
+	if event.Series != nil {
+		converted.Series = &corev1.EventSeries{
+			Count:            event.Series.Count,
+			LastObservedTime: event.Series.LastObservedTime,
+		}
+		converted.Count = event.Series.Count
+		converted.LastTimestamp = metav1.NewTime(event.Series.LastObservedTime.Time)
+	}
+	if converted.LastTimestamp.IsZero() {
+		converted.LastTimestamp = metav1.NewTime(event.EventTime.Time)
+	}
+	if converted.FirstTimestamp.IsZero() {
+		converted.FirstTimestamp = metav1.NewTime(event.EventTime.Time)
+	}
+	if converted.Count == 0 {
+		converted.Count = 1
+	}
+	if converted.Source.Component == "" {
+		converted.Source.Component = event.ReportingController
+	}
+	return converted
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	defaultEventBridgeSource = "k8s-event-tailer"
+	// maxEventBridgeEntries is the limit of entries per PutEvents call
+	maxEventBridgeEntries = 10
+)
+
+// eventBridgeSink publishes events to an Amazon EventBridge event bus.
+// Rules can match the source, the detail type "Kubernetes Warning Event" or
+// "Kubernetes Normal Event" and any field of the JSON event in detail.
+type eventBridgeSink struct {
+	endpoint string
+	bus      string
+	source   string
+	signer   *awsSigner
+	client   *http.Client
+}
+
+type eventBridgeEntry struct {
+	Source       string    `json:"Source"`
+	DetailType   string    `json:"DetailType"`
+	Detail       string    `json:"Detail"`
+	EventBusName string    `json:"EventBusName,omitempty"`
+	Time         time.Time `json:"Time"`
+}
+
+type eventBridgeResponse struct {
+	FailedEntryCount int `json:"FailedEntryCount"`
+	Entries          []struct {
+		ErrorCode    string `json:"ErrorCode"`
+		ErrorMessage string `json:"ErrorMessage"`
+	} `json:"Entries"`
+}
+
+func newEventBridgeSink(bus, region, source string, policy *tlsPolicy) (*eventBridgeSink, error) {
+	signer, err := newAWSSigner(region, "events", policy)
+	if err != nil {
+		return nil, fmt.Errorf("eventbridge: %w", err)
+	}
+	return &eventBridgeSink{
+		endpoint: fmt.Sprintf("https://events.%s.amazonaws.com/", region),
+		bus:      bus,
+		source:   source,
+		signer:   signer,
+		client:   policy.HTTPClient(30 * time.Second),
+	}, nil
+}
+
+func (s *eventBridgeSink) Name() string {
+	return "eventbridge"
+}
+
+func (s *eventBridgeSink) Write(event *corev1.Event) error {
+	return s.WriteBatch([]*corev1.Event{event})
+}
+
+// WriteBatch puts the events with as few calls as the entry limit allows.
+// EventBridge accepts entries individually, so a retry after partly failed
+// batch may deliver some entries twice.
+func (s *eventBridgeSink) WriteBatch(events []*corev1.Event) error {
+	for len(events) > 0 {
+		n := len(events)
+		if n > maxEventBridgeEntries {
+			n = maxEventBridgeEntries
+		}
+		if err := s.putEvents(events[:n]); err != nil {
+			return err
+		}
+		events = events[n:]
+	}
+	return nil
+}
+
+func (s *eventBridgeSink) putEvents(events []*corev1.Event) error {
+	entries := make([]eventBridgeEntry, 0, len(events))
+	for _, event := range events {
+		detail, err := json.Marshal(newJSONEvent(event))
+		if err != nil {
+			return err
+		}
+		entries = append(entries, eventBridgeEntry{
+			Source:       s.source,
+			DetailType:   "Kubernetes " + event.Type + " Event",
+			Detail:       string(detail),
+			EventBusName: s.bus,
+			Time:         eventTime(event).UTC(),
+		})
+	}
+	body, err := json.Marshal(struct {
+		Entries []eventBridgeEntry `json:"Entries"`
+	}{entries})
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "AWSEvents.PutEvents")
+	if err := s.signer.Sign(ctx, req, body); err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+	var result eventBridgeResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&result); err != nil {
+		return err
+	}
+	if result.FailedEntryCount == 0 {
+		return nil
+	}
+	var reasons []string
+	for _, entry := range result.Entries {
+		if entry.ErrorCode != "" {
+			reasons = append(reasons, entry.ErrorCode+": "+entry.ErrorMessage)
+		}
+	}
+	return fmt.Errorf("%d of %d entries failed: %s", result.FailedEntryCount, len(entries), strings.Join(reasons, "; "))
+}
+
+func (s *eventBridgeSink) Close() error {
+	return nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const defaultEventBufferSize = 5000
+
+// eventBuffer keeps the last published events numbered with increasing ids.
+// It serves the queries for recent events, and the streams read from it, so
+// that clients reconnecting with Last-Event-ID get the events they missed. A
+// stream falling behind by more than the buffer skips the events in between.
+type eventBuffer struct {
+	mu   sync.Mutex
+	size int
+	// records hold consecutive ids, the oldest first
+	records []bufferedEvent
+	lastID  uint64
+	// updated is closed and replaced when a record is added
+	updated chan struct{}
+}
+
+type bufferedEvent struct {
+	id    uint64
+	event *corev1.Event
+}
+
+func newEventBuffer(size int) *eventBuffer {
+	if size < 1 {
+		size = 1
+	}
+	return &eventBuffer{size: size, updated: make(chan struct{})}
+}
+
+// Subscribe adds every published event to the buffer
+func (b *eventBuffer) Subscribe(bus *eventBus) {
+	bus.Subscribe("event-buffer", subscriberOptions{lossy: true}, func(record eventRecord) {
+		b.add(record.event)
+	})
+}
+
+func (b *eventBuffer) add(event *corev1.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastID++
+	if len(b.records) == 2*b.size {
+		// compact instead of shifting on every add
+		b.records = append(b.records[:0], b.records[b.size:]...)
+	}
+	b.records = append(b.records, bufferedEvent{id: b.lastID, event: event})
+	close(b.updated)
+	b.updated = make(chan struct{})
+}
+
+// after returns the buffered records with an id above id, and a channel
+// closed when further records are added
+func (b *eventBuffer) after(id uint64) ([]bufferedEvent, <-chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	records := b.records
+	if len(records) > b.size {
+		records = records[len(records)-b.size:]
+	}
+	if len(records) == 0 || id >= b.lastID {
+		return nil, b.updated
+	}
+	if first := records[0].id; id >= first {
+		records = records[id-first+1:]
+	}
+	return append([]bufferedEvent(nil), records...), b.updated
+}
+
+// lastEventID returns the id of the last buffered event
+func (b *eventBuffer) lastEventID() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.lastID
+}
+
+// eventList is a page of buffered events, the newest first
+type eventList struct {
+	Items []eventListItem `json:"items"`
+	// Continue is passed as continue to get the next, older page, empty on
+	// the last page
+	Continue string `json:"continue,omitempty"`
+}
+
+type eventListItem struct {
+	ID uint64 `json:"id"`
+	jsonEvent
+}
+
+// eventQuery selects buffered events by the query parameters of
+// /api/v1/events
+type eventQuery struct {
+	filter streamFilter
+	// since is the earliest time events were last seen, zero for all
+	since time.Time
+	// kind and name of the involved object, empty for all
+	kind, name string
+}
+
+// parseEventQuery parses the namespace, type and reason parameters like
+// for the streams, since as duration or RFC 3339 time and involvedObject as
+// KIND/NAME or NAME
+func parseEventQuery(query url.Values, now time.Time) (eventQuery, error) {
+	q := eventQuery{filter: newStreamFilter(query)}
+	if since := query.Get("since"); since != "" {
+		if d, err := time.ParseDuration(since); err == nil {
+			q.since = now.Add(-d)
+		} else if q.since, err = time.Parse(time.RFC3339, since); err != nil {
+			return q, fmt.Errorf("since must be a duration or an RFC 3339 time")
+		}
+	}
+	if object := query.Get("involvedObject"); object != "" {
+		if i := strings.Index(object, "/"); i >= 0 {
+			q.kind, q.name = object[:i], object[i+1:]
+		} else {
+			q.name = object
+		}
+	}
+	return q, nil
+}
+
+func (q eventQuery) Matches(event *corev1.Event) bool {
+	obj := event.InvolvedObject
+	return q.filter.Matches(event) &&
+		(q.since.IsZero() || !eventTime(event).Before(q.since)) &&
+		(q.kind == "" || strings.EqualFold(obj.Kind, q.kind)) &&
+		(q.name == "" || obj.Name == q.name)
+}
+
+// handler serves the buffered events matching the query parameters of
+// parseEventQuery, the newest first, in pages of limit events. The continue
+// value of a page is passed as continue to get the next one.
+func (b *eventBuffer) handler(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	query, err := parseEventQuery(params, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit := defaultStoreListLimit
+	if value := params.Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > maxStoreListLimit {
+			http.Error(w, "limit must be between 1 and "+strconv.Itoa(maxStoreListLimit), http.StatusBadRequest)
+			return
+		}
+	}
+	before := uint64(math.MaxUint64)
+	if value := params.Get("continue"); value != "" {
+		if before, err = strconv.ParseUint(value, 10, 64); err != nil {
+			http.Error(w, "invalid continue", http.StatusBadRequest)
+			return
+		}
+	}
+
+	records, _ := b.after(0)
+	list := eventList{Items: []eventListItem{}}
+	for i := len(records) - 1; i >= 0; i-- {
+		record := records[i]
+		if record.id >= before || !query.Matches(record.event) {
+			continue
+		}
+		if len(list.Items) == limit {
+			list.Continue = strconv.FormatUint(list.Items[limit-1].ID, 10)
+			break
+		}
+		list.Items = append(list.Items, eventListItem{ID: record.id, jsonEvent: newJSONEvent(record.event)})
+	}
+
+	w.Header().Add("Content-Type", "application/json; charset=UTF-8")
+	if err := json.NewEncoder(w).Encode(list); err != nil {
+		log.Error().Err(err).Msg("Could not write events")
+	}
+}
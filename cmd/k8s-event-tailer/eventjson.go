@@ -0,0 +1,81 @@
+package main
+
+import (
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// jsonEvent is the JSON representation of events written by the tailer. Its
+// fields are kept stable, so that consumers do not depend on the Kubernetes
+// Event type.
+type jsonEvent struct {
+	Timestamp      time.Time       `json:"timestamp"`
+	Namespace      string          `json:"namespace"`
+	Name           string          `json:"name"`
+	UID            string          `json:"uid"`
+	Type           string          `json:"type"`
+	Reason         string          `json:"reason"`
+	Message        string          `json:"message"`
+	Count          int32           `json:"count"`
+	InvolvedObject jsonObjectRef   `json:"involvedObject"`
+	Source         jsonEventSource `json:"source"`
+	FirstTimestamp *time.Time      `json:"firstTimestamp,omitempty"`
+	LastTimestamp  *time.Time      `json:"lastTimestamp,omitempty"`
+}
+
+type jsonObjectRef struct {
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+	UID        string `json:"uid,omitempty"`
+	APIVersion string `json:"apiVersion,omitempty"`
+	FieldPath  string `json:"fieldPath,omitempty"`
+	// objectEnrichment is set for events enriched with --enrich
+	objectEnrichment
+}
+
+type jsonEventSource struct {
+	Component string `json:"component,omitempty"`
+	Host      string `json:"host,omitempty"`
+}
+
+// newJSONEvent converts event into its JSON representation. Timestamp is the
+// time the event was last seen.
+func newJSONEvent(event *corev1.Event) jsonEvent {
+	obj := event.InvolvedObject
+	out := jsonEvent{
+		Timestamp: eventTime(event).UTC(),
+		Namespace: event.Namespace,
+		Name:      event.Name,
+		UID:       string(event.UID),
+		Type:      event.Type,
+		Reason:    event.Reason,
+		Message:   event.Message,
+		Count:     event.Count,
+		InvolvedObject: jsonObjectRef{
+			Kind:       obj.Kind,
+			Namespace:  obj.Namespace,
+			Name:       obj.Name,
+			UID:        string(obj.UID),
+			APIVersion: obj.APIVersion,
+			FieldPath:  obj.FieldPath,
+		},
+		Source: jsonEventSource{
+			Component: event.Source.Component,
+			Host:      event.Source.Host,
+		},
+	}
+	if enrichment := eventEnrichment(event); enrichment != nil {
+		out.InvolvedObject.objectEnrichment = *enrichment
+	}
+	if !event.FirstTimestamp.IsZero() {
+		first := event.FirstTimestamp.UTC()
+		out.FirstTimestamp = &first
+	}
+	if !event.LastTimestamp.IsZero() {
+		last := event.LastTimestamp.UTC()
+		out.LastTimestamp = &last
+	}
+	return out
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// eventLogger writes published events to the application log output. Event
+// lines bypass zerolog, see consoleEncoder.
+type eventLogger struct {
+	mu      sync.Mutex
+	out     io.Writer
+	encoder consoleEncoder
+	sampler *logSampler
+	// json writes JSON lines instead of console lines
+	json bool
+	// format writes lines of the template instead, see parseLineFormat
+	format *template.Template
+}
+
+// subscribeEventLogger logs the published events to out. sampler may be nil
+// to log every event, format nil to write console or JSON lines.
+func subscribeEventLogger(bus *eventBus, out io.Writer, sampler *logSampler, jsonOutput bool, format *template.Template) {
+	el := &eventLogger{out: out, sampler: sampler, json: jsonOutput, format: format}
+	bus.Subscribe("log", subscriberOptions{}, el.logEvent)
+}
+
+func (el *eventLogger) logEvent(record eventRecord) {
+	if log.Logger.GetLevel() > zerolog.InfoLevel || !el.sampler.Sample(record.event) {
+		return
+	}
+	buf := getLineBuffer()
+	if el.format != nil {
+		if err := el.format.Execute(buf, newJSONEventRecord(record, time.Now())); err != nil {
+			putLineBuffer(buf)
+			log.Error().Err(err).Msg("Could not format event")
+			return
+		}
+		if len(buf.b) == 0 || buf.b[len(buf.b)-1] != '\n' {
+			buf.b = append(buf.b, '\n')
+		}
+	} else if el.json {
+		var err error
+		if buf.b, err = encodeJSON(buf.b, record, time.Now()); err != nil {
+			putLineBuffer(buf)
+			log.Error().Err(err).Msg("Could not encode event")
+			return
+		}
+	} else {
+		buf.b = el.encoder.Encode(buf.b, record, time.Now())
+	}
+
+	el.mu.Lock()
+	_, err := el.out.Write(buf.b)
+	el.mu.Unlock()
+	putLineBuffer(buf)
+
+	if err != nil {
+		log.Error().Err(err).Msg("Could not write event")
+	}
+}
+
+// jsonEventRecord is a log line of an event in JSON output mode. The event
+// fields are inlined next to the fields of zerolog's JSON lines.
+type jsonEventRecord struct {
+	Time      time.Time `json:"time"`
+	Level     string    `json:"level"`
+	Component string    `json:"component"`
+	Msg       string    `json:"msg"`
+	jsonEvent
+	// CountDelta is the increase of the count of a repeated event in update
+	// diff mode
+	CountDelta int32  `json:"countDelta,omitempty"`
+	Logs       string `json:"logs,omitempty"`
+}
+
+func newJSONEventRecord(record eventRecord, now time.Time) jsonEventRecord {
+	line := jsonEventRecord{
+		Time:      now.UTC(),
+		Level:     zerolog.InfoLevel.String(),
+		Component: "watcher",
+		Msg:       record.message,
+		jsonEvent: newJSONEvent(record.event),
+		Logs:      record.logs,
+	}
+	if record.diff != nil {
+		line.CountDelta = record.diff.countDelta
+	}
+	return line
+}
+
+// encodeJSON appends the JSON line for record to b
+func encodeJSON(b []byte, record eventRecord, now time.Time) ([]byte, error) {
+	data, err := json.Marshal(newJSONEventRecord(record, now))
+	if err != nil {
+		return b, err
+	}
+	b = append(b, data...)
+	return append(b, '\n'), nil
+}
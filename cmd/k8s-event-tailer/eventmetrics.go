@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/hex"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// eventMetrics holds the series derived from events, as opposed to the
+// metrics about the tailer itself. They are served on /metrics and can be
+// pushed with remote write.
+var eventMetrics = prometheus.NewRegistry()
+
+// metricRules counts the events matching each rule by namespace
+type metricRules struct {
+	rules   []*eventRule
+	matches *prometheus.CounterVec
+}
+
+func newMetricRules(rules []*eventRule) *metricRules {
+	m := &metricRules{
+		rules: rules,
+		matches: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "k8s_event_rule_matches_total",
+			Help: "Number of events matching an event-to-metric rule by namespace",
+		}, []string{"rule", "namespace"}),
+	}
+	eventMetrics.MustRegister(m.matches)
+	return m
+}
+
+// Subscribe counts every published event
+func (m *metricRules) Subscribe(bus *eventBus) {
+	bus.Subscribe("metric-rules", subscriberOptions{}, func(record eventRecord) {
+		m.observe(record)
+	})
+}
+
+func (m *metricRules) observe(record eventRecord) {
+	event := record.event
+	for _, rule := range m.rules {
+		if rule.Matches(event) {
+			incWithExemplar(m.matches.WithLabelValues(rule.name, event.Namespace), eventExemplar(event, record.trace))
+		}
+	}
+}
+
+// eventExemplar identifies event and its trace, if traced, in exemplars, so
+// that a metric spike leads to the events behind it
+func eventExemplar(event *corev1.Event, trace spanContext) prometheus.Labels {
+	exemplar := prometheus.Labels{"event_uid": string(event.UID)}
+	if trace.valid() {
+		exemplar["trace_id"] = hex.EncodeToString(trace.traceID[:])
+	}
+	return exemplar
+}
+
+// incWithExemplar increments counter, with exemplar if the counter supports
+// exemplars. Exemplars are only exposed in the OpenMetrics format.
+func incWithExemplar(counter prometheus.Counter, exemplar prometheus.Labels) {
+	if adder, ok := counter.(prometheus.ExemplarAdder); ok {
+		adder.AddWithExemplar(1, exemplar)
+		return
+	}
+	counter.Inc()
+}
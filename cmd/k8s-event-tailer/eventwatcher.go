@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -10,45 +11,266 @@ import (
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/fields"
-	"k8s.io/client-go/rest"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
+
+	"k8s-event-tailer/pkg/extension"
 )
 
 const oldEventAgeMinutes = 5
 
+// Messages of the event records by watch notification
+const (
+	eventAddedMessage   = "Event added"
+	eventUpdatedMessage = "Event updated"
+	eventDeletedMessage = "Event deleted"
+)
+
 type EventWatcher struct {
-	client    rest.Interface
-	namespace string
-	logger    zerolog.Logger
-
-	_startTime       time.Time
-	_store           cache.Store
-	_controller      cache.Controller
-	startTimeGauge   prometheus.Gauge
-	storeSizeGauge   prometheus.GaugeFunc
-	addCounter       prometheus.Counter
-	updateCounter    prometheus.Counter
-	deleteCounter    prometheus.Counter
-	oldEventsCounter prometheus.Counter
-}
+	client     kubernetes.Interface
+	namespaces []string
+	queue      *deliveryQueue
+	filtersMu  sync.RWMutex
+	filters    []extension.Filter
+	redactor   *redactor
+	bus        *eventBus
+	logger     zerolog.Logger
 
-func (ew *EventWatcher) Run(stopChan chan struct{}, wg *sync.WaitGroup) {
-	defer wg.Done()
+	// statsInterval is how often stats are logged, zero disables it
+	statsInterval time.Duration
+	// heartbeatInterval is how often a heartbeat is logged, zero disables it
+	heartbeatInterval time.Duration
+	// shardByNamespace runs one informer per namespace instead of a single
+	// cluster-wide one
+	shardByNamespace bool
+	// watchOnly runs bare reflectors without an informer store
+	watchOnly bool
+	// replicaSharding splits the namespaces among several replicas, implies
+	// shardByNamespace
+	replicaSharding replicaSharding
+	// leader only lets the leading replica deliver events, nil if not
+	// running in HA mode
+	leader *leaderGate
+	// stallTimeout is how long a watch may go without any activity before
+	// its informer is restarted, zero disables the watchdog
+	stallTimeout time.Duration
+	// watchBrokenThreshold is how long lists and watches may keep failing
+	// before the watcher is not ready anymore, zero ignores failures
+	watchBrokenThreshold time.Duration
+	// readinessFreshness is how long ago the last list or watch event of
+	// every informer may be for the watcher to be ready, zero disables it
+	readinessFreshness time.Duration
+	// listPageSize is the number of events fetched per list request, zero
+	// lists all events at once
+	listPageSize int64
+	// fieldSelector and labelSelector restrict the watched events server
+	// side, empty selects all events
+	fieldSelector string
+	labelSelector string
+	// eventAPI is the API events are watched with, auto picks
+	// events.k8s.io/v1 if available
+	eventAPI string
+	// newClient builds a client with reloaded credentials after the API
+	// server rejected the current ones, nil keeps using client
+	newClient func() (kubernetes.Interface, error)
+	// tracer records the pipeline stages of events, nil disables tracing
+	tracer *tracer
+	// differ logs repeated events with their changes only, nil logs them
+	// in full
+	differ *updateDiffer
+	// limiter caps the events per involved object, per namespace and in
+	// total, nil disables it
+	limiter *eventLimiter
+	// deduplicator suppresses repeated events, nil disables it
+	deduplicator *deduplicator
+	// enricher adds the labels, owners and node of the involved object to
+	// events, nil disables it
+	enricher *objectEnricher
+	// logCapturer attaches pod logs to matching events, nil disables it
+	logCapturer *logCapturer
+	// snapshotter attaches the state of the involved object to matching
+	// events, nil disables it
+	snapshotter *objectSnapshotter
+	// optOut watches the namespaces for the opt out annotation, nil
+	// disables it. It is one of filters as well.
+	optOut *namespaceOptOut
+	// labelLimiter caps the label sets of the event counters, nil does not
+	// limit them
+	labelLimiter *labelLimiter
+	// resume saves the resourceVersions of the shards to watch from them
+	// after a restart, nil lists all events on every start
+	resume *watchResume
+	// kubeconfigPaths are polled every kubeconfigReloadInterval, changes
+	// rebuild the client with newClient, zero disables it
+	kubeconfigPaths          []string
+	kubeconfigReloadInterval time.Duration
 
-	watchlist := cache.NewListWatchFromClient(ew.client, "events", ew.namespace, fields.Everything())
-	store, controller := cache.NewInformer(watchlist, &corev1.Event{}, 0, ew)
-	ew._store = store
-	ew._controller = controller
+	_startTime time.Time
+	// initialSync is set to 1 once the informers started initially have
+	// synced
+	initialSync       int32
+	clientMu          sync.Mutex
+	authFailed        chan *informerShard
+	shardsMu          sync.Mutex
+	shards            map[string]*informerShard
+	shardsWG          sync.WaitGroup
+	stats             watcherStats
+	namespaceStats    namespaceStats
+	startTimeGauge    prometheus.Gauge
+	storeSizeGauge    prometheus.GaugeFunc
+	shardsGauge       prometheus.GaugeFunc
+	addCounter        *prometheus.CounterVec
+	updateCounter     *prometheus.CounterVec
+	deleteCounter     *prometheus.CounterVec
+	oldEventsCounter  prometheus.CounterFunc
+	filteredCounter   prometheus.CounterFunc
+	apiErrorsCounter  *prometheus.CounterVec
+	shardEvents       *prometheus.CounterVec
+	restartsCounter   *prometheus.CounterVec
+	authFailures      *prometheus.CounterVec
+	kubeconfigReloads prometheus.Counter
+}
+
+// Run watches events until ctx is cancelled. It returns once all informers
+// have stopped and all queued events have been delivered.
+func (ew *EventWatcher) Run(ctx context.Context) {
 	ew.logger = log.With().Str("component", "watcher").Logger()
+	ew.shards = map[string]*informerShard{}
+	ew.authFailed = make(chan *informerShard, 1)
 
 	ew._startTime = time.Now().UTC()
 
 	ew.setupStats()
 
-	go controller.Run(stopChan)
+	delivered := make(chan struct{})
+	go ew.deliver(delivered)
+	go ew.logStats(ctx)
+	go ew.runHeartbeat(ctx)
+	go ew.runWatchdog(ctx)
+	go ew.runReauth(ctx)
+	go ew.runSystemdNotify(ctx)
+	go ew.runKubeconfigReload(ctx)
+	limiterDone := make(chan struct{})
+	go func() {
+		ew.runLimiter(ctx)
+		close(limiterDone)
+	}()
+	deduplicatorDone := make(chan struct{})
+	go func() {
+		ew.runDeduplicator(ctx)
+		close(deduplicatorDone)
+	}()
+
+	ew.optOut.Start(ctx, ew.restClient())
+	ew.enricher.Start(ctx, ew.kubeClient(), ew.namespaces)
+	ew.eventAPI = ew.resolveEventAPI()
+	ew.resume.Load(ctx)
+	go ew.runResume(ctx)
+
 	ew.logger.Info().Msg("Watcher started")
-	<-stopChan
+	if ew.replicaSharding.Enabled() {
+		ew.logger.Info().
+			Int("replicas", ew.replicaSharding.replicas).
+			Int("ordinal", ew.replicaSharding.ordinal).
+			Msg("Splitting namespaces among replicas")
+		ew.shardByNamespace = true
+	}
+	switch {
+	case len(ew.namespaces) > 0 && ew.namespaces[0] != corev1.NamespaceAll:
+		// one informer per namespace, they share the delivery queue. With
+		// several replicas, each one watches the listed namespaces it owns.
+		owned := 0
+		for _, namespace := range ew.namespaces {
+			if !ew.replicaSharding.Owns(namespace) {
+				continue
+			}
+			ew.startShard(ctx, namespace)
+			owned++
+		}
+		if owned == 0 {
+			ew.logger.Warn().Msg("None of the namespaces is owned by this replica, watching nothing")
+		}
+		ew.waitForSync(ctx)
+		<-ctx.Done()
+	case !ew.shardByNamespace:
+		ew.startShard(ctx, corev1.NamespaceAll)
+		ew.waitForSync(ctx)
+		<-ctx.Done()
+	default:
+		ew.watchNamespaces(ctx)
+	}
+	ew.shardsWG.Wait()
+	ew.saveResumeVersions()
+	ew.logger.Info().Msg("Watch stopped, draining delivery queue")
+
+	// the informer handlers, the limiter and the deduplicator have
+	// returned, so nothing can push anymore
+	<-limiterDone
+	<-deduplicatorDone
+	ew.queue.Close()
+	<-delivered
+	ew.logger.Info().Msg("Watcher stopped")
+}
+
+// waitForSync waits until the informers running now have synced, and marks
+// the watcher as synced then
+func (ew *EventWatcher) waitForSync(ctx context.Context) {
+	ew.shardsMu.Lock()
+	synced := make([]cache.InformerSynced, 0, len(ew.shards))
+	for _, shard := range ew.shards {
+		synced = append(synced, shard.controller.HasSynced)
+	}
+	ew.shardsMu.Unlock()
+	if !cache.WaitForCacheSync(ctx.Done(), synced...) {
+		return
+	}
+	atomic.StoreInt32(&ew.initialSync, 1)
+	ew.logger.Info().Int("shards", len(synced)).Msg("Informers synced")
+}
+
+// deliver publishes queued events on the bus until the queue is closed. The
+// bus is closed afterwards, which waits for all subscribers to finish.
+func (ew *EventWatcher) deliver(done chan struct{}) {
+	defer close(done)
+	defer errorReports.Recover()
+	var takeover <-chan struct{}
+	if ew.leader != nil {
+		takeover = ew.leader.Takeover()
+	}
+	for {
+		record, ok, woken := ew.queue.NextOrWake(takeover)
+		if woken {
+			for _, record := range ew.leader.Replay() {
+				ew.publish(record)
+			}
+			continue
+		}
+		if !ok {
+			break
+		}
+		ew.publish(record)
+	}
+	ew.bus.Close()
+}
+
+// publish enriches record and publishes it on the bus, unless this replica is
+// on standby
+func (ew *EventWatcher) publish(record eventRecord) {
+	span := ew.tracer.StartChild("event.deliver", record.trace)
+	if ew.leader != nil && ew.leader.Standby(record) {
+		span.SetAttr("outcome", "standby")
+		span.End(nil)
+		return
+	}
+	record.trace = span.Context()
+	record.event = ew.enricher.Enrich(record.event)
+	record.logs = ew.logCapturer.Capture(context.Background(), record.event)
+	record.snapshot = ew.snapshotter.Capture(context.Background(), record.event)
+	ew.bus.Publish(record)
+	if ew.leader != nil {
+		ew.leader.Delivered(record)
+	}
+	span.End(nil)
 }
 
 func (ew *EventWatcher) setupStats() {
@@ -62,27 +284,70 @@ func (ew *EventWatcher) setupStats() {
 		Name: "informer_store_size",
 		Help: "Number of items in store",
 	}, func() float64 {
-		return float64(len(ew._store.ListKeys()))
+		return float64(ew.storeSize())
 	})
 
-	ew.addCounter = promauto.NewCounter(prometheus.CounterOpts{
-		Name: "informer_events_add_total",
-		Help: "Number of new events received by the informer",
+	ew.shardsGauge = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "informer_shards",
+		Help: "Number of running event informers",
+	}, func() float64 {
+		ew.shardsMu.Lock()
+		defer ew.shardsMu.Unlock()
+		return float64(len(ew.shards))
 	})
 
-	ew.updateCounter = promauto.NewCounter(prometheus.CounterOpts{
+	ew.addCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "informer_events_add_total",
+		Help: "Number of new events received by the informer by namespace, reason, type and involved object kind",
+	}, eventCounterLabels)
+
+	ew.updateCounter = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "informer_events_update_total",
-		Help: "Number of update events received by the informer",
-	})
+		Help: "Number of update events received by the informer by namespace, reason, type and involved object kind",
+	}, eventCounterLabels)
 
-	ew.deleteCounter = promauto.NewCounter(prometheus.CounterOpts{
+	ew.deleteCounter = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "informer_events_delete_total",
-		Help: "Number of delete events received by the informer",
-	})
+		Help: "Number of delete events received by the informer by namespace, reason, type and involved object kind",
+	}, eventCounterLabels)
 
-	ew.oldEventsCounter = promauto.NewCounter(prometheus.CounterOpts{
+	ew.oldEventsCounter = promauto.NewCounterFunc(prometheus.CounterOpts{
 		Name: "informer_events_old_total",
 		Help: "Number of old events ignored by the informer",
+	}, func() float64 {
+		return float64(ew.stats.Old())
+	})
+
+	ew.filteredCounter = promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name: "informer_events_filtered_total",
+		Help: "Number of events skipped by filters",
+	}, func() float64 {
+		return float64(ew.stats.Filtered())
+	})
+
+	ew.apiErrorsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "informer_api_errors_total",
+		Help: "Number of list/watch errors by informer shard and error class",
+	}, []string{"shard", "class"})
+
+	ew.shardEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "informer_shard_events_total",
+		Help: "Number of events received by informer shard",
+	}, []string{"shard"})
+
+	ew.restartsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "informer_restarts_total",
+		Help: "Number of informers restarted by the watchdog because their watch stalled",
+	}, []string{"shard"})
+
+	ew.authFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "informer_auth_failures_total",
+		Help: "Number of list/watch requests rejected as unauthorized by informer shard",
+	}, []string{"shard"})
+
+	ew.kubeconfigReloads = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "kubeconfig_reloads_total",
+		Help: "Number of times the client was rebuilt because the kubeconfig changed",
 	})
 }
 
@@ -95,56 +360,94 @@ func (ew *EventWatcher) isOldEvent(event *corev1.Event) bool {
 	return ew._startTime.UTC().Sub(event.LastTimestamp.Time.UTC()) > oldEventAgeMinutes*time.Minute
 }
 
-func (ew *EventWatcher) OnAdd(obj interface{}) {
-	event := obj.(*corev1.Event)
-	if !ew.isOldEvent(event) {
-		ew.logEvent(event, "Event added")
-		atomic.AddInt32(&addCounter, 1)
-		ew.addCounter.Inc()
-	} else {
-		ew.oldEventsCounter.Inc()
-	}
-	ew.deleteEvent(obj)
+// SetFilters replaces the filters events have to pass
+func (ew *EventWatcher) SetFilters(filters []extension.Filter) {
+	ew.filtersMu.Lock()
+	defer ew.filtersMu.Unlock()
+	ew.filters = filters
 }
 
-func (ew *EventWatcher) OnUpdate(oldObj, newObj interface{}) {
-	event := newObj.(*corev1.Event)
-	if !ew.isOldEvent(event) {
-		ew.logEvent(event, "Event updated")
-		atomic.AddInt32(&updateCounter, 1)
-		ew.updateCounter.Inc()
+// queueEvent hands the event to the delivery queue unless it is too old or
+// rejected by a filter. Events of a resumed watch are never too old, they
+// happened while the tailer was not running. It reports whether the event
+// was queued.
+func (ew *EventWatcher) queueEvent(event *corev1.Event, message string, resumed bool) bool {
+	if !resumed && ew.isOldEvent(event) {
+		atomic.AddUint64(&ew.stats.old, 1)
+		drops.Add(dropCauseOld, "", 1)
+		return false
+	}
+	span := ew.tracer.StartRoot("event.receive")
+	span.SetAttr("k8s.namespace.name", event.Namespace)
+	span.SetAttr("k8s.event.reason", event.Reason)
+	span.SetAttr("k8s.event.type", event.Type)
+	ew.filtersMu.RLock()
+	filters := ew.filters
+	ew.filtersMu.RUnlock()
+	for _, filter := range filters {
+		if !filter.Allow(event) {
+			atomic.AddUint64(&ew.stats.filtered, 1)
+			drops.Add(dropCauseFiltered, filter.Name(), 1)
+			filteredEventsCounter.WithLabelValues(filter.Name()).Inc()
+			span.SetAttr("outcome", "filtered")
+			span.SetAttr("filter", filter.Name())
+			span.End(nil)
+			return false
+		}
+	}
+	if message != eventDeletedMessage && !ew.deduplicator.Allow(event, time.Now()) {
+		drops.Add(dropCauseDeduplicated, "", 1)
+		span.SetAttr("outcome", "deduplicated")
+		span.End(nil)
+		return false
+	}
+	if limit, ok := ew.limiter.Allow(event, time.Now()); !ok {
+		drops.Add(dropCauseRateLimited, limit, 1)
+		span.SetAttr("outcome", "rate_limited")
+		span.SetAttr("limit", limit)
+		span.End(nil)
+		return false
+	}
+	if ew.redactor != nil {
+		if redacted, changed := ew.redactor.Redact(event.Message); changed {
+			// the object belongs to the informer, don't modify it
+			event = event.DeepCopy()
+			event.Message = redacted
+			span.SetAttr("redacted", "true")
+		}
+	}
+	record := eventRecord{event: event, message: message, trace: span.Context()}
+	if message != eventDeletedMessage {
+		if record.diff = ew.differ.Diff(event); record.diff != nil {
+			record.message = eventUpdatedMessage
+		}
 	} else {
-		ew.oldEventsCounter.Inc()
+		ew.differ.Forget(event)
 	}
-	ew.deleteEvent(newObj)
+	ew.queue.Push(record)
+	ew.namespaceStats.record(event, message)
+	atomic.StoreInt64(&ew.stats.lastEvent, time.Now().UnixNano())
+	span.End(nil)
+	return true
 }
 
-func (ew *EventWatcher) OnDelete(obj interface{}) {
-	event := obj.(*corev1.Event)
-	if !ew.isOldEvent(event) {
-		ew.logEvent(event, "Event deleted")
-		atomic.AddInt32(&deleteCounter, 1)
-		ew.deleteCounter.Inc()
-	} else {
-		ew.oldEventsCounter.Inc()
+func (ew *EventWatcher) onAdd(event *corev1.Event, resumed bool) {
+	if ew.queueEvent(event, eventAddedMessage, resumed) {
+		atomic.AddUint64(&ew.stats.added, 1)
+		ew.addCounter.WithLabelValues(ew.labelLimiter.values(event)...).Inc()
 	}
-	// ew.deleteEvent(obj)
 }
 
-func (ew *EventWatcher) deleteEvent(obj interface{}) {
-	if err := ew._store.Delete(obj); err != nil {
-		ew.logger.Error().Err(err).Msg("Could not delete object")
+func (ew *EventWatcher) onUpdate(event *corev1.Event, resumed bool) {
+	if ew.queueEvent(event, eventUpdatedMessage, resumed) {
+		atomic.AddUint64(&ew.stats.updated, 1)
+		ew.updateCounter.WithLabelValues(ew.labelLimiter.values(event)...).Inc()
 	}
 }
 
-func (ew *EventWatcher) logEvent(event *corev1.Event, message string) {
-	ew.logger.Info().
-		Str("namespace", event.Namespace).
-		Str("name", event.Name).
-		Str("version", event.ResourceVersion).
-		Str("eventMsg", event.Message).
-		Str("lastTimestamp", event.LastTimestamp.UTC().Format(time.RFC3339)).
-		Str("age", time.Since(event.LastTimestamp.Time).Round(time.Second).String()).
-		Int32("count", event.Count).
-		Msg(message)
+func (ew *EventWatcher) onDelete(event *corev1.Event, resumed bool) {
+	if ew.queueEvent(event, eventDeletedMessage, resumed) {
+		atomic.AddUint64(&ew.stats.deleted, 1)
+		ew.deleteCounter.WithLabelValues(ew.labelLimiter.values(event)...).Inc()
+	}
 }
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"sync/atomic"
+
+	"github.com/rs/zerolog/log"
+)
+
+// exitCodeFailOn is returned when events matching --fail-on were seen
+const exitCodeFailOn = 1
+
+// failOnRules records whether any published event matched, so that bounded
+// runs in CI pipelines can fail on e.g. warning events.
+type failOnRules struct {
+	rules   []*eventRule
+	matched uint64
+}
+
+// newFailOnRules parses selectors of the form KEY=VALUE,... A bare value
+// like Warning is short for type=Warning. It returns nil without selectors.
+func newFailOnRules(specs []string) (*failOnRules, error) {
+	if len(specs) == 0 {
+		return nil, nil
+	}
+	f := &failOnRules{}
+	for _, spec := range specs {
+		if !strings.Contains(spec, "=") {
+			spec = "type=" + spec
+		}
+		rule, err := parseEventSelector(spec)
+		if err != nil {
+			return nil, err
+		}
+		f.rules = append(f.rules, rule)
+	}
+	return f, nil
+}
+
+// Subscribe counts the published events matching any rule
+func (f *failOnRules) Subscribe(bus *eventBus) {
+	bus.Subscribe("fail-on", subscriberOptions{}, func(record eventRecord) {
+		event := record.event
+		for _, rule := range f.rules {
+			if !rule.Matches(event) {
+				continue
+			}
+			if atomic.AddUint64(&f.matched, 1) == 1 {
+				log.Warn().
+					Str("namespace", event.Namespace).
+					Str("reason", event.Reason).
+					Str("eventMsg", event.Message).
+					Msg("Event matching --fail-on seen, exiting with an error")
+			}
+			return
+		}
+	})
+}
+
+// Matched returns the number of matching events, zero for nil
+func (f *failOnRules) Matched() uint64 {
+	if f == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&f.matched)
+}
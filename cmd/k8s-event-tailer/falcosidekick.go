@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// falcoPayload is the alert format falcosidekick receives from Falco
+type falcoPayload struct {
+	UUID         string                 `json:"uuid"`
+	Output       string                 `json:"output"`
+	Priority     string                 `json:"priority"`
+	Rule         string                 `json:"rule"`
+	Time         string                 `json:"time"`
+	Source       string                 `json:"source"`
+	Hostname     string                 `json:"hostname,omitempty"`
+	Tags         []string               `json:"tags"`
+	OutputFields map[string]interface{} `json:"output_fields"`
+}
+
+// falcosidekickSink posts events in the Falco alert format, so that all
+// outputs of falcosidekick can be used for them.
+type falcosidekickSink struct {
+	url      string
+	hostname string
+	client   *http.Client
+}
+
+func newFalcosidekickSink(url string, policy *tlsPolicy) *falcosidekickSink {
+	s := &falcosidekickSink{
+		url:    url,
+		client: policy.HTTPClient(30 * time.Second),
+	}
+	s.hostname, _ = os.Hostname()
+	return s
+}
+
+func (s *falcosidekickSink) Name() string {
+	return "falcosidekick"
+}
+
+// Write maps the event type to the Falco priority, warnings to Warning and
+// normal events to Notice, and the reason to the rule. The event fields are
+// sent as output fields, so they can be used in falcosidekick's filters and
+// templates.
+func (s *falcosidekickSink) Write(event *corev1.Event) error {
+	priority := "Notice"
+	if event.Type == corev1.EventTypeWarning {
+		priority = "Warning"
+	}
+	obj := event.InvolvedObject
+	payload := falcoPayload{
+		UUID:     fmt.Sprintf("%s-%s", event.UID, event.ResourceVersion),
+		Output:   fmt.Sprintf("%s %s: %s", eventTime(event).UTC().Format(time.RFC3339), eventTitle(event), event.Message),
+		Priority: priority,
+		Rule:     "Kubernetes " + event.Type + " event " + event.Reason,
+		Time:     eventTime(event).UTC().Format(time.RFC3339Nano),
+		Source:   "k8s_events",
+		Hostname: s.hostname,
+		Tags:     []string{"k8s", "k8s_events", strings.ToLower(event.Type)},
+		OutputFields: map[string]interface{}{
+			"k8s.ns.name":           event.Namespace,
+			"k8s.event.name":        event.Name,
+			"k8s.event.type":        event.Type,
+			"k8s.event.reason":      event.Reason,
+			"k8s.event.count":       event.Count,
+			"k8s.event.message":     event.Message,
+			"k8s.event.source":      event.Source.Component,
+			"k8s.obj.kind":          obj.Kind,
+			"k8s.obj.namespace":     obj.Namespace,
+			"k8s.obj.name":          obj.Name,
+			"k8s.obj.fieldpath":     obj.FieldPath,
+			"k8s.event.source.host": event.Source.Host,
+		},
+	}
+	return doJSON(context.Background(), s.client, http.MethodPost, s.url, nil, payload, nil)
+}
+
+func (s *falcosidekickSink) Close() error {
+	return nil
+}
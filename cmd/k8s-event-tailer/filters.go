@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var filteredEventsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "events_filtered_total",
+	Help: "Number of events skipped by filters, by filter",
+}, []string{"filter"})
+
+var excludedNamespaceCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "events_namespace_excluded_total",
+	Help: "Number of events skipped because their namespace is excluded, by namespace",
+}, []string{"namespace"})
+
+// namespaceExclusion skips the events of noisy namespaces, e.g. kube-system,
+// when watching all namespaces
+type namespaceExclusion struct {
+	namespaces map[string]bool
+}
+
+// newNamespaceExclusion returns a filter for the given namespaces, which may
+// be separated by commas as well, nil if there are none
+func newNamespaceExclusion(namespaces []string) *namespaceExclusion {
+	f := &namespaceExclusion{namespaces: stringSet(namespaces)}
+	if len(f.namespaces) == 0 {
+		return nil
+	}
+	return f
+}
+
+func (f *namespaceExclusion) Name() string {
+	return "exclude-namespace"
+}
+
+func (f *namespaceExclusion) Allow(event *corev1.Event) bool {
+	if !f.namespaces[event.Namespace] {
+		return true
+	}
+	excludedNamespaceCounter.WithLabelValues(event.Namespace).Inc()
+	return false
+}
+
+// reasonFilter only lets events of the included reasons and types through
+// and skips the excluded reasons. Empty sets include everything.
+type reasonFilter struct {
+	include map[string]bool
+	exclude map[string]bool
+	types   map[string]bool
+}
+
+// newReasonFilter returns a filter for the given reasons and types, nil if
+// none are given
+func newReasonFilter(include, exclude, types []string) *reasonFilter {
+	if len(include) == 0 && len(exclude) == 0 && len(types) == 0 {
+		return nil
+	}
+	return &reasonFilter{
+		include: stringSet(include),
+		exclude: stringSet(exclude),
+		types:   stringSet(types),
+	}
+}
+
+func (f *reasonFilter) Name() string {
+	return "reason"
+}
+
+func (f *reasonFilter) Allow(event *corev1.Event) bool {
+	if len(f.types) > 0 && !f.types[event.Type] {
+		return false
+	}
+	if len(f.include) > 0 && !f.include[event.Reason] {
+		return false
+	}
+	return !f.exclude[event.Reason]
+}
+
+// stringSet returns the set of values, which may be separated by commas
+func stringSet(values []string) map[string]bool {
+	set := map[string]bool{}
+	for _, list := range values {
+		for _, value := range strings.Split(list, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				set[value] = true
+			}
+		}
+	}
+	return set
+}
+
+// Event fields the patterns of messageFilter are applied to
+const (
+	matchFieldMessage = "message"
+	matchFieldReason  = "reason"
+	matchFieldName    = "name"
+)
+
+var matchFields = []string{matchFieldMessage, matchFieldReason, matchFieldName}
+
+// messageFilter greps the event stream: events must match one of the match
+// patterns, if any, and none of the exclude patterns. The patterns are
+// applied to the message and optionally the reason and involved object name.
+type messageFilter struct {
+	match   []*regexp.Regexp
+	exclude []*regexp.Regexp
+	fields  []string
+}
+
+// newMessageFilter compiles the patterns, it returns nil if there are none
+func newMessageFilter(match, exclude, fields []string) (*messageFilter, error) {
+	if len(match) == 0 && len(exclude) == 0 {
+		return nil, nil
+	}
+	f := &messageFilter{fields: fields}
+	if len(f.fields) == 0 {
+		f.fields = []string{matchFieldMessage}
+	}
+	var err error
+	if f.match, err = compilePatterns(match); err != nil {
+		return nil, err
+	}
+	if f.exclude, err = compilePatterns(exclude); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+func (f *messageFilter) Name() string {
+	return "message"
+}
+
+func (f *messageFilter) Allow(event *corev1.Event) bool {
+	if len(f.match) > 0 && !f.matches(event, f.match) {
+		return false
+	}
+	return !f.matches(event, f.exclude)
+}
+
+// matches reports whether any pattern matches any of the fields of event
+func (f *messageFilter) matches(event *corev1.Event, patterns []*regexp.Regexp) bool {
+	for _, field := range f.fields {
+		var value string
+		switch field {
+		case matchFieldMessage:
+			value = event.Message
+		case matchFieldReason:
+			value = event.Reason
+		case matchFieldName:
+			value = event.InvolvedObject.Name
+		}
+		for _, re := range patterns {
+			if re.MatchString(value) {
+				return true
+			}
+		}
+	}
+	return false
+}
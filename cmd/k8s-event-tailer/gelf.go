@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// gelfChunkSize fits a chunk into a single Ethernet frame
+	gelfChunkSize      = 1420
+	gelfChunkHeaderLen = 12
+	gelfMaxChunks      = 128
+	// syslog severities used as GELF levels
+	gelfLevelWarning = 4
+	gelfLevelInfo    = 6
+)
+
+var gelfChunkMagic = []byte{0x1e, 0x0f}
+
+// gelfSink sends events to Graylog as GELF messages. Over UDP, messages are
+// compressed and split into chunks, over TCP and TLS they are delimited by
+// null bytes.
+type gelfSink struct {
+	host   string
+	udp    net.Conn
+	stream *streamConn
+}
+
+// newGELFSink connects to an address of the form udp://, tcp:// or
+// tls://host:port
+func newGELFSink(address string, policy *tlsPolicy) (*gelfSink, error) {
+	scheme, hostPort, err := parseStreamAddress(address, "udp", "tcp", "tls")
+	if err != nil {
+		return nil, err
+	}
+	s := &gelfSink{}
+	s.host, _ = os.Hostname()
+	switch scheme {
+	case "udp":
+		if s.udp, err = net.Dial("udp", hostPort); err != nil {
+			return nil, err
+		}
+	case "tcp":
+		s.stream = newStreamConn(hostPort, nil)
+	case "tls":
+		s.stream = newStreamConn(hostPort, policy.Config())
+	}
+	return s, nil
+}
+
+func (s *gelfSink) Name() string {
+	return "gelf"
+}
+
+// gelfMessage maps an event to GELF 1.1, event fields become additional
+// fields prefixed with an underscore
+func (s *gelfSink) gelfMessage(event *corev1.Event) map[string]interface{} {
+	level := gelfLevelInfo
+	if event.Type == corev1.EventTypeWarning {
+		level = gelfLevelWarning
+	}
+	obj := event.InvolvedObject
+	return map[string]interface{}{
+		"version":           "1.1",
+		"host":              s.host,
+		"short_message":     eventTitle(event),
+		"full_message":      event.Message,
+		"timestamp":         float64(eventTime(event).UnixNano()) / 1e9,
+		"level":             level,
+		"_namespace":        event.Namespace,
+		"_event_name":       event.Name,
+		"_event_uid":        string(event.UID),
+		"_type":             event.Type,
+		"_reason":           event.Reason,
+		"_count":            event.Count,
+		"_object_kind":      obj.Kind,
+		"_object_name":      obj.Name,
+		"_object_namespace": obj.Namespace,
+		"_source_component": event.Source.Component,
+		"_source_host":      event.Source.Host,
+	}
+}
+
+func (s *gelfSink) Write(event *corev1.Event) error {
+	payload, err := json.Marshal(s.gelfMessage(event))
+	if err != nil {
+		return err
+	}
+	if s.stream != nil {
+		return s.stream.Write(append(payload, 0))
+	}
+	return s.writeUDP(payload)
+}
+
+// writeUDP sends the gzipped message, split into chunks if it does not fit
+// into a single datagram
+func (s *gelfSink) writeUDP(payload []byte) error {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(payload); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+	if len(data) <= gelfChunkSize {
+		_, err := s.udp.Write(data)
+		return err
+	}
+
+	dataSize := gelfChunkSize - gelfChunkHeaderLen
+	count := (len(data) + dataSize - 1) / dataSize
+	if count > gelfMaxChunks {
+		return fmt.Errorf("GELF message too large: %d bytes compressed", len(data))
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	chunk := make([]byte, 0, gelfChunkSize)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * dataSize
+		if end > len(data) {
+			end = len(data)
+		}
+		chunk = append(chunk[:0], gelfChunkMagic...)
+		chunk = append(chunk, id...)
+		chunk = append(chunk, byte(i), byte(count))
+		chunk = append(chunk, data[i*dataSize:end]...)
+		if _, err := s.udp.Write(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *gelfSink) Close() error {
+	if s.stream != nil {
+		return s.stream.Close()
+	}
+	return s.udp.Close()
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultGitHubAPIURL = "https://api.github.com"
+	githubTitlePrefix   = "[k8s-event-tailer] "
+)
+
+// githubOptions configures the GitHub issue notifier
+type githubOptions struct {
+	apiURL string
+	// repo is owner/name
+	repo   string
+	token  string
+	labels []string
+	tls    *tlsPolicy
+}
+
+// githubNotifier opens an issue when an alert fires, or comments on the open
+// issue of the same alert, and closes it when the alert is resolved. Issues
+// are found by their title, which contains the alert key, so the tailer
+// picks up its issues again after a restart.
+type githubNotifier struct {
+	opts   githubOptions
+	client *http.Client
+	header http.Header
+
+	mu     sync.Mutex
+	issues map[string]int
+}
+
+type githubIssue struct {
+	Number int    `json:"number"`
+	Title  string `json:"title"`
+}
+
+func newGitHubNotifier(opts githubOptions) (*githubNotifier, error) {
+	if owner, name, ok := strings.Cut(opts.repo, "/"); !ok || owner == "" || name == "" {
+		return nil, fmt.Errorf("invalid GitHub repository %q, expected OWNER/NAME", opts.repo)
+	}
+	if opts.token == "" {
+		return nil, fmt.Errorf("GitHub token missing")
+	}
+	opts.apiURL = strings.TrimSuffix(opts.apiURL, "/")
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+opts.token)
+	header.Set("X-GitHub-Api-Version", "2022-11-28")
+	return &githubNotifier{
+		opts:   opts,
+		client: opts.tls.HTTPClient(30 * time.Second),
+		header: header,
+		issues: map[string]int{},
+	}, nil
+}
+
+func (g *githubNotifier) Name() string {
+	return "github"
+}
+
+func (g *githubNotifier) Fire(ctx context.Context, a *alert) error {
+	number, err := g.findIssue(ctx, a)
+	if err != nil {
+		return err
+	}
+	if number != 0 {
+		return g.comment(ctx, number, "Alert fired again.\n\n"+githubEventDetails(a))
+	}
+
+	var issue githubIssue
+	request := map[string]interface{}{
+		"title": g.title(a),
+		"body": fmt.Sprintf("Alert rule `%s` fired for %s `%s/%s`.\n\n%s",
+			a.rule, a.kind, a.namespace, a.name, githubEventDetails(a)),
+	}
+	if len(g.opts.labels) > 0 {
+		request["labels"] = g.opts.labels
+	}
+	if err := doJSON(ctx, g.client, http.MethodPost, g.repoURL("/issues"), g.header, request, &issue); err != nil {
+		return err
+	}
+	g.mu.Lock()
+	g.issues[a.Key()] = issue.Number
+	g.mu.Unlock()
+	return nil
+}
+
+func (g *githubNotifier) Resolve(ctx context.Context, a *alert) error {
+	number, err := g.findIssue(ctx, a)
+	if err != nil || number == 0 {
+		return err
+	}
+	message := fmt.Sprintf("Resolved, no matching event since %s (%d events while firing).",
+		a.lastSeen.UTC().Format(time.RFC3339), a.count)
+	if err := g.comment(ctx, number, message); err != nil {
+		return err
+	}
+	err = doJSON(ctx, g.client, http.MethodPatch, g.repoURL(fmt.Sprintf("/issues/%d", number)), g.header,
+		map[string]string{"state": "closed", "state_reason": "completed"}, nil)
+	if err == nil {
+		g.mu.Lock()
+		delete(g.issues, a.Key())
+		g.mu.Unlock()
+	}
+	return err
+}
+
+func (g *githubNotifier) title(a *alert) string {
+	return githubTitlePrefix + a.Key()
+}
+
+func (g *githubNotifier) repoURL(path string) string {
+	return g.opts.apiURL + "/repos/" + g.opts.repo + path
+}
+
+// findIssue returns the number of the open issue of the alert, zero if there
+// is none
+func (g *githubNotifier) findIssue(ctx context.Context, a *alert) (int, error) {
+	g.mu.Lock()
+	number, ok := g.issues[a.Key()]
+	g.mu.Unlock()
+	if ok {
+		return number, nil
+	}
+
+	title := g.title(a)
+	query := fmt.Sprintf("repo:%s is:issue is:open in:title %q", g.opts.repo, title)
+	var result struct {
+		Items []githubIssue `json:"items"`
+	}
+	if err := doJSON(ctx, g.client, http.MethodGet, g.opts.apiURL+"/search/issues?q="+url.QueryEscape(query), g.header, nil, &result); err != nil {
+		return 0, err
+	}
+	for _, issue := range result.Items {
+		// the search is fuzzy, only take the exact title
+		if issue.Title == title {
+			g.mu.Lock()
+			g.issues[a.Key()] = issue.Number
+			g.mu.Unlock()
+			return issue.Number, nil
+		}
+	}
+	return 0, nil
+}
+
+func (g *githubNotifier) comment(ctx context.Context, number int, body string) error {
+	return doJSON(ctx, g.client, http.MethodPost, g.repoURL(fmt.Sprintf("/issues/%d/comments", number)), g.header,
+		map[string]string{"body": body}, nil)
+}
+
+func githubEventDetails(a *alert) string {
+	event := a.event
+	if event == nil {
+		return ""
+	}
+	details := fmt.Sprintf("| | |\n|---|---|\n| Type | %s |\n| Reason | %s |\n| Count | %d |\n| Last seen | %s |\n\n```\n%s\n```",
+		event.Type, event.Reason, event.Count, event.LastTimestamp.UTC().Format(time.RFC3339), event.Message)
+	if a.logs != "" {
+		details += "\n\nPod logs:\n\n```\n" + a.logs + "\n```"
+	}
+	if a.snapshot != "" {
+		details += "\n\n<details><summary>Object snapshot</summary>\n\n```yaml\n" + a.snapshot + "\n```\n</details>"
+	}
+	return details
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// googleChatSink posts events as cards to a Google Chat webhook. Events of
+// the same involved object are grouped in one thread.
+type googleChatSink struct {
+	url    string
+	client *http.Client
+}
+
+func newGoogleChatSink(webhookURL string, policy *tlsPolicy) (*googleChatSink, error) {
+	u, err := url.Parse(webhookURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Google Chat webhook URL: %w", err)
+	}
+	query := u.Query()
+	query.Set("messageReplyOption", "REPLY_MESSAGE_FALLBACK_TO_NEW_THREAD")
+	u.RawQuery = query.Encode()
+	return &googleChatSink{
+		url:    u.String(),
+		client: policy.HTTPClient(30 * time.Second),
+	}, nil
+}
+
+func (s *googleChatSink) Name() string {
+	return "googlechat"
+}
+
+func (s *googleChatSink) Write(event *corev1.Event) error {
+	obj := event.InvolvedObject
+	decorated := func(label, text string) map[string]interface{} {
+		return map[string]interface{}{
+			"decoratedText": map[string]string{"topLabel": label, "text": html.EscapeString(text)},
+		}
+	}
+	message := map[string]interface{}{
+		"text": eventTitle(event),
+		"thread": map[string]string{
+			"threadKey": fmt.Sprintf("%s/%s/%s", obj.Namespace, obj.Kind, obj.Name),
+		},
+		"cardsV2": []map[string]interface{}{{
+			"cardId": "event",
+			"card": map[string]interface{}{
+				"header": map[string]string{
+					"title":    event.Type + " " + event.Reason,
+					"subtitle": eventObject(event),
+				},
+				"sections": []map[string]interface{}{{
+					"widgets": []map[string]interface{}{
+						{"textParagraph": map[string]string{"text": html.EscapeString(event.Message)}},
+						decorated("Count", fmt.Sprint(event.Count)),
+						decorated("Last seen", eventTime(event).UTC().Format(time.RFC3339)),
+						decorated("Source", event.Source.Component),
+					},
+				}},
+			},
+		}},
+	}
+	return doJSON(context.Background(), s.client, http.MethodPost, s.url, nil, message, nil)
+}
+
+func (s *googleChatSink) Close() error {
+	return nil
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/http2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	corev1 "k8s.io/api/core/v1"
+
+	eventtailerv1 "k8s-event-tailer/api/v1"
+)
+
+const (
+	defaultGRPCListLimit      = 100
+	grpcMaxRequestMessageSize = 1 << 20
+)
+
+// grpcServer serves the EventTailer service of api/v1/events.proto, with
+// TLS if a certificate is set. The health and reflection services are
+// served next to it, the health service without authentication so that
+// probes need no credentials.
+type grpcServer struct {
+	eventtailerv1.UnimplementedEventTailerServer
+
+	addr   string
+	server *grpc.Server
+	health *health.Server
+	buffer *eventBuffer
+	auth   *webAuth
+	logger zerolog.Logger
+	// closing ends the Subscribe streams, which would otherwise keep the
+	// server from shutting down
+	closing chan struct{}
+	tls     bool
+}
+
+// newGRPCServer returns an error if the TLS policy does not meet the
+// requirements of HTTP/2
+func newGRPCServer(addr string, buffer *eventBuffer, policy *tlsPolicy, certificate *certificateReloader, auth *webAuth) (*grpcServer, error) {
+	s := &grpcServer{
+		addr:    addr,
+		health:  health.NewServer(),
+		buffer:  buffer,
+		auth:    auth,
+		logger:  log.With().Str("component", "grpc").Logger(),
+		closing: make(chan struct{}),
+		tls:     certificate != nil,
+	}
+	options := []grpc.ServerOption{
+		grpc.MaxRecvMsgSize(grpcMaxRequestMessageSize),
+		grpc.ChainUnaryInterceptor(s.unaryInterceptor),
+		grpc.ChainStreamInterceptor(s.streamInterceptor),
+	}
+	if certificate != nil {
+		config := policy.Config()
+		config.GetCertificate = certificate.GetCertificate
+		if err := checkHTTP2TLSConfig(config); err != nil {
+			return nil, err
+		}
+		options = append(options, grpc.Creds(credentials.NewTLS(config)))
+	}
+	s.server = grpc.NewServer(options...)
+	eventtailerv1.RegisterEventTailerServer(s.server, s)
+	healthpb.RegisterHealthServer(s.server, s.health)
+	reflection.Register(s.server)
+	return s, nil
+}
+
+// checkHTTP2TLSConfig rejects configurations, e.g. of --tls-cipher-suite,
+// which HTTP/2 clients refuse, with the check of the HTTP/2 server of net/http
+func checkHTTP2TLSConfig(config *tls.Config) error {
+	if err := http2.ConfigureServer(&http.Server{TLSConfig: config.Clone()}, &http2.Server{}); err != nil {
+		return fmt.Errorf("TLS settings unusable for gRPC: %w", err)
+	}
+	return nil
+}
+
+// Run serves gRPC until ctx is cancelled and the server has shut down.
+func (s *grpcServer) Run(ctx context.Context) {
+	s.logger.Info().Bool("tls", s.tls).Msgf("Starting gRPC server listening to %s", s.addr)
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		s.logger.Err(err).Msg("Could not start gRPC server")
+		return
+	}
+	go func() {
+		if err := s.server.Serve(listener); err != nil {
+			s.logger.Err(err).Msg("Error stopping gRPC server")
+		}
+	}()
+	<-ctx.Done()
+
+	s.health.Shutdown()
+	close(s.closing)
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(10 * time.Second):
+		s.server.Stop()
+	}
+	s.logger.Info().Msg("Shut down gRPC server")
+}
+
+func (s *grpcServer) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := s.authenticate(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	resp, err := handler(ctx, req)
+	s.logFailure(info.FullMethod, err)
+	return resp, err
+}
+
+func (s *grpcServer) streamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := s.authenticate(stream.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	err := handler(srv, stream)
+	s.logFailure(info.FullMethod, err)
+	return err
+}
+
+// authenticate checks the credentials in the authorization metadata with
+// every method of --web-auth which has credentials
+func (s *grpcServer) authenticate(ctx context.Context, method string) error {
+	if s.auth == nil || strings.HasPrefix(method, "/"+healthpb.Health_ServiceDesc.ServiceName+"/") {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	r := &http.Request{Header: http.Header{}}
+	for _, value := range md.Get("authorization") {
+		r.Header.Add("Authorization", value)
+	}
+	if !s.auth.authenticated(r, s.auth.configured) {
+		return status.Error(codes.Unauthenticated, "unauthorized")
+	}
+	return nil
+}
+
+func (s *grpcServer) logFailure(method string, err error) {
+	if code := status.Code(err); code != codes.OK && code != codes.Canceled {
+		s.logger.Debug().Str("method", method).Str("status", code.String()).Err(err).Msg("gRPC request failed")
+	}
+}
+
+// Subscribe streams the events added to the buffer from now on
+func (s *grpcServer) Subscribe(request *eventtailerv1.EventFilter, stream eventtailerv1.EventTailer_SubscribeServer) error {
+	filter := newGRPCStreamFilter(request)
+	cursor := s.buffer.lastEventID()
+	for {
+		records, updated := s.buffer.after(cursor)
+		for _, record := range records {
+			cursor = record.id
+			if !filter.Matches(record.event) {
+				continue
+			}
+			if err := stream.Send(newGRPCEvent(record.event)); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-stream.Context().Done():
+			return status.FromContextError(stream.Context().Err()).Err()
+		case <-s.closing:
+			return status.Error(codes.Unavailable, "server shutting down")
+		case <-updated:
+		}
+	}
+}
+
+// ListRecent returns the most recent buffered events, the oldest first
+func (s *grpcServer) ListRecent(_ context.Context, request *eventtailerv1.ListRecentRequest) (*eventtailerv1.ListRecentResponse, error) {
+	filter := newGRPCStreamFilter(request.GetFilter())
+	limit := int(request.GetLimit())
+	if limit == 0 {
+		limit = defaultGRPCListLimit
+	}
+	records, _ := s.buffer.after(0)
+	var events []*eventtailerv1.Event
+	for i := len(records) - 1; i >= 0 && len(events) < limit; i-- {
+		if filter.Matches(records[i].event) {
+			events = append(events, newGRPCEvent(records[i].event))
+		}
+	}
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	return &eventtailerv1.ListRecentResponse{Events: events}, nil
+}
+
+func newGRPCStreamFilter(filter *eventtailerv1.EventFilter) streamFilter {
+	return newStreamFilter(url.Values{
+		"namespace": filter.GetNamespaces(),
+		"type":      filter.GetTypes(),
+		"reason":    filter.GetReasons(),
+	})
+}
+
+// newGRPCEvent returns the Event message with the fields of the JSON
+// representation of event
+func newGRPCEvent(event *corev1.Event) *eventtailerv1.Event {
+	e := newJSONEvent(event)
+	obj := e.InvolvedObject
+	message := &eventtailerv1.Event{
+		Timestamp: timestamppb.New(e.Timestamp),
+		Namespace: e.Namespace,
+		Name:      e.Name,
+		Uid:       e.UID,
+		Type:      e.Type,
+		Reason:    e.Reason,
+		Message:   e.Message,
+		Count:     e.Count,
+		InvolvedObject: &eventtailerv1.ObjectReference{
+			Kind:       obj.Kind,
+			Namespace:  obj.Namespace,
+			Name:       obj.Name,
+			Uid:        obj.UID,
+			ApiVersion: obj.APIVersion,
+			FieldPath:  obj.FieldPath,
+		},
+		Source: &eventtailerv1.EventSource{
+			Component: e.Source.Component,
+			Host:      e.Source.Host,
+		},
+	}
+	if e.FirstTimestamp != nil {
+		message.FirstTimestamp = timestamppb.New(*e.FirstTimestamp)
+	}
+	if e.LastTimestamp != nil {
+		message.LastTimestamp = timestamppb.New(*e.LastTimestamp)
+	}
+	return message
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	eventtailerv1 "k8s-event-tailer/api/v1"
+)
+
+func grpcTestEvent(name, eventType, reason string, last time.Time) *corev1.Event {
+	return &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			UID:       "0b1c2d3e-4f50-6172-8394-a5b6c7d8e9f0",
+		},
+		InvolvedObject: corev1.ObjectReference{
+			Kind:       "Pod",
+			Namespace:  "default",
+			Name:       "web-7d4b9c8f6-x2kzq",
+			APIVersion: "v1",
+			FieldPath:  "spec.containers{web}",
+		},
+		Type:           eventType,
+		Reason:         reason,
+		Message:        "Back-off restarting failed container",
+		Count:          3,
+		Source:         corev1.EventSource{Component: "kubelet", Host: "node-1"},
+		FirstTimestamp: metav1.NewTime(last.Add(-time.Minute)),
+		LastTimestamp:  metav1.NewTime(last),
+	}
+}
+
+// startGRPCTestServer serves buffer over an in-memory listener, requiring the
+// bearer token "secret"
+func startGRPCTestServer(t *testing.T, buffer *eventBuffer) *grpc.ClientConn {
+	t.Helper()
+	auth := &webAuth{tokens: [][32]byte{sha256.Sum256([]byte("secret"))}, configured: []string{webAuthBearer}}
+	s, err := newGRPCServer("", buffer, nil, nil, auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := bufconn.Listen(1 << 20)
+	go func() { _ = s.server.Serve(listener) }()
+	t.Cleanup(s.server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestGRPCListRecent(t *testing.T) {
+	last := time.Date(2022, 6, 10, 0, 10, 24, 500, time.UTC)
+	buffer := newEventBuffer(10)
+	buffer.add(grpcTestEvent("a", corev1.EventTypeWarning, "BackOff", last))
+	buffer.add(grpcTestEvent("b", corev1.EventTypeNormal, "Pulled", last))
+	buffer.add(grpcTestEvent("c", corev1.EventTypeWarning, "BackOff", last))
+	buffer.add(grpcTestEvent("d", corev1.EventTypeWarning, "Failed", last))
+	client := eventtailerv1.NewEventTailerClient(startGRPCTestServer(t, buffer))
+
+	request := &eventtailerv1.ListRecentRequest{
+		Filter: &eventtailerv1.EventFilter{Reasons: []string{"BackOff"}},
+		Limit:  2,
+	}
+	if _, err := client.ListRecent(context.Background(), request); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("ListRecent without token: %v, want Unauthenticated", err)
+	}
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer secret")
+	response, err := client.ListRecent(ctx, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Events) != 2 || response.Events[0].Name != "a" || response.Events[1].Name != "c" {
+		t.Fatalf("ListRecent = %v, want events a and c", response.Events)
+	}
+
+	event := response.Events[1]
+	if event.Type != corev1.EventTypeWarning || event.Count != 3 || event.InvolvedObject.FieldPath != "spec.containers{web}" ||
+		event.Source.Host != "node-1" || !event.LastTimestamp.AsTime().Equal(last) ||
+		!event.FirstTimestamp.AsTime().Equal(last.Add(-time.Minute)) || !event.Timestamp.AsTime().Equal(last) {
+		t.Errorf("unexpected event %v", event)
+	}
+}
+
+func TestGRPCSubscribe(t *testing.T) {
+	buffer := newEventBuffer(10)
+	conn := startGRPCTestServer(t, buffer)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	health, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil || health.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("health check without token = %v, %v, want SERVING", health, err)
+	}
+
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer secret")
+	stream, err := eventtailerv1.NewEventTailerClient(conn).Subscribe(ctx, &eventtailerv1.EventFilter{Types: []string{corev1.EventTypeWarning}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the stream starts at the events added once the server handles it
+	go func() {
+		for ctx.Err() == nil {
+			buffer.add(grpcTestEvent("normal", corev1.EventTypeNormal, "Pulled", time.Now()))
+			buffer.add(grpcTestEvent("warning", corev1.EventTypeWarning, "BackOff", time.Now()))
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	event, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Name != "warning" {
+		t.Errorf("Subscribe received %s, want warning", event.Name)
+	}
+}
+
+func TestCheckHTTP2TLSConfig(t *testing.T) {
+	policy, err := newTLSPolicy("1.2", []string{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkHTTP2TLSConfig(policy.Config()); err == nil {
+		t.Error("cipher suites without TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 accepted for HTTP/2")
+	}
+	policy, err = newTLSPolicy("1.2", nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkHTTP2TLSConfig(policy.Config()); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	haLeaseDuration = 15 * time.Second
+	haRenewDeadline = 10 * time.Second
+	haRetryPeriod   = 2 * time.Second
+
+	// haDeliveredAnnotation on the Lease holds the highest resourceVersion
+	// delivered by the leader, updated with every renewal
+	haDeliveredAnnotation = "k8s-event-tailer/delivered-resource-version"
+	// haStandbyBufferSize and haStandbyWindow bound the records a standby
+	// keeps to replay after a takeover. The window covers an expiring lease
+	// of a leader which stopped renewing.
+	haStandbyBufferSize = 10000
+	haStandbyWindow     = 2 * haLeaseDuration
+)
+
+// leaderGate coordinates replicas running in HA mode through a Lease. All
+// replicas watch events, but only the current leader delivers them, so every
+// event is emitted once cluster-wide. Standbys keep the records of the last
+// haStandbyWindow and, once they take over, replay those the previous
+// leader has not marked as delivered in the Lease.
+type leaderGate struct {
+	clientset *kubernetes.Clientset
+	namespace string
+	name      string
+	identity  string
+	leading   int32
+	logger    zerolog.Logger
+
+	// delivered is the highest resourceVersion delivered while leading
+	delivered uint64
+	// observed is the delivered mark of the last other leader
+	observed uint64
+
+	mu      sync.Mutex
+	standby []standbyRecord
+	replay  []eventRecord
+	// takeover wakes up delivery to replay the standby records
+	takeover chan struct{}
+
+	leaderGauge     prometheus.GaugeFunc
+	skippedCounter  prometheus.Counter
+	replayedCounter prometheus.Counter
+}
+
+type standbyRecord struct {
+	record   eventRecord
+	received time.Time
+}
+
+func newLeaderGate(clientset *kubernetes.Clientset, namespace, name, identity string) *leaderGate {
+	g := &leaderGate{
+		clientset: clientset,
+		namespace: namespace,
+		name:      name,
+		identity:  identity,
+		logger:    log.With().Str("component", "ha").Str("identity", identity).Logger(),
+		takeover:  make(chan struct{}, 1),
+	}
+	g.leaderGauge = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "ha_leader",
+		Help: "1 if this replica currently delivers events, 0 if it is on standby",
+	}, func() float64 {
+		return float64(atomic.LoadInt32(&g.leading))
+	})
+	g.skippedCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ha_standby_skipped_total",
+		Help: "Number of events not delivered because this replica was on standby and they left the standby buffer",
+	})
+	g.replayedCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ha_replayed_total",
+		Help: "Number of events buffered on standby and delivered after taking over leadership",
+	})
+	return g
+}
+
+// IsLeader reports whether this replica should deliver events
+func (g *leaderGate) IsLeader() bool {
+	return atomic.LoadInt32(&g.leading) == 1
+}
+
+// Standby keeps record for a replay after a takeover and reports true if this
+// replica is on standby, false if it should deliver record
+func (g *leaderGate) Standby(record eventRecord) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.IsLeader() {
+		return false
+	}
+	now := time.Now()
+	g.standby = append(g.standby, standbyRecord{record: record, received: now})
+	expired := 0
+	for expired < len(g.standby) &&
+		(len(g.standby)-expired > haStandbyBufferSize || now.Sub(g.standby[expired].received) > haStandbyWindow) {
+		expired++
+	}
+	if expired > 0 {
+		g.skippedCounter.Add(float64(expired))
+		drops.Add(dropCauseStandby, "", expired)
+		g.standby = append(g.standby[:0], g.standby[expired:]...)
+	}
+	return true
+}
+
+// Takeover is signaled once this replica became leader, see Replay
+func (g *leaderGate) Takeover() <-chan struct{} {
+	return g.takeover
+}
+
+// Replay returns the standby records to deliver after a takeover
+func (g *leaderGate) Replay() []eventRecord {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	records := g.replay
+	g.replay = nil
+	g.replayedCounter.Add(float64(len(records)))
+	return records
+}
+
+// Delivered marks record as delivered, for the Lease
+func (g *leaderGate) Delivered(record eventRecord) {
+	version, err := strconv.ParseUint(record.event.ResourceVersion, 10, 64)
+	if err != nil {
+		return
+	}
+	for {
+		delivered := atomic.LoadUint64(&g.delivered)
+		if version <= delivered || atomic.CompareAndSwapUint64(&g.delivered, delivered, version) {
+			return
+		}
+	}
+}
+
+// startLeading switches to delivery and queues the standby records newer than
+// the delivered mark of the previous leader for the replay. Without a mark,
+// all of them are replayed.
+func (g *leaderGate) startLeading() {
+	g.mu.Lock()
+	mark := atomic.LoadUint64(&g.observed)
+	for _, buffered := range g.standby {
+		version, err := strconv.ParseUint(buffered.record.event.ResourceVersion, 10, 64)
+		if mark == 0 || err != nil || version > mark {
+			g.replay = append(g.replay, buffered.record)
+		}
+	}
+	g.standby = nil
+	if mark > atomic.LoadUint64(&g.delivered) {
+		atomic.StoreUint64(&g.delivered, mark)
+	}
+	atomic.StoreInt32(&g.leading, 1)
+	replay := len(g.replay)
+	g.mu.Unlock()
+
+	select {
+	case g.takeover <- struct{}{}:
+	default:
+	}
+	g.logger.Info().Uint64("mark", mark).Int("replay", replay).Msg("Became leader, delivering events")
+}
+
+// Run takes part in the leader election until ctx is done
+func (g *leaderGate) Run(ctx context.Context) {
+	lock := &markingLeaseLock{
+		gate: g,
+		LeaseLock: resourcelock.LeaseLock{
+			LeaseMeta: metav1.ObjectMeta{
+				Namespace: g.namespace,
+				Name:      g.name,
+			},
+			Client: g.clientset.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{
+				Identity: g.identity,
+			},
+		},
+	}
+	config := leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   haLeaseDuration,
+		RenewDeadline:   haRenewDeadline,
+		RetryPeriod:     haRetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            g.name,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(context.Context) {
+				g.startLeading()
+			},
+			OnStoppedLeading: func() {
+				g.mu.Lock()
+				atomic.StoreInt32(&g.leading, 0)
+				g.mu.Unlock()
+				g.logger.Warn().Msg("Lost leadership, standing by")
+			},
+			OnNewLeader: func(identity string) {
+				if identity != g.identity {
+					g.logger.Info().Str("leader", identity).Msg("Standing by for leader")
+				}
+			},
+		},
+	}
+
+	g.logger.Info().Str("lease", g.namespace+"/"+g.name).Msg("Joining leader election")
+	// RunOrDie returns whenever leadership is lost, so keep competing
+	for ctx.Err() == nil {
+		leaderelection.RunOrDie(ctx, config)
+	}
+}
+
+// markingLeaseLock is a LeaseLock which also keeps the delivered mark of the
+// leader in haDeliveredAnnotation. resourcelock.LeaseLock rewrites only the
+// spec, so the mark cannot be patched in without conflicting with renewals.
+type markingLeaseLock struct {
+	resourcelock.LeaseLock
+	gate  *leaderGate
+	lease *coordinationv1.Lease
+}
+
+// Get returns the election record and notes the mark of another leader
+func (l *markingLeaseLock) Get(ctx context.Context) (*resourcelock.LeaderElectionRecord, []byte, error) {
+	lease, err := l.Client.Leases(l.LeaseMeta.Namespace).Get(ctx, l.LeaseMeta.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	l.lease = lease
+	record := resourcelock.LeaseSpecToLeaderElectionRecord(&lease.Spec)
+	if record.HolderIdentity != l.Identity() {
+		mark, _ := strconv.ParseUint(lease.Annotations[haDeliveredAnnotation], 10, 64)
+		atomic.StoreUint64(&l.gate.observed, mark)
+	}
+	recordBytes, err := json.Marshal(*record)
+	if err != nil {
+		return nil, nil, err
+	}
+	return record, recordBytes, nil
+}
+
+// Create creates the Lease
+func (l *markingLeaseLock) Create(ctx context.Context, ler resourcelock.LeaderElectionRecord) error {
+	lease, err := l.Client.Leases(l.LeaseMeta.Namespace).Create(ctx, &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        l.LeaseMeta.Name,
+			Namespace:   l.LeaseMeta.Namespace,
+			Annotations: map[string]string{haDeliveredAnnotation: l.mark()},
+		},
+		Spec: resourcelock.LeaderElectionRecordToLeaseSpec(&ler),
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return err
+	}
+	l.lease = lease
+	return nil
+}
+
+// Update renews the Lease along with the delivered mark
+func (l *markingLeaseLock) Update(ctx context.Context, ler resourcelock.LeaderElectionRecord) error {
+	if l.lease == nil {
+		return errors.New("lease not initialized, call get or create first")
+	}
+	lease := l.lease.DeepCopy()
+	lease.Spec = resourcelock.LeaderElectionRecordToLeaseSpec(&ler)
+	if lease.Annotations == nil {
+		lease.Annotations = map[string]string{}
+	}
+	lease.Annotations[haDeliveredAnnotation] = l.mark()
+	lease, err := l.Client.Leases(l.LeaseMeta.Namespace).Update(ctx, lease, metav1.UpdateOptions{})
+	if err != nil {
+		return err
+	}
+	l.lease = lease
+	return nil
+}
+
+// RecordEvent is a no-op like for LeaseLock without an EventRecorder
+func (l *markingLeaseLock) RecordEvent(string) {}
+
+func (l *markingLeaseLock) Describe() string {
+	return fmt.Sprintf("%v/%v", l.LeaseMeta.Namespace, l.LeaseMeta.Name)
+}
+
+func (l *markingLeaseLock) mark() string {
+	return strconv.FormatUint(atomic.LoadUint64(&l.gate.delivered), 10)
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Health states, ordered from best to worst. GOOD and DEGRADED are served
+// with 200, so that a degraded tailer is not restarted by the liveness probe.
+const (
+	healthGood     = "GOOD"
+	healthDegraded = "DEGRADED"
+	healthBad      = "BAD"
+)
+
+var healthRank = map[string]int{healthGood: 0, healthDegraded: 1, healthBad: 2}
+
+// componentHealth is the state of a single component in the health report
+type componentHealth struct {
+	Status  string                 `json:"status"`
+	Message string                 `json:"message,omitempty"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// healthCheck reports the current health of a component
+type healthCheck func() componentHealth
+
+// healthReport is served by /healthz. Its status is the worst status of all
+// components.
+type healthReport struct {
+	Status     string                     `json:"status"`
+	Components map[string]componentHealth `json:"components,omitempty"`
+}
+
+// healthChecks holds the registered components by name
+type healthChecks struct {
+	mu     sync.Mutex
+	checks map[string]healthCheck
+}
+
+func (hc *healthChecks) add(name string, check healthCheck) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	if hc.checks == nil {
+		hc.checks = map[string]healthCheck{}
+	}
+	hc.checks[name] = check
+}
+
+func (hc *healthChecks) remove(name string) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	delete(hc.checks, name)
+}
+
+func (hc *healthChecks) report() healthReport {
+	hc.mu.Lock()
+	names := make([]string, 0, len(hc.checks))
+	// checks are copied as they may be replaced while they are run
+	checks := make(map[string]healthCheck, len(hc.checks))
+	for name, check := range hc.checks {
+		names = append(names, name)
+		checks[name] = check
+	}
+	hc.mu.Unlock()
+	sort.Strings(names)
+
+	report := healthReport{Status: healthGood, Components: map[string]componentHealth{}}
+	for _, name := range names {
+		component := checks[name]()
+		report.Components[name] = component
+		if healthRank[component.Status] > healthRank[report.Status] {
+			report.Status = component.Status
+		}
+	}
+	return report
+}
+
+func (ws *WebServer) healthHandler(w http.ResponseWriter, r *http.Request) {
+	ws.writeHealthReport(w, ws.health.report())
+}
+
+// readyHandler serves the readiness report on /readyz. Unlike /healthz, it
+// is meant for readiness probes, so that stream and API clients are not
+// routed to a tailer which is not watching events.
+func (ws *WebServer) readyHandler(w http.ResponseWriter, r *http.Request) {
+	ws.writeHealthReport(w, ws.readiness.report())
+}
+
+func (ws *WebServer) writeHealthReport(w http.ResponseWriter, report healthReport) {
+	w.Header().Add("Content-Type", "application/json; charset=UTF-8")
+	if report.Status == healthBad {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		ws.logger.Error().Err(err).Msg("Could not write health report")
+	}
+}
+
+// informerHealth is degraded while informers have not synced yet, and bad
+// once an informer failed to sync within the stall timeout.
+func (ew *EventWatcher) informerHealth() componentHealth {
+	ew.shardsMu.Lock()
+	defer ew.shardsMu.Unlock()
+	health := componentHealth{Status: healthGood}
+	var unsynced []string
+	for name, shard := range ew.shards {
+		if shard.controller.HasSynced() {
+			continue
+		}
+		unsynced = append(unsynced, name)
+		if ew.stallTimeout > 0 && time.Since(shard.started) > ew.stallTimeout {
+			health.Status = healthBad
+		} else if health.Status == healthGood {
+			health.Status = healthDegraded
+		}
+	}
+	sort.Strings(unsynced)
+	health.Details = map[string]interface{}{
+		"shards":   len(ew.shards),
+		"unsynced": unsynced,
+	}
+	if len(unsynced) > 0 {
+		health.Message = "informers have not synced yet"
+	}
+	return health
+}
+
+// informerReadiness is bad until the informers have synced initially, and
+// afterwards while an informer has not synced, its lists and watches have
+// kept failing for longer than watchBrokenThreshold or it has not seen any
+// list or watch event, including bookmarks, within readinessFreshness
+func (ew *EventWatcher) informerReadiness() componentHealth {
+	ew.shardsMu.Lock()
+	defer ew.shardsMu.Unlock()
+	health := componentHealth{Status: healthGood}
+	var unsynced, broken, stale []string
+	for name, shard := range ew.shards {
+		if !shard.controller.HasSynced() {
+			unsynced = append(unsynced, name)
+		}
+		if ew.watchBrokenThreshold > 0 && shard.broken() > ew.watchBrokenThreshold {
+			broken = append(broken, name)
+		}
+		if ew.readinessFreshness > 0 && shard.idle() > ew.readinessFreshness {
+			stale = append(stale, name)
+		}
+	}
+	sort.Strings(unsynced)
+	sort.Strings(broken)
+	sort.Strings(stale)
+	health.Details = map[string]interface{}{
+		"shards":   len(ew.shards),
+		"unsynced": unsynced,
+		"broken":   broken,
+		"stale":    stale,
+	}
+	switch {
+	case atomic.LoadInt32(&ew.initialSync) == 0:
+		health.Status = healthBad
+		health.Message = "informers have not synced initially yet"
+	case len(unsynced) > 0:
+		health.Status = healthBad
+		health.Message = "informers have not synced yet"
+	case len(broken) > 0:
+		health.Status = healthBad
+		health.Message = "watches have been failing for longer than " + ew.watchBrokenThreshold.String()
+	case len(stale) > 0:
+		health.Status = healthBad
+		health.Message = "watches have not seen any event for longer than " + ew.readinessFreshness.String()
+	}
+	return health
+}
+
+// eventsHealth reports when the last event was received. A quiet cluster is
+// not unhealthy, so it is always good.
+func (ew *EventWatcher) eventsHealth() componentHealth {
+	health := componentHealth{
+		Status:  healthGood,
+		Details: map[string]interface{}{},
+	}
+	if last := ew.stats.LastEvent(); !last.IsZero() {
+		health.Details["last_event"] = last.UTC().Format(time.RFC3339)
+		health.Details["last_event_age_seconds"] = int64(time.Since(last).Seconds())
+	}
+	return health
+}
+
+// health is degraded while the queue is nearly full
+func (q *deliveryQueue) health() componentHealth {
+	length, capacity := len(q.items), cap(q.items)
+	health := componentHealth{
+		Status: healthGood,
+		Details: map[string]interface{}{
+			"length":   length,
+			"capacity": capacity,
+			"dropped":  q.Dropped(),
+		},
+	}
+	if length*10 >= capacity*9 {
+		health.Status = healthDegraded
+		health.Message = "delivery queue is nearly full"
+	}
+	return health
+}
+
+// health is degraded while the last write of the sink failed
+func (w *sinkWriter) health() componentHealth {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	health := componentHealth{
+		Status: healthGood,
+		Details: map[string]interface{}{
+			"consecutive_failures": w.failures,
+		},
+	}
+	if !w.lastSuccess.IsZero() {
+		health.Details["last_success"] = w.lastSuccess.UTC().Format(time.RFC3339)
+	}
+	if w.failures > 0 {
+		health.Status = healthDegraded
+		health.Message = w.lastError
+	}
+	return health
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var heartbeatGauge = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "heartbeat_timestamp_seconds",
+	Help: "Unix time of the last heartbeat",
+})
+
+// runHeartbeat logs a heartbeat record every heartbeatInterval until ctx is
+// done, so that log pipelines can alert when the tailer goes quiet even if
+// the cluster has no events. A zero interval disables it.
+func (ew *EventWatcher) runHeartbeat(ctx context.Context) {
+	if ew.heartbeatInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(ew.heartbeatInterval)
+	defer ticker.Stop()
+	lastTotal, lastTime := ew.eventsTotal(), time.Now()
+	for {
+		select {
+		case now := <-ticker.C:
+			total := ew.eventsTotal()
+			rate := float64(total-lastTotal) / now.Sub(lastTime).Seconds()
+			lastTotal, lastTime = total, now
+
+			entry := ew.logger.Info().
+				Str("type", "heartbeat").
+				Int64("uptime_seconds", int64(now.Sub(ew._startTime).Seconds())).
+				Float64("events_per_second", rate)
+			if last := ew.stats.LastEvent(); !last.IsZero() {
+				entry = entry.Time("last_event", last.UTC())
+			}
+			entry.Msg("HEARTBEAT")
+			heartbeatGauge.Set(float64(now.Unix()))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// eventsTotal returns the number of events queued for delivery so far
+func (ew *EventWatcher) eventsTotal() uint64 {
+	return ew.stats.Added() + ew.stats.Updated() + ew.stats.Deleted()
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	historyMinutes = 60
+	// historyTopSeries limits the series per chart, the remaining values
+	// are summed up as "other"
+	historyTopSeries = 8
+	historyOther     = "other"
+)
+
+// eventHistory counts the published events per minute by namespace and
+// reason over the last hour, for the dashboard charts.
+type eventHistory struct {
+	mu sync.Mutex
+	// buckets is a ring of minutes, index minute % historyMinutes
+	buckets [historyMinutes]historyBucket
+}
+
+type historyBucket struct {
+	minute     int64
+	namespaces map[string]int
+	reasons    map[string]int
+}
+
+// historyReport is the JSON served on /api/v1/history. Series hold one
+// count per minute in Minutes, oldest first.
+type historyReport struct {
+	Minutes    []time.Time      `json:"minutes"`
+	Namespaces map[string][]int `json:"namespaces"`
+	Reasons    map[string][]int `json:"reasons"`
+}
+
+func newEventHistory() *eventHistory {
+	return &eventHistory{}
+}
+
+// Subscribe counts the events published on bus
+func (h *eventHistory) Subscribe(bus *eventBus) {
+	bus.Subscribe("history", subscriberOptions{lossy: true}, func(record eventRecord) {
+		h.add(time.Now(), record.event.Namespace, record.event.Reason)
+	})
+}
+
+func (h *eventHistory) add(now time.Time, namespace, reason string) {
+	minute := now.Unix() / 60
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	b := &h.buckets[minute%historyMinutes]
+	if b.minute != minute {
+		*b = historyBucket{minute: minute, namespaces: map[string]int{}, reasons: map[string]int{}}
+	}
+	b.namespaces[namespace]++
+	b.reasons[reason]++
+}
+
+func (h *eventHistory) report(now time.Time) historyReport {
+	current := now.Unix() / 60
+	report := historyReport{Minutes: make([]time.Time, historyMinutes)}
+	namespaces := make([]map[string]int, historyMinutes)
+	reasons := make([]map[string]int, historyMinutes)
+
+	h.mu.Lock()
+	for i := 0; i < historyMinutes; i++ {
+		minute := current - historyMinutes + 1 + int64(i)
+		report.Minutes[i] = time.Unix(minute*60, 0).UTC()
+		b := h.buckets[minute%historyMinutes]
+		if b.minute == minute {
+			namespaces[i] = copyCounts(b.namespaces)
+			reasons[i] = copyCounts(b.reasons)
+		}
+	}
+	h.mu.Unlock()
+
+	report.Namespaces = historySeries(namespaces)
+	report.Reasons = historySeries(reasons)
+	return report
+}
+
+func copyCounts(counts map[string]int) map[string]int {
+	out := make(map[string]int, len(counts))
+	for key, n := range counts {
+		out[key] = n
+	}
+	return out
+}
+
+// historySeries converts per-minute counts into a series per key, keeping
+// the keys with the most events
+func historySeries(minutes []map[string]int) map[string][]int {
+	totals := map[string]int{}
+	for _, counts := range minutes {
+		for key, n := range counts {
+			totals[key] += n
+		}
+	}
+	keys := make([]string, 0, len(totals))
+	for key := range totals {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if totals[keys[i]] != totals[keys[j]] {
+			return totals[keys[i]] > totals[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	top := map[string]bool{}
+	for i, key := range keys {
+		if i < historyTopSeries {
+			top[key] = true
+		}
+	}
+
+	series := map[string][]int{}
+	for i, counts := range minutes {
+		for key, n := range counts {
+			if !top[key] {
+				key = historyOther
+			}
+			if series[key] == nil {
+				series[key] = make([]int, len(minutes))
+			}
+			series[key][i] += n
+		}
+	}
+	return series
+}
+
+func (h *eventHistory) handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json; charset=UTF-8")
+	if err := json.NewEncoder(w).Encode(h.report(time.Now())); err != nil {
+		log.Error().Err(err).Msg("Could not write event history")
+	}
+}
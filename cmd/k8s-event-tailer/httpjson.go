@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"k8s-event-tailer/pkg/extension"
+)
+
+// maxErrorBodySize limits how much of an error response is kept for the
+// error message
+const maxErrorBodySize = 512
+
+// httpStatusError is returned for responses other than 2xx
+type httpStatusError struct {
+	status string
+	code   int
+	body   string
+}
+
+func (e *httpStatusError) Error() string {
+	if e.body == "" {
+		return "server returned " + e.status
+	}
+	return fmt.Sprintf("server returned %s: %s", e.status, e.body)
+}
+
+// checkResponse returns an error for responses other than 2xx. Client
+// errors are permanent, except for 408 and 429, since retrying the same
+// request will not help.
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+	err := &httpStatusError{status: resp.Status, code: resp.StatusCode, body: string(bytes.TrimSpace(body))}
+	if resp.StatusCode/100 == 4 && resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+		return extension.Permanent(err)
+	}
+	return err
+}
+
+// doJSON sends in as JSON body, unless it is nil, and decodes the response
+// into out, unless it is nil.
+func doJSON(ctx context.Context, client *http.Client, method, url string, header http.Header, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		payload, err := json.Marshal(in)
+		if err != nil {
+			return extension.Permanent(err)
+		}
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return extension.Permanent(err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// isHTTPStatus reports whether err is a response with the given status code
+func isHTTPStatus(err error, code int) bool {
+	var statusErr *httpStatusError
+	return errors.As(err, &statusErr) && statusErr.code == code
+}
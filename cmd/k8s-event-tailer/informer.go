@@ -0,0 +1,288 @@
+package main
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// informerOptions holds the settings cache.NewInformer does not expose
+type informerOptions struct {
+	// watchErrorHandler is called whenever the watch drops with an error
+	watchErrorHandler cache.WatchErrorHandler
+	// pageSize is the number of events fetched per request when listing,
+	// zero lists everything at once
+	pageSize int64
+}
+
+// newEventInformer works like cache.NewInformer for events, but accepts
+// additional options.
+func newEventInformer(lw cache.ListerWatcher, handler cache.ResourceEventHandler, opts informerOptions) (cache.Store, cache.Controller) {
+	if opts.pageSize > 0 {
+		lw = &pagedListerWatcher{ListerWatcher: lw}
+	}
+	store := cache.NewStore(cache.DeletionHandlingMetaNamespaceKeyFunc)
+	fifo := cache.NewDeltaFIFOWithOptions(cache.DeltaFIFOOptions{
+		KnownObjects:          store,
+		EmitDeltaTypeReplaced: true,
+	})
+
+	cfg := &cache.Config{
+		Queue:             fifo,
+		ListerWatcher:     lw,
+		ObjectType:        &corev1.Event{},
+		RetryOnError:      false,
+		WatchErrorHandler: opts.watchErrorHandler,
+		WatchListPageSize: opts.pageSize,
+
+		Process: func(obj interface{}) error {
+			deltas, ok := obj.(cache.Deltas)
+			if !ok {
+				return errors.New("object given as Process argument is not Deltas")
+			}
+			return processDeltas(handler, store, deltas)
+		},
+	}
+	return store, cache.New(cfg)
+}
+
+// processDeltas is a copy of the unexported function in client-go which
+// updates the store and calls the handler for each delta.
+func processDeltas(handler cache.ResourceEventHandler, store cache.Store, deltas cache.Deltas) error {
+	// from oldest to newest
+	for _, d := range deltas {
+		obj := d.Object
+		switch d.Type {
+		case cache.Sync, cache.Replaced, cache.Added, cache.Updated:
+			if old, exists, err := store.Get(obj); err == nil && exists {
+				if err := store.Update(obj); err != nil {
+					return err
+				}
+				handler.OnUpdate(old, obj)
+			} else {
+				if err := store.Add(obj); err != nil {
+					return err
+				}
+				handler.OnAdd(obj)
+			}
+		case cache.Deleted:
+			if err := store.Delete(obj); err != nil {
+				return err
+			}
+			handler.OnDelete(obj)
+		}
+	}
+	return nil
+}
+
+// watchOnlyController runs a bare Reflector on a store which keeps nothing and
+// passes every change straight to the handler. Since the watcher forgets
+// events right away anyway, this avoids the store churn of an informer.
+type watchOnlyController struct {
+	reflector *cache.Reflector
+	store     *watchOnlyStore
+}
+
+// newWatchOnlyController works like newEventInformer, but without a store
+func newWatchOnlyController(lw cache.ListerWatcher, handler cache.ResourceEventHandler, opts informerOptions) cache.Controller {
+	if opts.watchErrorHandler != nil {
+		// the reflector does not expose its error handler in this client-go version
+		lw = &observingListerWatcher{ListerWatcher: lw, onError: func(err error) {
+			opts.watchErrorHandler(nil, err)
+		}}
+	}
+	if opts.pageSize > 0 {
+		lw = &pagedListerWatcher{ListerWatcher: lw}
+	}
+	store := &watchOnlyStore{handler: handler}
+	reflector := cache.NewReflector(lw, &corev1.Event{}, store, 0)
+	reflector.WatchListPageSize = opts.pageSize
+	return &watchOnlyController{
+		reflector: reflector,
+		store:     store,
+	}
+}
+
+func (c *watchOnlyController) Run(stopCh <-chan struct{}) {
+	c.reflector.Run(stopCh)
+}
+
+func (c *watchOnlyController) HasSynced() bool {
+	return atomic.LoadInt32(&c.store.synced) == 1
+}
+
+func (c *watchOnlyController) LastSyncResourceVersion() string {
+	return c.reflector.LastSyncResourceVersion()
+}
+
+// watchOnlyStore implements cache.Store by forwarding every change to a
+// handler. It never holds any objects.
+type watchOnlyStore struct {
+	handler cache.ResourceEventHandler
+	synced  int32
+}
+
+func (s *watchOnlyStore) Add(obj interface{}) error {
+	s.handler.OnAdd(obj)
+	return nil
+}
+
+func (s *watchOnlyStore) Update(obj interface{}) error {
+	s.handler.OnUpdate(nil, obj)
+	return nil
+}
+
+func (s *watchOnlyStore) Delete(obj interface{}) error {
+	s.handler.OnDelete(obj)
+	return nil
+}
+
+// Replace is called with the result of every (re)list
+func (s *watchOnlyStore) Replace(list []interface{}, _ string) error {
+	for _, obj := range list {
+		s.handler.OnAdd(obj)
+	}
+	atomic.StoreInt32(&s.synced, 1)
+	return nil
+}
+
+func (s *watchOnlyStore) List() []interface{} { return nil }
+func (s *watchOnlyStore) ListKeys() []string  { return nil }
+func (s *watchOnlyStore) Resync() error       { return nil }
+func (s *watchOnlyStore) Get(interface{}) (interface{}, bool, error) {
+	return nil, false, nil
+}
+func (s *watchOnlyStore) GetByKey(string) (interface{}, bool, error) {
+	return nil, false, nil
+}
+
+// observingListerWatcher reports list and watch errors as well as any
+// activity, including bookmarks, before passing them on to the reflector
+type observingListerWatcher struct {
+	cache.ListerWatcher
+	// onError is called for failed lists and watches, may be nil
+	onError func(error)
+	// onActivity is called for successful lists and every watch event, may
+	// be nil
+	onActivity func()
+}
+
+func (lw *observingListerWatcher) List(options metav1.ListOptions) (runtime.Object, error) {
+	obj, err := lw.ListerWatcher.List(options)
+	if err != nil {
+		lw.error(err)
+	} else {
+		lw.activity()
+	}
+	return obj, err
+}
+
+func (lw *observingListerWatcher) Watch(options metav1.ListOptions) (watch.Interface, error) {
+	w, err := lw.ListerWatcher.Watch(options)
+	if err != nil {
+		lw.error(err)
+		return nil, err
+	}
+	return watch.Filter(w, func(in watch.Event) (watch.Event, bool) {
+		if in.Type == watch.Error {
+			lw.error(apierrors.FromObject(in.Object))
+		} else {
+			lw.activity()
+		}
+		return in, true
+	}), nil
+}
+
+func (lw *observingListerWatcher) error(err error) {
+	if lw.onError != nil {
+		lw.onError(err)
+	}
+}
+
+func (lw *observingListerWatcher) activity() {
+	if lw.onActivity != nil {
+		lw.onActivity()
+	}
+}
+
+// pagedListerWatcher makes lists honor the page size. The reflector lists at
+// resourceVersion 0 at first, which the API server answers from its watch
+// cache, ignoring the limit and returning all events at once.
+type pagedListerWatcher struct {
+	cache.ListerWatcher
+}
+
+func (lw *pagedListerWatcher) List(options metav1.ListOptions) (runtime.Object, error) {
+	if options.Limit > 0 && options.ResourceVersion == "0" {
+		options.ResourceVersion = ""
+	}
+	return lw.ListerWatcher.List(options)
+}
+
+// Backoff of lists and watches after consecutive failures
+const (
+	watchBackoffInitial = time.Second
+	watchBackoffMax     = time.Minute
+	watchBackoffJitter  = 0.2
+)
+
+// backoffListerWatcher delays lists and watches exponentially while the
+// previous ones keep failing, so that an unavailable API server is not hit
+// by every informer at the reflector's pace.
+type backoffListerWatcher struct {
+	cache.ListerWatcher
+	// failures returns the number of consecutive failures
+	failures func() int32
+	// onRetry is called before a delayed list or watch, may be nil
+	onRetry func(delay time.Duration)
+	stop    <-chan struct{}
+}
+
+func (lw *backoffListerWatcher) List(options metav1.ListOptions) (runtime.Object, error) {
+	lw.wait()
+	return lw.ListerWatcher.List(options)
+}
+
+func (lw *backoffListerWatcher) Watch(options metav1.ListOptions) (watch.Interface, error) {
+	lw.wait()
+	return lw.ListerWatcher.Watch(options)
+}
+
+func (lw *backoffListerWatcher) wait() {
+	failures := lw.failures()
+	if failures == 0 {
+		return
+	}
+	delay := watchBackoff(failures)
+	if lw.onRetry != nil {
+		lw.onRetry(delay)
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-lw.stop:
+	}
+}
+
+// watchBackoff returns the delay after failures consecutive failures
+func watchBackoff(failures int32) time.Duration {
+	delay := watchBackoffInitial
+	for i := int32(1); i < failures && delay < watchBackoffMax; i++ {
+		delay *= 2
+	}
+	// jitter spreads the reconnects of the informers
+	delay = wait.Jitter(delay, watchBackoffJitter)
+	if delay > watchBackoffMax {
+		delay = watchBackoffMax
+	}
+	return delay
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"os"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// runKubeconfigReload rebuilds the client and restarts all informers when
+// the content of the kubeconfig changes, e.g. because an external tool
+// refreshed a token or switched the current context. The delivery queue and
+// the sinks are not touched, so no buffered event is lost. The informers
+// resume watching where they stopped, unless the kubeconfig now points to
+// another API server. The files are polled, which also detects atomic
+// replacements of mounted secrets.
+func (ew *EventWatcher) runKubeconfigReload(ctx context.Context) {
+	if len(ew.kubeconfigPaths) == 0 || ew.kubeconfigReloadInterval <= 0 || ew.newClient == nil {
+		return
+	}
+	logger := ew.logger.With().Strs("kubeconfig", ew.kubeconfigPaths).Logger()
+	last, found, err := filesDigest(ew.kubeconfigPaths)
+	if !found {
+		return
+	}
+	if err != nil {
+		logger.Warn().Err(err).Msg("Could not read kubeconfig, changes are not detected")
+		return
+	}
+
+	ticker := time.NewTicker(ew.kubeconfigReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		digest, _, err := filesDigest(ew.kubeconfigPaths)
+		if err != nil {
+			// tools may replace the file non-atomically, retry next time
+			logger.Debug().Err(err).Msg("Could not read kubeconfig")
+			continue
+		}
+		if digest == last {
+			continue
+		}
+
+		client, err := ew.newClient()
+		if err != nil {
+			logger.Error().Err(err).Msg("Kubeconfig changed but is invalid, keeping the current client")
+			continue
+		}
+		last = digest
+		ew.clientMu.Lock()
+		switched := apiServerHost(client) != apiServerHost(ew.client)
+		ew.client = client
+		ew.clientMu.Unlock()
+		ew.kubeconfigReloads.Inc()
+
+		ew.shardsMu.Lock()
+		shards := make([]*informerShard, 0, len(ew.shards))
+		for _, shard := range ew.shards {
+			shards = append(shards, shard)
+		}
+		ew.shardsMu.Unlock()
+		if switched {
+			logger.Info().Int("shards", len(shards)).Str("server", apiServerHost(client)).
+				Msg("Kubeconfig changed to another API server, relisting events with the new client")
+		} else {
+			logger.Info().Int("shards", len(shards)).Msg("Kubeconfig changed, restarting informers with the new client")
+		}
+		for _, shard := range shards {
+			if ctx.Err() != nil {
+				return
+			}
+			if switched {
+				ew.relistShard(ctx, shard)
+			} else {
+				ew.restartShard(ctx, shard)
+			}
+		}
+	}
+}
+
+// apiServerHost returns the host of the API server client talks to
+func apiServerHost(client kubernetes.Interface) string {
+	restClient, ok := client.CoreV1().RESTClient().(*rest.RESTClient)
+	if !ok || restClient == nil {
+		return ""
+	}
+	return restClient.Get().URL().Host
+}
+
+// filesDigest hashes the content of the existing files in paths. Missing
+// files are skipped as the kubeconfig loading rules ignore them too, found
+// reports whether any file exists.
+func filesDigest(paths []string) (digest [sha256.Size]byte, found bool, err error) {
+	h := sha256.New()
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			h.Write(make([]byte, sha256.Size))
+			continue
+		}
+		if err != nil {
+			return digest, true, err
+		}
+		found = true
+		sum := sha256.Sum256(data)
+		h.Write(sum[:])
+	}
+	copy(digest[:], h.Sum(nil))
+	return digest, found, nil
+}
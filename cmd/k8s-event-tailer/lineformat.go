@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// parseLineFormat parses the --format of event lines. A format with actions
+// is a text/template executed with the jsonEventRecord of the event, like
+// "{{.Namespace}}/{{.InvolvedObject.Name}} {{.Reason}}: {{.Message}}".
+// Otherwise it is a comma-separated list of the JSON field names of the
+// record, like "namespace,involvedObject.name,reason,message", printed
+// separated by spaces.
+func parseLineFormat(format string) (*template.Template, error) {
+	text := format
+	if !strings.Contains(format, "{{") {
+		var err error
+		if text, err = fieldListTemplate(format); err != nil {
+			return nil, err
+		}
+	}
+	tmpl, err := template.New("format").Option("missingkey=error").Funcs(template.FuncMap{
+		"json":    templateJSON,
+		"rfc3339": templateRFC3339,
+	}).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid format template: %w", err)
+	}
+	// unknown fields only fail on execution
+	if err := tmpl.Execute(&strings.Builder{}, jsonEventRecord{}); err != nil {
+		return nil, fmt.Errorf("invalid format template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// fieldListTemplate returns the template printing the fields of list
+func fieldListTemplate(list string) (string, error) {
+	var actions []string
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		action, err := fieldAction(reflect.TypeOf(jsonEventRecord{}), name)
+		if err != nil {
+			return "", err
+		}
+		actions = append(actions, action)
+	}
+	if len(actions) == 0 {
+		return "", fmt.Errorf("empty format")
+	}
+	return strings.Join(actions, " "), nil
+}
+
+// fieldAction returns the template action printing the field of t with
+// the dotted JSON field name. Optional objects are entered with "with", so
+// that unset ones print nothing.
+func fieldAction(t reflect.Type, name string) (string, error) {
+	var path, open, end string
+	segments := strings.Split(name, ".")
+	for i, segment := range segments {
+		field, ok := jsonField(t, segment)
+		if !ok {
+			return "", fmt.Errorf("unknown field %q", name)
+		}
+		path += "." + field.Name
+		t = field.Type
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+			if t.Kind() == reflect.Struct && t != timeType && i < len(segments)-1 {
+				open += "{{with " + path + "}}"
+				end = "{{end}}" + end
+				path = ""
+			}
+		}
+	}
+	if t.Kind() == reflect.Struct && t != timeType {
+		return "", fmt.Errorf("field %q is an object, name one of its fields", name)
+	}
+	if t == timeType {
+		return open + "{{rfc3339 " + path + "}}" + end, nil
+	}
+	return open + "{{" + path + "}}" + end, nil
+}
+
+// jsonField returns the field of struct t encoded as name, including the
+// fields of embedded structs
+func jsonField(t reflect.Type, name string) (reflect.StructField, bool) {
+	if t.Kind() != reflect.Struct || t == timeType {
+		return reflect.StructField{}, false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("json"), ",")[0]
+		if field.Anonymous && tag == "" {
+			// templates reach the fields promoted by embedding directly
+			if embedded, ok := jsonField(field.Type, name); ok {
+				return embedded, true
+			}
+			continue
+		}
+		if tag == name {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// templateRFC3339 formats a time or time pointer as RFC 3339, empty if unset
+func templateRFC3339(v interface{}) string {
+	switch t := v.(type) {
+	case time.Time:
+		if !t.IsZero() {
+			return t.Format(time.RFC3339)
+		}
+	case *time.Time:
+		if t != nil && !t.IsZero() {
+			return t.Format(time.RFC3339)
+		}
+	}
+	return ""
+}
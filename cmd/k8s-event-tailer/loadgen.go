@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const loadgenComponent = "k8s-event-tailer-loadgen"
+
+// loadgen creates synthetic events at a fixed rate until interrupted or the
+// configured duration has passed
+func loadgen() {
+	logger := log.With().Str("component", "loadgen").Str("namespace", *loadgenNamespace).Logger()
+	if *loadgenRate <= 0 || *loadgenObjects < 1 || *loadgenReasons < 1 {
+		logger.Fatal().Msg("Rate, objects and reasons must be positive")
+	}
+	// the ticker needs an interval of at least a nanosecond
+	if *loadgenRate > float64(time.Second) {
+		logger.Fatal().Msgf("Rate must be at most %d events per second", time.Second)
+	}
+	if *loadgenWarningRatio < 0 || *loadgenWarningRatio > 1 {
+		logger.Fatal().Msg("Warning ratio must be between 0 and 1")
+	}
+
+	clientset := getKubeClient()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *loadgenDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *loadgenDuration)
+		defer cancel()
+	}
+
+	if err := ensureNamespace(ctx, clientset, *loadgenNamespace); err != nil {
+		logger.Fatal().Err(err).Msg("Could not create namespace")
+	}
+
+	logger.Info().
+		Float64("rate", *loadgenRate).
+		Int("objects", *loadgenObjects).
+		Int("reasons", *loadgenReasons).
+		Msg("Creating events")
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / *loadgenRate))
+	defer ticker.Stop()
+	start := time.Now()
+	var created, failed int
+	for i := 0; ; i++ {
+		select {
+		case <-ctx.Done():
+			logger.Info().
+				Int("created", created).
+				Int("failed", failed).
+				Str("elapsed", time.Since(start).Round(time.Second).String()).
+				Msg("Load generation finished")
+			return
+		case <-ticker.C:
+		}
+
+		_, err := clientset.CoreV1().Events(*loadgenNamespace).Create(ctx, syntheticEvent(i), metav1.CreateOptions{})
+		if err != nil {
+			if ctx.Err() == nil {
+				failed++
+				logger.Error().Err(err).Msg("Could not create event")
+			}
+			continue
+		}
+		created++
+	}
+}
+
+// syntheticEvent returns the i-th generated event. Involved objects and
+// reasons cycle independently, so that all combinations occur.
+func syntheticEvent(i int) *corev1.Event {
+	now := metav1.Now()
+	eventType := corev1.EventTypeNormal
+	if rand.Float64() < *loadgenWarningRatio {
+		eventType = corev1.EventTypeWarning
+	}
+	object := fmt.Sprintf("loadgen-%d", i%*loadgenObjects)
+	reason := fmt.Sprintf("LoadGen%d", i%*loadgenReasons)
+	return &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: object + ".",
+			Namespace:    *loadgenNamespace,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			Kind:       "Pod",
+			APIVersion: "v1",
+			Namespace:  *loadgenNamespace,
+			Name:       object,
+		},
+		Reason:         reason,
+		Message:        fmt.Sprintf("Synthetic event %d for %s", i, object),
+		Type:           eventType,
+		Count:          1,
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Source:         corev1.EventSource{Component: loadgenComponent},
+	}
+}
+
+func ensureNamespace(ctx context.Context, clientset *kubernetes.Clientset, name string) error {
+	_, err := clientset.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+	if !apierrors.IsNotFound(err) {
+		return err
+	}
+	_, err = clientset.CoreV1().Namespaces().Create(ctx, &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+	}, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) {
+		return nil
+	}
+	return err
+}
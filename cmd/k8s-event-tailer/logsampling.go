@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// logSampleAnyReason is the rule key applying to reasons without their own rule
+const logSampleAnyReason = "*"
+
+var logSampledCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "event_log_sampled_total",
+	Help: "Number of events left out of the log by sampling, by sampling rule",
+}, []string{"rule"})
+
+// logSampler thins out high-frequency events in the log. Only every Nth
+// event of a reason is logged, warnings are always logged. Sampling only
+// affects the log, sinks still receive every event.
+type logSampler struct {
+	rates  map[string]uint64
+	mu     sync.Mutex
+	counts map[string]uint64
+}
+
+// newLogSampler parses rules of reason to N, e.g. Pulled=50 to log one in 50
+// Pulled events. The reason * applies to all reasons without a rule.
+func newLogSampler(rules map[string]string) (*logSampler, error) {
+	s := &logSampler{
+		rates:  map[string]uint64{},
+		counts: map[string]uint64{},
+	}
+	for reason, value := range rules {
+		rate, err := strconv.ParseUint(value, 10, 64)
+		if err != nil || rate == 0 {
+			return nil, fmt.Errorf("invalid log sampling rate %q for %s, expected a positive number", value, reason)
+		}
+		s.rates[reason] = rate
+	}
+	return s, nil
+}
+
+// Sample reports whether event should be logged
+func (s *logSampler) Sample(event *corev1.Event) bool {
+	if s == nil || event.Type == corev1.EventTypeWarning {
+		return true
+	}
+	rule := event.Reason
+	rate, ok := s.rates[rule]
+	if !ok {
+		rule = logSampleAnyReason
+		rate = s.rates[rule]
+	}
+	if rate <= 1 {
+		return true
+	}
+
+	s.mu.Lock()
+	// counted per reason, so that the default rule does not let a chatty
+	// reason starve the others
+	n := s.counts[event.Reason]
+	s.counts[event.Reason] = n + 1
+	s.mu.Unlock()
+	if n%rate == 0 {
+		return true
+	}
+	logSampledCounter.WithLabelValues(rule).Inc()
+	return false
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// logstashSink writes events as JSON lines to a Logstash tcp input with the
+// json_lines codec, or an Elastic Agent TCP input.
+type logstashSink struct {
+	conn *streamConn
+}
+
+// newLogstashSink connects to an address of the form tcp:// or
+// tls://host:port
+func newLogstashSink(address string, policy *tlsPolicy) (*logstashSink, error) {
+	scheme, hostPort, err := parseStreamAddress(address, "tcp", "tls")
+	if err != nil {
+		return nil, err
+	}
+	s := &logstashSink{conn: newStreamConn(hostPort, nil)}
+	if scheme == "tls" {
+		s.conn = newStreamConn(hostPort, policy.Config())
+	}
+	return s, nil
+}
+
+func (s *logstashSink) Name() string {
+	return "logstash"
+}
+
+func (s *logstashSink) Write(event *corev1.Event) error {
+	return s.WriteBatch([]*corev1.Event{event})
+}
+
+// WriteBatch sends all events with a single write. After a broken
+// connection, the batch is retried on a new one, which may duplicate events
+// Logstash already received.
+func (s *logstashSink) WriteBatch(events []*corev1.Event) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, event := range events {
+		if err := enc.Encode(newJSONEvent(event)); err != nil {
+			return err
+		}
+	}
+	return s.conn.Write(buf.Bytes())
+}
+
+func (s *logstashSink) Close() error {
+	return s.conn.Close()
+}
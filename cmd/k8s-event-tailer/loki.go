@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	lokiSinkName         = "loki"
+	defaultLokiQueueSize = 10000
+)
+
+// lokiLabels are the event fields which can be used as stream labels
+var lokiLabels = []string{"namespace", "reason", "type", "kind", "source"}
+
+var lokiLabelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// lokiOptions configures the Loki sink
+type lokiOptions struct {
+	url string
+	// labels are added to every stream
+	labels map[string]string
+	// derivedLabels are the event fields of lokiLabels added as labels
+	derivedLabels []string
+	// tenant is sent as X-Scope-OrgID for multi-tenant Loki
+	tenant   string
+	username string
+	password string
+	tls      *tlsPolicy
+}
+
+// lokiSink pushes events as JSON lines with the push API. Each batch is sent
+// with one request, grouped into streams by their labels.
+type lokiSink struct {
+	url           string
+	labels        map[string]string
+	derivedLabels []string
+	header        http.Header
+	client        *http.Client
+}
+
+type lokiPushRequest struct {
+	Streams []lokiStream `json:"streams"`
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+func newLokiSink(opts lokiOptions) (*lokiSink, error) {
+	if opts.url == "" {
+		return nil, fmt.Errorf("loki URL missing")
+	}
+	for name := range opts.labels {
+		if !lokiLabelName.MatchString(name) {
+			return nil, fmt.Errorf("invalid loki label name %q", name)
+		}
+	}
+	url := strings.TrimSuffix(opts.url, "/")
+	if !strings.HasSuffix(url, "/loki/api/v1/push") {
+		url += "/loki/api/v1/push"
+	}
+	header := http.Header{}
+	if opts.tenant != "" {
+		header.Set("X-Scope-OrgID", opts.tenant)
+	}
+	s := &lokiSink{
+		url:           url,
+		labels:        opts.labels,
+		derivedLabels: opts.derivedLabels,
+		header:        header,
+		client:        opts.tls.HTTPClient(30 * time.Second),
+	}
+	if opts.username != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(opts.username + ":" + opts.password))
+		s.header.Set("Authorization", "Basic "+credentials)
+	}
+	return s, nil
+}
+
+func (s *lokiSink) Name() string {
+	return lokiSinkName
+}
+
+func (s *lokiSink) Write(event *corev1.Event) error {
+	return s.WriteBatch([]*corev1.Event{event})
+}
+
+// WriteBatch pushes the events with one request. Loki answers 429 while it
+// is overloaded, which is retried with backoff, meanwhile further events are
+// queued for the sink up to its queue size.
+func (s *lokiSink) WriteBatch(events []*corev1.Event) error {
+	var request lokiPushRequest
+	streams := map[string]int{}
+	for _, event := range events {
+		labels := s.eventLabels(event)
+		key := lokiStreamKey(labels)
+		i, ok := streams[key]
+		if !ok {
+			i = len(request.Streams)
+			streams[key] = i
+			request.Streams = append(request.Streams, lokiStream{Stream: labels})
+		}
+		line, err := json.Marshal(newJSONEvent(event))
+		if err != nil {
+			return err
+		}
+		timestamp := strconv.FormatInt(eventTime(event).UnixNano(), 10)
+		request.Streams[i].Values = append(request.Streams[i].Values, [2]string{timestamp, string(line)})
+	}
+	return doJSON(context.Background(), s.client, http.MethodPost, s.url, s.header, request, nil)
+}
+
+// eventLabels returns the static labels and the derived labels of event.
+// Labels of empty fields are left out, as Loki does not accept empty values.
+func (s *lokiSink) eventLabels(event *corev1.Event) map[string]string {
+	labels := make(map[string]string, len(s.labels)+len(s.derivedLabels))
+	for name, value := range s.labels {
+		labels[name] = value
+	}
+	for _, name := range s.derivedLabels {
+		var value string
+		switch name {
+		case "namespace":
+			value = event.Namespace
+		case "reason":
+			value = event.Reason
+		case "type":
+			value = event.Type
+		case "kind":
+			value = event.InvolvedObject.Kind
+		case "source":
+			value = event.Source.Component
+		}
+		if value != "" {
+			labels[name] = value
+		}
+	}
+	return labels
+}
+
+// lokiStreamKey returns the labels in a canonical form
+func lokiStreamKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s=%q,", name, labels[name])
+	}
+	return b.String()
+}
+
+func (s *lokiSink) Close() error {
+	return nil
+}
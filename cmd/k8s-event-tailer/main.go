@@ -1,92 +1,1282 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strconv"
 	"strings"
-	"sync"
 	"syscall"
+	"text/template"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"gopkg.in/alecthomas/kingpin.v2"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/oidc"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog/v2"
+
+	"k8s-event-tailer/pkg/extension"
 )
 
+// Log output formats
+const (
+	outputFormatConsole = "console"
+	outputFormatJSON    = "json"
+)
+
+var outputFormats = []string{outputFormatConsole, outputFormatJSON}
+
 const (
 	defaultKubeconfig = ""
 	defaultPort       = 8000
+	defaultQueueSize  = 1000
+
+	defaultStatsIntervalSeconds = 10
+	defaultSinkMaxRetries       = 3
+	defaultSinkRetriesPerMinute = 60
+	defaultSinkBatchSize        = 500
+	defaultSinkBatchInterval    = "2s"
+
+	defaultHALeaseNamespace = "k8s-event-tailer"
+	defaultStallTimeout     = "15m"
+	defaultListPageSize     = 500
+
+	defaultRedactBase64MinLength = 64
+	defaultTLSMinVersion         = "1.2"
+
+	defaultTraceServiceName     = "k8s-event-tailer"
+	defaultSentryMinOccurrences = 3
+	defaultAlertResolveAfter    = "30m"
+	defaultRemoteWriteInterval  = "30s"
+	defaultSentryInterval       = "10m"
+	defaultTraceSampleRatio     = "1"
+
+	defaultProfilingAppName     = "k8s-event-tailer"
+	defaultProfilingInterval    = "60s"
+	defaultProfilingCPUDuration = "10s"
+
+	defaultLoadgenNamespace = "k8s-event-tailer-loadgen"
+	defaultLoadgenRate      = 10
+	defaultLoadgenObjects   = 10
+	defaultLoadgenReasons   = 5
 )
 
 var (
-	kubeconfig = kingpin.Flag("kubeconfig", "Path to kubeconfig or set in env(KUBECONFIG)").Default(defaultKubeconfig).Short('k').Envar("KUBECONFIG").String()
-	verbose    = kingpin.Flag("verbose", "Debug logging").Short('v').Bool()
-	namespace  = kingpin.Flag("namespace", "Namespace").Default(corev1.NamespaceAll).Short('n').String()
-	port       = kingpin.Flag("port", "HTTP port for metrics").Default(strconv.Itoa(defaultPort)).Short('p').Int()
-
-	addCounter    int32
-	updateCounter int32
-	deleteCounter int32
+	kubeconfig                 = kingpin.Flag("kubeconfig", "Path to kubeconfig or set in env(KUBECONFIG), several colon separated files are merged. Defaults to ~/.kube/config, in a pod without kubeconfig the service account is used").Default(defaultKubeconfig).Short('k').Envar("KUBECONFIG").String()
+	tokenFile                  = kingpin.Flag("token-file", "File with a bearer token for the API server, overriding the kubeconfig credentials. Reread when it changes").String()
+	kubeconfigReloadInterval   = kingpin.Flag("kubeconfig-reload-interval", "How often the kubeconfig is checked for changes, which rebuild the client and restart the informers, 0 disables it").Default("10s").Duration()
+	configPath                 = kingpin.Flag("config", "YAML or JSON file with flags by their long name, lists for repeatable flags and maps for KEY=VALUE flags. Flags on the command line take precedence").String()
+	configReloadInterval       = kingpin.Flag("config-reload-interval", "How often the config file is checked for changes, which apply the filter and sink flags, 0 disables it").Default("10s").Duration()
+	verbose                    = kingpin.Flag("verbose", "Debug logging").Short('v').Bool()
+	outputFormat               = kingpin.Flag("output", "Log format: console on stderr, or json lines on stdout").Short('o').Default(outputFormatConsole).Enum(outputFormats...)
+	logFormat                  = kingpin.Flag("format", "Format of the event lines: a Go template of the JSON fields, e.g. '{{.Namespace}}/{{.InvolvedObject.Name}} {{.Reason}}: {{.Message}}', or a comma-separated list of JSON field names, e.g. namespace,involvedObject.name,reason,message").String()
+	runFor                     = kingpin.Flag("run-for", "Stop after this time, e.g. for bounded runs in CI pipelines, 0 runs until interrupted").Default("0").Duration()
+	failOnSpecs                = kingpin.Flag("fail-on", "Exit with code 1 if an event matching KEY=VALUE,... was seen, a bare value is short for type=VALUE, e.g. Warning (repeatable)").Strings()
+	tuiMode                    = kingpin.Flag("tui", "Show events in an interactive terminal UI instead of logging them").Bool()
+	tuiBufferSize              = kingpin.Flag("tui-buffer-size", "Number of events kept in the terminal UI").Default(strconv.Itoa(defaultTUIBufferSize)).Int()
+	namespace                  = kingpin.Flag("namespace", "Namespace to watch, several separated by commas, all if empty").Default(corev1.NamespaceAll).Short('n').String()
+	excludeNamespaces          = kingpin.Flag("exclude-namespace", "Skip the events of this namespace, e.g. kube-system (repeatable)").Strings()
+	includeReasons             = kingpin.Flag("include-reason", "Only handle events with this reason, e.g. FailedScheduling (repeatable)").Strings()
+	excludeReasons             = kingpin.Flag("exclude-reason", "Skip events with this reason (repeatable)").Strings()
+	eventTypes                 = kingpin.Flag("event-type", "Only handle events of this type (repeatable)").Enums(corev1.EventTypeNormal, corev1.EventTypeWarning)
+	matchPatterns              = kingpin.Flag("match", "Only handle events matching this regular expression, like grep (repeatable)").Strings()
+	excludePatterns            = kingpin.Flag("exclude", "Skip events matching this regular expression (repeatable)").Strings()
+	matchFieldNames            = kingpin.Flag("match-field", "Event field --match and --exclude apply to: "+strings.Join(matchFields, ", ")+". Defaults to message (repeatable)").Enums(matchFields...)
+	namespaceIgnoreAnnotation  = kingpin.Flag("namespace-ignore-annotation", "Skip the events of namespaces with this annotation set to true, e.g. k8s-event-tailer.eumel8.de/ignore").String()
+	eventAPI                   = kingpin.Flag("event-api", "API events are watched with, auto uses events.k8s.io/v1 if the API server serves it and core/v1 otherwise").Default(eventAPIAuto).Enum(eventAPIs...)
+	fieldSelector              = kingpin.Flag("field-selector", "Only watch events matching this field selector, e.g. involvedObject.kind=Pod,type=Warning").String()
+	labelSelector              = kingpin.Flag("label-selector", "Only watch events with labels matching this selector").String()
+	port                       = kingpin.Flag("port", "HTTP port of the dashboard, the APIs, the streams and, without --metrics-port, the metrics. 0 disables it").Default(strconv.Itoa(defaultPort)).Short('p').Int()
+	bindAddress                = kingpin.Flag("bind-address", "Address the web and gRPC servers listen on, all interfaces if empty, e.g. 127.0.0.1").String()
+	metricsPort                = kingpin.Flag("metrics-port", "Separate HTTP port for the metrics and health checks, e.g. to scrape them on another interface than the APIs. 0 serves them on --port").Default("0").Int()
+	metricsBindAddress         = kingpin.Flag("metrics-bind-address", "Address the metrics port listens on, --bind-address if empty").String()
+	eventBufferSize            = kingpin.Flag("buffer-size", "Number of recent events kept in memory for /api/v1/events, the gRPC ListRecent method and /events/sse clients reconnecting with Last-Event-ID").Default(strconv.Itoa(defaultEventBufferSize)).Int()
+	grpcPort                   = kingpin.Flag("grpc-port", "Port of the gRPC API of api/v1/events.proto, served with TLS if --tls-cert is set, 0 disables it").Default("0").Int()
+	archiveDir                 = kingpin.Flag("archive-dir", "Directory of the database to persist events in, queried on /api/v1/archive. Disabled if empty").String()
+	archiveRetention           = kingpin.Flag("archive-retention", "Time archived events are kept").Default(defaultArchiveRetention).Duration()
+	archiveKeyFile             = kingpin.Flag("archive-encryption-key-file", "File with a 256 bit key, raw, hex or base64 encoded, to encrypt archived events and S3 objects with AES-256-GCM").String()
+	statsInterval              = kingpin.Flag("stats-interval", "Seconds after which stats are printed, 0 to disable").Default(strconv.Itoa(defaultStatsIntervalSeconds)).Short('s').Int()
+	heartbeatInterval          = kingpin.Flag("heartbeat-interval", "Interval of the heartbeat log record, 0 to disable").Default("0").Duration()
+	queueSize                  = kingpin.Flag("queue-size", "Number of events buffered for delivery").Default(strconv.Itoa(defaultQueueSize)).Int()
+	dropPolicy                 = kingpin.Flag("drop-policy", "What to do when the delivery queue is full: "+strings.Join(dropPolicies, ", ")).Default(dropPolicyBlock).Enum(dropPolicies...)
+	memoryBudgetBytes          = kingpin.Flag("memory-budget", "Memory budget for buffered events (e.g. 64MB), oldest events are evicted when exceeded. 0 for unlimited").Default("0").Bytes()
+	shardByNamespace           = kingpin.Flag("shard-by-namespace", "Run one informer per namespace when watching all namespaces").Bool()
+	replicas                   = kingpin.Flag("replicas", "Number of replicas splitting the namespaces among themselves").Default("1").Int()
+	replicaOrdinal             = kingpin.Flag("replica-ordinal", "Ordinal of this replica, taken from the hostname of StatefulSet pods if negative").Default("-1").Int()
+	haLease                    = kingpin.Flag("ha-lease", "Name of the Lease coordinating replicas in HA mode, only the leader delivers events. On failover, the new leader replays the events of its last 30s (at most 10000) which the previous leader had not delivered, events missed for longer are lost. Disabled if empty").String()
+	haLeaseNamespace           = kingpin.Flag("ha-lease-namespace", "Namespace of the HA Lease").Default(defaultHALeaseNamespace).Envar("POD_NAMESPACE").String()
+	stallTimeout               = kingpin.Flag("stall-timeout", "Restart informers whose watch has been silent, without even bookmarks, for this long. 0 to disable").Default(defaultStallTimeout).Duration()
+	watchBrokenThreshold       = kingpin.Flag("watch-broken-threshold", "Report not ready on /readyz once the list/watch of an informer has kept failing for this long. 0 to disable").Default("1m").Duration()
+	readinessFreshness         = kingpin.Flag("readiness-freshness", "Report not ready on /readyz while an informer has not seen any list or watch event, including bookmarks, for this long. 0 to disable").Default("5m").Duration()
+	listPageSize               = kingpin.Flag("list-page-size", "Number of events fetched per request when listing, 0 to list all at once").Default(strconv.Itoa(defaultListPageSize)).Int64()
+	watchOnly                  = kingpin.Flag("watch-only", "Watch events without keeping them in an informer store").Bool()
+	resumeFile                 = kingpin.Flag("resume-file", "File to save the resourceVersion of the watch in, so that a restart watches from there instead of listing all events. Disabled if empty").String()
+	resumeConfigMap            = kingpin.Flag("resume-configmap", "ConfigMap to save the resourceVersion of the watch in, like --resume-file for pods without a volume. Disabled if empty").String()
+	resumeConfigMapNamespace   = kingpin.Flag("resume-configmap-namespace", "Namespace of the --resume-configmap").Default(defaultHALeaseNamespace).Envar("POD_NAMESPACE").String()
+	redact                     = kingpin.Flag("redact", "Mask secrets like JWTs, AWS keys and long base64 blobs in event messages").Bool()
+	redactPatterns             = kingpin.Flag("redact-pattern", "Additional regular expression to mask in event messages (repeatable), implies --redact").Strings()
+	redactBase64MinLength      = kingpin.Flag("redact-base64-min-length", "Mask base64 blobs from this length on, 0 to disable").Default(strconv.Itoa(defaultRedactBase64MinLength)).Int()
+	auditLog                   = kingpin.Flag("audit-log", "File to write the audit log of HTTP API requests to, - for stdout. Disabled if empty").String()
+	auditAll                   = kingpin.Flag("audit-all", "Audit all HTTP requests, including health checks and metrics").Bool()
+	tlsMinVersion              = kingpin.Flag("tls-min-version", "Minimum TLS version of the web server and outbound connections: "+strings.Join(tlsVersionNames(), ", ")).Default(defaultTLSMinVersion).String()
+	tlsCipherSuites            = kingpin.Flag("tls-cipher-suite", "Allowed TLS 1.2 cipher suite, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 (repeatable). Go defaults if not set").Strings()
+	tlsFIPS                    = kingpin.Flag("tls-fips", "Restrict TLS to FIPS-approved algorithms, which limits it to TLS 1.2").Bool()
+	tlsCert                    = kingpin.Flag("tls-cert", "Certificate file to serve the web and gRPC servers with HTTPS, reloaded when it changes").String()
+	tlsKey                     = kingpin.Flag("tls-key", "Private key file of --tls-cert").String()
+	webBasicAuthFile           = kingpin.Flag("web-basic-auth-file", "File with USER:PASSWORD lines, protects the endpoints exposing events and metrics with basic auth").String()
+	webBearerTokenFile         = kingpin.Flag("web-bearer-token-file", "File with one token per line, protects the endpoints exposing events and metrics with bearer tokens").String()
+	webAuthRules               = kingpin.Flag("web-auth", "Methods accepted by endpoints under a path prefix, PATH=METHOD|METHOD with methods basic, bearer and none, e.g. /metrics=bearer (repeatable). Replaces the default protected endpoints").Strings()
+	otlpEndpoint               = kingpin.Flag("otlp-endpoint", "OTLP/HTTP endpoint to export pipeline traces to, e.g. http://otel-collector:4318. Disabled if empty").String()
+	otlpHeaders                = kingpin.Flag("otlp-header", "Header sent with trace exports, e.g. authorization (repeatable)").PlaceHolder("KEY=VALUE").StringMap()
+	traceServiceName           = kingpin.Flag("trace-service-name", "Service name of the exported traces").Default(defaultTraceServiceName).String()
+	traceSampleRatio           = kingpin.Flag("trace-sample-ratio", "Fraction of events to trace, between 0 and 1").Default(defaultTraceSampleRatio).Float64()
+	logSample                  = kingpin.Flag("log-sample", "Log only one in N events of a reason, * for all other reasons. Warnings are always logged (repeatable)").PlaceHolder("REASON=N").StringMap()
+	updateDiff                 = kingpin.Flag("update-diff", "Log repeated events with the changed fields only: count delta, message if changed and last timestamp. Sinks still get the whole event").Bool()
+	updateDiffCacheSize        = kingpin.Flag("update-diff-cache-size", "Number of events remembered for --update-diff").Default(strconv.Itoa(defaultUpdateDiffCacheSize)).Int()
+	maxEventLabelSets          = kingpin.Flag("metrics-max-label-sets", "Maximum label sets of the informer event counters, events with further namespaces, reasons or kinds are counted as other, 0 does not limit them").Default(strconv.Itoa(defaultMaxEventLabelSets)).Int()
+	objectRateLimit            = kingpin.Flag("object-rate-limit", "Maximum events per minute and involved object, further events are summed up in an EventsRateLimited summary event, 0 disables it").Default("0").Int()
+	namespaceRateLimit         = kingpin.Flag("namespace-rate-limit", "Maximum events per second and namespace, further events are summed up in an EventsRateLimited summary event, 0 disables it").Default("0").Float64()
+	globalRateLimit            = kingpin.Flag("global-rate-limit", "Maximum events per second in total, further events are summed up in an EventsRateLimited summary event, 0 disables it").Default("0").Float64()
+	rateLimitBurst             = kingpin.Flag("rate-limit-burst", "Events --namespace-rate-limit and --global-rate-limit let through at once, 0 for one second's worth").Default("0").Int()
+	dedupWindow                = kingpin.Flag("dedup-window", "Suppress events repeating the object, reason and message of an event within this time, and sum them up in one event once it is over. 0 disables it").Default("0").Duration()
+	sentryDSN                  = kingpin.Flag("sentry-dsn", "Sentry or GlitchTip DSN to report panics and repeated errors to. Disabled if empty").Envar("SENTRY_DSN").String()
+	sentryEnvironment          = kingpin.Flag("sentry-environment", "Environment reported to Sentry").Envar("SENTRY_ENVIRONMENT").String()
+	sentryMinOccurrences       = kingpin.Flag("sentry-min-occurrences", "Number of times an error has to occur before it is reported").Default(strconv.Itoa(defaultSentryMinOccurrences)).Int()
+	sentryInterval             = kingpin.Flag("sentry-interval", "Shortest time between two reports of the same error").Default(defaultSentryInterval).Duration()
+	alertRules                 = kingpin.Flag("alert-rule", "Alert rule NAME:KEY=VALUE,... with keys type, reason, kind, namespace and name, e.g. crashloop:type=Warning,reason=BackOff (repeatable)").Strings()
+	alertResolveAfter          = kingpin.Flag("alert-resolve-after", "Time without matching events after which an alert is resolved").Default(defaultAlertResolveAfter).Duration()
+	enrichResourceList         = kingpin.Flag("enrich", "Add the labels, owners and node of the involved object to the JSON output and sinks, for objects of these resources cached by informers: pods, nodes, deployments, replicasets, jobs. Owner chains through cached objects are resolved to the top-level controller (comma-separated, repeatable)").Strings()
+	captureLogs                = kingpin.Flag("capture-logs", "Attach the last container log lines of the pod to events matching KEY=VALUE,... with keys type, reason, kind, namespace and name, e.g. type=Warning,reason=BackOff (repeatable)").Strings()
+	captureLogsLines           = kingpin.Flag("capture-logs-lines", "Number of log lines captured by --capture-logs").Default("50").Int64()
+	snapshotSelectors          = kingpin.Flag("snapshot", "Attach a describe-style snapshot of the involved object to alerts of events matching KEY=VALUE,... with keys type, reason, kind, namespace and name (repeatable)").Strings()
+	snapshotDir                = kingpin.Flag("snapshot-dir", "Directory the object snapshots of --snapshot are stored in as well").String()
+	metricRuleSpecs            = kingpin.Flag("metric-rule", "Count events matching KEY=VALUE,... by namespace in k8s_event_rule_matches_total{rule=NAME} (repeatable)").PlaceHolder("NAME:SELECTOR").Strings()
+	textfileDir                = kingpin.Flag("textfile-dir", "node_exporter textfile collector directory to write the metrics to. Disabled if empty").String()
+	textfileInterval           = kingpin.Flag("textfile-interval", "How often the metrics are written to --textfile-dir").Default("15s").Duration()
+	remoteWriteURL             = kingpin.Flag("remote-write-url", "Prometheus remote write URL to push event metrics to. Disabled if empty").String()
+	remoteWriteInterval        = kingpin.Flag("remote-write-interval", "Interval of remote write pushes").Default(defaultRemoteWriteInterval).Duration()
+	remoteWriteHeaders         = kingpin.Flag("remote-write-header", "Header sent with remote write requests, e.g. authorization or X-Scope-OrgID (repeatable)").PlaceHolder("KEY=VALUE").StringMap()
+	remoteWriteLabels          = kingpin.Flag("remote-write-label", "Label added to every pushed series, e.g. cluster=prod (repeatable)").PlaceHolder("NAME=VALUE").StringMap()
+	githubRepo                 = kingpin.Flag("github-repo", "GitHub repository OWNER/NAME to open issues in when alerts fire. Disabled if empty").String()
+	githubToken                = kingpin.Flag("github-token", "GitHub token allowed to write issues").Envar("GITHUB_TOKEN").String()
+	githubAPIURL               = kingpin.Flag("github-api-url", "GitHub API URL, for GitHub Enterprise").Default(defaultGitHubAPIURL).String()
+	githubLabels               = kingpin.Flag("github-label", "Label added to opened issues (repeatable)").Strings()
+	onCallWebhookURL           = kingpin.Flag("oncall-webhook-url", "Grafana OnCall formatted webhook integration URL to send alerts to").Envar("ONCALL_WEBHOOK_URL").String()
+	onCallSeverities           = kingpin.Flag("oncall-severity", "Severity sent for alerts of RULE, defaults to warning for Warning events and info otherwise (repeatable)").PlaceHolder("RULE=SEVERITY").StringMap()
+	pluginsDir                 = kingpin.Flag("plugins-dir", "Directory with sink and filter plugins (*.so) to load at startup").String()
+	sinkFilters                = kingpin.Flag("sink-filter", "Only write events matching KEY=VALUE,... to the sink, with keys type, reason, kind, namespace and name, e.g. matrix=type=Warning (repeatable)").PlaceHolder("SINK=SELECTOR").StringMap()
+	outputFile                 = kingpin.Flag("output-file", "File to append events to as JSON lines. Disabled if empty").String()
+	sinkMaxRetries             = kingpin.Flag("sink-max-retries", "Number of times a failed sink write is retried").Default(strconv.Itoa(defaultSinkMaxRetries)).Int()
+	sinkRetriesPerMinute       = kingpin.Flag("sink-retries-per-minute", "Retry budget per sink, failed writes are not retried once it is used up").Default(strconv.Itoa(defaultSinkRetriesPerMinute)).Int()
+	sinkBatchSize              = kingpin.Flag("sink-batch-size", "Maximum number of events written at once to sinks supporting batches, 1 to disable batching").Default(strconv.Itoa(defaultSinkBatchSize)).Int()
+	sinkBatchInterval          = kingpin.Flag("sink-batch-interval", "Maximum time events wait for their batch to fill up").Default(defaultSinkBatchInterval).Duration()
+	matrixHomeserver           = kingpin.Flag("matrix-homeserver", "Matrix homeserver URL to send events to, e.g. https://matrix.example.org. Disabled if empty").String()
+	matrixRoom                 = kingpin.Flag("matrix-room", "Matrix room ID to send events to, e.g. !abc:example.org").String()
+	matrixToken                = kingpin.Flag("matrix-token", "Matrix access token").Envar("MATRIX_ACCESS_TOKEN").String()
+	webexWebhookURL            = kingpin.Flag("webex-webhook-url", "Webex incoming webhook URL to send events to").Envar("WEBEX_WEBHOOK_URL").String()
+	webexToken                 = kingpin.Flag("webex-token", "Webex bot access token, used with --webex-room instead of a webhook").Envar("WEBEX_TOKEN").String()
+	webexRoom                  = kingpin.Flag("webex-room", "Webex room ID the bot posts to").String()
+	rocketChatWebhookURL       = kingpin.Flag("rocketchat-webhook-url", "Rocket.Chat incoming webhook URL to send events to").Envar("ROCKETCHAT_WEBHOOK_URL").String()
+	rocketChatRoutes           = kingpin.Flag("rocketchat-route", "Send events matching KEY=VALUE,... to CHANNEL instead of the webhook's channel, first match wins (repeatable)").PlaceHolder("CHANNEL:SELECTOR").Strings()
+	mattermostWebhookURL       = kingpin.Flag("mattermost-webhook-url", "Mattermost incoming webhook URL to send events to").Envar("MATTERMOST_WEBHOOK_URL").String()
+	mattermostUsername         = kingpin.Flag("mattermost-username", "User name the messages are posted as, if the webhook allows overriding it").String()
+	mattermostRoutes           = kingpin.Flag("mattermost-route", "Send events matching KEY=VALUE,... to CHANNEL instead of the webhook's channel, first match wins (repeatable)").PlaceHolder("CHANNEL:SELECTOR").Strings()
+	gelfAddress                = kingpin.Flag("gelf-address", "Graylog GELF input to send events to, udp://, tcp:// or tls://HOST:PORT").String()
+	logstashAddress            = kingpin.Flag("logstash-address", "Logstash or Elastic Agent TCP input to send JSON lines to, tcp:// or tls://HOST:PORT").String()
+	syslogAddress              = kingpin.Flag("syslog-address", "Syslog server to send RFC 5424 messages to, udp://, tcp:// or tls://HOST:PORT").String()
+	syslogFacility             = kingpin.Flag("syslog-facility", "Syslog facility of the messages").Default(defaultSyslogFacility).Enum(syslogNames(syslogFacilities)...)
+	syslogSeverityMap          = kingpin.Flag("syslog-severity", "Syslog severity of events of TYPE, e.g. Warning=err (repeatable). Normal events are info and warnings warning by default").PlaceHolder("TYPE=SEVERITY").StringMap()
+	syslogAppName              = kingpin.Flag("syslog-app-name", "Application name of the syslog messages").Default(defaultSyslogAppName).String()
+	azureLogsEndpoint          = kingpin.Flag("azure-logs-endpoint", "Azure Monitor logs ingestion endpoint of the data collection endpoint or rule. Disabled if empty").String()
+	azureLogsRuleID            = kingpin.Flag("azure-logs-dcr-id", "Immutable ID of the data collection rule").String()
+	azureLogsStream            = kingpin.Flag("azure-logs-stream", "Stream of the data collection rule").Default(defaultAzureLogStream).String()
+	azureTenantID              = kingpin.Flag("azure-tenant-id", "Microsoft Entra tenant ID").Envar("AZURE_TENANT_ID").String()
+	azureClientID              = kingpin.Flag("azure-client-id", "Client ID of the app registration or managed identity").Envar("AZURE_CLIENT_ID").String()
+	azureClientSecret          = kingpin.Flag("azure-client-secret", "Client secret, not needed with workload identity").Envar("AZURE_CLIENT_SECRET").String()
+	cloudEventsURL             = kingpin.Flag("cloudevents-url", "URL to post events to as CloudEvents, e.g. a Knative broker or Argo Events webhook").String()
+	cloudEventsSource          = kingpin.Flag("cloudevents-source", "Source attribute of the CloudEvents").Default(defaultCloudEventsSource).String()
+	falcosidekickURL           = kingpin.Flag("falcosidekick-url", "falcosidekick URL to post events to in the Falco alert format, e.g. http://falcosidekick:2801").String()
+	googleChatWebhookURL       = kingpin.Flag("googlechat-webhook-url", "Google Chat webhook URL to send events to").Envar("GOOGLECHAT_WEBHOOK_URL").String()
+	slackWebhookURL            = kingpin.Flag("slack-webhook-url", "Slack incoming webhook URL to send notifications to").Envar("SLACK_WEBHOOK_URL").String()
+	teamsWebhookURL            = kingpin.Flag("teams-webhook-url", "Microsoft Teams workflow or incoming webhook URL to send notifications to").Envar("TEAMS_WEBHOOK_URL").String()
+	notifyFilter               = kingpin.Flag("notify-filter", "Events notified to Slack and Teams as KEY=VALUE,..., unless set with --sink-filter").Default(defaultNotifyFilter).String()
+	notifyBatchSize            = kingpin.Flag("notify-batch-size", "Maximum number of events notified in one Slack or Teams message").Default(strconv.Itoa(defaultNotifyBatchSize)).Int()
+	notifyBatchInterval        = kingpin.Flag("notify-batch-interval", "Time events are collected for one Slack or Teams message").Default(defaultNotifyBatchInterval).Duration()
+	notifyMessagesPerMinute    = kingpin.Flag("notify-messages-per-minute", "Maximum number of Slack or Teams messages per minute, further events are suppressed. 0 disables the limit").Default(strconv.Itoa(defaultNotifyMessagesPerMinute)).Int()
+	eventBridgeBus             = kingpin.Flag("eventbridge-bus", "Amazon EventBridge event bus name or ARN to publish events to. Disabled if empty").String()
+	eventBridgeSource          = kingpin.Flag("eventbridge-source", "Source of the published EventBridge events").Default(defaultEventBridgeSource).String()
+	awsRegion                  = kingpin.Flag("aws-region", "AWS region of the EventBridge bus, the OpenSearch domain and the S3 bucket").Envar("AWS_REGION").String()
+	webhookURL                 = kingpin.Flag("webhook-url", "URL every event is posted to. Disabled if empty").String()
+	webhookHeaders             = kingpin.Flag("webhook-header", "Header sent with webhook requests, e.g. authorization (repeatable)").PlaceHolder("KEY=VALUE").StringMap()
+	webhookTemplate            = kingpin.Flag("webhook-template", "Template of the webhook payload, executed with the JSON event, e.g. {\"text\": {{json .Message}}}. Sends the JSON event if empty").String()
+	webhookSigningSecretFile   = kingpin.Flag("webhook-signing-secret-file", "File with the secret webhook payloads are signed with using HMAC-SHA256").String()
+	webhookSignatureHeader     = kingpin.Flag("webhook-signature-header", "Header of the webhook payload signature").Default(defaultSignatureHeader).String()
+	webhookQueueSize           = kingpin.Flag("webhook-queue-size", "Number of events buffered for the webhook, further events are dropped while it is behind").Default(strconv.Itoa(defaultWebhookQueueSize)).Int()
+	pulsarURL                  = kingpin.Flag("pulsar-url", "Pulsar broker or proxy HTTP service URL to publish events to, e.g. http://pulsar:8080. Disabled if empty").String()
+	pulsarTopic                = kingpin.Flag("pulsar-topic", "Pulsar topic, a template executed with the JSON event, e.g. persistent://public/default/events-{{.Namespace}}").Default(defaultPulsarTopic).String()
+	pulsarToken                = kingpin.Flag("pulsar-token", "Pulsar JWT token").Envar("PULSAR_TOKEN").String()
+	elasticsearchURL           = kingpin.Flag("elasticsearch-url", "Elasticsearch URL to index events in, e.g. https://elasticsearch:9200. Disabled if empty").String()
+	elasticsearchIndex         = kingpin.Flag("elasticsearch-index", "Elasticsearch index, a template executed with the JSON event, so that indices roll over by date").Default(defaultElasticsearchIndex).String()
+	elasticsearchIndexTemplate = kingpin.Flag("elasticsearch-index-template", "Name of the index template mapping the event fields, installed on the first write. Empty leaves the mapping to Elasticsearch").Default(defaultElasticsearchIndexTemplate).String()
+	elasticsearchUsername      = kingpin.Flag("elasticsearch-username", "Elasticsearch basic auth user").String()
+	elasticsearchPassword      = kingpin.Flag("elasticsearch-password", "Elasticsearch basic auth password").Envar("ELASTICSEARCH_PASSWORD").String()
+	elasticsearchAPIKey        = kingpin.Flag("elasticsearch-api-key", "Elasticsearch API key, encoded as returned by the create API key API").Envar("ELASTICSEARCH_API_KEY").String()
+	lokiURL                    = kingpin.Flag("loki-url", "Loki URL to push events to, e.g. http://loki:3100. Disabled if empty").String()
+	lokiStaticLabels           = kingpin.Flag("loki-label", "Label added to every Loki stream (repeatable)").PlaceHolder("KEY=VALUE").StringMap()
+	lokiDerivedLabels          = kingpin.Flag("loki-event-label", "Event field used as Loki label (repeatable)").Default("namespace", "reason", "type").Enums(lokiLabels...)
+	lokiTenant                 = kingpin.Flag("loki-tenant", "Tenant ID sent as X-Scope-OrgID to multi-tenant Loki").Envar("LOKI_TENANT").String()
+	lokiUsername               = kingpin.Flag("loki-username", "Loki basic auth user").String()
+	lokiPassword               = kingpin.Flag("loki-password", "Loki basic auth password").Envar("LOKI_PASSWORD").String()
+	lokiQueueSize              = kingpin.Flag("loki-queue-size", "Number of events buffered while Loki is slow or unavailable, further events are dropped").Default(strconv.Itoa(defaultLokiQueueSize)).Int()
+	natsAddress                = kingpin.Flag("nats-address", "NATS server to publish events to, nats:// or tls://HOST:PORT. Disabled if empty").String()
+	natsSubjectTemplate        = kingpin.Flag("nats-subject", "NATS subject, a template executed with the JSON event").Default(defaultNATSSubject).String()
+	natsJetStream              = kingpin.Flag("nats-jetstream", "Wait for a JetStream stream to acknowledge every event").Bool()
+	natsToken                  = kingpin.Flag("nats-token", "NATS authentication token").Envar("NATS_TOKEN").String()
+	natsUsername               = kingpin.Flag("nats-username", "NATS user").String()
+	natsPassword               = kingpin.Flag("nats-password", "NATS password").Envar("NATS_PASSWORD").String()
+	openSearchURL              = kingpin.Flag("opensearch-url", "OpenSearch URL to index events in, e.g. https://search-domain.eu-central-1.es.amazonaws.com. Disabled if empty").String()
+	openSearchIndex            = kingpin.Flag("opensearch-index", "OpenSearch index or data stream").Default(defaultOpenSearchIndex).String()
+	openSearchUsername         = kingpin.Flag("opensearch-username", "OpenSearch basic auth user").String()
+	openSearchPassword         = kingpin.Flag("opensearch-password", "OpenSearch basic auth password").Envar("OPENSEARCH_PASSWORD").String()
+	openSearchSigV4            = kingpin.Flag("opensearch-aws-sigv4", "Sign OpenSearch requests with AWS SigV4, needs --aws-region").Bool()
+	openSearchService          = kingpin.Flag("opensearch-aws-service", "AWS service name used for signing, es for domains or aoss for serverless collections").Default(defaultOpenSearchService).String()
+	s3Bucket                   = kingpin.Flag("s3-bucket", "S3 bucket to archive events in as gzipped JSON lines. Disabled if empty").String()
+	s3Endpoint                 = kingpin.Flag("s3-endpoint", "URL of S3-compatible storage like MinIO, AWS S3 of --aws-region if empty").String()
+	s3Prefix                   = kingpin.Flag("s3-prefix", "Prefix of the archived objects, a template executed with the JSON event, so that objects are partitioned by date and hour").Default(defaultS3Prefix).String()
+	s3FlushInterval            = kingpin.Flag("s3-flush-interval", "Interval of the uploads to S3, one object per prefix").Default(defaultS3FlushInterval).Duration()
+	windowsEventLogEnabled     = kingpin.Flag("windows-eventlog", "Write events to the Windows Event Log").Bool()
+	windowsEventLogSource      = kingpin.Flag("windows-eventlog-source", "Source name of the Windows Event Log records").Default(defaultWindowsEventLogSource).String()
+	windowsEventLogErrors      = kingpin.Flag("windows-eventlog-error-reason", "Reason of warnings written as errors to the Windows Event Log (repeatable)").Strings()
+	profilingURL               = kingpin.Flag("profiling-url", "Pyroscope server to push pprof profiles to, disabled if empty").String()
+	profilingAppName           = kingpin.Flag("profiling-app-name", "Application name of pushed profiles").Default(defaultProfilingAppName).String()
+	profilingTags              = kingpin.Flag("profiling-tag", "Tag added to pushed profiles (repeatable)").PlaceHolder("KEY=VALUE").StringMap()
+	profilingAuthToken         = kingpin.Flag("profiling-auth-token", "Bearer token for the profiling server").Envar("PROFILING_AUTH_TOKEN").String()
+	profilingTypes             = kingpin.Flag("profiling-type", "Profile type to push (repeatable): "+strings.Join(profileTypes, ", ")).Default(profileCPU, profileHeap).Enums(profileTypes...)
+	profilingInterval          = kingpin.Flag("profiling-interval", "How often profiles are pushed").Default(defaultProfilingInterval).Duration()
+	profilingCPUDuration       = kingpin.Flag("profiling-cpu-duration", "How long the CPU is sampled per interval").Default(defaultProfilingCPUDuration).Duration()
+	profilingMutexFraction     = kingpin.Flag("profiling-mutex-fraction", "Sample 1/n mutex contention events for mutex profiles").Default("10").Int()
+	profilingBlockRate         = kingpin.Flag("profiling-block-rate", "Sample one blocking event per n nanoseconds blocked for block profiles").Default("10000").Int()
+
+	tailCommand = kingpin.Command("tail", "Tail events (default)").Default()
+
+	loadgenCommand      = kingpin.Command("loadgen", "Create synthetic events to capacity test the tailer")
+	loadgenNamespace    = loadgenCommand.Flag("target-namespace", "Namespace to create the events in, created if missing").Default(defaultLoadgenNamespace).String()
+	loadgenRate         = loadgenCommand.Flag("rate", "Events created per second").Default(strconv.Itoa(defaultLoadgenRate)).Float64()
+	loadgenObjects      = loadgenCommand.Flag("objects", "Number of distinct involved objects").Default(strconv.Itoa(defaultLoadgenObjects)).Int()
+	loadgenReasons      = loadgenCommand.Flag("reasons", "Number of distinct event reasons").Default(strconv.Itoa(defaultLoadgenReasons)).Int()
+	loadgenWarningRatio = loadgenCommand.Flag("warning-ratio", "Fraction of events created as warnings").Default("0.1").Float64()
+	loadgenDuration     = loadgenCommand.Flag("duration", "How long to create events, 0 to run until interrupted").Default("0").Duration()
+
+	decryptCommand = kingpin.Command("decrypt", "Decrypt an archive object encrypted with --archive-encryption-key-file from stdin to stdout, and exit")
+
+	analyzeCommand = kingpin.Command("analyze", "Print how often the stored events occurred by reason, object and namespace, and exit")
+	analyzeSince   = analyzeCommand.Flag("since", "Only count events seen within this duration, 0 counts all stored events").Default("24h").Duration()
+	analyzeTop     = analyzeCommand.Flag("top", "Number of rows per table, 0 prints all").Default(strconv.Itoa(defaultAnalyzeTop)).Int()
 )
 
-func setup() {
+// startupConfig is the config file the flags were parsed with
+var startupConfig configFile
+
+// setup parses the command line and configures logging. It returns the
+// selected command.
+func setup() string {
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr, TimeFormat: time.RFC3339})
 	log.Logger = log.Logger.Level(zerolog.InfoLevel)
 	kingpin.CommandLine.HelpFlag.Short('h')
-	kingpin.Parse()
+	args, config, err := withConfigFile(os.Args[1:])
+	kingpin.FatalIfError(err, "could not read the config file")
+	startupConfig = config
+	command := kingpin.MustParse(kingpin.CommandLine.Parse(args))
+	if *outputFormat == outputFormatJSON {
+		// event lines use message for the event message
+		zerolog.MessageFieldName = "msg"
+		log.Logger = zerolog.New(os.Stdout).With().Timestamp().Logger().Level(zerolog.InfoLevel)
+	}
 	if *verbose {
 		log.Logger = log.Logger.Level(zerolog.DebugLevel)
 	}
 
 	klog.SetOutput(log.Logger)
 
-	if strings.HasPrefix(*kubeconfig, "~/") {
-		*kubeconfig = strings.Replace(*kubeconfig, "~/", os.Getenv("HOME")+"/", 1)
+	paths := filepath.SplitList(*kubeconfig)
+	for i, path := range paths {
+		if strings.HasPrefix(path, "~/") {
+			paths[i] = strings.Replace(path, "~/", os.Getenv("HOME")+"/", 1)
+		}
 	}
+	*kubeconfig = strings.Join(paths, string(filepath.ListSeparator))
+	return command
+}
+
+// watchedNamespaces returns the namespaces listed in --namespace, which may
+// be separated by commas. The list holds only corev1.NamespaceAll if none
+// are.
+func watchedNamespaces() []string {
+	var namespaces []string
+	seen := map[string]bool{}
+	for _, namespace := range strings.Split(*namespace, ",") {
+		namespace = strings.TrimSpace(namespace)
+		if namespace == "" || seen[namespace] {
+			continue
+		}
+		seen[namespace] = true
+		namespaces = append(namespaces, namespace)
+	}
+	if len(namespaces) == 0 {
+		return []string{corev1.NamespaceAll}
+	}
+	return namespaces
+}
+
+// kubeconfigPaths returns the files listed in --kubeconfig, which like
+// KUBECONFIG may hold several paths separated by colons, ~/.kube/config if
+// none are
+func kubeconfigPaths() []string {
+	if paths := filepath.SplitList(*kubeconfig); len(paths) > 0 {
+		return paths
+	}
+	return []string{clientcmd.RecommendedHomeFile}
+}
+
+// inPod reports whether the service account credentials of a pod are
+// available
+func inPod() bool {
+	return os.Getenv("KUBERNETES_SERVICE_HOST") != "" && os.Getenv("KUBERNETES_SERVICE_PORT") != ""
+}
+
+// loadKubeConfig loads the kubeconfig files, merged with the same rules as
+// kubectl, the first file setting a value wins. In a pod, the service
+// account is used if none of the files exist or they cannot be loaded. It
+// also returns the mode used for logging.
+func loadKubeConfig() (*rest.Config, string, error) {
+	paths := kubeconfigPaths()
+	found := false
+	for _, path := range paths {
+		if _, err := os.Stat(path); err == nil {
+			found = true
+		}
+	}
+	inClusterMode := "the in-cluster service account"
+	if !found && inPod() {
+		config, err := rest.InClusterConfig()
+		return config, inClusterMode, err
+	}
+
+	rules := &clientcmd.ClientConfigLoadingRules{Precedence: paths}
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{}).ClientConfig()
+	if err == nil {
+		return config, "kubeconfig " + strings.Join(paths, string(filepath.ListSeparator)), nil
+	}
+	if !inPod() {
+		return nil, "", err
+	}
+	inClusterConfig, inClusterErr := rest.InClusterConfig()
+	if inClusterErr != nil {
+		return nil, "", err
+	}
+	log.Warn().Err(err).Msg("Could not load the kubeconfig, falling back to the in-cluster service account")
+	return inClusterConfig, inClusterMode, nil
+}
+
+// getKubeConfig builds the client config from the kubeconfig, see
+// loadKubeConfig, and --token-file
+func getKubeConfig() (*rest.Config, string, error) {
+	config, mode, err := loadKubeConfig()
+	if err != nil {
+		return nil, "", err
+	}
+	if *tokenFile != "" {
+		if _, err := os.ReadFile(*tokenFile); err != nil {
+			return nil, "", err
+		}
+		// client-go rereads the file periodically, so rotated tokens
+		// such as projected service account tokens are picked up
+		config.BearerToken = ""
+		config.BearerTokenFile = *tokenFile
+		config.AuthProvider = nil
+		config.ExecProvider = nil
+		config.Username = ""
+		config.Password = ""
+	}
+	return config, mode, nil
 }
 
 func getKubeClient() *kubernetes.Clientset {
-	// build config
-	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
+	config, mode, err := getKubeConfig()
 	if err != nil {
 		log.Fatal().Err(err).Msg("Could not create kube config")
 	}
+	log.Info().Msgf("Using %s", mode)
 	log.Debug().Msgf("API host: %v", config.Host)
 
 	// create client from config
 	return kubernetes.NewForConfigOrDie(config)
 }
 
+// reloadClient rereads the kubeconfig and returns a new client for watching
+// events with its current credentials
+func reloadClient() (kubernetes.Interface, error) {
+	config, _, err := getKubeConfig()
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(config)
+}
+
+// init registers the built-in sinks, which are created if their flags are set
+func init() {
+	registerSink("file", func(policy *tlsPolicy) (extension.Sink, error) {
+		if *outputFile == "" {
+			return nil, nil
+		}
+		return newFileSink(*outputFile)
+	})
+	registerSink("matrix", func(policy *tlsPolicy) (extension.Sink, error) {
+		if *matrixHomeserver == "" {
+			return nil, nil
+		}
+		return newMatrixSink(matrixOptions{
+			homeserver: *matrixHomeserver,
+			room:       *matrixRoom,
+			token:      *matrixToken,
+			tls:        policy,
+		})
+	})
+	registerSink("webex", func(policy *tlsPolicy) (extension.Sink, error) {
+		if *webexWebhookURL == "" && *webexToken == "" {
+			return nil, nil
+		}
+		return newWebexSink(webexOptions{
+			webhookURL: *webexWebhookURL,
+			token:      *webexToken,
+			room:       *webexRoom,
+			tls:        policy,
+		})
+	})
+	registerSink("rocketchat", func(policy *tlsPolicy) (extension.Sink, error) {
+		if *rocketChatWebhookURL == "" {
+			return nil, nil
+		}
+		routes, err := parseEventRules(*rocketChatRoutes)
+		if err != nil {
+			return nil, err
+		}
+		return newRocketChatSink(rocketChatOptions{
+			webhookURL: *rocketChatWebhookURL,
+			routes:     routes,
+			tls:        policy,
+		})
+	})
+	registerSink("mattermost", func(policy *tlsPolicy) (extension.Sink, error) {
+		if *mattermostWebhookURL == "" {
+			return nil, nil
+		}
+		routes, err := parseEventRules(*mattermostRoutes)
+		if err != nil {
+			return nil, err
+		}
+		return newMattermostSink(mattermostOptions{
+			webhookURL: *mattermostWebhookURL,
+			username:   *mattermostUsername,
+			routes:     routes,
+			tls:        policy,
+		})
+	})
+	registerSink("gelf", func(policy *tlsPolicy) (extension.Sink, error) {
+		if *gelfAddress == "" {
+			return nil, nil
+		}
+		return newGELFSink(*gelfAddress, policy)
+	})
+	registerSink("syslog", func(policy *tlsPolicy) (extension.Sink, error) {
+		if *syslogAddress == "" {
+			return nil, nil
+		}
+		return newSyslogSink(syslogOptions{
+			address:    *syslogAddress,
+			facility:   *syslogFacility,
+			severities: *syslogSeverityMap,
+			appName:    *syslogAppName,
+			tls:        policy,
+		})
+	})
+	registerSink("logstash", func(policy *tlsPolicy) (extension.Sink, error) {
+		if *logstashAddress == "" {
+			return nil, nil
+		}
+		return newLogstashSink(*logstashAddress, policy)
+	})
+	registerSink("azure-logs", func(policy *tlsPolicy) (extension.Sink, error) {
+		if *azureLogsEndpoint == "" {
+			return nil, nil
+		}
+		return newAzureLogsSink(azureLogsOptions{
+			endpoint:     *azureLogsEndpoint,
+			ruleID:       *azureLogsRuleID,
+			stream:       *azureLogsStream,
+			tenantID:     *azureTenantID,
+			clientID:     *azureClientID,
+			clientSecret: *azureClientSecret,
+			tls:          policy,
+		})
+	})
+	registerSink("cloudevents", func(policy *tlsPolicy) (extension.Sink, error) {
+		if *cloudEventsURL == "" {
+			return nil, nil
+		}
+		return newCloudEventsSink(*cloudEventsURL, *cloudEventsSource, policy), nil
+	})
+	registerSink("falcosidekick", func(policy *tlsPolicy) (extension.Sink, error) {
+		if *falcosidekickURL == "" {
+			return nil, nil
+		}
+		return newFalcosidekickSink(*falcosidekickURL, policy), nil
+	})
+	registerSink("googlechat", func(policy *tlsPolicy) (extension.Sink, error) {
+		if *googleChatWebhookURL == "" {
+			return nil, nil
+		}
+		return newGoogleChatSink(*googleChatWebhookURL, policy)
+	})
+	registerSink(slackSinkName, func(policy *tlsPolicy) (extension.Sink, error) {
+		if *slackWebhookURL == "" {
+			return nil, nil
+		}
+		return newSlackSink(notificationOptions{
+			webhookURL:        *slackWebhookURL,
+			messagesPerMinute: *notifyMessagesPerMinute,
+			tls:               policy,
+		})
+	})
+	registerSink(teamsSinkName, func(policy *tlsPolicy) (extension.Sink, error) {
+		if *teamsWebhookURL == "" {
+			return nil, nil
+		}
+		return newTeamsSink(notificationOptions{
+			webhookURL:        *teamsWebhookURL,
+			messagesPerMinute: *notifyMessagesPerMinute,
+			tls:               policy,
+		})
+	})
+	registerSink("eventbridge", func(policy *tlsPolicy) (extension.Sink, error) {
+		if *eventBridgeBus == "" {
+			return nil, nil
+		}
+		return newEventBridgeSink(*eventBridgeBus, *awsRegion, *eventBridgeSource, policy)
+	})
+	registerSink(webhookSinkName, func(policy *tlsPolicy) (extension.Sink, error) {
+		if *webhookURL == "" {
+			return nil, nil
+		}
+		var signer *payloadSigner
+		if *webhookSigningSecretFile != "" {
+			var err error
+			if signer, err = newPayloadSigner(*webhookSigningSecretFile, *webhookSignatureHeader); err != nil {
+				return nil, err
+			}
+		}
+		return newWebhookSink(webhookOptions{
+			url:      *webhookURL,
+			header:   *webhookHeaders,
+			template: *webhookTemplate,
+			signer:   signer,
+			tls:      policy,
+		})
+	})
+	registerSink("pulsar", func(policy *tlsPolicy) (extension.Sink, error) {
+		if *pulsarURL == "" {
+			return nil, nil
+		}
+		return newPulsarSink(*pulsarURL, *pulsarTopic, *pulsarToken, policy)
+	})
+	registerSink("windows-eventlog", func(policy *tlsPolicy) (extension.Sink, error) {
+		if !*windowsEventLogEnabled {
+			return nil, nil
+		}
+		return newWindowsEventLogSink(*windowsEventLogSource, *windowsEventLogErrors)
+	})
+	registerSink("elasticsearch", func(policy *tlsPolicy) (extension.Sink, error) {
+		if *elasticsearchURL == "" {
+			return nil, nil
+		}
+		return newElasticsearchSink(elasticsearchOptions{
+			url:           *elasticsearchURL,
+			index:         *elasticsearchIndex,
+			indexTemplate: *elasticsearchIndexTemplate,
+			username:      *elasticsearchUsername,
+			password:      *elasticsearchPassword,
+			apiKey:        *elasticsearchAPIKey,
+			tls:           policy,
+		})
+	})
+	registerSink(lokiSinkName, func(policy *tlsPolicy) (extension.Sink, error) {
+		if *lokiURL == "" {
+			return nil, nil
+		}
+		return newLokiSink(lokiOptions{
+			url:           *lokiURL,
+			labels:        *lokiStaticLabels,
+			derivedLabels: *lokiDerivedLabels,
+			tenant:        *lokiTenant,
+			username:      *lokiUsername,
+			password:      *lokiPassword,
+			tls:           policy,
+		})
+	})
+	registerSink("nats", func(policy *tlsPolicy) (extension.Sink, error) {
+		if *natsAddress == "" {
+			return nil, nil
+		}
+		return newNATSSink(natsOptions{
+			address:   *natsAddress,
+			subject:   *natsSubjectTemplate,
+			jetStream: *natsJetStream,
+			token:     *natsToken,
+			username:  *natsUsername,
+			password:  *natsPassword,
+			tls:       policy,
+		})
+	})
+	registerSink("opensearch", func(policy *tlsPolicy) (extension.Sink, error) {
+		if *openSearchURL == "" {
+			return nil, nil
+		}
+		opts := openSearchOptions{
+			url:      *openSearchURL,
+			index:    *openSearchIndex,
+			username: *openSearchUsername,
+			password: *openSearchPassword,
+			service:  *openSearchService,
+			tls:      policy,
+		}
+		if *openSearchSigV4 {
+			opts.region = *awsRegion
+		}
+		return newOpenSearchSink(opts)
+	})
+	registerSink("s3", func(policy *tlsPolicy) (extension.Sink, error) {
+		if *s3Bucket == "" {
+			return nil, nil
+		}
+		cipher, err := newArchiveCipher(*archiveKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		return newS3Sink(s3Options{
+			bucket:        *s3Bucket,
+			endpoint:      *s3Endpoint,
+			region:        *awsRegion,
+			prefix:        *s3Prefix,
+			flushInterval: *s3FlushInterval,
+			cipher:        cipher,
+			tls:           policy,
+		})
+	})
+}
+
+// newAlertNotifiers returns the configured alert notifiers
+func newAlertNotifiers(tlsSettings *tlsPolicy) ([]alertNotifier, error) {
+	var notifiers []alertNotifier
+	if *githubRepo != "" {
+		github, err := newGitHubNotifier(githubOptions{
+			apiURL: *githubAPIURL,
+			repo:   *githubRepo,
+			token:  *githubToken,
+			labels: *githubLabels,
+			tls:    tlsSettings,
+		})
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, github)
+	}
+	if *onCallWebhookURL != "" {
+		onCall, err := newOnCallNotifier(onCallOptions{
+			webhookURL: *onCallWebhookURL,
+			severities: *onCallSeverities,
+			tls:        tlsSettings,
+		})
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, onCall)
+	}
+	return notifiers, nil
+}
+
 func main() {
-	setup()
-	log.Info().Msgf("Using kubeconfig: %v", *kubeconfig)
+	switch setup() {
+	case loadgenCommand.FullCommand():
+		loadgen()
+	case analyzeCommand.FullCommand():
+		analyze()
+	case decryptCommand.FullCommand():
+		decrypt()
+	default:
+		if code := tail(); code != 0 {
+			os.Exit(code)
+		}
+	}
+}
+
+// newFlagFilters creates the filters configured by flags
+func newFlagFilters() ([]extension.Filter, error) {
+	var filters []extension.Filter
+	if exclusion := newNamespaceExclusion(*excludeNamespaces); exclusion != nil {
+		filters = append(filters, exclusion)
+	}
+	if reasons := newReasonFilter(*includeReasons, *excludeReasons, *eventTypes); reasons != nil {
+		filters = append(filters, reasons)
+	}
+	messages, err := newMessageFilter(*matchPatterns, *excludePatterns, *matchFieldNames)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --match or --exclude: %w", err)
+	}
+	if messages != nil {
+		filters = append(filters, messages)
+	}
+	return filters, nil
+}
+
+// newSinkSelectors parses --notify-filter and --sink-filter into the rules
+// events have to match, by sink name
+func newSinkSelectors(sinks []extension.Sink) (map[string]*eventRule, error) {
+	selectors := map[string]*eventRule{}
+	if *notifyFilter != "" {
+		rule, err := parseEventSelector(*notifyFilter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --notify-filter: %w", err)
+		}
+		selectors[slackSinkName] = rule
+		selectors[teamsSinkName] = rule
+	}
+	for name, selector := range *sinkFilters {
+		if !hasSink(sinks, name) {
+			log.Warn().Str("sink", name).Strs("sinks", registeredSinkNames()).Msg("Sink filter for a sink which is not configured")
+		}
+		rule, err := parseEventSelector(selector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sink filter for %s: %w", name, err)
+		}
+		selectors[name] = rule
+	}
+	return selectors, nil
+}
+
+// subscribeSinks subscribes the sinks to the bus with the options of the
+// sink flags
+func subscribeSinks(bus *eventBus, sinks []extension.Sink, selectors map[string]*eventRule, eventTracer *tracer) []sinkSubscription {
+	queueSizes := map[string]int{webhookSinkName: *webhookQueueSize, lokiSinkName: *lokiQueueSize}
+	subscriptions := make([]sinkSubscription, 0, len(sinks))
+	for _, sink := range sinks {
+		opts := sinkOptions{
+			maxRetries:       *sinkMaxRetries,
+			retriesPerMinute: *sinkRetriesPerMinute,
+			batchSize:        *sinkBatchSize,
+			batchInterval:    *sinkBatchInterval,
+			filter:           selectors[sink.Name()],
+			tracer:           eventTracer,
+			queueSize:        queueSizes[sink.Name()],
+		}
+		if sink.Name() == slackSinkName || sink.Name() == teamsSinkName {
+			// notifications are batched longer, one message per batch
+			opts.batchSize = *notifyBatchSize
+			opts.batchInterval = *notifyBatchInterval
+		}
+		subscriptions = append(subscriptions, subscribeSink(bus, sink, opts))
+	}
+	return subscriptions
+}
+
+// tail runs the tailer until it is stopped and returns the exit code
+func tail() int {
 	clientset := getKubeClient()
+	tlsSettings, err := newTLSPolicy(*tlsMinVersion, *tlsCipherSuites, *tlsFIPS)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid TLS configuration")
+	}
+	if *sentryDSN != "" {
+		if errorReports, err = newErrorReporter(sentryOptions{
+			dsn:            *sentryDSN,
+			environment:    *sentryEnvironment,
+			minOccurrences: *sentryMinOccurrences,
+			interval:       *sentryInterval,
+			tls:            tlsSettings,
+		}); err != nil {
+			log.Fatal().Err(err).Msg("Could not set up Sentry")
+		}
+		defer errorReports.Recover()
+	}
+	memory := newMemoryBudget(int64(*memoryBudgetBytes))
+	queue, err := newDeliveryQueue(*queueSize, *dropPolicy, memory.Account("delivery-queue"))
+	if err != nil {
+		log.Fatal().Err(err).Msg("Could not create delivery queue")
+	}
+	pluginSinks, pluginFilters, err := loadPlugins(*pluginsDir)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Could not load plugins")
+	}
+	if _, err := fields.ParseSelector(*fieldSelector); err != nil {
+		log.Fatal().Err(err).Msg("Invalid field selector")
+	}
+	if _, err := labels.Parse(*labelSelector); err != nil {
+		log.Fatal().Err(err).Msg("Invalid label selector")
+	}
+	optOut := newNamespaceOptOut(*namespaceIgnoreAnnotation)
+	// filters are rebuilt from their flags when the config file changes
+	newFilters := func() ([]extension.Filter, error) {
+		flagFilters, err := newFlagFilters()
+		if err != nil {
+			return nil, err
+		}
+		filters := append(append([]extension.Filter{}, pluginFilters...), flagFilters...)
+		if optOut != nil {
+			filters = append(filters, optOut)
+		}
+		return filters, nil
+	}
+	filters, err := newFilters()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid filter")
+	}
+	builtinSinks, err := newRegisteredSinks(tlsSettings)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Could not set up sinks")
+	}
+	sinks := append(append([]extension.Sink{}, pluginSinks...), builtinSinks...)
+	sinkSelectors, err := newSinkSelectors(sinks)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid sink filter")
+	}
+	var messageRedactor *redactor
+	if *redact || len(*redactPatterns) > 0 {
+		if messageRedactor, err = newRedactor(*redactPatterns, *redactBase64MinLength); err != nil {
+			log.Fatal().Err(err).Msg("Could not set up redaction")
+		}
+	}
+	sharding, err := newReplicaSharding(*replicas, *replicaOrdinal)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid replica configuration")
+	}
+	var eventTracer *tracer
+	if *otlpEndpoint != "" {
+		if eventTracer, err = newTracer(tracingOptions{
+			endpoint:    *otlpEndpoint,
+			serviceName: *traceServiceName,
+			headers:     *otlpHeaders,
+			sampleRatio: *traceSampleRatio,
+			tls:         tlsSettings,
+		}); err != nil {
+			log.Fatal().Err(err).Msg("Could not set up tracing")
+		}
+	}
+	bus := newEventBus(memory)
+	var sampler *logSampler
+	if len(*logSample) > 0 {
+		if sampler, err = newLogSampler(*logSample); err != nil {
+			log.Fatal().Err(err).Msg("Invalid log sampling")
+		}
+	}
+	var lineFormat *template.Template
+	if *logFormat != "" {
+		if lineFormat, err = parseLineFormat(*logFormat); err != nil {
+			log.Fatal().Err(err).Msg("Invalid --format")
+		}
+	}
+	if !*tuiMode {
+		if *outputFormat == outputFormatJSON {
+			subscribeEventLogger(bus, os.Stdout, sampler, true, lineFormat)
+		} else {
+			subscribeEventLogger(bus, os.Stderr, sampler, false, lineFormat)
+		}
+	}
+	history := newEventHistory()
+	history.Subscribe(bus)
+	pluginSubscriptions := subscribeSinks(bus, pluginSinks, sinkSelectors, eventTracer)
+	builtinSubscriptions := subscribeSinks(bus, builtinSinks, sinkSelectors, eventTracer)
+	// alerts are notified until the delivery queue has been drained
+	alertsCtx, stopAlerts := context.WithCancel(context.Background())
+	defer stopAlerts()
+	if len(*alertRules) > 0 {
+		rules, err := parseEventRules(*alertRules)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Invalid alert rule")
+		}
+		notifiers, err := newAlertNotifiers(tlsSettings)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Could not set up alert notifications")
+		}
+		newAlertManager(rules, notifiers, *alertResolveAfter).Subscribe(alertsCtx, bus)
+	}
+	if len(*metricRuleSpecs) > 0 {
+		rules, err := parseEventRules(*metricRuleSpecs)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Invalid metric rule")
+		}
+		newMetricRules(rules).Subscribe(bus)
+	}
+	failOn, err := newFailOnRules(*failOnSpecs)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid --fail-on selector")
+	}
+	if failOn != nil {
+		failOn.Subscribe(bus)
+	}
+	var metricsWriter *remoteWriter
+	if *remoteWriteURL != "" {
+		if metricsWriter, err = newRemoteWriter(remoteWriteOptions{
+			url:      *remoteWriteURL,
+			interval: *remoteWriteInterval,
+			headers:  *remoteWriteHeaders,
+			labels:   *remoteWriteLabels,
+			tls:      tlsSettings,
+		}, eventMetrics); err != nil {
+			log.Fatal().Err(err).Msg("Could not set up remote write")
+		}
+	}
+	eventCipher, err := newArchiveCipher(*archiveKeyFile)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Could not read the archive encryption key")
+	}
+	var archive *eventArchive
+	if *archiveDir != "" {
+		if archive, err = newEventArchive(*archiveDir, *archiveRetention, eventCipher); err != nil {
+			log.Fatal().Err(err).Msg("Could not set up the event archive")
+		}
+		archive.Subscribe(bus)
+	}
+	var metricsFile *textfileWriter
+	if *textfileDir != "" {
+		if metricsFile, err = newTextfileWriter(*textfileDir, *textfileInterval,
+			prometheus.Gatherers{prometheus.DefaultGatherer, eventMetrics}); err != nil {
+			log.Fatal().Err(err).Msg("Could not set up the textfile collector output")
+		}
+	}
 	watcher := EventWatcher{
-		client:    clientset.CoreV1().RESTClient(),
-		namespace: *namespace,
+		client:        clientset,
+		namespaces:    watchedNamespaces(),
+		queue:         queue,
+		filters:       filters,
+		optOut:        optOut,
+		eventAPI:      *eventAPI,
+		fieldSelector: *fieldSelector,
+		labelSelector: *labelSelector,
+		redactor:      messageRedactor,
+		bus:           bus,
+
+		statsInterval:            time.Duration(*statsInterval) * time.Second,
+		namespaceStats:           namespaceStats{interval: time.Duration(*statsInterval) * time.Second},
+		heartbeatInterval:        *heartbeatInterval,
+		shardByNamespace:         *shardByNamespace,
+		watchOnly:                *watchOnly,
+		replicaSharding:          sharding,
+		stallTimeout:             *stallTimeout,
+		watchBrokenThreshold:     *watchBrokenThreshold,
+		readinessFreshness:       *readinessFreshness,
+		listPageSize:             *listPageSize,
+		newClient:                reloadClient,
+		kubeconfigPaths:          kubeconfigPaths(),
+		kubeconfigReloadInterval: *kubeconfigReloadInterval,
+		tracer:                   eventTracer,
+		limiter: newEventLimiter(rateLimitOptions{
+			objectPerMinute:    *objectRateLimit,
+			namespacePerSecond: *namespaceRateLimit,
+			globalPerSecond:    *globalRateLimit,
+			burst:              *rateLimitBurst,
+		}),
+		deduplicator: newDeduplicator(*dedupWindow),
+		labelLimiter: newLabelLimiter(*maxEventLabelSets),
+	}
+	if *updateDiff {
+		watcher.differ = newUpdateDiffer(*updateDiffCacheSize)
+	}
+	if watcher.enricher, err = newObjectEnricher(*enrichResourceList); err != nil {
+		log.Fatal().Err(err).Msg("Invalid --enrich")
+	}
+	if watcher.logCapturer, err = newLogCapturer(clientset, *captureLogs, *captureLogsLines); err != nil {
+		log.Fatal().Err(err).Msg("Invalid log capture selector")
+	}
+	// the resolver fetches involved objects for snapshots and the TUI
+	var resolver *objectResolver
+	if *tuiMode || len(*snapshotSelectors) > 0 {
+		config, _, err := getKubeConfig()
+		if err != nil {
+			log.Fatal().Err(err).Msg("Could not create kube config")
+		}
+		if resolver, err = newObjectResolver(config); err != nil {
+			log.Fatal().Err(err).Msg("Could not create object resolver")
+		}
+	}
+	if watcher.snapshotter, err = newObjectSnapshotter(resolver, *snapshotSelectors, *snapshotDir); err != nil {
+		log.Fatal().Err(err).Msg("Invalid object snapshot configuration")
+	}
+	switch {
+	case *resumeFile != "" && *resumeConfigMap != "":
+		log.Fatal().Msg("--resume-file and --resume-configmap exclude each other")
+	case *resumeFile != "":
+		watcher.resume = newWatchResume(&fileResumeStore{path: *resumeFile})
+	case *resumeConfigMap != "":
+		watcher.resume = newWatchResume(&configMapResumeStore{
+			client:    watcher.kubeClient,
+			namespace: *resumeConfigMapNamespace,
+			name:      *resumeConfigMap,
+		})
+	}
+	if *haLease != "" {
+		identity, err := os.Hostname()
+		if err != nil {
+			log.Fatal().Err(err).Msg("Could not determine HA identity")
+		}
+		watcher.leader = newLeaderGate(clientset, *haLeaseNamespace, *haLease, identity)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *runFor > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *runFor)
+		defer cancel()
+	}
+
+	// quitting the TUI stops the tailer like a signal
+	tuiDone := make(chan struct{})
+	if *tuiMode {
+		view, err := newTUI(*tuiBufferSize, resolver, stop)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Could not start the TUI")
+		}
+		view.Subscribe(bus)
+		go func() {
+			defer close(tuiDone)
+			if err := view.Run(ctx); err != nil {
+				log.Error().Err(err).Msg("TUI failed")
+				stop()
+			}
+		}()
+	} else {
+		close(tuiDone)
+	}
+
+	// The web server gets its own context so that it keeps serving metrics
+	// while the watcher drains. Shutdown order: watch, delivery, traces, HTTP.
+	webCtx, stopWeb := context.WithCancel(context.Background())
+	webDone := make(chan struct{})
+	grpcDone := make(chan struct{})
+	recentEvents := newEventBuffer(*eventBufferSize)
+	recentEvents.Subscribe(bus)
+	metricsAddress := *metricsBindAddress
+	if metricsAddress == "" {
+		metricsAddress = *bindAddress
+	}
+	webServer := NewWebServer(webServerOptions{
+		bindAddress:        *bindAddress,
+		port:               *port,
+		metricsBindAddress: metricsAddress,
+		metricsPort:        *metricsPort,
+	})
+	webServer.SetTLSPolicy(tlsSettings)
+	var webCertificate *certificateReloader
+	if (*tlsCert == "") != (*tlsKey == "") {
+		log.Fatal().Msg("--tls-cert and --tls-key must be set together")
+	}
+	if *tlsCert != "" {
+		if webCertificate, err = newCertificateReloader(*tlsCert, *tlsKey); err != nil {
+			log.Fatal().Err(err).Msg("Could not load the TLS certificate")
+		}
+		webServer.SetCertificate(webCertificate)
+	}
+	auth, err := newWebAuth(*webBasicAuthFile, *webBearerTokenFile, *webAuthRules)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid web authentication")
+	}
+	if auth != nil {
+		webServer.SetAuth(auth)
+	}
+	webServer.SetEventHistory(history)
+	webServer.SetEventStream(bus, recentEvents)
+	webServer.SetStatsHandler(watcher.statsHandler)
+	webServer.SetStoreListHandler(watcher.storeListHandler)
+	if archive != nil {
+		webServer.SetEventArchive(archive)
+	}
+	webServer.AddHealthCheck("informer", watcher.informerHealth)
+	webServer.AddReadinessCheck("informer", watcher.informerReadiness)
+	webServer.AddHealthCheck("events", watcher.eventsHealth)
+	webServer.AddHealthCheck("queue", queue.health)
+	for _, subscription := range append(pluginSubscriptions, builtinSubscriptions...) {
+		webServer.AddHealthCheck("sink/"+subscription.name, subscription.health)
+	}
+	// A changed config file rebuilds the filters and the built-in sinks. The
+	// new sinks are subscribed before the old ones are closed, so no event is
+	// missed. Plugin sinks keep running with their filters as they cannot be
+	// recreated.
+	reloader := &configReloader{
+		path:        *configPath,
+		interval:    *configReloadInterval,
+		config:      startupConfig,
+		commandLine: commandLineFlags(os.Args[1:]),
+		apply: func() error {
+			filters, err := newFilters()
+			if err != nil {
+				return err
+			}
+			sinks, err := newRegisteredSinks(tlsSettings)
+			if err != nil {
+				return err
+			}
+			selectors, err := newSinkSelectors(append(append([]extension.Sink{}, pluginSinks...), sinks...))
+			if err != nil {
+				closeSinks(sinks)
+				return err
+			}
+			watcher.SetFilters(filters)
+			old := builtinSubscriptions
+			builtinSubscriptions = subscribeSinks(bus, sinks, selectors, eventTracer)
+			for _, subscription := range old {
+				bus.Unsubscribe(subscription.sub)
+				webServer.RemoveHealthCheck("sink/" + subscription.name)
+			}
+			for _, subscription := range builtinSubscriptions {
+				webServer.AddHealthCheck("sink/"+subscription.name, subscription.health)
+			}
+			return nil
+		},
+	}
+	go reloader.run(ctx)
+	if *auditLog != "" {
+		out, err := openAuditLog(*auditLog)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Could not open audit log")
+		}
+		defer out.Close()
+		webServer.SetAuditLogger(newAuditLogger(out, *auditAll))
+	}
+	go func() {
+		defer close(webDone)
+		webServer.Run(webCtx)
+	}()
+	if *grpcPort > 0 {
+		grpcServer, err := newGRPCServer(net.JoinHostPort(*bindAddress, strconv.Itoa(*grpcPort)), recentEvents, tlsSettings, webCertificate, auth)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Could not set up the gRPC server")
+		}
+		go func() {
+			defer close(grpcDone)
+			grpcServer.Run(webCtx)
+		}()
+	} else {
+		close(grpcDone)
+	}
+	if archive != nil {
+		go archive.Run(ctx)
+	}
+
+	if *profilingURL != "" {
+		pusher, err := newProfilePusher(profilingOptions{
+			url:           *profilingURL,
+			appName:       *profilingAppName,
+			tags:          *profilingTags,
+			authToken:     *profilingAuthToken,
+			types:         *profilingTypes,
+			interval:      *profilingInterval,
+			cpuDuration:   *profilingCPUDuration,
+			mutexFraction: *profilingMutexFraction,
+			blockRate:     *profilingBlockRate,
+			tls:           tlsSettings,
+		})
+		if err != nil {
+			log.Fatal().Err(err).Msg("Could not set up profiling")
+		}
+		go pusher.Run(ctx)
 	}
 
-	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
+	// keep leading while the watcher drains, so queued events are delivered
+	haCtx, stopHA := context.WithCancel(context.Background())
+	haDone := make(chan struct{})
+	go func() {
+		defer close(haDone)
+		if watcher.leader != nil {
+			watcher.leader.Run(haCtx)
+		}
+	}()
 
-	stopChan := make(chan struct{})
-	wg := new(sync.WaitGroup)
+	// the tracer, remote write and the textfile export the drained events
+	// before they stop
+	tracerCtx, stopTracer := context.WithCancel(context.Background())
+	tracerDone := make(chan struct{})
+	go func() {
+		defer close(tracerDone)
+		if eventTracer != nil {
+			eventTracer.Run(tracerCtx)
+		}
+	}()
+	remoteWriteDone := make(chan struct{})
+	go func() {
+		defer close(remoteWriteDone)
+		if metricsWriter != nil {
+			metricsWriter.Run(tracerCtx)
+		}
+	}()
+	textfileDone := make(chan struct{})
+	go func() {
+		defer close(textfileDone)
+		if metricsFile != nil {
+			metricsFile.Run(tracerCtx)
+		}
+	}()
 
-	wg.Add(1)
-	go watcher.Run(stopChan, wg)
+	reportsCtx, stopReports := context.WithCancel(context.Background())
+	defer stopReports()
+	if errorReports != nil {
+		go errorReports.Run(reportsCtx)
+	}
 
-	wg.Add(1)
-	go NewWebServer(*port).Run(stopChan, wg)
+	watcherDone := make(chan struct{})
+	go func() {
+		defer close(watcherDone)
+		defer errorReports.Recover()
+		watcher.Run(ctx)
+	}()
 
-	<-signalChan
-	log.Warn().Msg("Signal to terminate received")
-	close(stopChan)
-	wg.Wait()
+	<-ctx.Done()
+	<-tuiDone
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		log.Info().Dur("run_for", *runFor).Msg("Run time over, stopping")
+	} else {
+		log.Warn().Msg("Signal to terminate received")
+	}
+	if err := sdNotify("STOPPING=1"); err != nil {
+		log.Error().Err(err).Msg("Could not notify systemd")
+	}
+	// restore default signal handling, a second signal kills the process
+	stop()
+	<-watcherDone
+	stopAlerts()
+	stopTracer()
+	<-tracerDone
+	<-remoteWriteDone
+	<-textfileDone
+	stopHA()
+	<-haDone
+	stopWeb()
+	<-webDone
+	<-grpcDone
 
+	if matched := failOn.Matched(); matched > 0 {
+		log.Error().Uint64("events", matched).Msg("Events matching --fail-on were seen")
+		return exitCodeFailOn
+	}
+	return 0
 }
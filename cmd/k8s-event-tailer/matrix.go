@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// matrixOptions configures the Matrix sink
+type matrixOptions struct {
+	homeserver string
+	room       string
+	token      string
+	tls        *tlsPolicy
+}
+
+// matrixSink sends events as notices to a Matrix room through the
+// client-server API.
+type matrixSink struct {
+	opts   matrixOptions
+	client *http.Client
+	header http.Header
+}
+
+func newMatrixSink(opts matrixOptions) (*matrixSink, error) {
+	if _, err := url.Parse(opts.homeserver); err != nil {
+		return nil, fmt.Errorf("invalid Matrix homeserver: %w", err)
+	}
+	if opts.room == "" || opts.token == "" {
+		return nil, fmt.Errorf("matrix room and access token are required")
+	}
+	opts.homeserver = strings.TrimSuffix(opts.homeserver, "/")
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+opts.token)
+	return &matrixSink{
+		opts:   opts,
+		client: opts.tls.HTTPClient(30 * time.Second),
+		header: header,
+	}, nil
+}
+
+func (m *matrixSink) Name() string {
+	return "matrix"
+}
+
+func (m *matrixSink) Write(event *corev1.Event) error {
+	// the transaction ID lets the homeserver deduplicate retried requests
+	txn := fmt.Sprintf("%s-%s", event.UID, event.ResourceVersion)
+	endpoint := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s",
+		m.opts.homeserver, url.PathEscape(m.opts.room), txn)
+	return doJSON(context.Background(), m.client, http.MethodPut, endpoint, m.header, map[string]string{
+		"msgtype":        "m.notice",
+		"body":           eventText(event),
+		"format":         "org.matrix.custom.html",
+		"formatted_body": eventHTML(event),
+	}, nil)
+}
+
+func (m *matrixSink) Close() error {
+	return nil
+}
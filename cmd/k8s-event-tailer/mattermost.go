@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// mattermostOptions configures the Mattermost sink
+type mattermostOptions struct {
+	webhookURL string
+	// username overrides the webhook's user name, which must be allowed in
+	// the Mattermost integration settings
+	username string
+	// routes send matching events to the channel named by the route
+	// instead of the webhook's default channel
+	routes []*eventRule
+	tls    *tlsPolicy
+}
+
+// mattermostSink posts events as attachments to a Mattermost incoming
+// webhook.
+type mattermostSink struct {
+	opts   mattermostOptions
+	client *http.Client
+}
+
+type mattermostMessage struct {
+	Text        string           `json:"text"`
+	Channel     string           `json:"channel,omitempty"`
+	Username    string           `json:"username,omitempty"`
+	Attachments []chatAttachment `json:"attachments"`
+}
+
+func newMattermostSink(opts mattermostOptions) (*mattermostSink, error) {
+	if opts.webhookURL == "" {
+		return nil, fmt.Errorf("mattermost webhook URL missing")
+	}
+	return &mattermostSink{
+		opts:   opts,
+		client: opts.tls.HTTPClient(30 * time.Second),
+	}, nil
+}
+
+func (s *mattermostSink) Name() string {
+	return "mattermost"
+}
+
+func (s *mattermostSink) Write(event *corev1.Event) error {
+	return doJSON(context.Background(), s.client, http.MethodPost, s.opts.webhookURL, nil, mattermostMessage{
+		Text:        eventTitle(event),
+		Channel:     routeChannel(s.opts.routes, event),
+		Username:    s.opts.username,
+		Attachments: []chatAttachment{eventAttachment(event)},
+	}, nil)
+}
+
+func (s *mattermostSink) Close() error {
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// recordOverhead approximates the memory of an event record beyond its
+// serialized size, i.e. Go struct headers and maps
+const recordOverhead = 512
+
+var (
+	memoryUsedGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "memory_budget_used_bytes",
+		Help: "Estimated memory held by buffered events by component",
+	}, []string{"component"})
+
+	memoryEvictedCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "memory_budget_evicted_total",
+		Help: "Number of buffered events evicted to stay within the memory budget",
+	}, []string{"component"})
+)
+
+// memoryBudget is the estimated memory all event buffers may use together.
+// Buffers account for their records and evict their oldest ones once the
+// budget is exceeded.
+type memoryBudget struct {
+	// limit in bytes, zero means unlimited
+	limit int64
+	used  int64
+}
+
+func newMemoryBudget(limit int64) *memoryBudget {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "memory_budget_limit_bytes",
+		Help: "Memory budget for buffered events, 0 if unlimited",
+	}, func() float64 {
+		return float64(limit)
+	})
+	return &memoryBudget{limit: limit}
+}
+
+// Account returns the account through which component uses the budget.
+func (b *memoryBudget) Account(component string) *memoryAccount {
+	return &memoryAccount{
+		component: component,
+		budget:    b,
+		used:      memoryUsedGauge.WithLabelValues(component),
+		evicted:   memoryEvictedCounter.WithLabelValues(component),
+	}
+}
+
+// memoryAccount tracks the memory used by a single buffer
+type memoryAccount struct {
+	component string
+	budget    *memoryBudget
+	used      prometheus.Gauge
+	evicted   prometheus.Counter
+}
+
+func (a *memoryAccount) Add(size int64) {
+	atomic.AddInt64(&a.budget.used, size)
+	a.used.Add(float64(size))
+}
+
+func (a *memoryAccount) Release(size int64) {
+	atomic.AddInt64(&a.budget.used, -size)
+	a.used.Sub(float64(size))
+}
+
+// Evicted records that a buffer dropped a record of the given size to make
+// room.
+func (a *memoryAccount) Evicted(size int64) {
+	a.Release(size)
+	a.evicted.Inc()
+	drops.Add(dropCauseMemoryBudget, a.component, 1)
+}
+
+// OverBudget reports whether all buffers together use more than the budget
+func (a *memoryAccount) OverBudget() bool {
+	return a.budget.limit > 0 && atomic.LoadInt64(&a.budget.used) > a.budget.limit
+}
+
+// recordSize estimates the memory held by record
+func recordSize(record eventRecord) int64 {
+	return int64(record.event.Size()+len(record.message)) + recordOverhead
+}
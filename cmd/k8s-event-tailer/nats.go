@@ -0,0 +1,431 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+
+	"k8s-event-tailer/pkg/extension"
+)
+
+const (
+	defaultNATSSubject = "k8s.events.{{.Namespace}}.{{.Reason}}"
+	// natsReplyTimeout bounds the wait for the server to confirm a batch
+	natsReplyTimeout = 10 * time.Second
+)
+
+// natsOptions configures the NATS sink
+type natsOptions struct {
+	// address is nats:// or tls://host:port
+	address string
+	// subject is a template executed with the JSON event
+	subject string
+	// jetStream waits for the stream to acknowledge every event
+	jetStream bool
+	token     string
+	username  string
+	password  string
+	tls       *tlsPolicy
+}
+
+// natsSink publishes events as JSON to subjects derived from the event. With
+// JetStream, each event is acknowledged by the stream capturing its subject
+// and carries its ID as Nats-Msg-Id, so that retried batches are
+// deduplicated. Otherwise a batch is confirmed with a ping once the server
+// has received it.
+type natsSink struct {
+	subject   *template.Template
+	jetStream bool
+	conn      *natsConn
+}
+
+func newNATSSink(opts natsOptions) (*natsSink, error) {
+	scheme, hostPort, err := parseStreamAddress(opts.address, "nats", "tls")
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := template.New("subject").Option("missingkey=error").Parse(opts.subject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid nats subject template: %w", err)
+	}
+	c := &natsConn{
+		address: hostPort,
+		tls:     opts.tls.Config(),
+		connect: natsConnect{
+			Name:         "k8s-event-tailer",
+			Lang:         "go",
+			Protocol:     1,
+			Headers:      true,
+			NoResponders: true,
+			AuthToken:    opts.token,
+			User:         opts.username,
+			Pass:         opts.password,
+		},
+		inbox: "_INBOX." + strings.ReplaceAll(string(uuid.NewUUID()), "-", ""),
+	}
+	c.connect.TLSRequired = scheme == "tls"
+	return &natsSink{subject: tmpl, jetStream: opts.jetStream, conn: c}, nil
+}
+
+func (s *natsSink) Name() string {
+	return "nats"
+}
+
+func (s *natsSink) Write(event *corev1.Event) error {
+	return s.WriteBatch([]*corev1.Event{event})
+}
+
+// WriteBatch publishes the events and waits for the server to confirm them
+func (s *natsSink) WriteBatch(events []*corev1.Event) error {
+	messages := make([]natsMessage, 0, len(events))
+	for _, event := range events {
+		data := newJSONEvent(event)
+		var subject strings.Builder
+		if err := s.subject.Execute(&subject, data); err != nil {
+			return extension.Permanent(fmt.Errorf("could not render nats subject: %w", err))
+		}
+		payload, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		message := natsMessage{subject: natsSubject(subject.String()), payload: payload}
+		if s.jetStream {
+			message.id = string(event.UID) + "-" + event.ResourceVersion
+		}
+		messages = append(messages, message)
+	}
+	return s.conn.Publish(messages, s.jetStream)
+}
+
+func (s *natsSink) Close() error {
+	return s.conn.Close()
+}
+
+// natsSubject replaces characters not allowed in subjects and fills empty
+// tokens, e.g. of cluster scoped events without namespace, with _
+func natsSubject(subject string) string {
+	tokens := strings.Split(subject, ".")
+	for i, token := range tokens {
+		token = strings.Map(func(r rune) rune {
+			if r <= ' ' || r == '*' || r == '>' {
+				return '_'
+			}
+			return r
+		}, token)
+		if token == "" {
+			token = "_"
+		}
+		tokens[i] = token
+	}
+	return strings.Join(tokens, ".")
+}
+
+type natsMessage struct {
+	subject string
+	// id is sent as Nats-Msg-Id header if set
+	id      string
+	payload []byte
+}
+
+// natsConnect is the CONNECT message of the client protocol
+type natsConnect struct {
+	Verbose      bool   `json:"verbose"`
+	Pedantic     bool   `json:"pedantic"`
+	TLSRequired  bool   `json:"tls_required"`
+	Name         string `json:"name"`
+	Lang         string `json:"lang"`
+	Protocol     int    `json:"protocol"`
+	Headers      bool   `json:"headers"`
+	NoResponders bool   `json:"no_responders"`
+	AuthToken    string `json:"auth_token,omitempty"`
+	User         string `json:"user,omitempty"`
+	Pass         string `json:"pass,omitempty"`
+}
+
+type natsInfo struct {
+	TLSRequired bool `json:"tls_required"`
+}
+
+// natsReply is a message read from the server: a pong, a message to the
+// inbox or an error, after which the connection is closed
+type natsReply struct {
+	pong    bool
+	subject string
+	header  string
+	payload []byte
+	err     error
+}
+
+type natsAck struct {
+	Stream string `json:"stream"`
+	Error  *struct {
+		Code        int    `json:"code"`
+		Description string `json:"description"`
+	} `json:"error"`
+}
+
+// natsConn is a connection speaking the NATS client protocol, which is
+// reestablished on the next publish after it broke. It implements just what
+// publishing needs.
+type natsConn struct {
+	address string
+	tls     *tls.Config
+	connect natsConnect
+	inbox   string
+
+	mu      sync.Mutex
+	conn    net.Conn
+	writeMu sync.Mutex
+	writer  *bufio.Writer
+	replies chan natsReply
+	// done is closed with the connection, so that its reader stops
+	done  chan struct{}
+	batch uint64
+}
+
+// Publish sends messages and waits until the server received them or, with
+// jetStream, the streams acknowledged them
+func (c *natsConn) Publish(messages []natsMessage, jetStream bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		if err := c.dial(); err != nil {
+			return err
+		}
+	}
+	c.batch++
+	prefix := c.inbox + "." + strconv.FormatUint(c.batch, 10) + "."
+	c.writeMu.Lock()
+	for i, message := range messages {
+		if jetStream {
+			header := "NATS/1.0\r\nNats-Msg-Id: " + message.id + "\r\n\r\n"
+			fmt.Fprintf(c.writer, "HPUB %s %s%d %d %d\r\n%s", message.subject, prefix, i,
+				len(header), len(header)+len(message.payload), header)
+		} else {
+			fmt.Fprintf(c.writer, "PUB %s %d\r\n", message.subject, len(message.payload))
+		}
+		c.writer.Write(message.payload)
+		c.writer.WriteString("\r\n")
+	}
+	if !jetStream {
+		c.writer.WriteString("PING\r\n")
+	}
+	_ = c.conn.SetWriteDeadline(time.Now().Add(natsReplyTimeout))
+	err := c.writer.Flush()
+	c.writeMu.Unlock()
+	if err != nil {
+		c.closeLocked()
+		return err
+	}
+
+	pending := 1
+	if jetStream {
+		pending = len(messages)
+	}
+	var failures []string
+	permanent := true
+	timeout := time.NewTimer(natsReplyTimeout)
+	defer timeout.Stop()
+	for pending > 0 {
+		var reply natsReply
+		select {
+		case reply = <-c.replies:
+		case <-timeout.C:
+			c.closeLocked()
+			return fmt.Errorf("nats: %d of %d messages not confirmed in time", pending, len(messages))
+		}
+		switch {
+		case reply.err != nil:
+			c.closeLocked()
+			return reply.err
+		case reply.pong:
+			if !jetStream {
+				pending--
+			}
+		case strings.HasPrefix(reply.subject, prefix):
+			pending--
+			if strings.HasPrefix(reply.header, "NATS/1.0 503") {
+				failures = append(failures, "no stream captures the subject")
+				continue
+			}
+			var ack natsAck
+			if err := json.Unmarshal(reply.payload, &ack); err != nil {
+				permanent = false
+				failures = append(failures, "invalid acknowledgement: "+err.Error())
+			} else if ack.Error != nil {
+				// e.g. a full stream may accept messages again later
+				permanent = permanent && ack.Error.Code/100 == 4
+				failures = append(failures, ack.Error.Description)
+			}
+		}
+	}
+	if len(failures) == 0 {
+		return nil
+	}
+	err = fmt.Errorf("nats: %d of %d messages failed: %s", len(failures), len(messages), failures[0])
+	if permanent {
+		return extension.Permanent(err)
+	}
+	return err
+}
+
+// dial connects, upgrades to TLS if required and authenticates. It starts
+// reading replies once the server accepted the connection.
+func (c *natsConn) dial() error {
+	dialer := &net.Dialer{Timeout: streamDialTimeout, KeepAlive: 30 * time.Second}
+	conn, err := dialer.Dial("tcp", c.address)
+	if err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(time.Now().Add(natsReplyTimeout))
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	var info natsInfo
+	if !strings.HasPrefix(line, "INFO ") || json.Unmarshal([]byte(line[len("INFO "):]), &info) != nil {
+		conn.Close()
+		return fmt.Errorf("nats: unexpected greeting %q", strings.TrimSpace(line))
+	}
+	if c.connect.TLSRequired || info.TLSRequired {
+		config := c.tls.Clone()
+		if config.ServerName == "" {
+			config.ServerName, _, _ = net.SplitHostPort(c.address)
+		}
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return err
+		}
+		conn = tlsConn
+		reader = bufio.NewReader(conn)
+	}
+	connect := c.connect
+	connect.TLSRequired = c.connect.TLSRequired || info.TLSRequired
+	data, err := json.Marshal(connect)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\nSUB %s.> 1\r\nPING\r\n", data, c.inbox); err != nil {
+		conn.Close()
+		return err
+	}
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "-ERR") {
+			conn.Close()
+			// e.g. authorization violations
+			return extension.Permanent(fmt.Errorf("nats: %s", line))
+		}
+		if line == "PONG" {
+			break
+		}
+	}
+	_ = conn.SetDeadline(time.Time{})
+	c.conn = conn
+	c.writer = bufio.NewWriter(conn)
+	c.replies = make(chan natsReply)
+	c.done = make(chan struct{})
+	go c.read(conn, reader, c.replies, c.done)
+	return nil
+}
+
+// read passes the replies of conn on until it fails, answering the pings of
+// the server
+func (c *natsConn) read(conn net.Conn, reader *bufio.Reader, replies chan<- natsReply, done <-chan struct{}) {
+	send := func(reply natsReply) {
+		select {
+		case replies <- reply:
+		case <-done:
+		}
+	}
+	fail := func(err error) {
+		send(natsReply{err: err})
+	}
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			fail(err)
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "PING":
+			c.writeMu.Lock()
+			_, err = conn.Write([]byte("PONG\r\n"))
+			c.writeMu.Unlock()
+			if err != nil {
+				fail(err)
+				return
+			}
+		case "PONG":
+			send(natsReply{pong: true})
+		case "-ERR":
+			fail(fmt.Errorf("nats: %s", strings.TrimSpace(line)))
+			return
+		case "MSG", "HMSG":
+			// MSG subject sid [reply] size, HMSG subject sid [reply] header-size size
+			headerSize, size := 0, 0
+			if fields[0] == "HMSG" && len(fields) >= 5 {
+				headerSize, _ = strconv.Atoi(fields[len(fields)-2])
+			}
+			if len(fields) >= 4 {
+				size, err = strconv.Atoi(fields[len(fields)-1])
+			}
+			if len(fields) < 4 || err != nil {
+				fail(fmt.Errorf("nats: invalid message %q", strings.TrimSpace(line)))
+				return
+			}
+			data := make([]byte, size+2)
+			if _, err := io.ReadFull(reader, data); err != nil {
+				fail(err)
+				return
+			}
+			send(natsReply{
+				subject: fields[1],
+				header:  string(data[:headerSize]),
+				payload: bytes.TrimSpace(data[headerSize:size]),
+			})
+		}
+	}
+}
+
+// Close closes the connection, if it is open
+func (c *natsConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closeLocked()
+	return nil
+}
+
+func (c *natsConn) closeLocked() {
+	if c.conn == nil {
+		return
+	}
+	c.conn.Close()
+	c.conn = nil
+	close(c.done)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	slackSinkName                  = "slack"
+	teamsSinkName                  = "teams"
+	defaultNotifyFilter            = "type=Warning"
+	defaultNotifyBatchSize         = 50
+	defaultNotifyBatchInterval     = "30s"
+	defaultNotifyMessagesPerMinute = 6
+	// maxNotificationGroups bounds the events listed in one message, the
+	// remaining ones are only counted
+	maxNotificationGroups = 10
+)
+
+// notificationOptions configures the Slack and Teams sinks
+type notificationOptions struct {
+	webhookURL string
+	// messagesPerMinute limits the messages posted, events of batches over
+	// the limit are suppressed and counted in the next message. Zero
+	// disables the limit.
+	messagesPerMinute int
+	tls               *tlsPolicy
+}
+
+// eventGroup is an event of a batch together with the number of events of
+// the batch with the same type, reason and involved object
+type eventGroup struct {
+	event *corev1.Event
+	count int
+}
+
+// notificationSink posts batches of events as one message to a chat webhook,
+// so that event storms do not flood the channel. Repeated events of an object
+// are listed once.
+type notificationSink struct {
+	name   string
+	url    string
+	client *http.Client
+	format func(groups []eventGroup, events, suppressed int) interface{}
+	budget *retryBudget
+
+	mu         sync.Mutex
+	suppressed int
+}
+
+func newNotificationSink(name string, opts notificationOptions, format func([]eventGroup, int, int) interface{}) (*notificationSink, error) {
+	if opts.webhookURL == "" {
+		return nil, fmt.Errorf("%s webhook URL missing", name)
+	}
+	s := &notificationSink{
+		name:   name,
+		url:    opts.webhookURL,
+		client: opts.tls.HTTPClient(30 * time.Second),
+		format: format,
+	}
+	if opts.messagesPerMinute > 0 {
+		s.budget = newRetryBudget(opts.messagesPerMinute)
+	}
+	return s, nil
+}
+
+// newSlackSink posts events to a Slack incoming webhook
+func newSlackSink(opts notificationOptions) (*notificationSink, error) {
+	return newNotificationSink(slackSinkName, opts, slackMessage)
+}
+
+// newTeamsSink posts events as adaptive cards to a Microsoft Teams workflow
+// or incoming webhook
+func newTeamsSink(opts notificationOptions) (*notificationSink, error) {
+	return newNotificationSink(teamsSinkName, opts, teamsMessage)
+}
+
+func (s *notificationSink) Name() string {
+	return s.name
+}
+
+func (s *notificationSink) Write(event *corev1.Event) error {
+	return s.WriteBatch([]*corev1.Event{event})
+}
+
+// WriteBatch posts events as one message, unless the message limit is
+// reached
+func (s *notificationSink) WriteBatch(events []*corev1.Event) error {
+	s.mu.Lock()
+	if s.budget != nil && !s.budget.Take() {
+		s.suppressed += len(events)
+		s.mu.Unlock()
+		drops.Add(dropCauseRateLimited, s.name, len(events))
+		return nil
+	}
+	suppressed := s.suppressed
+	s.suppressed = 0
+	s.mu.Unlock()
+
+	err := doJSON(context.Background(), s.client, http.MethodPost, s.url, nil,
+		s.format(groupEvents(events), len(events), suppressed), nil)
+	if err != nil {
+		s.mu.Lock()
+		s.suppressed += suppressed
+		s.mu.Unlock()
+	}
+	return err
+}
+
+func (s *notificationSink) Close() error {
+	return nil
+}
+
+// groupEvents groups events by type, reason and involved object in the order
+// the groups were first seen. A group keeps its latest event.
+func groupEvents(events []*corev1.Event) []eventGroup {
+	var groups []eventGroup
+	index := map[string]int{}
+	for _, event := range events {
+		key := eventTitle(event)
+		if i, ok := index[key]; ok {
+			groups[i].event = event
+			groups[i].count++
+			continue
+		}
+		index[key] = len(groups)
+		groups = append(groups, eventGroup{event: event, count: 1})
+	}
+	return groups
+}
+
+// notificationSummary returns the headline of a message with events
+func notificationSummary(groups []eventGroup, events, suppressed int) string {
+	summary := eventTitle(groups[0].event)
+	if events > 1 {
+		summary = fmt.Sprintf("%d events of %d objects", events, len(groups))
+	}
+	if suppressed > 0 {
+		summary += fmt.Sprintf(" (%d more suppressed by the rate limit)", suppressed)
+	}
+	return summary
+}
+
+// notificationOmitted returns the note on groups not listed in a message,
+// empty if all are listed
+func notificationOmitted(groups []eventGroup) string {
+	if len(groups) <= maxNotificationGroups {
+		return ""
+	}
+	omitted := 0
+	for _, group := range groups[maxNotificationGroups:] {
+		omitted += group.count
+	}
+	return fmt.Sprintf("… and %d more events", omitted)
+}
+
+func groupTitle(group eventGroup) string {
+	if group.count == 1 {
+		return eventTitle(group.event)
+	}
+	return fmt.Sprintf("%s (%d×)", eventTitle(group.event), group.count)
+}
+
+type slackNotification struct {
+	Text        string           `json:"text"`
+	Attachments []chatAttachment `json:"attachments"`
+}
+
+// slackMessage lists the groups as attachments
+func slackMessage(groups []eventGroup, events, suppressed int) interface{} {
+	message := slackNotification{Text: notificationSummary(groups, events, suppressed)}
+	for i, group := range groups {
+		if i == maxNotificationGroups {
+			message.Attachments = append(message.Attachments, chatAttachment{Text: notificationOmitted(groups)})
+			break
+		}
+		attachment := eventAttachment(group.event)
+		attachment.Title = groupTitle(group)
+		message.Attachments = append(message.Attachments, attachment)
+	}
+	return message
+}
+
+// teamsMessage lists the groups in an adaptive card
+func teamsMessage(groups []eventGroup, events, suppressed int) interface{} {
+	body := []map[string]interface{}{{
+		"type":   "TextBlock",
+		"text":   notificationSummary(groups, events, suppressed),
+		"size":   "Medium",
+		"weight": "Bolder",
+		"wrap":   true,
+	}}
+	for i, group := range groups {
+		if i == maxNotificationGroups {
+			body = append(body, map[string]interface{}{
+				"type": "TextBlock", "text": notificationOmitted(groups), "isSubtle": true, "wrap": true,
+			})
+			break
+		}
+		color := "Good"
+		if group.event.Type == corev1.EventTypeWarning {
+			color = "Attention"
+		}
+		body = append(body, map[string]interface{}{
+			"type":      "Container",
+			"separator": true,
+			"items": []map[string]interface{}{
+				{"type": "TextBlock", "text": groupTitle(group), "weight": "Bolder", "color": color, "wrap": true},
+				{"type": "TextBlock", "text": group.event.Message, "wrap": true},
+				{"type": "TextBlock", "text": fmt.Sprintf("count %d, last seen %s", group.event.Count,
+					eventTime(group.event).UTC().Format(time.RFC3339)), "isSubtle": true, "size": "Small", "wrap": true},
+			},
+		})
+	}
+	return map[string]interface{}{
+		"type": "message",
+		"attachments": []map[string]interface{}{{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content": map[string]interface{}{
+				"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+				"type":    "AdaptiveCard",
+				"version": "1.4",
+				"msteams": map[string]string{"width": "Full"},
+				"body":    body,
+			},
+		}},
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+)
+
+// objectResolver fetches the involved objects of events of any kind,
+// including custom resources. Resources are looked up with the cached
+// discovery information.
+type objectResolver struct {
+	client dynamic.Interface
+	mapper *restmapper.DeferredDiscoveryRESTMapper
+}
+
+func newObjectResolver(config *rest.Config) (*objectResolver, error) {
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &objectResolver{
+		client: client,
+		mapper: restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(discoveryClient)),
+	}, nil
+}
+
+// Get returns the object ref points to
+func (r *objectResolver) Get(ctx context.Context, ref corev1.ObjectReference) (*unstructured.Unstructured, error) {
+	gv, err := schema.ParseGroupVersion(ref.APIVersion)
+	if err != nil {
+		return nil, err
+	}
+	gvk := gv.WithKind(ref.Kind)
+	mapping, err := r.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if meta.IsNoMatchError(err) {
+		// the resource may have been installed after discovery was cached
+		r.mapper.Reset()
+		mapping, err = r.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	}
+	if err != nil {
+		return nil, err
+	}
+	resource := r.client.Resource(mapping.Resource)
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		return resource.Namespace(ref.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+	}
+	return resource.Get(ctx, ref.Name, metav1.GetOptions{})
+}
+
+// objectSummary returns a short description of obj: its age, owners,
+// labels, phase and conditions
+func objectSummary(obj *unstructured.Unstructured) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s", obj.GetKind(), obj.GetName())
+	if obj.GetNamespace() != "" {
+		fmt.Fprintf(&b, " in %s", obj.GetNamespace())
+	}
+	fmt.Fprintf(&b, ", created %s ago\n", time.Since(obj.GetCreationTimestamp().Time).Round(time.Second))
+	for _, owner := range obj.GetOwnerReferences() {
+		fmt.Fprintf(&b, "owner: %s %s\n", owner.Kind, owner.Name)
+	}
+	if labels := obj.GetLabels(); len(labels) > 0 {
+		keys := make([]string, 0, len(labels))
+		for key := range labels {
+			keys = append(keys, key+"="+labels[key])
+		}
+		sort.Strings(keys)
+		fmt.Fprintf(&b, "labels: %s\n", strings.Join(keys, ", "))
+	}
+	if phase, ok, _ := unstructured.NestedString(obj.Object, "status", "phase"); ok {
+		fmt.Fprintf(&b, "phase: %s\n", phase)
+	}
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(&b, "condition: %v=%v", condition["type"], condition["status"])
+		if reason, ok := condition["reason"]; ok {
+			fmt.Fprintf(&b, " (%v)", reason)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// onCallOptions configures the Grafana OnCall notifier
+type onCallOptions struct {
+	webhookURL string
+	// severities maps rule names to severities, rules without a mapping use
+	// warning for Warning events and info otherwise
+	severities map[string]string
+	tls        *tlsPolicy
+}
+
+// onCallNotifier sends alerts to a Grafana OnCall (IRM) formatted webhook
+// integration. The alert key is the alert_uid, which OnCall groups by in its
+// default grouping template, so an alert group is created when an alert
+// fires and resolved with it.
+type onCallNotifier struct {
+	opts   onCallOptions
+	client *http.Client
+}
+
+// onCallAlert follows the formatted webhook payload. The additional fields
+// can be used in the routing and grouping templates of the integration,
+// e.g. {{ payload.severity }} or {{ payload.group_key }}.
+type onCallAlert struct {
+	AlertUID string `json:"alert_uid"`
+	Title    string `json:"title"`
+	State    string `json:"state"`
+	Message  string `json:"message"`
+	Severity string `json:"severity"`
+	// GroupKey is the rule and namespace, to group the alerts of a rule
+	// across objects of a namespace
+	GroupKey string            `json:"group_key"`
+	Labels   map[string]string `json:"labels"`
+}
+
+func newOnCallNotifier(opts onCallOptions) (*onCallNotifier, error) {
+	if opts.webhookURL == "" {
+		return nil, fmt.Errorf("grafana oncall webhook URL missing")
+	}
+	return &onCallNotifier{
+		opts:   opts,
+		client: opts.tls.HTTPClient(30 * time.Second),
+	}, nil
+}
+
+func (n *onCallNotifier) Name() string {
+	return "oncall"
+}
+
+func (n *onCallNotifier) Fire(ctx context.Context, a *alert) error {
+	return n.send(ctx, a, "alerting")
+}
+
+func (n *onCallNotifier) Resolve(ctx context.Context, a *alert) error {
+	return n.send(ctx, a, "ok")
+}
+
+func (n *onCallNotifier) send(ctx context.Context, a *alert, state string) error {
+	payload := onCallAlert{
+		AlertUID: a.Key(),
+		Title:    fmt.Sprintf("%s: %s %s/%s", a.rule, strings.ToLower(a.kind), a.namespace, a.name),
+		State:    state,
+		Severity: n.severity(a),
+		GroupKey: a.rule + "/" + a.namespace,
+		Labels: map[string]string{
+			"rule":      a.rule,
+			"namespace": a.namespace,
+			"kind":      a.kind,
+			"name":      a.name,
+		},
+	}
+	if a.event != nil {
+		payload.Message = eventMarkdown(a.event)
+		if a.logs != "" {
+			payload.Message += "\n\n```\n" + a.logs + "\n```"
+		}
+		if a.snapshot != "" {
+			payload.Message += "\n\n```\n" + a.snapshot + "\n```"
+		}
+		payload.Labels["reason"] = a.event.Reason
+	}
+	return doJSON(ctx, n.client, http.MethodPost, n.opts.webhookURL, nil, payload, nil)
+}
+
+func (n *onCallNotifier) severity(a *alert) string {
+	if severity, ok := n.opts.severities[a.rule]; ok {
+		return severity
+	}
+	if a.event != nil && a.event.Type == corev1.EventTypeWarning {
+		return "warning"
+	}
+	return "info"
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"k8s-event-tailer/pkg/extension"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	defaultOpenSearchIndex   = "k8s-events"
+	defaultOpenSearchService = "es"
+)
+
+// openSearchSink indexes events with the bulk API. Documents are created
+// with the ID <uid>-<resourceVersion>, which works for data streams as well
+// as regular indices and makes retries idempotent. Requests are signed with
+// SigV4 for Amazon OpenSearch Service domains.
+type openSearchSink struct {
+	url      string
+	index    string
+	username string
+	password string
+	signer   *awsSigner
+	client   *http.Client
+}
+
+type openSearchOptions struct {
+	url      string
+	index    string
+	username string
+	password string
+	// region enables SigV4 signing for service, which is es for domains and
+	// aoss for serverless collections
+	region  string
+	service string
+	tls     *tlsPolicy
+}
+
+// openSearchDocument adds the @timestamp field required by data streams
+type openSearchDocument struct {
+	Timestamp time.Time `json:"@timestamp"`
+	jsonEvent
+}
+
+type openSearchBulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int `json:"status"`
+		Error  struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
+}
+
+func newOpenSearchSink(opts openSearchOptions) (*openSearchSink, error) {
+	s := &openSearchSink{
+		url:      strings.TrimSuffix(opts.url, "/"),
+		index:    opts.index,
+		username: opts.username,
+		password: opts.password,
+		client:   opts.tls.HTTPClient(30 * time.Second),
+	}
+	if opts.region != "" {
+		signer, err := newAWSSigner(opts.region, opts.service, opts.tls)
+		if err != nil {
+			return nil, fmt.Errorf("opensearch: %w", err)
+		}
+		s.signer = signer
+	}
+	return s, nil
+}
+
+func (s *openSearchSink) Name() string {
+	return "opensearch"
+}
+
+func (s *openSearchSink) Write(event *corev1.Event) error {
+	return s.WriteBatch([]*corev1.Event{event})
+}
+
+// WriteBatch sends the events with one bulk request
+func (s *openSearchSink) WriteBatch(events []*corev1.Event) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, event := range events {
+		action := map[string]interface{}{
+			"create": map[string]string{"_id": string(event.UID) + "-" + event.ResourceVersion},
+		}
+		if err := enc.Encode(action); err != nil {
+			return err
+		}
+		data := newJSONEvent(event)
+		if err := enc.Encode(openSearchDocument{Timestamp: data.Timestamp, jsonEvent: data}); err != nil {
+			return err
+		}
+	}
+
+	ctx := context.Background()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url+"/"+s.index+"/_bulk", bytes.NewReader(body.Bytes()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if s.username != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+	if s.signer != nil {
+		if err := s.signer.Sign(ctx, req, body.Bytes()); err != nil {
+			return err
+		}
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+	return checkBulkResponse(resp.Body, len(events))
+}
+
+// checkBulkResponse returns an error if documents of a bulk request with
+// events documents failed. Documents which already exist are not an error,
+// since they were written by an earlier attempt. The error is permanent
+// unless a document may succeed on retry.
+func checkBulkResponse(body io.Reader, events int) error {
+	var result openSearchBulkResponse
+	if err := json.NewDecoder(body).Decode(&result); err != nil {
+		return err
+	}
+	if !result.Errors {
+		return nil
+	}
+
+	var reasons []string
+	retryable := false
+	for _, item := range result.Items {
+		for _, status := range item {
+			switch {
+			case status.Status/100 == 2, status.Status == http.StatusConflict:
+			case status.Status == http.StatusTooManyRequests || status.Status/100 == 5:
+				retryable = true
+				reasons = append(reasons, status.Error.Type+": "+status.Error.Reason)
+			default:
+				reasons = append(reasons, status.Error.Type+": "+status.Error.Reason)
+			}
+		}
+	}
+	if len(reasons) == 0 {
+		return nil
+	}
+	err := fmt.Errorf("%d of %d documents failed: %s", len(reasons), events, reasons[0])
+	if !retryable {
+		return extension.Permanent(err)
+	}
+	return err
+}
+
+func (s *openSearchSink) Close() error {
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+// namespaceOptOut skips the events of namespaces annotated with annotation
+// set to true, so that teams can opt out their namespaces themselves. The
+// namespaces are watched, so changes of the annotation apply right away.
+type namespaceOptOut struct {
+	annotation string
+	mu         sync.RWMutex
+	ignored    map[string]bool
+}
+
+func newNamespaceOptOut(annotation string) *namespaceOptOut {
+	if annotation == "" {
+		return nil
+	}
+	return &namespaceOptOut{annotation: annotation, ignored: map[string]bool{}}
+}
+
+func (o *namespaceOptOut) Name() string {
+	return "namespace-opt-out"
+}
+
+// Allow returns false for events of namespaces which opted out
+func (o *namespaceOptOut) Allow(event *corev1.Event) bool {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	return !o.ignored[event.Namespace]
+}
+
+// Start watches the namespaces until ctx is done. It returns once the
+// namespaces have been listed, so that no event of an opted out namespace
+// slips through on startup.
+func (o *namespaceOptOut) Start(ctx context.Context, client rest.Interface) {
+	if o == nil {
+		return
+	}
+	watchlist := cache.NewListWatchFromClient(client, "namespaces", corev1.NamespaceAll, fields.Everything())
+	_, controller := cache.NewInformer(watchlist, &corev1.Namespace{}, 0, cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			o.update(obj.(*corev1.Namespace))
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			o.update(obj.(*corev1.Namespace))
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if ns, ok := obj.(*corev1.Namespace); ok {
+				o.mu.Lock()
+				delete(o.ignored, ns.Name)
+				o.mu.Unlock()
+			}
+		},
+	})
+	go controller.Run(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), controller.HasSynced) {
+		return
+	}
+	o.mu.RLock()
+	log.Info().Int("namespaces", len(o.ignored)).Str("annotation", o.annotation).Msg("Namespaces opted out")
+	o.mu.RUnlock()
+}
+
+func (o *namespaceOptOut) update(ns *corev1.Namespace) {
+	ignore, _ := strconv.ParseBool(ns.Annotations[o.annotation])
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if ignore == o.ignored[ns.Name] {
+		return
+	}
+	if ignore {
+		o.ignored[ns.Name] = true
+		log.Info().Str("namespace", ns.Name).Msg("Namespace opted out, skipping its events")
+	} else {
+		delete(o.ignored, ns.Name)
+		log.Info().Str("namespace", ns.Name).Msg("Namespace opted in again")
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"plugin"
+
+	"github.com/rs/zerolog/log"
+
+	"k8s-event-tailer/pkg/extension"
+)
+
+const (
+	sinkFactorySymbol   = "NewSink"
+	filterFactorySymbol = "NewFilter"
+)
+
+// loadPlugins opens all *.so files in dir and instantiates the sinks and
+// filters they export. Loading plugins requires a binary built with cgo.
+func loadPlugins(dir string) ([]extension.Sink, []extension.Filter, error) {
+	var sinks []extension.Sink
+	var filters []extension.Filter
+	if dir == "" {
+		return sinks, filters, nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.so"))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(paths) > 0 && !pluginsSupported {
+		return nil, nil, fmt.Errorf("could not load plugins from %s: binary built without cgo, build it with CGO_ENABLED=1, e.g. make build-plugins", dir)
+	}
+	for _, path := range paths {
+		logger := log.With().Str("component", "plugins").Str("plugin", path).Logger()
+		p, err := plugin.Open(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not open plugin %s: %w", path, err)
+		}
+
+		found := false
+		if sym, err := p.Lookup(sinkFactorySymbol); err == nil {
+			factory, ok := sym.(extension.SinkFactory)
+			if !ok {
+				return nil, nil, fmt.Errorf("plugin %s: %s has type %T, want %T", path, sinkFactorySymbol, sym, factory)
+			}
+			sink, err := factory()
+			if err != nil {
+				return nil, nil, fmt.Errorf("plugin %s: could not create sink: %w", path, err)
+			}
+			logger.Info().Str("sink", sink.Name()).Msg("Loaded sink plugin")
+			sinks = append(sinks, sink)
+			found = true
+		}
+		if sym, err := p.Lookup(filterFactorySymbol); err == nil {
+			factory, ok := sym.(extension.FilterFactory)
+			if !ok {
+				return nil, nil, fmt.Errorf("plugin %s: %s has type %T, want %T", path, filterFactorySymbol, sym, factory)
+			}
+			filter, err := factory()
+			if err != nil {
+				return nil, nil, fmt.Errorf("plugin %s: could not create filter: %w", path, err)
+			}
+			logger.Info().Str("filter", filter.Name()).Msg("Loaded filter plugin")
+			filters = append(filters, filter)
+			found = true
+		}
+		if !found {
+			logger.Warn().Msgf("Plugin exports neither %s nor %s, ignoring", sinkFactorySymbol, filterFactorySymbol)
+		}
+	}
+	return sinks, filters, nil
+}
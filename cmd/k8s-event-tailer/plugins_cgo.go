@@ -0,0 +1,8 @@
+//go:build cgo
+// +build cgo
+
+package main
+
+// pluginsSupported reports whether the binary can load plugins, which needs
+// cgo
+const pluginsSupported = true
@@ -0,0 +1,7 @@
+//go:build !cgo
+// +build !cgo
+
+package main
+
+// pluginsSupported is false since the plugin package needs cgo
+const pluginsSupported = false
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog/log"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	defaultLogCaptureLines = 50
+	// logCaptureTimeout bounds the time delivery waits for the logs of an
+	// event
+	logCaptureTimeout = 5 * time.Second
+	// logCaptureMaxBytes cuts off pods logging very long lines
+	logCaptureMaxBytes = 64 << 10
+)
+
+var logCapturesCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "pod_log_captures_total",
+	Help: "Number of pod log captures for matching events, by result",
+}, []string{"result"})
+
+// logCapturer fetches the last lines of the container logs of pods with
+// events matching one of its rules, e.g. reason=BackOff,kind=Pod, so that
+// the log record and alerts show why the pod failed.
+type logCapturer struct {
+	client kubernetes.Interface
+	rules  []*eventRule
+	lines  int64
+}
+
+// newLogCapturer parses the selectors of the events to capture logs for,
+// nil if there are none
+func newLogCapturer(client kubernetes.Interface, selectors []string, lines int64) (*logCapturer, error) {
+	if len(selectors) == 0 {
+		return nil, nil
+	}
+	c := &logCapturer{client: client, lines: lines}
+	if c.lines <= 0 {
+		c.lines = defaultLogCaptureLines
+	}
+	for _, selector := range selectors {
+		rule, err := parseEventSelector(selector)
+		if err != nil {
+			return nil, err
+		}
+		c.rules = append(c.rules, rule)
+	}
+	return c, nil
+}
+
+// Capture returns the logs of the pod event is about, empty if the event
+// does not match or the logs could not be fetched
+func (c *logCapturer) Capture(ctx context.Context, event *corev1.Event) string {
+	if c == nil || event.InvolvedObject.Kind != "Pod" || !c.matches(event) {
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(ctx, logCaptureTimeout)
+	defer cancel()
+	ref := event.InvolvedObject
+	opts := &corev1.PodLogOptions{
+		Container:  eventContainer(ref.FieldPath),
+		TailLines:  &c.lines,
+		LimitBytes: int64Ptr(logCaptureMaxBytes),
+	}
+	logs, err := c.fetch(ctx, ref, opts)
+	if err != nil || len(logs) == 0 {
+		// a crash looping container has no current logs while it waits
+		// for its restart
+		opts.Previous = true
+		if previous, previousErr := c.fetch(ctx, ref, opts); previousErr == nil {
+			logs, err = previous, nil
+		}
+	}
+	if err != nil {
+		logCapturesCounter.WithLabelValues("error").Inc()
+		log.Debug().Err(err).Str("namespace", ref.Namespace).Str("pod", ref.Name).Msg("Could not capture pod logs")
+		return ""
+	}
+	logCapturesCounter.WithLabelValues("success").Inc()
+	return strings.TrimRight(string(logs), "\n")
+}
+
+func (c *logCapturer) fetch(ctx context.Context, ref corev1.ObjectReference, opts *corev1.PodLogOptions) ([]byte, error) {
+	return c.client.CoreV1().Pods(ref.Namespace).GetLogs(ref.Name, opts).DoRaw(ctx)
+}
+
+func (c *logCapturer) matches(event *corev1.Event) bool {
+	for _, rule := range c.rules {
+		if rule.Matches(event) {
+			return true
+		}
+	}
+	return false
+}
+
+// eventContainer returns the container name of a field path like
+// spec.containers{nginx}, empty for events about the whole pod
+func eventContainer(fieldPath string) string {
+	_, name, ok := strings.Cut(fieldPath, "{")
+	if !ok {
+		return ""
+	}
+	return strings.TrimSuffix(name, "}")
+}
+
+func int64Ptr(n int64) *int64 {
+	return &n
+}
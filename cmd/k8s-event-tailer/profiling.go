@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Profile types which can be pushed
+const (
+	profileCPU       = "cpu"
+	profileHeap      = "heap"
+	profileGoroutine = "goroutine"
+	profileMutex     = "mutex"
+	profileBlock     = "block"
+)
+
+var profileTypes = []string{profileCPU, profileHeap, profileGoroutine, profileMutex, profileBlock}
+
+var profileUploadsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "profiling_uploads_total",
+	Help: "Number of pushed profiles by type and result",
+}, []string{"type", "result"})
+
+// profilingOptions configures the profile pusher
+type profilingOptions struct {
+	// url of the Pyroscope server
+	url       string
+	appName   string
+	tags      map[string]string
+	authToken string
+	// types of profiles to push
+	types []string
+	// interval between pushes
+	interval time.Duration
+	// cpuDuration is how long the CPU is sampled per interval
+	cpuDuration time.Duration
+	// mutexFraction samples 1/n mutex contention events, see
+	// runtime.SetMutexProfileFraction
+	mutexFraction int
+	// blockRate samples one blocking event per rate nanoseconds spent
+	// blocked, see runtime.SetBlockProfileRate
+	blockRate int
+	tls       *tlsPolicy
+}
+
+// profilePusher periodically collects pprof profiles and pushes them to the
+// ingest API of a Pyroscope server.
+type profilePusher struct {
+	opts   profilingOptions
+	client *http.Client
+	logger zerolog.Logger
+}
+
+func newProfilePusher(opts profilingOptions) (*profilePusher, error) {
+	if _, err := url.Parse(opts.url); err != nil {
+		return nil, fmt.Errorf("invalid profiling URL: %w", err)
+	}
+	if opts.interval <= 0 {
+		return nil, fmt.Errorf("profiling interval must be positive")
+	}
+	if opts.cpuDuration <= 0 || opts.cpuDuration > opts.interval {
+		opts.cpuDuration = opts.interval
+	}
+	opts.url = strings.TrimSuffix(opts.url, "/")
+	for _, profileType := range opts.types {
+		switch profileType {
+		case profileMutex:
+			runtime.SetMutexProfileFraction(opts.mutexFraction)
+		case profileBlock:
+			runtime.SetBlockProfileRate(opts.blockRate)
+		}
+	}
+	return &profilePusher{
+		opts:   opts,
+		client: opts.tls.HTTPClient(30 * time.Second),
+		logger: log.With().Str("component", "profiling").Logger(),
+	}, nil
+}
+
+// Run collects and pushes profiles every interval until ctx is done. The CPU
+// profile samples the first cpuDuration of every interval.
+func (p *profilePusher) Run(ctx context.Context) {
+	p.logger.Info().Str("url", p.opts.url).Strs("types", p.opts.types).Msg("Pushing profiles")
+	ticker := time.NewTicker(p.opts.interval)
+	defer ticker.Stop()
+	for {
+		p.collect(ctx)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *profilePusher) collect(ctx context.Context) {
+	for _, profileType := range p.opts.types {
+		var buf bytes.Buffer
+		from := time.Now()
+		var err error
+		if profileType == profileCPU {
+			err = p.cpuProfile(ctx, &buf)
+		} else {
+			err = pprof.Lookup(profileType).WriteTo(&buf, 0)
+		}
+		if err == nil {
+			err = p.push(ctx, profileType, from, time.Now(), &buf)
+		}
+		if err != nil {
+			profileUploadsCounter.WithLabelValues(profileType, "error").Inc()
+			p.logger.Error().Err(err).Str("type", profileType).Msg("Could not push profile")
+			continue
+		}
+		profileUploadsCounter.WithLabelValues(profileType, "success").Inc()
+	}
+}
+
+func (p *profilePusher) cpuProfile(ctx context.Context, w io.Writer) error {
+	if err := pprof.StartCPUProfile(w); err != nil {
+		return err
+	}
+	timer := time.NewTimer(p.opts.cpuDuration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	pprof.StopCPUProfile()
+	return nil
+}
+
+func (p *profilePusher) push(ctx context.Context, profileType string, from, until time.Time, profile io.Reader) error {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("profile", "profile.pprof")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(part, profile); err != nil {
+		return err
+	}
+	if err := form.Close(); err != nil {
+		return err
+	}
+
+	params := url.Values{}
+	params.Set("name", p.applicationName(profileType))
+	params.Set("from", strconv.FormatInt(from.Unix(), 10))
+	params.Set("until", strconv.FormatInt(until.Unix(), 10))
+	params.Set("format", "pprof")
+	params.Set("spyName", "gospy")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.opts.url+"/ingest?"+params.Encode(), &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if p.opts.authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+p.opts.authToken)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// applicationName returns the Pyroscope application name, e.g.
+// k8s-event-tailer.cpu{cluster=prod}
+func (p *profilePusher) applicationName(profileType string) string {
+	keys := make([]string, 0, len(p.opts.tags))
+	for key := range p.opts.tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	tags := make([]string, 0, len(keys))
+	for _, key := range keys {
+		tags = append(tags, key+"="+p.opts.tags[key])
+	}
+	return fmt.Sprintf("%s.%s{%s}", p.opts.appName, profileType, strings.Join(tags, ","))
+}
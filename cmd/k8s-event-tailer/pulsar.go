@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	defaultPulsarTopic = "persistent://public/default/k8s-events"
+	// pulsarStringSchema is the schema info of string keys and values, sent
+	// with every request so that the REST producer does not expect base64
+	// encoded bytes
+	pulsarStringSchema = `{"name":"","schema":"","type":"STRING","properties":{}}`
+)
+
+// pulsarSink publishes events through the REST producer API of the Pulsar
+// brokers. The topic is a template executed with the JSON event, e.g.
+// persistent://public/default/events-{{.Namespace}}. Messages are keyed by
+// the involved object, so Key_Shared subscriptions see the events of an
+// object in order.
+type pulsarSink struct {
+	serviceURL string
+	topic      *template.Template
+	header     http.Header
+	client     *http.Client
+}
+
+type pulsarProducerMessages struct {
+	ProducerName string                  `json:"producerName"`
+	KeySchema    string                  `json:"keySchema"`
+	ValueSchema  string                  `json:"valueSchema"`
+	Messages     []pulsarProducerMessage `json:"messages"`
+}
+
+type pulsarProducerMessage struct {
+	Key       string `json:"key"`
+	Payload   string `json:"payload"`
+	EventTime string `json:"eventTime"`
+}
+
+func newPulsarSink(serviceURL, topic, token string, policy *tlsPolicy) (*pulsarSink, error) {
+	tmpl, err := template.New("topic").Option("missingkey=error").Parse(topic)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pulsar topic template: %w", err)
+	}
+	header := http.Header{}
+	if token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+	return &pulsarSink{
+		serviceURL: strings.TrimSuffix(serviceURL, "/"),
+		topic:      tmpl,
+		header:     header,
+		client:     policy.HTTPClient(30 * time.Second),
+	}, nil
+}
+
+func (s *pulsarSink) Name() string {
+	return "pulsar"
+}
+
+func (s *pulsarSink) Write(event *corev1.Event) error {
+	return s.WriteBatch([]*corev1.Event{event})
+}
+
+// WriteBatch groups the events by topic and sends one request per topic,
+// keeping the order of the events
+func (s *pulsarSink) WriteBatch(events []*corev1.Event) error {
+	var topics []string
+	batches := map[string][]pulsarProducerMessage{}
+	for _, event := range events {
+		data := newJSONEvent(event)
+		var topic strings.Builder
+		if err := s.topic.Execute(&topic, data); err != nil {
+			return fmt.Errorf("could not render pulsar topic: %w", err)
+		}
+		payload, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		obj := event.InvolvedObject
+		if _, ok := batches[topic.String()]; !ok {
+			topics = append(topics, topic.String())
+		}
+		batches[topic.String()] = append(batches[topic.String()], pulsarProducerMessage{
+			Key:       obj.Namespace + "/" + obj.Kind + "/" + obj.Name,
+			Payload:   string(payload),
+			EventTime: fmt.Sprint(data.Timestamp.UnixMilli()),
+		})
+	}
+	for _, topic := range topics {
+		if err := s.produce(topic, batches[topic]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *pulsarSink) produce(topic string, messages []pulsarProducerMessage) error {
+	path, err := pulsarTopicPath(topic)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(pulsarProducerMessages{
+		ProducerName: "k8s-event-tailer",
+		KeySchema:    pulsarStringSchema,
+		ValueSchema:  pulsarStringSchema,
+		Messages:     messages,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, s.serviceURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, values := range s.header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp)
+}
+
+// pulsarTopicPath converts a topic name like persistent://tenant/ns/topic
+// into the path of the REST producer endpoint
+func pulsarTopicPath(topic string) (string, error) {
+	domain, name, ok := strings.Cut(topic, "://")
+	if !ok {
+		domain, name = "persistent", topic
+	}
+	if domain != "persistent" && domain != "non-persistent" {
+		return "", fmt.Errorf("invalid pulsar topic %q", topic)
+	}
+	if strings.Count(name, "/") != 2 {
+		return "", fmt.Errorf("invalid pulsar topic %q, expected tenant/namespace/topic", topic)
+	}
+	return "/topics/" + domain + "/" + name, nil
+}
+
+func (s *pulsarSink) Close() error {
+	return nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Policies applied when the delivery queue is full
+const (
+	dropPolicyBlock      = "block"
+	dropPolicyDropOldest = "drop-oldest"
+	dropPolicyDropNewest = "drop-newest"
+)
+
+var dropPolicies = []string{dropPolicyBlock, dropPolicyDropOldest, dropPolicyDropNewest}
+
+// eventRecord is a single event waiting to be delivered
+type eventRecord struct {
+	event   *corev1.Event
+	message string
+	// size is the estimated memory held by the record
+	size int64
+	// trace is the span of the previous pipeline stage, zero if the event
+	// is not traced
+	trace spanContext
+	// diff holds the changes of a repeated event in update diff mode, nil
+	// logs the whole event
+	diff *eventDiff
+	// logs holds the last container log lines of the pod of a matching
+	// event, empty if none were captured
+	logs string
+	// snapshot describes the involved object of a matching event, empty if
+	// none was taken
+	snapshot string
+}
+
+// deliveryQueue decouples the informer callbacks from event delivery. When the
+// queue is full, the configured policy decides whether the informer blocks or
+// which record gets dropped.
+type deliveryQueue struct {
+	items   chan eventRecord
+	policy  string
+	mu      sync.Mutex
+	dropped uint64
+	memory  *memoryAccount
+
+	lengthGauge    prometheus.GaugeFunc
+	droppedCounter prometheus.CounterFunc
+}
+
+func newDeliveryQueue(size int, policy string, memory *memoryAccount) (*deliveryQueue, error) {
+	if size < 1 {
+		return nil, fmt.Errorf("queue size must be positive, got %d", size)
+	}
+	switch policy {
+	case dropPolicyBlock, dropPolicyDropOldest, dropPolicyDropNewest:
+	default:
+		return nil, fmt.Errorf("unknown drop policy %q", policy)
+	}
+	q := &deliveryQueue{
+		items:  make(chan eventRecord, size),
+		policy: policy,
+		memory: memory,
+	}
+
+	q.lengthGauge = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "delivery_queue_length",
+		Help: "Number of events waiting in the delivery queue",
+	}, func() float64 {
+		return float64(len(q.items))
+	})
+
+	q.droppedCounter = promauto.NewCounterFunc(prometheus.CounterOpts{
+		Name:        "delivery_queue_dropped_total",
+		Help:        "Number of events dropped because the delivery queue was full",
+		ConstLabels: prometheus.Labels{"policy": policy},
+	}, func() float64 {
+		return float64(q.Dropped())
+	})
+	return q, nil
+}
+
+// Push adds a record to the queue, applying the drop policy if it is full.
+// Once the memory budget is exceeded, the oldest records are evicted.
+func (q *deliveryQueue) Push(record eventRecord) {
+	record.size = recordSize(record)
+	// account before queueing, the consumer may release the record as
+	// soon as it is on the channel
+	q.memory.Add(record.size)
+	if !q.push(record) {
+		q.memory.Release(record.size)
+		return
+	}
+	q.evictOverBudget()
+}
+
+// push applies the drop policy and reports whether record was queued
+func (q *deliveryQueue) push(record eventRecord) bool {
+	switch q.policy {
+	case dropPolicyDropNewest:
+		select {
+		case q.items <- record:
+			return true
+		default:
+			atomic.AddUint64(&q.dropped, 1)
+			drops.Add(dropCauseQueueFull, "", 1)
+			return false
+		}
+	case dropPolicyDropOldest:
+		// serialize producers so that the slot freed below is not taken by
+		// another producer before this record is queued
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		for {
+			select {
+			case q.items <- record:
+				return true
+			default:
+			}
+			select {
+			case old := <-q.items:
+				q.memory.Release(old.size)
+				atomic.AddUint64(&q.dropped, 1)
+				drops.Add(dropCauseQueueFull, "", 1)
+			default:
+			}
+		}
+	default:
+		q.items <- record
+		return true
+	}
+}
+
+// evictOverBudget drops the oldest records while the memory budget is exceeded
+func (q *deliveryQueue) evictOverBudget() {
+	for q.memory.OverBudget() {
+		select {
+		case old := <-q.items:
+			q.memory.Evicted(old.size)
+		default:
+			return
+		}
+	}
+}
+
+// Dropped returns the number of records dropped so far.
+func (q *deliveryQueue) Dropped() uint64 {
+	return atomic.LoadUint64(&q.dropped)
+}
+
+// Close stops the queue. Records already queued can still be received with
+// NextOrWake, but Push must not be called afterwards.
+func (q *deliveryQueue) Close() {
+	close(q.items)
+}
+
+// NextOrWake returns the oldest record, blocking until there is one or wake
+// is signaled, which sets woken. It returns false once the queue is closed
+// and empty.
+func (q *deliveryQueue) NextOrWake(wake <-chan struct{}) (record eventRecord, ok, woken bool) {
+	select {
+	case record, ok = <-q.items:
+		if ok {
+			q.memory.Release(record.size)
+		}
+		return record, ok, false
+	case <-wake:
+		return eventRecord{}, true, true
+	}
+}
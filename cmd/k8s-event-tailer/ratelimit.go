@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+)
+
+const (
+	// rateLimitedReason is the reason of the summary events reporting
+	// suppressed events
+	rateLimitedReason = "EventsRateLimited"
+	rateLimitSource   = "k8s-event-tailer"
+	// limiterFlushInterval is how often summaries of suppressed events
+	// are emitted
+	limiterFlushInterval = time.Minute
+)
+
+// Limits of the eventLimiter
+const (
+	limitObject    = "object"
+	limitNamespace = "namespace"
+	limitGlobal    = "global"
+)
+
+var rateLimitedCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "events_rate_limited_total",
+	Help: "Number of events suppressed by rate limits, by limit: object, namespace or global",
+}, []string{"limit"})
+
+// rateLimitOptions configures the limits of an eventLimiter, zero rates
+// disable a limit
+type rateLimitOptions struct {
+	objectPerMinute    int
+	namespacePerSecond float64
+	globalPerSecond    float64
+	// burst is the number of events the namespace and global limits let
+	// through at once, zero allows one second's worth
+	burst int
+}
+
+// eventLimiter limits the events per involved object, per namespace and in
+// total with token buckets, so that a single crash looping pod or an event
+// storm can't flood the sinks. Suppressed events are summed up in a summary
+// event per bucket, emitted every limiterFlushInterval.
+type eventLimiter struct {
+	limits []*rateLimit
+}
+
+// rateLimit is a token bucket per key refilled by rate tokens a second
+type rateLimit struct {
+	name  string
+	rate  float64
+	burst float64
+	// key returns the bucket of event
+	key func(event *corev1.Event) string
+	// summarize describes the suppressed events of b
+	summarize func(b *limitBucket) (object corev1.ObjectReference, message string)
+
+	mu      sync.Mutex
+	buckets map[string]*limitBucket
+	counter prometheus.Counter
+}
+
+type limitBucket struct {
+	tokens float64
+	last   time.Time
+	// object is the involved object of the last suppressed event
+	object     corev1.ObjectReference
+	key        string
+	suppressed int32
+	first      time.Time
+	lastDrop   time.Time
+}
+
+func newEventLimiter(opts rateLimitOptions) *eventLimiter {
+	l := &eventLimiter{}
+	if opts.objectPerMinute > 0 {
+		perMinute := opts.objectPerMinute
+		l.limits = append(l.limits, newRateLimit(limitObject, float64(perMinute)/60, float64(perMinute),
+			func(event *corev1.Event) string {
+				ref := event.InvolvedObject
+				return ref.Namespace + "/" + ref.Kind + "/" + ref.Name
+			},
+			func(b *limitBucket) (corev1.ObjectReference, string) {
+				return b.object, fmt.Sprintf("%d events of %s %s were suppressed, the limit is %d events per minute",
+					b.suppressed, b.object.Kind, b.object.Name, perMinute)
+			}))
+	}
+	burst := func(rate float64) float64 {
+		if opts.burst > 0 {
+			return float64(opts.burst)
+		}
+		if rate < 1 {
+			return 1
+		}
+		return rate
+	}
+	if rate := opts.namespacePerSecond; rate > 0 {
+		l.limits = append(l.limits, newRateLimit(limitNamespace, rate, burst(rate),
+			func(event *corev1.Event) string {
+				return event.Namespace
+			},
+			func(b *limitBucket) (corev1.ObjectReference, string) {
+				namespace := corev1.ObjectReference{Kind: "Namespace", APIVersion: "v1", Name: b.key}
+				return namespace, fmt.Sprintf("%d events in namespace %s were suppressed, the limit is %s events per second",
+					b.suppressed, b.key, strconv.FormatFloat(rate, 'f', -1, 64))
+			}))
+	}
+	if rate := opts.globalPerSecond; rate > 0 {
+		l.limits = append(l.limits, newRateLimit(limitGlobal, rate, burst(rate),
+			func(*corev1.Event) string {
+				return ""
+			},
+			func(b *limitBucket) (corev1.ObjectReference, string) {
+				return corev1.ObjectReference{}, fmt.Sprintf("%d events were suppressed, the limit is %s events per second in total",
+					b.suppressed, strconv.FormatFloat(rate, 'f', -1, 64))
+			}))
+	}
+	if len(l.limits) == 0 {
+		return nil
+	}
+	return l
+}
+
+func newRateLimit(name string, rate, burst float64, key func(*corev1.Event) string,
+	summarize func(*limitBucket) (corev1.ObjectReference, string)) *rateLimit {
+	return &rateLimit{
+		name:      name,
+		rate:      rate,
+		burst:     burst,
+		key:       key,
+		summarize: summarize,
+		buckets:   map[string]*limitBucket{},
+		counter:   rateLimitedCounter.WithLabelValues(name),
+	}
+}
+
+// Allow reports whether event is within the limits, and otherwise the name
+// of the exceeded limit. Limits are checked from the narrowest to the widest
+// and tokens are only taken once all of them pass, so an event suppressed by
+// one limit does not take from the others.
+func (l *eventLimiter) Allow(event *corev1.Event, now time.Time) (string, bool) {
+	if l == nil {
+		return "", true
+	}
+	// the buckets are locked in the order of the limits, so concurrent
+	// calls cannot deadlock
+	var buckets [3]*limitBucket
+	for i, limit := range l.limits {
+		limit.mu.Lock()
+		defer limit.mu.Unlock()
+		buckets[i] = limit.refill(event, now)
+	}
+	for i, limit := range l.limits {
+		if buckets[i].tokens < 1 {
+			buckets[i].suppress(event, now)
+			limit.counter.Inc()
+			return limit.name, false
+		}
+	}
+	for i := range l.limits {
+		buckets[i].tokens--
+	}
+	return "", true
+}
+
+// refill returns the bucket of event with the tokens accrued until now, r.mu
+// must be held
+func (r *rateLimit) refill(event *corev1.Event, now time.Time) *limitBucket {
+	key := r.key(event)
+	b, ok := r.buckets[key]
+	if !ok {
+		b = &limitBucket{tokens: r.burst, last: now, key: key}
+		r.buckets[key] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * r.rate
+	if b.tokens > r.burst {
+		b.tokens = r.burst
+	}
+	b.last = now
+	return b
+}
+
+// suppress records event as suppressed by the bucket
+func (b *limitBucket) suppress(event *corev1.Event, now time.Time) {
+	if b.suppressed == 0 {
+		b.first = now
+	}
+	b.object = event.InvolvedObject
+	b.suppressed++
+	b.lastDrop = now
+}
+
+// Flush returns a summary event for every bucket with suppressed events and
+// forgets the buckets which are full again
+func (l *eventLimiter) Flush(now time.Time) []*corev1.Event {
+	if l == nil {
+		return nil
+	}
+	var summaries []*corev1.Event
+	for _, limit := range l.limits {
+		summaries = append(summaries, limit.flush(now)...)
+	}
+	return summaries
+}
+
+func (r *rateLimit) flush(now time.Time) []*corev1.Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var summaries []*corev1.Event
+	for key, b := range r.buckets {
+		if b.suppressed > 0 {
+			summaries = append(summaries, r.summary(b, now))
+			b.suppressed = 0
+			continue
+		}
+		if b.tokens+now.Sub(b.last).Seconds()*r.rate >= r.burst {
+			delete(r.buckets, key)
+		}
+	}
+	return summaries
+}
+
+func (r *rateLimit) summary(b *limitBucket, now time.Time) *corev1.Event {
+	object, message := r.summarize(b)
+	name, namespace := object.Name, object.Namespace
+	if name == "" {
+		name = rateLimitSource
+	}
+	if object.Kind == "Namespace" {
+		namespace = object.Name
+	}
+	return &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              fmt.Sprintf("%s.%x", name, now.UnixNano()),
+			Namespace:         namespace,
+			UID:               uuid.NewUUID(),
+			CreationTimestamp: metav1.NewTime(now),
+		},
+		InvolvedObject:      object,
+		Reason:              rateLimitedReason,
+		Message:             message,
+		Source:              corev1.EventSource{Component: rateLimitSource},
+		FirstTimestamp:      metav1.NewTime(b.first),
+		LastTimestamp:       metav1.NewTime(b.lastDrop),
+		Count:               b.suppressed,
+		Type:                corev1.EventTypeWarning,
+		ReportingController: rateLimitSource,
+	}
+}
+
+// runLimiter queues the summaries of suppressed events until ctx is
+// done, including a last one on shutdown
+func (ew *EventWatcher) runLimiter(ctx context.Context) {
+	if ew.limiter == nil {
+		return
+	}
+	ticker := time.NewTicker(limiterFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			ew.queueSummaries(now)
+		case <-ctx.Done():
+			ew.queueSummaries(time.Now())
+			return
+		}
+	}
+}
+
+func (ew *EventWatcher) queueSummaries(now time.Time) {
+	for _, event := range ew.limiter.Flush(now) {
+		ew.logger.Warn().
+			Str("namespace", event.InvolvedObject.Namespace).
+			Str("kind", event.InvolvedObject.Kind).
+			Str("name", event.InvolvedObject.Name).
+			Int32("suppressed", event.Count).
+			Msg("Events rate limited")
+		ew.queue.Push(eventRecord{event: event, message: eventAddedMessage})
+		ew.namespaceStats.record(event, eventAddedMessage)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const redactPatternCustom = "custom"
+
+var redactionsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "redactions_total",
+	Help: "Number of secrets masked in event messages by pattern",
+}, []string{"pattern"})
+
+type redactPattern struct {
+	name     string
+	re       *regexp.Regexp
+	redacted prometheus.Counter
+}
+
+// redactor masks secrets in event messages before they are delivered
+type redactor struct {
+	patterns []redactPattern
+}
+
+// newRedactor returns a redactor using the built-in patterns plus the custom
+// ones. Base64 blobs are masked from base64MinLength characters on, zero
+// disables that pattern.
+func newRedactor(custom []string, base64MinLength int) (*redactor, error) {
+	r := &redactor{}
+	r.add("jwt", regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`))
+	r.add("aws-access-key", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`))
+	r.add("aws-secret-key", regexp.MustCompile(`(?i)aws_secret_access_key["']?\s*[:=]\s*["']?[A-Za-z0-9/+=]{40}`))
+	r.add("private-key", regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?(?:-----END [A-Z ]*PRIVATE KEY-----|$)`))
+	r.add("bearer-token", regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/-]{20,}=*`))
+	if base64MinLength > 0 {
+		r.add("base64", regexp.MustCompile(fmt.Sprintf(`[A-Za-z0-9+/]{%d,}={0,2}`, base64MinLength)))
+	}
+	for _, pattern := range custom {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %w", pattern, err)
+		}
+		r.add(redactPatternCustom, re)
+	}
+	return r, nil
+}
+
+func (r *redactor) add(name string, re *regexp.Regexp) {
+	r.patterns = append(r.patterns, redactPattern{
+		name:     name,
+		re:       re,
+		redacted: redactionsCounter.WithLabelValues(name),
+	})
+}
+
+// Redact returns message with all matches masked, and whether anything was
+// masked.
+func (r *redactor) Redact(message string) (string, bool) {
+	changed := false
+	for _, pattern := range r.patterns {
+		matches := 0
+		message = pattern.re.ReplaceAllStringFunc(message, func(string) string {
+			matches++
+			return "[REDACTED:" + pattern.name + "]"
+		})
+		if matches > 0 {
+			pattern.redacted.Add(float64(matches))
+			changed = true
+		}
+	}
+	return message, changed
+}
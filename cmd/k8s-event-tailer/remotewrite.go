@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	remoteWriteSamplesCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "remote_write_samples_total",
+		Help: "Number of samples sent with remote write",
+	})
+
+	remoteWriteErrorsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "remote_write_errors_total",
+		Help: "Number of failed remote write requests by error class",
+	}, []string{"class"})
+)
+
+// remoteWriteOptions configures pushing event-derived series
+type remoteWriteOptions struct {
+	url      string
+	interval time.Duration
+	headers  map[string]string
+	// labels are added to every series, e.g. cluster=prod
+	labels map[string]string
+	tls    *tlsPolicy
+}
+
+// remoteWriter periodically pushes the event-derived series with the
+// Prometheus remote write protocol (1.0) to receivers such as
+// VictoriaMetrics, Mimir or Thanos.
+type remoteWriter struct {
+	opts     remoteWriteOptions
+	gatherer prometheus.Gatherer
+	client   *http.Client
+	logger   zerolog.Logger
+}
+
+func newRemoteWriter(opts remoteWriteOptions, gatherer prometheus.Gatherer) (*remoteWriter, error) {
+	if opts.interval <= 0 {
+		return nil, fmt.Errorf("remote write interval must be positive")
+	}
+	return &remoteWriter{
+		opts:     opts,
+		gatherer: gatherer,
+		client:   opts.tls.HTTPClient(30 * time.Second),
+		logger:   log.With().Str("component", "remote-write").Logger(),
+	}, nil
+}
+
+// Run pushes the series every interval until ctx is done, and a last time
+// afterwards.
+func (w *remoteWriter) Run(ctx context.Context) {
+	w.logger.Info().Str("url", w.opts.url).Msg("Pushing event metrics with remote write")
+	ticker := time.NewTicker(w.opts.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.push(ctx)
+		case <-ctx.Done():
+			pushCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			w.push(pushCtx)
+			cancel()
+			return
+		}
+	}
+}
+
+func (w *remoteWriter) push(ctx context.Context) {
+	families, err := w.gatherer.Gather()
+	if err != nil {
+		w.logger.Error().Err(err).Msg("Could not gather metrics")
+		return
+	}
+	series := w.timeSeries(families, time.Now())
+	if len(series) == 0 {
+		return
+	}
+	if err := w.send(ctx, encodeWriteRequest(series)); err != nil {
+		class := classifyError(err)
+		remoteWriteErrorsCounter.WithLabelValues(class).Inc()
+		w.logger.Error().Err(err).Str("class", class).Int("series", len(series)).Msg("Could not push metrics")
+		return
+	}
+	remoteWriteSamplesCounter.Add(float64(len(series)))
+}
+
+// remoteSeries is a single sample with its sorted labels
+type remoteSeries struct {
+	labels    []*dto.LabelPair
+	value     float64
+	timestamp int64
+}
+
+// timeSeries converts counters, gauges and untyped metrics into samples.
+// Histograms and summaries are not derived from events and are skipped.
+func (w *remoteWriter) timeSeries(families []*dto.MetricFamily, now time.Time) []remoteSeries {
+	var series []remoteSeries
+	for _, family := range families {
+		for _, metric := range family.Metric {
+			var value float64
+			switch {
+			case metric.Counter != nil:
+				value = metric.Counter.GetValue()
+			case metric.Gauge != nil:
+				value = metric.Gauge.GetValue()
+			case metric.Untyped != nil:
+				value = metric.Untyped.GetValue()
+			default:
+				continue
+			}
+			labels := []*dto.LabelPair{{Name: strPtr("__name__"), Value: family.Name}}
+			labels = append(labels, metric.Label...)
+			for name, value := range w.opts.labels {
+				labels = append(labels, &dto.LabelPair{Name: strPtr(name), Value: strPtr(value)})
+			}
+			sort.Slice(labels, func(i, j int) bool {
+				return labels[i].GetName() < labels[j].GetName()
+			})
+			series = append(series, remoteSeries{
+				labels:    labels,
+				value:     value,
+				timestamp: now.UnixMilli(),
+			})
+		}
+	}
+	return series
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func (w *remoteWriter) send(ctx context.Context, request []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.opts.url, bytes.NewReader(snappyEncode(request)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	for key, value := range w.opts.headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp)
+}
+
+// encodeWriteRequest encodes the prometheus.WriteRequest protobuf message:
+//
+//	WriteRequest { repeated TimeSeries timeseries = 1; }
+//	TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	Label { string name = 1; string value = 2; }
+//	Sample { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(series []remoteSeries) []byte {
+	var request, ts, msg []byte
+	for _, s := range series {
+		ts = ts[:0]
+		for _, label := range s.labels {
+			msg = msg[:0]
+			msg = protoString(msg, 1, label.GetName())
+			msg = protoString(msg, 2, label.GetValue())
+			ts = protoBytes(ts, 1, msg)
+		}
+		msg = msg[:0]
+		msg = protoTag(msg, 1, 1)
+		msg = appendFixed64(msg, math.Float64bits(s.value))
+		msg = protoTag(msg, 2, 0)
+		msg = appendUvarint(msg, uint64(s.timestamp))
+		ts = protoBytes(ts, 2, msg)
+		request = protoBytes(request, 1, ts)
+	}
+	return request
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func appendFixed64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func protoTag(b []byte, field, wireType int) []byte {
+	return appendUvarint(b, uint64(field<<3|wireType))
+}
+
+func protoBytes(b []byte, field int, value []byte) []byte {
+	b = protoTag(b, field, 2)
+	b = appendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+func protoString(b []byte, field int, value string) []byte {
+	b = protoTag(b, field, 2)
+	b = appendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+// snappyEncode frames data as a snappy block made of literals only. This is
+// valid snappy without compressing, which is fine for the small requests
+// sent here and avoids another dependency.
+func snappyEncode(data []byte) []byte {
+	const maxLiteral = 1 << 16
+	out := appendUvarint(make([]byte, 0, len(data)+len(data)/maxLiteral*3+16), uint64(len(data)))
+	for len(data) > 0 {
+		n := len(data)
+		if n > maxLiteral {
+			n = maxLiteral
+		}
+		switch {
+		case n <= 60:
+			out = append(out, byte(n-1)<<2)
+		case n <= 1<<8:
+			out = append(out, 60<<2, byte(n-1))
+		default:
+			out = append(out, 61<<2, byte(n-1), byte((n-1)>>8))
+		}
+		out = append(out, data[:n]...)
+		data = data[n:]
+	}
+	return out
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// replicaSharding splits namespaces deterministically among replicas. Each
+// namespace is watched by exactly one replica, picked by hashing its name.
+type replicaSharding struct {
+	replicas int
+	ordinal  int
+}
+
+// newReplicaSharding validates the configuration. A negative ordinal is taken
+// from the hostname, which ends in the ordinal for StatefulSet pods.
+func newReplicaSharding(replicas, ordinal int) (replicaSharding, error) {
+	if replicas < 1 {
+		return replicaSharding{}, fmt.Errorf("number of replicas must be positive, got %d", replicas)
+	}
+	if ordinal < 0 {
+		if replicas == 1 {
+			ordinal = 0
+		} else {
+			var err error
+			if ordinal, err = hostnameOrdinal(); err != nil {
+				return replicaSharding{}, err
+			}
+		}
+	}
+	if ordinal >= replicas {
+		return replicaSharding{}, fmt.Errorf("replica ordinal %d out of range for %d replicas", ordinal, replicas)
+	}
+	return replicaSharding{replicas: replicas, ordinal: ordinal}, nil
+}
+
+// Enabled reports whether namespaces are split among several replicas
+func (rs replicaSharding) Enabled() bool {
+	return rs.replicas > 1
+}
+
+// Owns reports whether this replica watches namespace
+func (rs replicaSharding) Owns(namespace string) bool {
+	if !rs.Enabled() {
+		return true
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(namespace))
+	return int(h.Sum32()%uint32(rs.replicas)) == rs.ordinal
+}
+
+func hostnameOrdinal() (int, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return 0, err
+	}
+	i := strings.LastIndex(hostname, "-")
+	ordinal, err := strconv.Atoi(hostname[i+1:])
+	if i < 0 || err != nil || ordinal < 0 {
+		return 0, fmt.Errorf("could not derive replica ordinal from hostname %q", hostname)
+	}
+	return ordinal, nil
+}
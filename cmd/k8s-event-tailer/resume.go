@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+)
+
+// resumeSaveInterval is how often the resourceVersions are saved
+const resumeSaveInterval = 10 * time.Second
+
+var resumeRelistsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "informer_resume_relists_total",
+	Help: "Number of informers which listed all events because the resourceVersion to resume from was too old",
+}, []string{"shard"})
+
+// resumeStore persists the resourceVersion each shard has seen events up to.
+// Save merges versions into the saved ones, so that replicas watching other
+// namespaces can share a store.
+type resumeStore interface {
+	Load(ctx context.Context) (map[string]string, error)
+	Save(ctx context.Context, versions map[string]string) error
+	String() string
+}
+
+// watchResume lets informers watch from the resourceVersion the previous
+// run of the tailer stopped at, instead of listing all events again. If the
+// API server does not have that version anymore, the informer falls back to
+// a list.
+type watchResume struct {
+	store  resumeStore
+	logger zerolog.Logger
+
+	mu sync.Mutex
+	// versions are the loaded versions to resume from by shard
+	versions map[string]string
+	// saved are the last saved versions by shard
+	saved map[string]string
+}
+
+func newWatchResume(store resumeStore) *watchResume {
+	return &watchResume{
+		store:    store,
+		logger:   log.With().Str("component", "resume").Str("store", store.String()).Logger(),
+		versions: map[string]string{},
+		saved:    map[string]string{},
+	}
+}
+
+// Load reads the saved versions. Without them, the informers list all
+// events like on a first start.
+func (r *watchResume) Load(ctx context.Context) {
+	if r == nil {
+		return
+	}
+	versions, err := r.store.Load(ctx)
+	if err != nil {
+		r.logger.Error().Err(err).Msg("Could not load the resourceVersions to resume from, listing all events")
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for shard, version := range versions {
+		r.versions[shard] = version
+		r.saved[shard] = version
+	}
+	r.logger.Info().Int("shards", len(versions)).Msg("Loaded the resourceVersions to resume from")
+}
+
+// Version returns the resourceVersion shard resumes from, empty to list
+func (r *watchResume) Version(shard string) string {
+	if r == nil {
+		return ""
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.versions[shard]
+}
+
+// Save stores the versions the shards have seen, if they changed. Restarted
+// shards resume from them as well.
+func (r *watchResume) Save(ctx context.Context, shards map[string]string) {
+	changed := map[string]string{}
+	r.mu.Lock()
+	for shard, version := range shards {
+		if version != "" && version != r.saved[shard] {
+			changed[shard] = version
+		}
+	}
+	r.mu.Unlock()
+	if len(changed) == 0 {
+		return
+	}
+	if err := r.store.Save(ctx, changed); err != nil {
+		r.logger.Error().Err(err).Msg("Could not save the resourceVersions")
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for shard, version := range changed {
+		r.versions[shard] = version
+		r.saved[shard] = version
+	}
+}
+
+// runResume saves the resourceVersions of the shards every
+// resumeSaveInterval until ctx is done. The watcher saves them a last time
+// once the informers have stopped.
+func (ew *EventWatcher) runResume(ctx context.Context) {
+	if ew.resume == nil {
+		return
+	}
+	ticker := time.NewTicker(resumeSaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ew.saveResumeVersions()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (ew *EventWatcher) saveResumeVersions() {
+	if ew.resume == nil {
+		return
+	}
+	versions := map[string]string{}
+	ew.shardsMu.Lock()
+	for name, shard := range ew.shards {
+		versions[name] = shard.controller.LastSyncResourceVersion()
+	}
+	ew.shardsMu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), resumeSaveInterval)
+	defer cancel()
+	ew.resume.Save(ctx, versions)
+}
+
+// resumingListerWatcher answers the first list with no events at version,
+// so that the reflector watches from there on. Further lists, e.g. after the
+// watch failed because version is too old, go to the API server.
+type resumingListerWatcher struct {
+	cache.ListerWatcher
+	version string
+	// lists counts the list calls of the reflector
+	lists int32
+}
+
+func (lw *resumingListerWatcher) List(options metav1.ListOptions) (runtime.Object, error) {
+	if atomic.AddInt32(&lw.lists, 1) == 1 {
+		return &corev1.EventList{ListMeta: metav1.ListMeta{ResourceVersion: lw.version}}, nil
+	}
+	return lw.ListerWatcher.List(options)
+}
+
+// Resuming reports whether the events come from the resumed watch, which
+// means they happened after the saved version and are not replayed.
+func (lw *resumingListerWatcher) Resuming() bool {
+	return lw != nil && atomic.LoadInt32(&lw.lists) <= 1
+}
+
+// fileResumeStore keeps the versions in a JSON file
+type fileResumeStore struct {
+	path string
+}
+
+func (s *fileResumeStore) String() string {
+	return s.path
+}
+
+func (s *fileResumeStore) Load(context.Context) (map[string]string, error) {
+	versions := map[string]string{}
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return versions, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &versions); err != nil {
+		return nil, fmt.Errorf("%s: %w", s.path, err)
+	}
+	return versions, nil
+}
+
+// Save replaces the file atomically, a crash must not leave a truncated one
+func (s *fileResumeStore) Save(ctx context.Context, versions map[string]string) error {
+	merged, err := s.Load(ctx)
+	if err != nil {
+		merged = map[string]string{}
+	}
+	for shard, version := range versions {
+		merged[shard] = version
+	}
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".resume-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// configMapResumeStore keeps the versions in a ConfigMap by shard, for
+// pods without a persistent volume
+type configMapResumeStore struct {
+	// client returns the current client, which changes when credentials are
+	// reloaded
+	client    func() kubernetes.Interface
+	namespace string
+	name      string
+}
+
+func (s *configMapResumeStore) String() string {
+	return "configmap/" + s.namespace + "/" + s.name
+}
+
+func (s *configMapResumeStore) Load(ctx context.Context) (map[string]string, error) {
+	cm, err := s.client().CoreV1().ConfigMaps(s.namespace).Get(ctx, s.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return map[string]string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	versions := map[string]string{}
+	for shard, version := range cm.Data {
+		versions[shard] = version
+	}
+	return versions, nil
+}
+
+func (s *configMapResumeStore) Save(ctx context.Context, versions map[string]string) error {
+	configMaps := s.client().CoreV1().ConfigMaps(s.namespace)
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := configMaps.Get(ctx, s.name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			_, err = configMaps.Create(ctx, &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Namespace: s.namespace, Name: s.name},
+				Data:       versions,
+			}, metav1.CreateOptions{})
+			return err
+		} else if err != nil {
+			return err
+		}
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		for shard, version := range versions {
+			cm.Data[shard] = version
+		}
+		_, err = configMaps.Update(ctx, cm, metav1.UpdateOptions{})
+		return err
+	})
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	sinkRetryInitialBackoff = 100 * time.Millisecond
+	sinkRetryMaxBackoff     = 5 * time.Second
+	retryBudgetRefillPeriod = time.Minute
+)
+
+// retryBudget limits the number of retries spent per minute, so that a broken
+// sink cannot stall delivery by retrying every single event. It is a token
+// bucket holding at most one minute worth of retries.
+type retryBudget struct {
+	mu     sync.Mutex
+	tokens float64
+	max    float64
+	last   time.Time
+}
+
+func newRetryBudget(perMinute int) *retryBudget {
+	return &retryBudget{
+		tokens: float64(perMinute),
+		max:    float64(perMinute),
+		last:   time.Now(),
+	}
+}
+
+// Take consumes one retry from the budget, reporting false if it is exhausted.
+func (b *retryBudget) Take() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.max / retryBudgetRefillPeriod.Seconds()
+	if b.tokens > b.max {
+		b.tokens = b.max
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// retryBackoff returns the delay before the given retry, starting at 1.
+func retryBackoff(retry int) time.Duration {
+	backoff := sinkRetryInitialBackoff
+	for i := 1; i < retry && backoff < sinkRetryMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > sinkRetryMaxBackoff {
+		backoff = sinkRetryMaxBackoff
+	}
+	return backoff
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// rocketChatOptions configures the Rocket.Chat sink
+type rocketChatOptions struct {
+	webhookURL string
+	// routes send matching events to the channel named by the route
+	// instead of the webhook's default channel
+	routes []*eventRule
+	tls    *tlsPolicy
+}
+
+// rocketChatSink posts events as attachments to a Rocket.Chat incoming
+// webhook.
+type rocketChatSink struct {
+	opts   rocketChatOptions
+	client *http.Client
+}
+
+type rocketChatMessage struct {
+	Text        string           `json:"text"`
+	Channel     string           `json:"channel,omitempty"`
+	Attachments []chatAttachment `json:"attachments"`
+}
+
+func newRocketChatSink(opts rocketChatOptions) (*rocketChatSink, error) {
+	if opts.webhookURL == "" {
+		return nil, fmt.Errorf("rocket.chat webhook URL missing")
+	}
+	return &rocketChatSink{
+		opts:   opts,
+		client: opts.tls.HTTPClient(30 * time.Second),
+	}, nil
+}
+
+func (s *rocketChatSink) Name() string {
+	return "rocketchat"
+}
+
+func (s *rocketChatSink) Write(event *corev1.Event) error {
+	return doJSON(context.Background(), s.client, http.MethodPost, s.opts.webhookURL, nil, rocketChatMessage{
+		Text:        eventTitle(event),
+		Channel:     routeChannel(s.opts.routes, event),
+		Attachments: []chatAttachment{eventAttachment(event)},
+	}, nil)
+}
+
+func (s *rocketChatSink) Close() error {
+	return nil
+}
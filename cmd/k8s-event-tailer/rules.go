@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// ruleKeys are the event fields a rule can match on
+var ruleKeys = []string{"type", "reason", "kind", "namespace", "name"}
+
+// eventRule matches events by exact field values. Every field given must
+// match, a field may list alternatives separated by |, e.g.
+// "type=Warning,reason=BackOff|Failed,kind=Pod".
+type eventRule struct {
+	name   string
+	fields map[string][]string
+}
+
+// parseEventRule parses a rule of the form NAME:KEY=VALUE,...
+func parseEventRule(spec string) (*eventRule, error) {
+	name, selector, ok := strings.Cut(spec, ":")
+	if !ok || name == "" {
+		return nil, fmt.Errorf("invalid rule %q, expected NAME:KEY=VALUE,...", spec)
+	}
+	rule, err := parseEventSelector(selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rule %s: %w", name, err)
+	}
+	rule.name = name
+	return rule, nil
+}
+
+// parseEventRules parses rules of the form NAME:KEY=VALUE,... keeping their
+// order
+func parseEventRules(specs []string) ([]*eventRule, error) {
+	var rules []*eventRule
+	for _, spec := range specs {
+		rule, err := parseEventRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// parseEventSelector parses KEY=VALUE,... into an unnamed rule
+func parseEventSelector(selector string) (*eventRule, error) {
+	rule := &eventRule{fields: map[string][]string{}}
+	for _, term := range strings.Split(selector, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(term), "=")
+		if !ok || value == "" {
+			return nil, fmt.Errorf("invalid term %q, expected KEY=VALUE", term)
+		}
+		if !isRuleKey(key) {
+			return nil, fmt.Errorf("unknown key %q, expected one of %s", key, strings.Join(ruleKeys, ", "))
+		}
+		rule.fields[key] = strings.Split(value, "|")
+	}
+	return rule, nil
+}
+
+func isRuleKey(key string) bool {
+	for _, k := range ruleKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches reports whether event matches all fields of the rule
+func (r *eventRule) Matches(event *corev1.Event) bool {
+	for key, values := range r.fields {
+		if !matchesAny(ruleField(event, key), values) {
+			return false
+		}
+	}
+	return true
+}
+
+func ruleField(event *corev1.Event, key string) string {
+	switch key {
+	case "type":
+		return event.Type
+	case "reason":
+		return event.Reason
+	case "kind":
+		return event.InvolvedObject.Kind
+	case "namespace":
+		return event.Namespace
+	case "name":
+		return event.InvolvedObject.Name
+	}
+	return ""
+}
+
+func matchesAny(value string, alternatives []string) bool {
+	for _, alternative := range alternatives {
+		if value == alternative {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	defaultS3Prefix        = `events/date={{.Timestamp.UTC.Format "2006-01-02"}}/hour={{.Timestamp.UTC.Format "15"}}/`
+	defaultS3FlushInterval = "5m"
+	s3ObjectTimeFormat     = "20060102T150405.000000000Z"
+	// s3EncryptedSuffix is appended to the names of encrypted objects
+	s3EncryptedSuffix = ".enc"
+)
+
+// s3Options configures the S3 archive sink
+type s3Options struct {
+	bucket string
+	// endpoint of S3-compatible storage like MinIO, AWS S3 of region if empty
+	endpoint string
+	region   string
+	// prefix is a template executed with the JSON event, events with the
+	// same prefix are uploaded together
+	prefix        string
+	flushInterval time.Duration
+	// cipher encrypts the objects if set
+	cipher *archiveCipher
+	tls    *tlsPolicy
+}
+
+// s3Sink archives events to S3 or S3-compatible object storage as gzipped
+// JSON lines. Events are collected by the prefix of their object and
+// uploaded every flush interval, one object per prefix named after the
+// upload time and the host, so that replicas do not overwrite each other.
+// Failed uploads are retried with the next flush. With a cipher, the gzipped
+// objects are encrypted and their names end with s3EncryptedSuffix.
+type s3Sink struct {
+	url      *url.URL
+	prefix   *template.Template
+	interval time.Duration
+	host     string
+	cipher   *archiveCipher
+	signer   *awsSigner
+	client   *http.Client
+
+	mu sync.Mutex
+	// pending holds the JSON lines of the events not uploaded yet by prefix
+	pending    map[string]*bytes.Buffer
+	lastUpload time.Time
+}
+
+func newS3Sink(opts s3Options) (*s3Sink, error) {
+	if opts.flushInterval <= 0 {
+		return nil, fmt.Errorf("s3: flush interval must be positive")
+	}
+	tmpl, err := template.New("prefix").Option("missingkey=error").Parse(opts.prefix)
+	if err != nil {
+		return nil, fmt.Errorf("invalid s3 prefix template: %w", err)
+	}
+	endpoint := opts.endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", opts.region)
+	}
+	// path-style URLs work with AWS and S3-compatible storage alike
+	u, err := url.Parse(strings.TrimSuffix(endpoint, "/") + "/" + opts.bucket + "/")
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", endpoint)
+	}
+	signer, err := newAWSSigner(opts.region, "s3", opts.tls)
+	if err != nil {
+		return nil, fmt.Errorf("s3: %w", err)
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "k8s-event-tailer"
+	}
+	return &s3Sink{
+		url:        u,
+		prefix:     tmpl,
+		interval:   opts.flushInterval,
+		host:       host,
+		cipher:     opts.cipher,
+		signer:     signer,
+		client:     opts.tls.HTTPClient(time.Minute),
+		pending:    map[string]*bytes.Buffer{},
+		lastUpload: time.Now(),
+	}, nil
+}
+
+func (s *s3Sink) Name() string {
+	return "s3"
+}
+
+func (s *s3Sink) Write(event *corev1.Event) error {
+	e := newJSONEvent(event)
+	var prefix strings.Builder
+	if err := s.prefix.Execute(&prefix, e); err != nil {
+		return err
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	buf := s.pending[prefix.String()]
+	if buf == nil {
+		buf = &bytes.Buffer{}
+		s.pending[prefix.String()] = buf
+	}
+	buf.Write(append(data, '\n'))
+	return nil
+}
+
+// Flush uploads the collected events once the flush interval has passed
+func (s *s3Sink) Flush() error {
+	s.mu.Lock()
+	due := time.Since(s.lastUpload) >= s.interval
+	s.mu.Unlock()
+	if !due {
+		return nil
+	}
+	return s.upload()
+}
+
+func (s *s3Sink) Close() error {
+	return s.upload()
+}
+
+// upload puts one object per prefix. Events of failed uploads are kept for
+// the next one.
+func (s *s3Sink) upload() error {
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = map[string]*bytes.Buffer{}
+	s.lastUpload = time.Now()
+	s.mu.Unlock()
+
+	var firstErr error
+	for prefix, lines := range pending {
+		if err := s.put(prefix, lines.Bytes()); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			s.mu.Lock()
+			if newer := s.pending[prefix]; newer != nil {
+				lines.Write(newer.Bytes())
+			}
+			s.pending[prefix] = lines
+			s.mu.Unlock()
+		}
+	}
+	return firstErr
+}
+
+func (s *s3Sink) put(prefix string, lines []byte) error {
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	if _, err := gz.Write(lines); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	key := prefix + time.Now().UTC().Format(s3ObjectTimeFormat) + "-" + s.host + ".ndjson.gz"
+	data, contentType := body.Bytes(), "application/gzip"
+	if s.cipher != nil {
+		var err error
+		if data, err = s.cipher.Seal(data); err != nil {
+			return err
+		}
+		key += s3EncryptedSuffix
+		contentType = "application/octet-stream"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	u := *s.url
+	u.Path += key
+	// SigV4 signs the path with every segment escaped like a query value
+	segments := strings.Split(u.Path, "/")
+	for i, segment := range segments {
+		segments[i] = awsEscape(segment)
+	}
+	u.RawPath = strings.Join(segments, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if s.cipher != nil {
+		// signed like all headers set before signing
+		req.Header.Set("X-Amz-Meta-Encryption", "aes-256-gcm")
+	}
+	if err := s.signer.Sign(ctx, req, data); err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp)
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const sentryQueueSize = 100
+
+// errorReports sends panics and repeated errors to Sentry, nil if disabled
+var errorReports *errorReporter
+
+// sentryOptions configures the error reporting to Sentry or GlitchTip
+type sentryOptions struct {
+	dsn         string
+	environment string
+	// minOccurrences is how often an error has to occur before it is
+	// reported, so that single transient errors are not reported
+	minOccurrences int
+	// interval is the shortest time between two reports of the same error
+	interval time.Duration
+	tls      *tlsPolicy
+}
+
+// sentryEvent is the subset of the Sentry event payload the tailer sends
+type sentryEvent struct {
+	EventID     string                 `json:"event_id"`
+	Timestamp   string                 `json:"timestamp"`
+	Level       string                 `json:"level"`
+	Platform    string                 `json:"platform"`
+	Logger      string                 `json:"logger"`
+	ServerName  string                 `json:"server_name,omitempty"`
+	Release     string                 `json:"release,omitempty"`
+	Environment string                 `json:"environment,omitempty"`
+	Message     string                 `json:"message"`
+	Fingerprint []string               `json:"fingerprint,omitempty"`
+	Tags        map[string]string      `json:"tags,omitempty"`
+	Extra       map[string]interface{} `json:"extra,omitempty"`
+}
+
+// errorOccurrences tracks a single error key between reports
+type errorOccurrences struct {
+	count    int
+	reported time.Time
+}
+
+// errorReporter reports errors to the envelope endpoint of a Sentry
+// compatible server. Errors are grouped by key, e.g. sink and error class,
+// and each group is reported at most once per interval with the number of
+// occurrences since the last report.
+type errorReporter struct {
+	opts       sentryOptions
+	endpoint   string
+	auth       string
+	release    string
+	serverName string
+	client     *http.Client
+	logger     zerolog.Logger
+	events     chan sentryEvent
+
+	mu     sync.Mutex
+	errors map[string]*errorOccurrences
+}
+
+// newErrorReporter parses a DSN of the form https://<key>@<host>/<project>
+func newErrorReporter(opts sentryOptions) (*errorReporter, error) {
+	dsn, err := url.Parse(opts.dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Sentry DSN: %w", err)
+	}
+	project := strings.TrimPrefix(dsn.Path, "/")
+	if dsn.User == nil || dsn.User.Username() == "" || project == "" {
+		return nil, fmt.Errorf("invalid Sentry DSN, expected https://<key>@<host>/<project>")
+	}
+	if opts.minOccurrences < 1 {
+		opts.minOccurrences = 1
+	}
+	r := &errorReporter{
+		opts:     opts,
+		endpoint: fmt.Sprintf("%s://%s/api/%s/envelope/", dsn.Scheme, dsn.Host, project),
+		auth:     fmt.Sprintf("Sentry sentry_version=7, sentry_client=k8s-event-tailer, sentry_key=%s", dsn.User.Username()),
+		client:   opts.tls.HTTPClient(10 * time.Second),
+		logger:   log.With().Str("component", "sentry").Logger(),
+		events:   make(chan sentryEvent, sentryQueueSize),
+		errors:   map[string]*errorOccurrences{},
+	}
+	r.serverName, _ = os.Hostname()
+	if info, ok := debug.ReadBuildInfo(); ok {
+		r.release = info.Main.Version
+	}
+	return r, nil
+}
+
+// CaptureError counts an occurrence of err under key and reports it once it
+// occurred often enough and was not reported within the interval.
+func (r *errorReporter) CaptureError(key string, err error, tags map[string]string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	occ, ok := r.errors[key]
+	if !ok {
+		occ = &errorOccurrences{}
+		r.errors[key] = occ
+	}
+	occ.count++
+	if occ.count < r.opts.minOccurrences || time.Since(occ.reported) < r.opts.interval {
+		r.mu.Unlock()
+		return
+	}
+	count := occ.count
+	occ.count = 0
+	occ.reported = time.Now()
+	r.mu.Unlock()
+
+	event := r.newEvent("error", err.Error(), tags)
+	event.Fingerprint = []string{key}
+	event.Extra["occurrences"] = count
+	select {
+	case r.events <- event:
+	default:
+		r.logger.Warn().Str("key", key).Msg("Report queue full, dropping error report")
+	}
+}
+
+// Recover reports a panic of the calling goroutine and panics again. It
+// must be deferred directly.
+func (r *errorReporter) Recover() {
+	if r == nil {
+		return
+	}
+	if value := recover(); value != nil {
+		event := r.newEvent("fatal", fmt.Sprintf("panic: %v", value), nil)
+		event.Extra["stack"] = string(debug.Stack())
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := r.send(ctx, event); err != nil {
+			r.logger.Error().Err(err).Msg("Could not report panic")
+		}
+		cancel()
+		panic(value)
+	}
+}
+
+func (r *errorReporter) newEvent(level, message string, tags map[string]string) sentryEvent {
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return sentryEvent{
+		EventID:     hex.EncodeToString(id),
+		Timestamp:   time.Now().UTC().Format(time.RFC3339Nano),
+		Level:       level,
+		Platform:    "go",
+		Logger:      "k8s-event-tailer",
+		ServerName:  r.serverName,
+		Release:     r.release,
+		Environment: r.opts.environment,
+		Message:     message,
+		Tags:        tags,
+		Extra:       map[string]interface{}{},
+	}
+}
+
+// Run sends the queued reports until ctx is done
+func (r *errorReporter) Run(ctx context.Context) {
+	r.logger.Info().Str("endpoint", r.endpoint).Msg("Reporting errors to Sentry")
+	for {
+		select {
+		case event := <-r.events:
+			if err := r.send(ctx, event); err != nil {
+				r.logger.Error().Err(err).Msg("Could not send error report")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// send posts event as an envelope with a single event item
+func (r *errorReporter) send(ctx context.Context, event sentryEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	fmt.Fprintf(&body, `{"event_id":%q,"sent_at":%q}`+"\n", event.EventID, time.Now().UTC().Format(time.RFC3339))
+	fmt.Fprintf(&body, `{"type":"event","length":%d}`+"\n", len(payload))
+	body.Write(payload)
+	body.WriteByte('\n')
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", r.auth)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("sentry returned %s", resp.Status)
+	}
+	return nil
+}
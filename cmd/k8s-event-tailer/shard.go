@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/tools/cache"
+)
+
+// shardAll is the shard name used for the informer watching all namespaces
+const shardAll = "all"
+
+var watchReconnectsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "informer_watch_reconnects_total",
+	Help: "Number of lists and watches retried with backoff after failures by informer shard",
+}, []string{"shard"})
+
+// informerShard is a single event informer with its own store, which is nil
+// in watch-only mode. Shards fail, relist and get stopped independently of
+// each other.
+type informerShard struct {
+	ew         *EventWatcher
+	name       string
+	namespace  string
+	store      cache.Store
+	controller cache.Controller
+	cancel     context.CancelFunc
+	logger     zerolog.Logger
+	events     prometheus.Counter
+	started    time.Time
+	// lastActivity is the time of the last list or watch event in unix
+	// nanoseconds, including bookmarks
+	lastActivity int64
+	// failures counts the list/watch failures since the last activity,
+	// brokenSince is the time of the first one in unix nanoseconds
+	failures    int32
+	brokenSince int64
+	// resume watches from the version saved by the previous run, nil if
+	// the shard started with a list
+	resume *resumingListerWatcher
+}
+
+// shardName returns the name of the shard watching namespace
+func shardName(namespace string) string {
+	if namespace == corev1.NamespaceAll {
+		return shardAll
+	}
+	return namespace
+}
+
+// startShard starts an informer for the events in namespace, unless one is
+// running already. It resumes from the version saved by the previous run.
+func (ew *EventWatcher) startShard(ctx context.Context, namespace string) {
+	ew.startShardAt(ctx, namespace, ew.resume.Version(shardName(namespace)))
+}
+
+// startShardAt starts an informer which watches the events in namespace
+// from version, or lists all events if version is empty.
+func (ew *EventWatcher) startShardAt(ctx context.Context, namespace, version string) {
+	name := shardName(namespace)
+
+	ew.shardsMu.Lock()
+	defer ew.shardsMu.Unlock()
+	if _, ok := ew.shards[name]; ok {
+		return
+	}
+
+	shardCtx, cancel := context.WithCancel(ctx)
+	shard := &informerShard{
+		ew:        ew,
+		name:      name,
+		namespace: namespace,
+		cancel:    cancel,
+		logger:    ew.logger.With().Str("shard", name).Logger(),
+		events:    ew.shardEvents.WithLabelValues(name),
+		started:   time.Now(),
+	}
+	shard.touch()
+	var watchlist cache.ListerWatcher = &backoffListerWatcher{
+		ListerWatcher: &observingListerWatcher{
+			ListerWatcher: ew.eventsListerWatcher(namespace),
+			onActivity:    shard.touch,
+		},
+		failures: shard.consecutiveFailures,
+		onRetry:  shard.onRetry,
+		stop:     shardCtx.Done(),
+	}
+	if version != "" {
+		shard.logger.Info().Str("resource_version", version).Msg("Resuming watch")
+		shard.resume = &resumingListerWatcher{ListerWatcher: watchlist, version: version}
+		watchlist = shard.resume
+	}
+	opts := informerOptions{
+		watchErrorHandler: shard.onWatchError,
+		pageSize:          ew.listPageSize,
+	}
+	if ew.watchOnly {
+		shard.controller = newWatchOnlyController(watchlist, shard, opts)
+	} else {
+		shard.store, shard.controller = newEventInformer(watchlist, shard, opts)
+	}
+	ew.shards[name] = shard
+
+	ew.shardsWG.Add(1)
+	go func() {
+		defer ew.shardsWG.Done()
+		defer errorReports.Recover()
+		shard.logger.Debug().Msg("Informer started")
+		shard.controller.Run(shardCtx.Done())
+		shard.logger.Debug().Msg("Informer stopped")
+	}()
+}
+
+// stopShard stops the informer for namespace, if there is one.
+func (ew *EventWatcher) stopShard(namespace string) {
+	ew.shardsMu.Lock()
+	defer ew.shardsMu.Unlock()
+	if shard, ok := ew.shards[namespace]; ok {
+		shard.cancel()
+		delete(ew.shards, namespace)
+	}
+}
+
+// restartShard replaces the informer of shard with a new one, which resumes
+// watching from the last version the old one has seen. A new list would
+// deliver all events since the start of the tailer again.
+func (ew *EventWatcher) restartShard(ctx context.Context, shard *informerShard) {
+	version := shard.controller.LastSyncResourceVersion()
+	if version == "" {
+		// not synced yet
+		version = ew.resume.Version(shard.name)
+	}
+	ew.stopShard(shard.name)
+	ew.startShardAt(ctx, shard.namespace, version)
+}
+
+// relistShard replaces the informer of shard with one listing all events,
+// for watches of another API server whose versions don't apply
+func (ew *EventWatcher) relistShard(ctx context.Context, shard *informerShard) {
+	ew.stopShard(shard.name)
+	ew.startShardAt(ctx, shard.namespace, "")
+}
+
+// storeSize returns the number of items in the stores of all shards
+func (ew *EventWatcher) storeSize() int {
+	ew.shardsMu.Lock()
+	defer ew.shardsMu.Unlock()
+	size := 0
+	for _, shard := range ew.shards {
+		if shard.store != nil {
+			size += len(shard.store.ListKeys())
+		}
+	}
+	return size
+}
+
+// watchNamespaces runs one event informer per namespace until ctx is done,
+// starting and stopping informers as namespaces come and go.
+func (ew *EventWatcher) watchNamespaces(ctx context.Context) {
+	watchlist := cache.NewListWatchFromClient(ew.restClient(), "namespaces", corev1.NamespaceAll, fields.Everything())
+	_, controller := cache.NewInformer(watchlist, &corev1.Namespace{}, 0, cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			ns := obj.(*corev1.Namespace)
+			if !ew.replicaSharding.Owns(ns.Name) {
+				ew.logger.Debug().Str("shard", ns.Name).Msg("Namespace discovered, watched by another replica")
+				return
+			}
+			ew.logger.Info().Str("shard", ns.Name).Msg("Namespace discovered, starting informer")
+			ew.startShard(ctx, ns.Name)
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if ns, ok := obj.(*corev1.Namespace); ok {
+				ew.logger.Info().Str("shard", ns.Name).Msg("Namespace deleted, stopping informer")
+				ew.stopShard(ns.Name)
+			}
+		},
+	})
+	// the informers of the namespaces known at startup are started once
+	// the namespaces have been listed
+	go func() {
+		if cache.WaitForCacheSync(ctx.Done(), controller.HasSynced) {
+			ew.waitForSync(ctx)
+		}
+	}()
+	controller.Run(ctx.Done())
+}
+
+// onWatchError is called by the reflector whenever list/watch fails. The
+// reflector retries on its own, this only classifies and reports the error.
+func (s *informerShard) onWatchError(_ *cache.Reflector, err error) {
+	if errors.Is(err, io.EOF) {
+		// watch closed normally
+		return
+	}
+	class := classifyError(err)
+	s.ew.apiErrorsCounter.WithLabelValues(s.name, class).Inc()
+	// expired watches are relisted as part of normal operation
+	if !apierrors.IsResourceExpired(err) && !apierrors.IsGone(err) {
+		s.fail()
+	}
+	errorReports.CaptureError("watch/"+s.name+"/"+class, err, map[string]string{"shard": s.name, "class": class})
+	if apierrors.IsUnauthorized(err) {
+		s.logger.Warn().Err(err).Str("class", class).Msg("Credentials rejected, reloading")
+		s.ew.onUnauthorized(s)
+		return
+	}
+	if (apierrors.IsResourceExpired(err) || apierrors.IsGone(err)) && s.resume.Resuming() {
+		resumeRelistsCounter.WithLabelValues(s.name).Inc()
+		s.logger.Warn().Err(err).Msg("ResourceVersion too old to resume from, listing all events")
+		return
+	}
+	if apierrors.IsResourceExpired(err) || apierrors.IsGone(err) {
+		s.logger.Debug().Err(err).Str("class", class).Msg("Watch expired, relisting")
+		return
+	}
+	s.logger.Error().Err(err).Str("class", class).Msg("Could not list/watch events")
+}
+
+// touch records activity on the watch, which ends a failure streak
+func (s *informerShard) touch() {
+	atomic.StoreInt64(&s.lastActivity, time.Now().UnixNano())
+	if failures := atomic.SwapInt32(&s.failures, 0); failures > 0 {
+		broken := time.Since(time.Unix(0, atomic.SwapInt64(&s.brokenSince, 0)))
+		s.logger.Info().
+			Int32("failures", failures).
+			Str("broken", broken.Round(time.Second).String()).
+			Msg("Watch recovered")
+	}
+}
+
+// fail records a list/watch failure
+func (s *informerShard) fail() {
+	if atomic.AddInt32(&s.failures, 1) == 1 {
+		atomic.StoreInt64(&s.brokenSince, time.Now().UnixNano())
+	}
+}
+
+func (s *informerShard) consecutiveFailures() int32 {
+	return atomic.LoadInt32(&s.failures)
+}
+
+// broken returns for how long lists and watches have been failing, zero if
+// the last one succeeded
+func (s *informerShard) broken() time.Duration {
+	since := atomic.LoadInt64(&s.brokenSince)
+	if since == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, since))
+}
+
+func (s *informerShard) onRetry(delay time.Duration) {
+	watchReconnectsCounter.WithLabelValues(s.name).Inc()
+	s.logger.Debug().
+		Int32("failures", s.consecutiveFailures()).
+		Str("delay", delay.Round(time.Millisecond).String()).
+		Msg("Backing off before reconnecting")
+}
+
+// idle returns the time since the last activity on the watch
+func (s *informerShard) idle() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&s.lastActivity)))
+}
+
+func (s *informerShard) OnAdd(obj interface{}) {
+	event := obj.(*corev1.Event)
+	incWithExemplar(s.events, eventExemplar(event, spanContext{}))
+	s.ew.onAdd(event, s.resume.Resuming())
+	s.deleteEvent(obj)
+}
+
+func (s *informerShard) OnUpdate(oldObj, newObj interface{}) {
+	event := newObj.(*corev1.Event)
+	incWithExemplar(s.events, eventExemplar(event, spanContext{}))
+	s.ew.onUpdate(event, s.resume.Resuming())
+	s.deleteEvent(newObj)
+}
+
+func (s *informerShard) OnDelete(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	event, ok := obj.(*corev1.Event)
+	if !ok {
+		return
+	}
+	incWithExemplar(s.events, eventExemplar(event, spanContext{}))
+	s.ew.onDelete(event, s.resume.Resuming())
+}
+
+func (s *informerShard) deleteEvent(obj interface{}) {
+	if s.store == nil {
+		return
+	}
+	if err := s.store.Delete(obj); err != nil {
+		s.logger.Error().Err(err).Msg("Could not delete object")
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/rs/zerolog/log"
+
+	"k8s-event-tailer/pkg/extension"
+)
+
+// sinkFactory creates a sink from its flags. It returns nil if the sink is
+// not configured.
+type sinkFactory func(policy *tlsPolicy) (extension.Sink, error)
+
+type sinkRegistration struct {
+	name    string
+	factory sinkFactory
+}
+
+// sinkRegistry holds the built-in sinks in the order they were registered
+var sinkRegistry []sinkRegistration
+
+// registerSink adds a built-in sink. Names must be unique, they are the names
+// the sinks report, which are used in flags like --sink-filter and in metrics.
+func registerSink(name string, factory sinkFactory) {
+	for _, registration := range sinkRegistry {
+		if registration.name == name {
+			panic("sink " + name + " registered twice")
+		}
+	}
+	sinkRegistry = append(sinkRegistry, sinkRegistration{name: name, factory: factory})
+}
+
+// registeredSinkNames returns the sorted names of the built-in sinks
+func registeredSinkNames() []string {
+	names := make([]string, 0, len(sinkRegistry))
+	for _, registration := range sinkRegistry {
+		names = append(names, registration.name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// hasSink reports whether sinks contain a sink called name
+func hasSink(sinks []extension.Sink, name string) bool {
+	for _, sink := range sinks {
+		if sink.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// newRegisteredSinks creates all configured built-in sinks
+func newRegisteredSinks(policy *tlsPolicy) ([]extension.Sink, error) {
+	var sinks []extension.Sink
+	for _, registration := range sinkRegistry {
+		sink, err := registration.factory(policy)
+		if err != nil {
+			return nil, fmt.Errorf("sink %s: %w", registration.name, err)
+		}
+		if sink != nil {
+			sinks = append(sinks, sink)
+		}
+	}
+	return sinks, nil
+}
+
+// closeSinks closes sinks which were never subscribed
+func closeSinks(sinks []extension.Sink) {
+	for _, sink := range sinks {
+		if err := sink.Close(); err != nil {
+			log.Warn().Err(err).Str("sink", sink.Name()).Msg("Could not close sink")
+		}
+	}
+}
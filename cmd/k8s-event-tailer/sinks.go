@@ -0,0 +1,236 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	corev1 "k8s.io/api/core/v1"
+
+	"k8s-event-tailer/pkg/extension"
+)
+
+// sinkFlushInterval is the time between flushes of sinks buffering writes
+const sinkFlushInterval = time.Second
+
+var (
+	sinkErrorsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sink_errors_total",
+		Help: "Number of failed sink writes by error class",
+	}, []string{"sink", "class"})
+
+	sinkRetriesCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sink_retries_total",
+		Help: "Number of retried sink writes",
+	}, []string{"sink"})
+
+	sinkBudgetExhaustedCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sink_retry_budget_exhausted_total",
+		Help: "Number of writes given up on because the sink's retry budget was exhausted",
+	}, []string{"sink"})
+
+	sinkWrittenCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sink_events_written_total",
+		Help: "Number of events written to the sink",
+	}, []string{"sink"})
+
+	sinkFailedCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sink_events_failed_total",
+		Help: "Number of events which could not be written to the sink, even with retries",
+	}, []string{"sink"})
+)
+
+// sinkSubscription is a sink subscribed to the bus
+type sinkSubscription struct {
+	name   string
+	sub    *subscription
+	health healthCheck
+}
+
+// sinkOptions configures retries and batching of sink writes
+type sinkOptions struct {
+	// maxRetries is the number of retries per write
+	maxRetries int
+	// retriesPerMinute is the retry budget shared by all writes of a sink
+	retriesPerMinute int
+	// batchSize is the maximum number of events written at once to sinks
+	// implementing extension.BatchSink, 1 disables batching
+	batchSize int
+	// batchInterval is the longest time events wait for their batch to fill up
+	batchInterval time.Duration
+	// filter selects the events written to the sink, nil writes all events
+	filter *eventRule
+	// tracer records sink writes, nil disables tracing
+	tracer *tracer
+	// queueSize bounds the events buffered for the sink, further events are
+	// dropped while the sink is behind. Zero blocks delivery instead.
+	queueSize int
+}
+
+// sinkWriter writes to a sink, retrying retryable errors with backoff as long
+// as the sink's retry budget allows.
+type sinkWriter struct {
+	sink      extension.Sink
+	opts      sinkOptions
+	logger    zerolog.Logger
+	budget    *retryBudget
+	retries   prometheus.Counter
+	exhausted prometheus.Counter
+
+	mu          sync.Mutex
+	failures    int
+	lastError   string
+	lastSuccess time.Time
+}
+
+// subscribeSink writes every published event to sink and closes the sink
+// once the bus is closed. Batch sinks receive their events in batches,
+// buffering sinks are flushed every sinkFlushInterval. It returns the health
+// check of the sink.
+func subscribeSink(bus *eventBus, sink extension.Sink, opts sinkOptions) sinkSubscription {
+	w := &sinkWriter{
+		sink:      sink,
+		opts:      opts,
+		logger:    log.With().Str("component", "sink").Str("sink", sink.Name()).Logger(),
+		budget:    newRetryBudget(opts.retriesPerMinute),
+		retries:   sinkRetriesCounter.WithLabelValues(sink.Name()),
+		exhausted: sinkBudgetExhaustedCounter.WithLabelValues(sink.Name()),
+	}
+
+	closeSink := w.close
+	if flusher, ok := sink.(extension.Flusher); ok {
+		stop := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			ticker := time.NewTicker(sinkFlushInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					w.flush(flusher)
+				case <-stop:
+					return
+				}
+			}
+		}()
+		closeSink = func() {
+			close(stop)
+			<-done
+			w.flush(flusher)
+			w.close()
+		}
+	}
+
+	handle := func(record eventRecord) {
+		span := opts.tracer.StartChild("sink.write", record.trace)
+		span.SetAttr("sink", sink.Name())
+		span.End(w.write(1, func() error {
+			return sink.Write(record.event)
+		}))
+	}
+	onClose := closeSink
+
+	if batchSink, ok := sink.(extension.BatchSink); ok && opts.batchSize > 1 {
+		b := newBatcher(opts.batchSize, opts.batchInterval, func(events []*corev1.Event) {
+			// a batch mixes events of several traces, so it starts its own
+			span := opts.tracer.StartRoot("sink.write_batch")
+			span.SetAttr("sink", sink.Name())
+			span.SetAttr("events", strconv.Itoa(len(events)))
+			span.End(w.write(len(events), func() error {
+				return batchSink.WriteBatch(events)
+			}))
+		})
+		handle = func(record eventRecord) {
+			b.Add(record.event)
+		}
+		onClose = func() {
+			b.Close()
+			closeSink()
+		}
+	}
+
+	if opts.filter != nil {
+		unfiltered := handle
+		handle = func(record eventRecord) {
+			if opts.filter.Matches(record.event) {
+				unfiltered(record)
+			}
+		}
+	}
+	sub := bus.Subscribe("sink-"+sink.Name(), subscriberOptions{
+		bufferSize: opts.queueSize,
+		lossy:      opts.queueSize > 0,
+		onClose:    onClose,
+	}, handle)
+	return sinkSubscription{name: sink.Name(), sub: sub, health: w.health}
+}
+
+// write calls fn until it succeeds or retrying is not possible anymore. It
+// returns the last error if the write failed for good.
+func (w *sinkWriter) write(events int, fn func() error) error {
+	err := w.retry(events, fn)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err != nil {
+		class := classifyError(err)
+		errorReports.CaptureError("sink/"+w.sink.Name()+"/"+class, err, map[string]string{"sink": w.sink.Name(), "class": class})
+		drops.Add(dropCauseSinkFailed, w.sink.Name(), events)
+		sinkFailedCounter.WithLabelValues(w.sink.Name()).Add(float64(events))
+		w.failures++
+		w.lastError = err.Error()
+	} else {
+		sinkWrittenCounter.WithLabelValues(w.sink.Name()).Add(float64(events))
+		w.failures = 0
+		w.lastSuccess = time.Now()
+	}
+	return err
+}
+
+func (w *sinkWriter) retry(events int, fn func() error) error {
+	for retry := 0; ; retry++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		class := classifyError(err)
+		sinkErrorsCounter.WithLabelValues(w.sink.Name(), class).Inc()
+		logEntry := w.logger.Error().Err(err).Str("class", class).Int("retry", retry).Int("events", events)
+
+		switch {
+		case class == errorClassPermanent:
+			logEntry.Msg("Could not write to sink, not retrying permanent error")
+			return err
+		case retry >= w.opts.maxRetries:
+			logEntry.Msg("Could not write to sink, giving up after retries")
+			return err
+		case !w.budget.Take():
+			w.exhausted.Inc()
+			logEntry.Msg("Could not write to sink, retry budget exhausted")
+			return err
+		}
+		logEntry.Msg("Could not write to sink, retrying")
+		w.retries.Inc()
+		time.Sleep(retryBackoff(retry + 1))
+	}
+}
+
+// flush writes out the events buffered by the sink. Failed flushes are not
+// retried, the sink keeps the events for the next flush if it can.
+func (w *sinkWriter) flush(flusher extension.Flusher) {
+	if err := flusher.Flush(); err != nil {
+		class := classifyError(err)
+		sinkErrorsCounter.WithLabelValues(w.sink.Name(), class).Inc()
+		w.logger.Error().Err(err).Str("class", class).Msg("Could not flush sink")
+	}
+}
+
+func (w *sinkWriter) close() {
+	if err := w.sink.Close(); err != nil {
+		w.logger.Error().Err(err).Msg("Could not close sink")
+	}
+}
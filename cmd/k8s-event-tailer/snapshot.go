@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog/log"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// snapshotTimeout bounds the time delivery waits for the involved object of
+// an event
+const snapshotTimeout = 5 * time.Second
+
+var snapshotsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "object_snapshots_total",
+	Help: "Number of involved object snapshots for matching events, by result",
+}, []string{"result"})
+
+// objectSnapshotter captures the state of the involved objects of events
+// matching one of its rules, like kubectl describe, so that the evidence is
+// kept after the object was deleted. Snapshots are attached to alerts and
+// written to dir if set.
+type objectSnapshotter struct {
+	resolver *objectResolver
+	rules    []*eventRule
+	dir      string
+}
+
+// newObjectSnapshotter parses the selectors of the events to snapshot the
+// involved object of, nil if there are none
+func newObjectSnapshotter(resolver *objectResolver, selectors []string, dir string) (*objectSnapshotter, error) {
+	if len(selectors) == 0 {
+		return nil, nil
+	}
+	s := &objectSnapshotter{resolver: resolver, dir: dir}
+	for _, selector := range selectors {
+		rule, err := parseEventSelector(selector)
+		if err != nil {
+			return nil, err
+		}
+		s.rules = append(s.rules, rule)
+	}
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o750); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Capture returns the snapshot of the involved object of event, empty if the
+// event does not match or the object could not be fetched
+func (s *objectSnapshotter) Capture(ctx context.Context, event *corev1.Event) string {
+	if s == nil || !s.matches(event) {
+		return ""
+	}
+	ctx, cancel := context.WithTimeout(ctx, snapshotTimeout)
+	defer cancel()
+	ref := event.InvolvedObject
+	logger := log.With().Str("namespace", ref.Namespace).Str("kind", ref.Kind).Str("name", ref.Name).Logger()
+	obj, err := s.resolver.Get(ctx, ref)
+	if err != nil {
+		snapshotsCounter.WithLabelValues("error").Inc()
+		logger.Debug().Err(err).Msg("Could not snapshot object")
+		return ""
+	}
+	snapshot := objectDescription(obj)
+	if s.dir != "" {
+		name := fmt.Sprintf("%s_%s_%s_%s.txt", ref.Namespace, strings.ToLower(ref.Kind), ref.Name,
+			time.Now().UTC().Format("20060102T150405.000"))
+		if err := os.WriteFile(filepath.Join(s.dir, name), []byte(snapshot), 0o640); err != nil {
+			logger.Error().Err(err).Msg("Could not store object snapshot")
+		}
+	}
+	snapshotsCounter.WithLabelValues("success").Inc()
+	return snapshot
+}
+
+func (s *objectSnapshotter) matches(event *corev1.Event) bool {
+	for _, rule := range s.rules {
+		if rule.Matches(event) {
+			return true
+		}
+	}
+	return false
+}
+
+// objectDescription returns the summary of obj followed by its spec and
+// status
+func objectDescription(obj *unstructured.Unstructured) string {
+	var b strings.Builder
+	b.WriteString(objectSummary(obj))
+	for _, field := range []string{"spec", "status"} {
+		value, ok := obj.Object[field]
+		if !ok {
+			continue
+		}
+		data, err := yaml.Marshal(map[string]interface{}{field: value})
+		if err != nil {
+			continue
+		}
+		b.WriteString("\n")
+		b.Write(data)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
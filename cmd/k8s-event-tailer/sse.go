@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const sseKeepAliveInterval = 15 * time.Second
+
+// resumeID returns the id after which a stream starts: the Last-Event-ID
+// of a reconnecting client, or the last buffered event for a new client.
+// Ids of an earlier run of the tailer are above the last id and also
+// start with new events.
+func (b *eventBuffer) resumeID(r *http.Request) uint64 {
+	value := r.Header.Get("Last-Event-ID")
+	if value == "" {
+		// EventSource cannot set headers on the first connection
+		value = r.URL.Query().Get("lastEventId")
+	}
+	last := b.lastEventID()
+	if id, err := strconv.ParseUint(value, 10, 64); err == nil && id <= last {
+		return id
+	}
+	return last
+}
+
+// sseHandler streams the buffered events as Server-Sent Events with the
+// JSON event as data and the buffer position as id. The namespace, type and
+// reason query parameters select the events like for the WebSocket stream.
+// A comment is sent as keep-alive when no event was sent for a while, so
+// proxies do not close idle streams. Streams end when closing is closed.
+func sseHandler(buffer *eventBuffer, closing <-chan struct{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming not supported", http.StatusInternalServerError)
+			return
+		}
+		filter := newStreamFilter(r.URL.Query())
+		cursor := buffer.resumeID(r)
+		done := r.Context().Done()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		keepAlive := time.NewTicker(sseKeepAliveInterval)
+		defer keepAlive.Stop()
+		for {
+			records, updated := buffer.after(cursor)
+			for _, record := range records {
+				cursor = record.id
+				if !filter.Matches(record.event) {
+					continue
+				}
+				data, err := json.Marshal(newJSONEvent(record.event))
+				if err != nil {
+					continue
+				}
+				if _, err := fmt.Fprintf(w, "id: %d\nevent: k8s-event\ndata: %s\n\n", record.id, data); err != nil {
+					return
+				}
+				keepAlive.Reset(sseKeepAliveInterval)
+			}
+			flusher.Flush()
+
+			select {
+			case <-done:
+				return
+			case <-closing:
+				return
+			case <-keepAlive.C:
+				if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+					return
+				}
+			case <-updated:
+			}
+		}
+	}
+}
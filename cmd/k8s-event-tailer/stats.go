@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// watcherStats holds the event counters of the watcher. They back both the
+// Prometheus metrics and the periodic stats log, so both always agree.
+type watcherStats struct {
+	added    uint64
+	updated  uint64
+	deleted  uint64
+	old      uint64
+	filtered uint64
+	// lastEvent is the time the last event was queued in unix nanoseconds
+	lastEvent int64
+}
+
+func (s *watcherStats) Added() uint64    { return atomic.LoadUint64(&s.added) }
+func (s *watcherStats) Updated() uint64  { return atomic.LoadUint64(&s.updated) }
+func (s *watcherStats) Deleted() uint64  { return atomic.LoadUint64(&s.deleted) }
+func (s *watcherStats) Old() uint64      { return atomic.LoadUint64(&s.old) }
+func (s *watcherStats) Filtered() uint64 { return atomic.LoadUint64(&s.filtered) }
+
+// LastEvent returns the time the last event was queued, zero if none was
+func (s *watcherStats) LastEvent() time.Time {
+	if last := atomic.LoadInt64(&s.lastEvent); last != 0 {
+		return time.Unix(0, last)
+	}
+	return time.Time{}
+}
+
+// logStats prints the watcher stats every statsInterval until ctx is done. A
+// zero interval disables stats logging.
+func (ew *EventWatcher) logStats(ctx context.Context) {
+	if ew.statsInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(ew.statsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ew.printStats()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// printStats logs the watcher stats as one record, encoded like all logs
+// as chosen by --output
+func (ew *EventWatcher) printStats() {
+	ew.logger.Info().
+		Int("store", ew.storeSize()).
+		Uint64("added", ew.stats.Added()).
+		Uint64("updated", ew.stats.Updated()).
+		Uint64("deleted", ew.stats.Deleted()).
+		Uint64("old", ew.stats.Old()).
+		Uint64("filtered", ew.stats.Filtered()).
+		Uint64("dropped", ew.queue.Dropped()).
+		Msg("STATS")
+}
+
+const (
+	// defaultNamespaceStatsInterval is the window of the namespace stats when
+	// stats logging is disabled
+	defaultNamespaceStatsInterval = time.Minute
+	namespaceStatsTopReasons      = 5
+)
+
+// namespaceCounts are the counters of a namespace within a window
+type namespaceCounts struct {
+	added   uint64
+	updated uint64
+	deleted uint64
+	reasons map[string]uint64
+}
+
+// namespaceStats counts the events per namespace in windows of the stats
+// interval. The report covers the last complete window, so that its counts
+// do not depend on when it is requested.
+type namespaceStats struct {
+	mu       sync.Mutex
+	interval time.Duration
+	start    time.Time
+	current  map[string]*namespaceCounts
+	previous map[string]*namespaceCounts
+	// lastEvent is kept across windows
+	lastEvent map[string]time.Time
+}
+
+// namespaceStatsReport is served on /api/v1/stats
+type namespaceStatsReport struct {
+	Interval    string                               `json:"interval"`
+	WindowStart time.Time                            `json:"windowStart"`
+	WindowEnd   time.Time                            `json:"windowEnd"`
+	Namespaces  map[string]namespaceStatsReportEntry `json:"namespaces"`
+}
+
+type namespaceStatsReportEntry struct {
+	Added      uint64        `json:"added"`
+	Updated    uint64        `json:"updated"`
+	Deleted    uint64        `json:"deleted"`
+	TopReasons []reasonCount `json:"topReasons"`
+	LastEvent  *time.Time    `json:"lastEvent,omitempty"`
+}
+
+type reasonCount struct {
+	Reason string `json:"reason"`
+	Count  uint64 `json:"count"`
+}
+
+// rotate starts new windows until now is within the current one. It must
+// be called with mu held.
+func (s *namespaceStats) rotate(now time.Time) {
+	if s.interval <= 0 {
+		s.interval = defaultNamespaceStatsInterval
+	}
+	if s.start.IsZero() {
+		s.start = now
+		s.current = map[string]*namespaceCounts{}
+		s.lastEvent = map[string]time.Time{}
+	}
+	for now.Sub(s.start) >= s.interval {
+		if now.Sub(s.start) >= 2*s.interval {
+			// nothing was counted in the last complete window
+			s.previous = map[string]*namespaceCounts{}
+			s.start = now.Add(-now.Sub(s.start) % s.interval)
+		} else {
+			s.previous = s.current
+			s.start = s.start.Add(s.interval)
+		}
+		s.current = map[string]*namespaceCounts{}
+	}
+}
+
+// record counts an event of namespace for the watch notification message
+func (s *namespaceStats) record(event *corev1.Event, message string) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rotate(now)
+	counts, ok := s.current[event.Namespace]
+	if !ok {
+		counts = &namespaceCounts{reasons: map[string]uint64{}}
+		s.current[event.Namespace] = counts
+	}
+	switch message {
+	case eventAddedMessage:
+		counts.added++
+	case eventUpdatedMessage:
+		counts.updated++
+	case eventDeletedMessage:
+		counts.deleted++
+	}
+	counts.reasons[event.Reason]++
+	s.lastEvent[event.Namespace] = now
+}
+
+func (s *namespaceStats) report() namespaceStatsReport {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rotate(now)
+	report := namespaceStatsReport{
+		Interval:    s.interval.String(),
+		WindowStart: s.start.Add(-s.interval).UTC(),
+		WindowEnd:   s.start.UTC(),
+		Namespaces:  map[string]namespaceStatsReportEntry{},
+	}
+	for namespace, last := range s.lastEvent {
+		last := last.UTC()
+		entry := namespaceStatsReportEntry{LastEvent: &last, TopReasons: []reasonCount{}}
+		if counts, ok := s.previous[namespace]; ok {
+			entry.Added, entry.Updated, entry.Deleted = counts.added, counts.updated, counts.deleted
+			entry.TopReasons = topReasons(counts.reasons, namespaceStatsTopReasons)
+		}
+		report.Namespaces[namespace] = entry
+	}
+	return report
+}
+
+func topReasons(reasons map[string]uint64, n int) []reasonCount {
+	top := make([]reasonCount, 0, len(reasons))
+	for reason, count := range reasons {
+		top = append(top, reasonCount{Reason: reason, Count: count})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Reason < top[j].Reason
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// statsHandler serves the namespace stats as JSON
+func (ew *EventWatcher) statsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Content-Type", "application/json; charset=UTF-8")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(ew.namespaceStats.report()); err != nil {
+		log.Error().Err(err).Msg("Could not write namespace stats")
+	}
+}
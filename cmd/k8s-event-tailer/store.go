@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	defaultStoreListLimit = 100
+	maxStoreListLimit     = 1000
+)
+
+// storeList is a page of the events held in the informer stores
+type storeList struct {
+	// Total is the number of matching events over all pages
+	Total int         `json:"total"`
+	Items []jsonEvent `json:"items"`
+	// Continue is passed as continue to get the next page, empty on the
+	// last page
+	Continue string `json:"continue,omitempty"`
+}
+
+// storeListHandler serves the events currently held in the informer stores,
+// sorted by namespace and name, for debugging what the tailer sees. The
+// query parameters are namespace to list a single namespace, limit for the
+// page size and continue for the following page. Informers without a store,
+// see --watch-only, are not listed.
+func (ew *EventWatcher) storeListHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit := defaultStoreListLimit
+	if value := query.Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > maxStoreListLimit {
+			http.Error(w, "limit must be between 1 and "+strconv.Itoa(maxStoreListLimit), http.StatusBadRequest)
+			return
+		}
+	}
+	namespace := query.Get("namespace")
+	after := query.Get("continue")
+
+	events := map[string]*corev1.Event{}
+	for _, event := range ew.storedEvents() {
+		if namespace != "" && event.Namespace != namespace {
+			continue
+		}
+		key, err := cache.MetaNamespaceKeyFunc(event)
+		if err != nil {
+			continue
+		}
+		events[key] = event
+	}
+	keys := make([]string, 0, len(events))
+	for key := range events {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	list := storeList{Total: len(keys), Items: []jsonEvent{}}
+	// keys are stable across requests, unlike offsets into a changing store
+	start := sort.SearchStrings(keys, after)
+	if start < len(keys) && keys[start] == after {
+		start++
+	}
+	for i, key := range keys[start:] {
+		if i == limit {
+			list.Continue = keys[start+i-1]
+			break
+		}
+		list.Items = append(list.Items, newJSONEvent(events[key]))
+	}
+
+	w.Header().Add("Content-Type", "application/json; charset=UTF-8")
+	if err := json.NewEncoder(w).Encode(list); err != nil {
+		log.Error().Err(err).Msg("Could not write store list")
+	}
+}
+
+// storedEvents returns the events in the stores of all shards
+func (ew *EventWatcher) storedEvents() []*corev1.Event {
+	ew.shardsMu.Lock()
+	defer ew.shardsMu.Unlock()
+	var events []*corev1.Event
+	for _, shard := range ew.shards {
+		if shard.store == nil {
+			continue
+		}
+		for _, obj := range shard.store.List() {
+			if event, ok := obj.(*corev1.Event); ok {
+				events = append(events, event)
+			}
+		}
+	}
+	return events
+}
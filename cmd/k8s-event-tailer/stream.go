@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/websocket"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const streamWriteTimeout = 10 * time.Second
+
+// streamFilter selects the events of a stream by the query parameters
+// namespace, type and reason. Each may be repeated or separated by commas,
+// an event matches if it matches one value of every given parameter.
+type streamFilter struct {
+	namespaces map[string]bool
+	types      map[string]bool
+	reasons    map[string]bool
+}
+
+func newStreamFilter(query url.Values) streamFilter {
+	return streamFilter{
+		namespaces: stringSet(query["namespace"]),
+		types:      stringSet(query["type"]),
+		reasons:    stringSet(query["reason"]),
+	}
+}
+
+func (f streamFilter) Matches(event *corev1.Event) bool {
+	return (len(f.namespaces) == 0 || f.namespaces[event.Namespace]) &&
+		(len(f.types) == 0 || f.types[event.Type]) &&
+		(len(f.reasons) == 0 || f.reasons[event.Reason])
+}
+
+// websocketHandler streams the published events matching the query filters
+// to WebSocket clients, one JSON event per text message. Messages of
+// clients are ignored. Slow clients miss events rather than holding up the
+// bus. Connections are closed when closing is closed.
+func websocketHandler(bus *eventBus, closing <-chan struct{}) http.Handler {
+	return websocket.Server{
+		Handshake: checkWebsocketOrigin,
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+			filter := newStreamFilter(ws.Request().URL.Query())
+			// reading detects when the client goes away
+			gone := make(chan struct{})
+			go func() {
+				defer close(gone)
+				io.Copy(io.Discard, ws)
+			}()
+
+			records := make(chan eventRecord)
+			sub := bus.Subscribe("websocket", subscriberOptions{lossy: true}, func(record eventRecord) {
+				if !filter.Matches(record.event) {
+					return
+				}
+				select {
+				case records <- record:
+				case <-gone:
+				}
+			})
+			if sub == nil {
+				return
+			}
+			defer bus.Unsubscribe(sub)
+
+			for {
+				select {
+				case <-gone:
+					return
+				case <-closing:
+					return
+				case record := <-records:
+					data, err := json.Marshal(newJSONEvent(record.event))
+					if err != nil {
+						continue
+					}
+					ws.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+					if err := websocket.Message.Send(ws, string(data)); err != nil {
+						return
+					}
+				}
+			}
+		},
+	}
+}
+
+// checkWebsocketOrigin accepts clients without Origin, like curl or
+// scripts, and browsers on pages served by the tailer itself. Other web
+// pages could otherwise read the events through the visitor's browser.
+func checkWebsocketOrigin(config *websocket.Config, r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host != r.Host {
+		return websocket.ErrBadWebSocketOrigin
+	}
+	config.Origin = u
+	return nil
+}
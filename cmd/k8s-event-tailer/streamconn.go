@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const streamDialTimeout = 10 * time.Second
+
+// streamConn is a TCP or TLS connection which is reestablished on the next
+// write after it broke.
+type streamConn struct {
+	address string
+	tls     *tls.Config
+	timeout time.Duration
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// newStreamConn returns a connection to address, using TLS if tlsConfig is
+// not nil. It connects lazily on the first write.
+func newStreamConn(address string, tlsConfig *tls.Config) *streamConn {
+	return &streamConn{
+		address: address,
+		tls:     tlsConfig,
+		timeout: 30 * time.Second,
+	}
+}
+
+// Write sends data, connecting first if necessary. After a failed write the
+// connection is closed, so the next write reconnects.
+func (c *streamConn) Write(data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		conn, err := c.dial()
+		if err != nil {
+			return err
+		}
+		c.conn = conn
+	}
+	_ = c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	if _, err := c.conn.Write(data); err != nil {
+		c.conn.Close()
+		c.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (c *streamConn) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: streamDialTimeout, KeepAlive: 30 * time.Second}
+	if c.tls != nil {
+		config := c.tls.Clone()
+		if config.ServerName == "" {
+			config.ServerName, _, _ = net.SplitHostPort(c.address)
+		}
+		return tls.DialWithDialer(dialer, "tcp", c.address, config)
+	}
+	return dialer.Dial("tcp", c.address)
+}
+
+// Close closes the connection, if it is open
+func (c *streamConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// parseStreamAddress splits an address of the form scheme://host:port
+func parseStreamAddress(address string, schemes ...string) (scheme, hostPort string, err error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return "", "", err
+	}
+	for _, s := range schemes {
+		if u.Scheme == s && u.Host != "" {
+			return u.Scheme, u.Host, nil
+		}
+	}
+	return "", "", fmt.Errorf("invalid address %q, expected SCHEME://HOST:PORT with scheme %v", address, schemes)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	defaultSyslogFacility = "local0"
+	defaultSyslogAppName  = "k8s-event-tailer"
+	// syslogSDID identifies the structured data of events, 32473 is the
+	// enterprise number reserved for examples and private use
+	syslogSDID = "event@32473"
+	// syslogTimeFormat is the RFC 5424 timestamp with microseconds
+	syslogTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+// syslogFacilities are the facility codes of RFC 5424 by name
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11, "ntp": 12, "security": 13, "console": 14, "cron2": 15,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogSeverities are the severity codes of RFC 5424 by name
+var syslogSeverities = map[string]int{
+	"emerg": 0, "alert": 1, "crit": 2, "err": 3, "warning": 4, "notice": 5, "info": 6, "debug": 7,
+}
+
+// syslogNames returns the sorted names of codes, for flag help and enums
+func syslogNames(codes map[string]int) []string {
+	names := make([]string, 0, len(codes))
+	for name := range codes {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return codes[names[i]] < codes[names[j]] })
+	return names
+}
+
+// syslogOptions configures the syslog sink
+type syslogOptions struct {
+	// address is udp://, tcp:// or tls://host:port
+	address  string
+	facility string
+	// severities maps event types to severity names, types not given keep
+	// their default severity
+	severities map[string]string
+	appName    string
+	tls        *tlsPolicy
+}
+
+// syslogSink sends events as RFC 5424 messages. Over UDP, each message is a
+// datagram, over TCP and TLS messages are framed by octet counting as in
+// RFC 6587 and RFC 5425. Event fields are sent as structured data.
+type syslogSink struct {
+	facility   int
+	severities map[string]int
+	host       string
+	appName    string
+	procID     string
+	udp        net.Conn
+	stream     *streamConn
+}
+
+func newSyslogSink(opts syslogOptions) (*syslogSink, error) {
+	scheme, hostPort, err := parseStreamAddress(opts.address, "udp", "tcp", "tls")
+	if err != nil {
+		return nil, err
+	}
+	facility, ok := syslogFacilities[opts.facility]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility %q, expected one of %s", opts.facility,
+			strings.Join(syslogNames(syslogFacilities), ", "))
+	}
+	s := &syslogSink{
+		facility: facility,
+		severities: map[string]int{
+			corev1.EventTypeNormal:  syslogSeverities["info"],
+			corev1.EventTypeWarning: syslogSeverities["warning"],
+		},
+		appName: opts.appName,
+		procID:  strconv.Itoa(os.Getpid()),
+	}
+	for eventType, name := range opts.severities {
+		severity, ok := syslogSeverities[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown syslog severity %q, expected one of %s", name,
+				strings.Join(syslogNames(syslogSeverities), ", "))
+		}
+		s.severities[eventType] = severity
+	}
+	if s.appName == "" {
+		s.appName = defaultSyslogAppName
+	}
+	if s.host, err = os.Hostname(); err != nil {
+		s.host = "-"
+	}
+	switch scheme {
+	case "udp":
+		if s.udp, err = net.Dial("udp", hostPort); err != nil {
+			return nil, err
+		}
+	case "tcp":
+		s.stream = newStreamConn(hostPort, nil)
+	case "tls":
+		s.stream = newStreamConn(hostPort, opts.tls.Config())
+	}
+	return s, nil
+}
+
+func (s *syslogSink) Name() string {
+	return "syslog"
+}
+
+// syslogMessage formats event as RFC 5424 message
+func (s *syslogSink) syslogMessage(event *corev1.Event) []byte {
+	severity, ok := s.severities[event.Type]
+	if !ok {
+		severity = syslogSeverities["notice"]
+	}
+	obj := event.InvolvedObject
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %s %s ", s.facility*8+severity,
+		eventTime(event).UTC().Format(syslogTimeFormat),
+		syslogHeaderField(s.host, 255), syslogHeaderField(s.appName, 48), s.procID,
+		syslogHeaderField(event.Reason, 32))
+	b.WriteString("[" + syslogSDID)
+	for _, param := range [][2]string{
+		{"namespace", event.Namespace},
+		{"name", event.Name},
+		{"uid", string(event.UID)},
+		{"type", event.Type},
+		{"reason", event.Reason},
+		{"count", strconv.Itoa(int(event.Count))},
+		{"kind", obj.Kind},
+		{"objectNamespace", obj.Namespace},
+		{"objectName", obj.Name},
+		{"source", event.Source.Component},
+	} {
+		if param[1] != "" {
+			fmt.Fprintf(&b, ` %s="%s"`, param[0], syslogParamEscaper.Replace(param[1]))
+		}
+	}
+	b.WriteString("] ")
+	b.WriteString(eventObject(event) + ": " + event.Message)
+	return b.Bytes()
+}
+
+// syslogParamEscaper escapes the characters RFC 5424 requires in parameter
+// values
+var syslogParamEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// syslogHeaderField returns value as header field of printable ASCII of at
+// most limit characters, - if it is empty
+func syslogHeaderField(value string, limit int) string {
+	value = strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return '_'
+		}
+		return r
+	}, value)
+	if len(value) > limit {
+		value = value[:limit]
+	}
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+func (s *syslogSink) Write(event *corev1.Event) error {
+	return s.WriteBatch([]*corev1.Event{event})
+}
+
+// WriteBatch sends the events with a single write over TCP and TLS, or one
+// datagram each over UDP
+func (s *syslogSink) WriteBatch(events []*corev1.Event) error {
+	if s.stream == nil {
+		for _, event := range events {
+			if _, err := s.udp.Write(s.syslogMessage(event)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var buf bytes.Buffer
+	for _, event := range events {
+		message := s.syslogMessage(event)
+		buf.WriteString(strconv.Itoa(len(message)) + " ")
+		buf.Write(message)
+	}
+	return s.stream.Write(buf.Bytes())
+}
+
+func (s *syslogSink) Close() error {
+	if s.stream != nil {
+		return s.stream.Close()
+	}
+	return s.udp.Close()
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const systemdReadyPollInterval = time.Second
+
+// sdNotify sends state to the systemd notification socket. It does nothing
+// if the tailer is not run by systemd with Type=notify or NotifyAccess.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	if socket[0] == '@' {
+		// abstract socket
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// systemdWatchdogInterval returns how often the watchdog has to be pinged,
+// half of WatchdogSec, or 0 if the watchdog is disabled
+func systemdWatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// runSystemdNotify tells systemd that the tailer is ready once the
+// informers have synced, and pings the watchdog as long as the watch is
+// healthy, so that systemd restarts a hung tailer.
+func (ew *EventWatcher) runSystemdNotify(ctx context.Context) {
+	if os.Getenv("NOTIFY_SOCKET") == "" {
+		return
+	}
+	logger := log.With().Str("component", "systemd").Logger()
+
+	ticker := time.NewTicker(systemdReadyPollInterval)
+	defer ticker.Stop()
+	for !ew.synced() {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+	if err := sdNotify("READY=1\nSTATUS=Watching events"); err != nil {
+		logger.Error().Err(err).Msg("Could not notify systemd")
+	}
+
+	interval := systemdWatchdogInterval()
+	if interval <= 0 {
+		return
+	}
+	logger.Info().Str("interval", interval.String()).Msg("Pinging the systemd watchdog")
+	ticker.Reset(interval)
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		if health := ew.informerHealth(); health.Status == healthBad {
+			logger.Warn().Str("reason", health.Message).Msg("Watch unhealthy, not pinging the systemd watchdog")
+			continue
+		}
+		if err := sdNotify("WATCHDOG=1"); err != nil {
+			logger.Error().Err(err).Msg("Could not ping the systemd watchdog")
+		}
+	}
+}
+
+// synced reports whether all informers have synced
+func (ew *EventWatcher) synced() bool {
+	ew.shardsMu.Lock()
+	defer ew.shardsMu.Unlock()
+	if len(ew.shards) == 0 {
+		return false
+	}
+	for _, shard := range ew.shards {
+		if !shard.controller.HasSynced() {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const textfileName = "k8s_event_tailer.prom"
+
+// textfileExcludedPrefixes are metrics node_exporter exports itself, which
+// would collide with ours
+var textfileExcludedPrefixes = []string{"go_", "process_", "promhttp_"}
+
+// textfileWriter writes the metrics to a node_exporter textfile collector
+// directory, so that nodes running node_exporter expose them without
+// scraping the tailer.
+type textfileWriter struct {
+	path     string
+	interval time.Duration
+	gatherer prometheus.Gatherer
+	logger   zerolog.Logger
+}
+
+func newTextfileWriter(dir string, interval time.Duration, gatherer prometheus.Gatherer) (*textfileWriter, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("textfile interval must be positive")
+	}
+	if info, err := os.Stat(dir); err != nil {
+		return nil, err
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	return &textfileWriter{
+		path:     filepath.Join(dir, textfileName),
+		interval: interval,
+		gatherer: gatherer,
+		logger:   log.With().Str("component", "textfile").Logger(),
+	}, nil
+}
+
+// Run writes the metrics every interval until ctx is done, and a last time
+// afterwards
+func (w *textfileWriter) Run(ctx context.Context) {
+	w.logger.Info().Str("path", w.path).Msg("Writing metrics for the textfile collector")
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		if err := w.write(); err != nil {
+			w.logger.Error().Err(err).Msg("Could not write metrics")
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			if err := w.write(); err != nil {
+				w.logger.Error().Err(err).Msg("Could not write metrics")
+			}
+			return
+		}
+	}
+}
+
+// write replaces the file atomically, node_exporter must never read a
+// partially written one
+func (w *textfileWriter) write() error {
+	families, err := w.gatherer.Gather()
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	for _, family := range families {
+		if hasAnyPrefix(family.GetName(), textfileExcludedPrefixes) {
+			continue
+		}
+		if _, err := expfmt.MetricFamilyToText(&buf, family); err != nil {
+			return err
+		}
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(w.path), "."+textfileName+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), w.path)
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
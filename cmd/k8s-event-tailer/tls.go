@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// fipsCipherSuites are the TLS 1.2 cipher suites built from FIPS-approved
+// algorithms
+var fipsCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+}
+
+// tlsPolicy is the TLS configuration shared by the web server and all
+// outbound connections of sinks and integrations
+type tlsPolicy struct {
+	minVersion   uint16
+	maxVersion   uint16
+	cipherSuites []uint16
+	curves       []tls.CurveID
+}
+
+// tlsVersionNames returns the accepted --tls-min-version values
+func tlsVersionNames() []string {
+	names := make([]string, 0, len(tlsVersions))
+	for name := range tlsVersions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newTLSPolicy builds the policy from the command line settings. Cipher
+// suites are given by their Go names, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+// and only apply up to TLS 1.2. The FIPS mode restricts everything to
+// FIPS-approved algorithms, which rules out TLS 1.3 since its cipher suites
+// cannot be restricted in Go.
+func newTLSPolicy(minVersion string, cipherSuites []string, fips bool) (*tlsPolicy, error) {
+	p := &tlsPolicy{}
+	version, ok := tlsVersions[minVersion]
+	if !ok {
+		return nil, fmt.Errorf("unknown TLS version %q", minVersion)
+	}
+	p.minVersion = version
+
+	if len(cipherSuites) > 0 {
+		ids := map[string]uint16{}
+		for _, suite := range tls.CipherSuites() {
+			ids[suite.Name] = suite.ID
+		}
+		for _, name := range cipherSuites {
+			id, ok := ids[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+			}
+			p.cipherSuites = append(p.cipherSuites, id)
+		}
+	}
+
+	if fips {
+		if p.minVersion < tls.VersionTLS12 {
+			p.minVersion = tls.VersionTLS12
+		}
+		p.maxVersion = tls.VersionTLS12
+		p.curves = []tls.CurveID{tls.CurveP256, tls.CurveP384}
+		if len(p.cipherSuites) == 0 {
+			p.cipherSuites = fipsCipherSuites
+		}
+		for _, id := range p.cipherSuites {
+			if !isFIPSCipherSuite(id) {
+				return nil, fmt.Errorf("cipher suite %s is not allowed in FIPS mode", tls.CipherSuiteName(id))
+			}
+		}
+	}
+	return p, nil
+}
+
+func isFIPSCipherSuite(id uint16) bool {
+	for _, fipsID := range fipsCipherSuites {
+		if id == fipsID {
+			return true
+		}
+	}
+	return false
+}
+
+// Config returns a new TLS configuration following the policy
+func (p *tlsPolicy) Config() *tls.Config {
+	return &tls.Config{
+		MinVersion:       p.minVersion,
+		MaxVersion:       p.maxVersion,
+		CipherSuites:     p.cipherSuites,
+		CurvePreferences: p.curves,
+	}
+}
+
+// HTTPClient returns an HTTP client whose connections follow the policy
+func (p *tlsPolicy) HTTPClient(timeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = p.Config()
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	traceExportInterval = 5 * time.Second
+	traceBatchSize      = 512
+	// traceMaxPending bounds the spans waiting for export, newer spans are
+	// dropped while the collector is unreachable
+	traceMaxPending = 8192
+	traceScopeName  = "k8s-event-tailer"
+)
+
+var (
+	traceSpansDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "tracing_spans_dropped_total",
+		Help: "Number of spans dropped because the export buffer was full",
+	})
+
+	traceExportErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "tracing_export_errors_total",
+		Help: "Number of failed span exports",
+	})
+)
+
+// tracingOptions configures the export of pipeline spans
+type tracingOptions struct {
+	// endpoint is the base URL of an OTLP/HTTP receiver, e.g.
+	// http://otel-collector:4318
+	endpoint    string
+	serviceName string
+	headers     map[string]string
+	// sampleRatio is the fraction of events traced
+	sampleRatio float64
+	tls         *tlsPolicy
+}
+
+// tracer records spans for the stages an event passes through and exports
+// them as OTLP/JSON. A nil tracer records nothing.
+type tracer struct {
+	opts    tracingOptions
+	client  *http.Client
+	logger  zerolog.Logger
+	mu      sync.Mutex
+	pending []*span
+}
+
+// spanContext identifies a span as the parent of the next pipeline stage.
+// The zero value stands for an event which is not traced.
+type spanContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+}
+
+func (sc spanContext) valid() bool {
+	return sc.spanID != [8]byte{}
+}
+
+// span is a single timed operation. All methods are no-ops on a nil span.
+type span struct {
+	tracer   *tracer
+	context  spanContext
+	parentID [8]byte
+	name     string
+	start    time.Time
+	end      time.Time
+	attrs    map[string]string
+	err      error
+}
+
+func newTracer(opts tracingOptions) (*tracer, error) {
+	if !strings.HasPrefix(opts.endpoint, "http://") && !strings.HasPrefix(opts.endpoint, "https://") {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q, expected an http(s) URL", opts.endpoint)
+	}
+	if opts.sampleRatio < 0 || opts.sampleRatio > 1 {
+		return nil, fmt.Errorf("trace sample ratio must be between 0 and 1, got %v", opts.sampleRatio)
+	}
+	opts.endpoint = strings.TrimSuffix(opts.endpoint, "/")
+	return &tracer{
+		opts:   opts,
+		client: opts.tls.HTTPClient(10 * time.Second),
+		logger: log.With().Str("component", "tracing").Logger(),
+	}, nil
+}
+
+// StartRoot starts the first span of a trace, or returns nil if the trace is
+// not sampled.
+func (t *tracer) StartRoot(name string) *span {
+	if t == nil {
+		return nil
+	}
+	var sc spanContext
+	if _, err := rand.Read(sc.traceID[:]); err != nil {
+		return nil
+	}
+	// the trace ID is random, so its lower half decides the sampling
+	if float64(binary.BigEndian.Uint64(sc.traceID[8:]))/(1<<64) >= t.opts.sampleRatio {
+		return nil
+	}
+	return t.start(name, sc, [8]byte{})
+}
+
+// StartChild starts a span below parent, or returns nil if parent is not
+// traced.
+func (t *tracer) StartChild(name string, parent spanContext) *span {
+	if t == nil || !parent.valid() {
+		return nil
+	}
+	return t.start(name, spanContext{traceID: parent.traceID}, parent.spanID)
+}
+
+func (t *tracer) start(name string, sc spanContext, parentID [8]byte) *span {
+	if _, err := rand.Read(sc.spanID[:]); err != nil {
+		return nil
+	}
+	return &span{
+		tracer:   t,
+		context:  sc,
+		parentID: parentID,
+		name:     name,
+		start:    time.Now(),
+		attrs:    map[string]string{},
+	}
+}
+
+// Context returns the span's context for starting child spans
+func (s *span) Context() spanContext {
+	if s == nil {
+		return spanContext{}
+	}
+	return s.context
+}
+
+// SetAttr sets a string attribute on the span
+func (s *span) SetAttr(key, value string) {
+	if s == nil {
+		return
+	}
+	s.attrs[key] = value
+}
+
+// End finishes the span, marking it as failed if err is not nil, and queues
+// it for export.
+func (s *span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	s.err = err
+	t := s.tracer
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.pending) >= traceMaxPending {
+		traceSpansDropped.Inc()
+		return
+	}
+	t.pending = append(t.pending, s)
+}
+
+// Run exports the recorded spans until ctx is done, then exports the
+// remaining ones.
+func (t *tracer) Run(ctx context.Context) {
+	t.logger.Info().Str("endpoint", t.opts.endpoint).Float64("sample_ratio", t.opts.sampleRatio).Msg("Exporting traces")
+	ticker := time.NewTicker(traceExportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.flush(ctx)
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			t.flush(flushCtx)
+			cancel()
+			return
+		}
+	}
+}
+
+func (t *tracer) flush(ctx context.Context) {
+	for {
+		t.mu.Lock()
+		n := len(t.pending)
+		if n > traceBatchSize {
+			n = traceBatchSize
+		}
+		batch := t.pending[:n:n]
+		t.pending = t.pending[n:]
+		t.mu.Unlock()
+		if n == 0 {
+			return
+		}
+		if err := t.export(ctx, batch); err != nil {
+			traceExportErrors.Inc()
+			t.logger.Error().Err(err).Int("spans", n).Msg("Could not export spans")
+			return
+		}
+	}
+}
+
+// OTLP/JSON request types, see
+// https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+type (
+	otlpTraceRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              int             `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Status            otlpStatus      `json:"status"`
+	}
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue string `json:"stringValue"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+)
+
+const (
+	otlpSpanKindInternal = 1
+	otlpStatusOK         = 1
+	otlpStatusError      = 2
+)
+
+func (t *tracer) export(ctx context.Context, spans []*span) error {
+	scope := otlpScopeSpans{Scope: otlpScope{Name: traceScopeName}}
+	for _, s := range spans {
+		out := otlpSpan{
+			TraceID:           hex.EncodeToString(s.context.traceID[:]),
+			SpanID:            hex.EncodeToString(s.context.spanID[:]),
+			Name:              s.name,
+			Kind:              otlpSpanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Status:            otlpStatus{Code: otlpStatusOK},
+		}
+		if s.parentID != [8]byte{} {
+			out.ParentSpanID = hex.EncodeToString(s.parentID[:])
+		}
+		for key, value := range s.attrs {
+			out.Attributes = append(out.Attributes, otlpAttribute{Key: key, Value: otlpValue{StringValue: value}})
+		}
+		if s.err != nil {
+			out.Status = otlpStatus{Code: otlpStatusError, Message: s.err.Error()}
+		}
+		scope.Spans = append(scope.Spans, out)
+	}
+	body, err := json.Marshal(otlpTraceRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpAttribute{
+			{Key: "service.name", Value: otlpValue{StringValue: t.opts.serviceName}},
+		}},
+		ScopeSpans: []otlpScopeSpans{scope},
+	}}})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.opts.endpoint+"/v1/traces", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range t.opts.headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("OTLP receiver returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,552 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/term"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	defaultTUIBufferSize = 2000
+	tuiRefreshInterval   = 200 * time.Millisecond
+	tuiHelp              = "/ search  t type  n namespace  r reason  c clear  ↑↓ PgUp PgDn g G move  enter details  q quit"
+	tuiDetailHelp        = "↑↓ PgUp PgDn scroll  esc back  q quit"
+	tuiResolveTimeout    = 10 * time.Second
+)
+
+// tuiKey is a key press decoded from the terminal input
+type tuiKey int
+
+const (
+	keyRune tuiKey = iota
+	keyUp
+	keyDown
+	keyPageUp
+	keyPageDown
+	keyEnter
+	keyEscape
+	keyBackspace
+	keyInterrupt
+)
+
+// tui shows the live event buffer in the terminal. The buffer can be
+// narrowed with an incremental full-text search and filters on type,
+// namespace and reason, which are cycled through the values seen in the
+// buffer. The detail view shows an event as YAML together with a summary
+// of its involved object.
+type tui struct {
+	in       *os.File
+	out      *os.File
+	quit     func()
+	resolver *objectResolver
+
+	mu     sync.Mutex
+	events []*corev1.Event
+	size   int
+	dirty  bool
+	// selected is the highlighted event, nil follows the newest event
+	selected  *corev1.Event
+	search    string
+	searching bool
+	eventType string
+	namespace string
+	reason    string
+	lastLog   string
+	// detail is the event shown in the detail view, nil shows the list
+	detail       *corev1.Event
+	detailLines  []string
+	detailScroll int
+}
+
+// newTUI returns a TUI keeping the last size events. quit is called when
+// the user quits. resolver may be nil to show events without their
+// involved objects.
+func newTUI(size int, resolver *objectResolver, quit func()) (*tui, error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
+		return nil, fmt.Errorf("the TUI needs a terminal")
+	}
+	if size < 1 {
+		size = defaultTUIBufferSize
+	}
+	return &tui{in: os.Stdin, out: os.Stdout, quit: quit, resolver: resolver, size: size, dirty: true}, nil
+}
+
+// Subscribe adds the published events to the buffer
+func (t *tui) Subscribe(bus *eventBus) {
+	bus.Subscribe("tui", subscriberOptions{lossy: true}, func(record eventRecord) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if len(t.events) == t.size {
+			copy(t.events, t.events[1:])
+			t.events = t.events[:len(t.events)-1]
+		}
+		t.events = append(t.events, record.event)
+		t.dirty = true
+	})
+}
+
+// Write shows the last log line in the status bar, so that log output does
+// not break the screen
+func (t *tui) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lastLog = strings.TrimSpace(string(p))
+	t.dirty = true
+	return len(p), nil
+}
+
+// Run draws the screen until ctx is done and restores the terminal
+func (t *tui) Run(ctx context.Context) error {
+	state, err := term.MakeRaw(int(t.in.Fd()))
+	if err != nil {
+		return err
+	}
+	logger := log.Logger
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: t, NoColor: true, TimeFormat: time.RFC3339})
+	klog.SetOutput(log.Logger)
+	// alternate screen, hide the cursor
+	fmt.Fprint(t.out, "\x1b[?1049h\x1b[?25l")
+	defer func() {
+		fmt.Fprint(t.out, "\x1b[?25h\x1b[?1049l")
+		_ = term.Restore(int(t.in.Fd()), state)
+		log.Logger = logger
+		klog.SetOutput(log.Logger)
+	}()
+
+	keys := make(chan tuiKeyPress)
+	go t.readKeys(keys)
+
+	ticker := time.NewTicker(tuiRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case key, ok := <-keys:
+			if !ok {
+				// stdin closed
+				keys = nil
+				t.quit()
+				continue
+			}
+			t.handleKey(key)
+		case <-ticker.C:
+		}
+		t.draw()
+	}
+}
+
+type tuiKeyPress struct {
+	key tuiKey
+	r   rune
+}
+
+// readKeys decodes the terminal input until it is closed. The goroutine
+// ends with the process, a blocking read cannot be cancelled.
+func (t *tui) readKeys(keys chan<- tuiKeyPress) {
+	defer close(keys)
+	buf := make([]byte, 64)
+	for {
+		n, err := t.in.Read(buf)
+		if err != nil {
+			return
+		}
+		for _, press := range decodeKeys(buf[:n]) {
+			keys <- press
+		}
+	}
+}
+
+// decodeKeys decodes the keys of a single read. Escape sequences are
+// expected to arrive in one read.
+func decodeKeys(input []byte) []tuiKeyPress {
+	var keys []tuiKeyPress
+	for len(input) > 0 {
+		switch {
+		case input[0] == 0x1b && len(input) >= 3 && input[1] == '[':
+			seq := string(input[2:3])
+			n := 3
+			if len(input) >= 4 && input[3] == '~' {
+				seq = string(input[2:4])
+				n = 4
+			}
+			switch seq {
+			case "A":
+				keys = append(keys, tuiKeyPress{key: keyUp})
+			case "B":
+				keys = append(keys, tuiKeyPress{key: keyDown})
+			case "5~":
+				keys = append(keys, tuiKeyPress{key: keyPageUp})
+			case "6~":
+				keys = append(keys, tuiKeyPress{key: keyPageDown})
+			}
+			input = input[n:]
+			continue
+		case input[0] == 0x1b:
+			keys = append(keys, tuiKeyPress{key: keyEscape})
+		case input[0] == '\r' || input[0] == '\n':
+			keys = append(keys, tuiKeyPress{key: keyEnter})
+		case input[0] == 0x7f || input[0] == 0x08:
+			keys = append(keys, tuiKeyPress{key: keyBackspace})
+		case input[0] == 0x03:
+			keys = append(keys, tuiKeyPress{key: keyInterrupt})
+		default:
+			r := []rune(string(input))[0]
+			keys = append(keys, tuiKeyPress{key: keyRune, r: r})
+			input = input[len(string(r)):]
+			continue
+		}
+		input = input[1:]
+	}
+	return keys
+}
+
+func (t *tui) handleKey(press tuiKeyPress) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.dirty = true
+	if press.key == keyInterrupt {
+		t.quit()
+		return
+	}
+	if t.detail != nil {
+		t.handleDetailKey(press)
+		return
+	}
+	if t.searching {
+		switch press.key {
+		case keyEnter:
+			t.searching = false
+		case keyEscape:
+			t.searching = false
+			t.search = ""
+		case keyBackspace:
+			if r := []rune(t.search); len(r) > 0 {
+				t.search = string(r[:len(r)-1])
+			}
+		case keyRune:
+			t.search += string(press.r)
+		}
+		return
+	}
+
+	visible := t.visible()
+	switch press.key {
+	case keyUp:
+		t.move(visible, -1)
+	case keyDown:
+		t.move(visible, 1)
+	case keyPageUp:
+		t.move(visible, -t.pageSize())
+	case keyPageDown:
+		t.move(visible, t.pageSize())
+	case keyEscape:
+		t.selected = nil
+	case keyEnter:
+		if t.selected != nil {
+			t.showDetail(t.selected)
+		} else if len(visible) > 0 {
+			t.showDetail(visible[len(visible)-1])
+		}
+	case keyRune:
+		switch press.r {
+		case 'q':
+			t.quit()
+		case '/':
+			t.searching = true
+		case 'k':
+			t.move(visible, -1)
+		case 'j':
+			t.move(visible, 1)
+		case 'g':
+			if len(visible) > 0 {
+				t.selected = visible[0]
+			}
+		case 'G':
+			t.selected = nil
+		case 't':
+			t.eventType = nextValue([]string{"", corev1.EventTypeWarning, corev1.EventTypeNormal}, t.eventType)
+		case 'n':
+			t.namespace = nextValue(t.values(func(e *corev1.Event) string { return e.Namespace }), t.namespace)
+		case 'r':
+			t.reason = nextValue(t.values(func(e *corev1.Event) string { return e.Reason }), t.reason)
+		case 'c':
+			t.search, t.eventType, t.namespace, t.reason = "", "", "", ""
+		}
+	}
+}
+
+func (t *tui) handleDetailKey(press tuiKeyPress) {
+	switch press.key {
+	case keyEscape, keyEnter, keyBackspace:
+		t.detail = nil
+	case keyUp:
+		t.scrollDetail(-1)
+	case keyDown:
+		t.scrollDetail(1)
+	case keyPageUp:
+		t.scrollDetail(-t.pageSize())
+	case keyPageDown:
+		t.scrollDetail(t.pageSize())
+	case keyRune:
+		switch press.r {
+		case 'q':
+			t.quit()
+		case 'k':
+			t.scrollDetail(-1)
+		case 'j':
+			t.scrollDetail(1)
+		}
+	}
+}
+
+func (t *tui) scrollDetail(delta int) {
+	t.detailScroll += delta
+	if last := len(t.detailLines) - t.pageSize(); t.detailScroll > last {
+		t.detailScroll = last
+	}
+	if t.detailScroll < 0 {
+		t.detailScroll = 0
+	}
+}
+
+// showDetail opens the detail view of event and resolves its involved
+// object in the background
+func (t *tui) showDetail(event *corev1.Event) {
+	t.detail = event
+	t.detailScroll = 0
+	t.detailLines = t.detailText(event, "")
+	if t.resolver == nil {
+		return
+	}
+	t.detailLines = t.detailText(event, "Resolving involved object...")
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), tuiResolveTimeout)
+		defer cancel()
+		var summary string
+		obj, err := t.resolver.Get(ctx, event.InvolvedObject)
+		if err != nil {
+			summary = "Could not resolve involved object: " + err.Error()
+		} else {
+			summary = objectSummary(obj)
+		}
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.detail == event {
+			t.detailLines = t.detailText(event, summary)
+			t.dirty = true
+		}
+	}()
+}
+
+// detailText returns the lines of the detail view: the involved object
+// summary followed by the event as YAML
+func (t *tui) detailText(event *corev1.Event, summary string) []string {
+	var lines []string
+	if summary != "" {
+		lines = append(lines, "# involved object")
+		lines = append(lines, strings.Split(strings.TrimSpace(summary), "\n")...)
+		lines = append(lines, "")
+	}
+	copied := event.DeepCopy()
+	copied.APIVersion = "v1"
+	copied.Kind = "Event"
+	copied.ManagedFields = nil
+	data, err := yaml.Marshal(copied)
+	if err != nil {
+		return append(lines, "Could not format event: "+err.Error())
+	}
+	lines = append(lines, "# event")
+	return append(lines, strings.Split(strings.TrimSpace(string(data)), "\n")...)
+}
+
+// move moves the selection by delta rows, moving past the newest event
+// follows new events again
+func (t *tui) move(visible []*corev1.Event, delta int) {
+	if len(visible) == 0 {
+		return
+	}
+	i := len(visible) - 1
+	for j, event := range visible {
+		if event == t.selected {
+			i = j
+		}
+	}
+	i += delta
+	switch {
+	case i < 0:
+		i = 0
+	case i >= len(visible)-1:
+		t.selected = nil
+		return
+	}
+	t.selected = visible[i]
+}
+
+// values returns the sorted distinct values of field in the buffer,
+// starting with the empty value which disables the filter
+func (t *tui) values(field func(*corev1.Event) string) []string {
+	seen := map[string]bool{}
+	for _, event := range t.events {
+		seen[field(event)] = true
+	}
+	values := make([]string, 0, len(seen)+1)
+	for value := range seen {
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+	sort.Strings(values)
+	return append([]string{""}, values...)
+}
+
+func nextValue(values []string, current string) string {
+	for i, value := range values {
+		if value == current {
+			return values[(i+1)%len(values)]
+		}
+	}
+	return values[0]
+}
+
+// visible returns the buffered events passing the filters and the search
+func (t *tui) visible() []*corev1.Event {
+	search := strings.ToLower(t.search)
+	var visible []*corev1.Event
+	for _, event := range t.events {
+		if t.eventType != "" && event.Type != t.eventType ||
+			t.namespace != "" && event.Namespace != t.namespace ||
+			t.reason != "" && event.Reason != t.reason {
+			continue
+		}
+		if search != "" && !strings.Contains(strings.ToLower(tuiSearchText(event)), search) {
+			continue
+		}
+		visible = append(visible, event)
+	}
+	return visible
+}
+
+// tuiSearchText is the text the full-text search matches against
+func tuiSearchText(event *corev1.Event) string {
+	obj := event.InvolvedObject
+	return strings.Join([]string{event.Type, event.Namespace, obj.Kind, obj.Name, event.Reason, event.Message, event.Source.Component}, " ")
+}
+
+func (t *tui) pageSize() int {
+	_, height, err := term.GetSize(int(t.out.Fd()))
+	if err != nil || height < 4 {
+		return 1
+	}
+	return height - 3
+}
+
+func (t *tui) draw() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.dirty {
+		return
+	}
+	t.dirty = false
+	width, height, err := term.GetSize(int(t.out.Fd()))
+	if err != nil {
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J")
+	if t.detail != nil {
+		t.drawDetail(&b, width, height)
+		_, _ = io.WriteString(t.out, b.String())
+		return
+	}
+	visible := t.visible()
+	header := fmt.Sprintf("k8s-event-tailer  %d/%d events", len(visible), len(t.events))
+	for _, filter := range []struct{ name, value string }{
+		{"type", t.eventType}, {"namespace", t.namespace}, {"reason", t.reason}, {"search", t.search},
+	} {
+		if filter.value != "" {
+			header += fmt.Sprintf("  %s=%s", filter.name, filter.value)
+		}
+	}
+	b.WriteString("\x1b[7m" + padRight(header, width) + "\x1b[0m\r\n")
+
+	rows := height - 3
+	if rows < 1 {
+		rows = 1
+	}
+	// keep the selected event on the screen, the newest event when following
+	last := len(visible) - 1
+	for i, event := range visible {
+		if event == t.selected {
+			last = i
+		}
+	}
+	first := last - rows + 1
+	if first < 0 {
+		first = 0
+	}
+	for i := first; i < first+rows; i++ {
+		if i >= len(visible) {
+			b.WriteString("\r\n")
+			continue
+		}
+		line := padRight(tuiRow(visible[i]), width)
+		switch {
+		case visible[i] == t.selected:
+			line = "\x1b[7m" + line + "\x1b[0m"
+		case visible[i].Type == corev1.EventTypeWarning:
+			line = "\x1b[33m" + line + "\x1b[0m"
+		}
+		b.WriteString(line + "\r\n")
+	}
+
+	if t.searching {
+		b.WriteString(padRight("/"+t.search, width) + "\r\n")
+	} else {
+		b.WriteString(padRight(tuiHelp, width) + "\r\n")
+	}
+	b.WriteString(padRight(t.lastLog, width))
+	_, _ = io.WriteString(t.out, b.String())
+}
+
+func (t *tui) drawDetail(b *strings.Builder, width, height int) {
+	b.WriteString("\x1b[7m" + padRight(eventTitle(t.detail), width) + "\x1b[0m\r\n")
+	rows := height - 3
+	for i := t.detailScroll; i < t.detailScroll+rows; i++ {
+		if i < len(t.detailLines) {
+			b.WriteString(padRight(t.detailLines[i], width))
+		}
+		b.WriteString("\r\n")
+	}
+	b.WriteString(padRight(tuiDetailHelp, width) + "\r\n")
+	b.WriteString(padRight(t.lastLog, width))
+}
+
+// tuiRow formats an event as a single line
+func tuiRow(event *corev1.Event) string {
+	return fmt.Sprintf("%s  %-7s  %-20s  %-40s  %-20s  %s",
+		eventTime(event).Local().Format("15:04:05"), event.Type, event.Namespace, eventObject(event), event.Reason,
+		strings.ReplaceAll(event.Message, "\n", " "))
+}
+
+// padRight cuts or pads s to width runes
+func padRight(s string, width int) string {
+	r := []rune(s)
+	if len(r) > width {
+		return string(r[:width])
+	}
+	return s + strings.Repeat(" ", width-len(r))
+}
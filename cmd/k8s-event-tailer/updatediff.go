@@ -0,0 +1,126 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const defaultUpdateDiffCacheSize = 10000
+
+// eventDiff holds the fields of an event which changed since it was last
+// seen
+type eventDiff struct {
+	countDelta     int32
+	messageChanged bool
+}
+
+// eventState is the part of an event compared for diffs
+type eventState struct {
+	count   int32
+	message string
+}
+
+// updateDiffer remembers the last seen state of events, so that repeated
+// events can be logged with their changes only. The informer store is
+// purged after every event, so the old object of an update is not
+// available. The least recently added events are forgotten once size
+// events are remembered.
+type updateDiffer struct {
+	mu     sync.Mutex
+	size   int
+	states map[types.UID]eventState
+	// order is a ring of the remembered UIDs in insertion order
+	order []types.UID
+	next  int
+}
+
+func newUpdateDiffer(size int) *updateDiffer {
+	if size < 1 {
+		size = defaultUpdateDiffCacheSize
+	}
+	return &updateDiffer{
+		size:   size,
+		states: make(map[types.UID]eventState, size),
+		order:  make([]types.UID, size),
+	}
+}
+
+// Diff returns the changes of event since it was last seen, nil if it was
+// not seen before
+func (d *updateDiffer) Diff(event *corev1.Event) *eventDiff {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	state := eventState{count: event.Count, message: event.Message}
+	old, seen := d.states[event.UID]
+	if !seen {
+		if evicted := d.order[d.next]; evicted != "" {
+			delete(d.states, evicted)
+		}
+		d.order[d.next] = event.UID
+		d.next = (d.next + 1) % d.size
+	}
+	d.states[event.UID] = state
+	if !seen {
+		return nil
+	}
+	return &eventDiff{
+		countDelta:     state.count - old.count,
+		messageChanged: state.message != old.message,
+	}
+}
+
+// Forget removes a deleted event. Its slot in the ring is reused once it
+// comes around.
+func (d *updateDiffer) Forget(event *corev1.Event) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.states, event.UID)
+}
+
+// encodeDiff appends the console line of a record carrying a diff: the
+// count with its change, the message only if it changed and the last
+// timestamp.
+func (e consoleEncoder) encodeDiff(b []byte, record eventRecord, now time.Time) []byte {
+	event := record.event
+
+	b = e.appendColorStart(b, colorDarkGray)
+	b = now.AppendFormat(b, time.RFC3339)
+	b = e.appendColorEnd(b)
+	b = append(b, ' ')
+	b = e.appendColorStart(b, colorGreen)
+	b = append(b, "INF"...)
+	b = e.appendColorEnd(b)
+	b = append(b, ' ')
+	b = append(b, record.message...)
+
+	b = e.appendKey(b, "component")
+	b = append(b, "watcher"...)
+	b = e.appendKey(b, "count")
+	b = strconv.AppendInt(b, int64(event.Count), 10)
+	b = e.appendKey(b, "countDelta")
+	if record.diff.countDelta >= 0 {
+		b = append(b, '+')
+	}
+	b = strconv.AppendInt(b, int64(record.diff.countDelta), 10)
+	if record.diff.messageChanged {
+		b = e.appendKey(b, "eventMsg")
+		b = appendValue(b, event.Message)
+	}
+	b = e.appendKey(b, "lastTimestamp")
+	b = event.LastTimestamp.UTC().AppendFormat(b, time.RFC3339)
+	b = e.appendKey(b, "name")
+	b = appendValue(b, event.Name)
+	b = e.appendKey(b, "namespace")
+	b = appendValue(b, event.Namespace)
+	return append(b, '\n')
+}
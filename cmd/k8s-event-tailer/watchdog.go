@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// runWatchdog restarts informers which have not seen any list or watch
+// activity, not even bookmarks, for stallTimeout. The reflector reconnects
+// broken watches on its own, but a watch can also hang silently.
+func (ew *EventWatcher) runWatchdog(ctx context.Context) {
+	if ew.stallTimeout <= 0 {
+		return
+	}
+	ticker := time.NewTicker(ew.stallTimeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		ew.shardsMu.Lock()
+		var stalled []*informerShard
+		for _, shard := range ew.shards {
+			if shard.idle() > ew.stallTimeout {
+				stalled = append(stalled, shard)
+			}
+		}
+		ew.shardsMu.Unlock()
+
+		for _, shard := range stalled {
+			if ctx.Err() != nil {
+				return
+			}
+			shard.logger.Warn().
+				Str("idle", shard.idle().Round(time.Second).String()).
+				Msg("Watch stalled, restarting informer")
+			ew.restartsCounter.WithLabelValues(shard.name).Inc()
+			ew.restartShard(ctx, shard)
+		}
+	}
+}
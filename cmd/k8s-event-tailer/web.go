@@ -2,65 +2,194 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"crypto/tls"
+	"net"
 	"net/http"
 	_ "net/http/pprof"
-	"sync"
+	"strconv"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
+// webServerOptions sets the addresses the web server listens on
+type webServerOptions struct {
+	bindAddress string
+	// port serves the dashboard, the APIs and the streams, 0 disables it
+	port int
+	// metricsPort serves the metrics and the health checks on
+	// metricsBindAddress instead of port, 0 serves them on port
+	metricsBindAddress string
+	metricsPort        int
+}
+
 type WebServer struct {
-	server           *http.Server
+	// servers are the main server and the metrics server, each if enabled
+	servers          []*http.Server
 	logger           zerolog.Logger
 	storeListHandler http.Handler
+	health           healthChecks
+	readiness        healthChecks
+	// certificate serves HTTPS if set
+	certificate *certificateReloader
+	auth        *webAuth
+	audit       *auditLogger
+	// closing ends the event streams, which would otherwise keep the
+	// server from shutting down
+	closing chan struct{}
 }
 
-func NewWebServer(port int) *WebServer {
+func NewWebServer(opts webServerOptions) *WebServer {
 	ws := &WebServer{
-		server: &http.Server{
-			Addr: fmt.Sprintf(":%d", port),
-		},
-		logger: log.With().Str("component", "web").Logger(),
+		logger:  log.With().Str("component", "web").Logger(),
+		closing: make(chan struct{}),
+	}
+	metrics := promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.Gatherers{prometheus.DefaultGatherer, eventMetrics}, promhttp.HandlerOpts{EnableOpenMetrics: true}))
+	if opts.port > 0 {
+		// the main server uses the default mux, which serves pprof as well
+		ws.servers = append(ws.servers, &http.Server{
+			Addr:    net.JoinHostPort(opts.bindAddress, strconv.Itoa(opts.port)),
+			Handler: http.DefaultServeMux,
+		})
+		http.HandleFunc("/healthz", ws.healthHandler)
+		http.HandleFunc("/readyz", ws.readyHandler)
+		http.HandleFunc("/api/v1/drops", dropsHandler)
+		if opts.metricsPort == 0 {
+			http.Handle("/metrics", metrics)
+		}
+	}
+	if opts.metricsPort > 0 {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics)
+		mux.HandleFunc("/healthz", ws.healthHandler)
+		mux.HandleFunc("/readyz", ws.readyHandler)
+		ws.servers = append(ws.servers, &http.Server{
+			Addr:    net.JoinHostPort(opts.metricsBindAddress, strconv.Itoa(opts.metricsPort)),
+			Handler: mux,
+		})
 	}
-	http.HandleFunc("/healthz", ws.healthHandler)
-	http.Handle("/metrics", promhttp.Handler())
 	return ws
 }
 
-func (ws *WebServer) Run(stopchan chan struct{}, wg *sync.WaitGroup) {
-	ws.logger.Info().Msgf("Starting web server listening to %s", ws.server.Addr)
-	ctx, cancel := context.WithCancel(context.Background())
-	go ws.stop(ctx, wg)
-	go func() {
-		if err := ws.server.ListenAndServe(); err != http.ErrServerClosed {
-			ws.logger.Err(err).Msg("Error stopping webserver")
+// Run serves HTTP until ctx is cancelled and the servers have shut down.
+func (ws *WebServer) Run(ctx context.Context) {
+	if len(ws.servers) == 0 {
+		ws.logger.Info().Msg("Web server disabled")
+		<-ctx.Done()
+		return
+	}
+	for _, server := range ws.servers {
+		// requests are audited before authentication, so that rejected
+		// ones are audited as well
+		if ws.auth != nil {
+			server.Handler = ws.auth.Middleware(server.Handler)
+		}
+		if ws.audit != nil {
+			server.Handler = ws.audit.Middleware(server.Handler)
+		}
+		if server.TLSConfig == nil {
+			server.TLSConfig = &tls.Config{}
 		}
-	}()
-	<-stopchan
-	cancel()
+		ws.logger.Info().Bool("tls", ws.certificate != nil).Msgf("Starting web server listening to %s", server.Addr)
+		go func(server *http.Server) {
+			var err error
+			if ws.certificate != nil {
+				server.TLSConfig.GetCertificate = ws.certificate.GetCertificate
+				err = server.ListenAndServeTLS("", "")
+			} else {
+				err = server.ListenAndServe()
+			}
+			if err != http.ErrServerClosed {
+				ws.logger.Err(err).Str("address", server.Addr).Msg("Error stopping webserver")
+			}
+		}(server)
+	}
+	<-ctx.Done()
+	ws.stop()
+}
+
+// SetTLSPolicy configures the TLS versions and cipher suites the server accepts.
+func (ws *WebServer) SetTLSPolicy(policy *tlsPolicy) {
+	for _, server := range ws.servers {
+		server.TLSConfig = policy.Config()
+	}
+}
+
+// SetCertificate serves HTTPS with the certificate of c.
+func (ws *WebServer) SetCertificate(c *certificateReloader) {
+	ws.certificate = c
+}
+
+// SetAuditLogger audits the requests to sensitive endpoints with a.
+func (ws *WebServer) SetAuditLogger(a *auditLogger) {
+	ws.audit = a
+}
+
+// SetAuth protects endpoints with the credentials of a.
+func (ws *WebServer) SetAuth(a *webAuth) {
+	ws.auth = a
 }
 
+// AddHealthCheck adds a component to the /healthz report.
+func (ws *WebServer) AddHealthCheck(name string, check healthCheck) {
+	ws.health.add(name, check)
+}
+
+// RemoveHealthCheck removes a component from the /healthz report.
+func (ws *WebServer) RemoveHealthCheck(name string) {
+	ws.health.remove(name)
+}
+
+// AddReadinessCheck adds a component to the /readyz report, which is not
+// ready while the component is BAD.
+func (ws *WebServer) AddReadinessCheck(name string, check healthCheck) {
+	ws.readiness.add(name, check)
+}
+
+// SetEventHistory serves the dashboard with charts of history.
+func (ws *WebServer) SetEventHistory(history *eventHistory) {
+	http.HandleFunc("/", dashboardHandler)
+	http.HandleFunc("/api/v1/history", history.handler)
+}
+
+// SetEventStream streams the events of buffer on /events/sse and the events
+// published on bus on the /stream WebSocket, serves the buffered events on
+// /api/v1/events and the live view of the dashboard.
+func (ws *WebServer) SetEventStream(bus *eventBus, buffer *eventBuffer) {
+	http.HandleFunc("/events/sse", sseHandler(buffer, ws.closing))
+	http.HandleFunc("/api/v1/events", buffer.handler)
+	http.Handle("/stream", websocketHandler(bus, ws.closing))
+	http.HandleFunc("/live", dashboardHandler)
+}
+
+// SetEventArchive serves the archived events on /api/v1/archive.
+func (ws *WebServer) SetEventArchive(archive *eventArchive) {
+	http.HandleFunc("/api/v1/archive", archive.handler)
+}
+
+// SetStatsHandler serves the per-namespace stats on /api/v1/stats.
+func (ws *WebServer) SetStatsHandler(handler http.HandlerFunc) {
+	http.Handle("/api/v1/stats", handler)
+}
+
+// SetStoreListHandler serves the events held by the informers on /store.
 func (ws *WebServer) SetStoreListHandler(handler http.HandlerFunc) {
 	ws.storeListHandler = handler
 	http.Handle("/store", ws.storeListHandler)
 }
 
-func (ws *WebServer) stop(ctx context.Context, wg *sync.WaitGroup) {
-	defer wg.Done()
-	<-ctx.Done()
+func (ws *WebServer) stop() {
 	stopCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	if err := ws.server.Shutdown(stopCtx); err != nil && err != http.ErrServerClosed {
-		ws.logger.Err(err).Send()
+	close(ws.closing)
+	for _, server := range ws.servers {
+		if err := server.Shutdown(stopCtx); err != nil && err != http.ErrServerClosed {
+			ws.logger.Err(err).Send()
+		}
 	}
 	ws.logger.Info().Msg("Shut down web server")
 }
-
-func (ws *WebServer) healthHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Add("Content-Type", "application/json; charset=UTF-8")
-	_, _ = w.Write([]byte(`{"status": "GOOD"}` + "\n"))
-}
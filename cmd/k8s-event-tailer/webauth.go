@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Authentication methods of web endpoints
+const (
+	webAuthBasic  = "basic"
+	webAuthBearer = "bearer"
+	webAuthNone   = "none"
+)
+
+// defaultWebAuthPaths are the endpoints protected once credentials are
+// configured: the ones exposing cluster data, metrics or admin functionality.
+// Health checks and the dashboard pages stay open.
+var defaultWebAuthPaths = []string{"/metrics", "/store", "/api/", "/events/", "/stream", "/debug/"}
+
+// webAuth protects web endpoints with basic auth or bearer tokens. Every
+// path prefix has the methods it accepts, the longest matching prefix
+// applies.
+type webAuth struct {
+	// users maps user names to the SHA-256 of their passwords, hashing
+	// lets the comparison take constant time regardless of the length
+	users  map[string][32]byte
+	tokens [][32]byte
+	// configured are the methods with credentials
+	configured []string
+	// paths are the protected path prefixes, the longest first
+	paths   []string
+	methods map[string][]string
+}
+
+// newWebAuth reads the credentials of the files and parses the per
+// endpoint rules of the form PREFIX=METHOD|METHOD, with methods basic,
+// bearer and none. Without rules, defaultWebAuthPaths accept every method
+// with credentials. It returns nil if neither file is set.
+func newWebAuth(basicAuthFile, bearerTokenFile string, rules []string) (*webAuth, error) {
+	if basicAuthFile == "" && bearerTokenFile == "" {
+		if len(rules) > 0 {
+			return nil, fmt.Errorf("web auth rules need a basic auth or bearer token file")
+		}
+		return nil, nil
+	}
+	a := &webAuth{users: map[string][32]byte{}, methods: map[string][]string{}}
+	if basicAuthFile != "" {
+		lines, err := readCredentialLines(basicAuthFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range lines {
+			user, password, ok := strings.Cut(line, ":")
+			if !ok || user == "" || password == "" {
+				return nil, fmt.Errorf("%s: invalid line, expected USER:PASSWORD", basicAuthFile)
+			}
+			a.users[user] = sha256.Sum256([]byte(password))
+		}
+		a.configured = append(a.configured, webAuthBasic)
+	}
+	if bearerTokenFile != "" {
+		lines, err := readCredentialLines(bearerTokenFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, token := range lines {
+			a.tokens = append(a.tokens, sha256.Sum256([]byte(token)))
+		}
+		a.configured = append(a.configured, webAuthBearer)
+	}
+
+	if len(rules) == 0 {
+		for _, path := range defaultWebAuthPaths {
+			a.methods[path] = a.configured
+		}
+	}
+	for _, rule := range rules {
+		path, value, ok := strings.Cut(rule, "=")
+		if !ok || !strings.HasPrefix(path, "/") || value == "" {
+			return nil, fmt.Errorf("invalid web auth rule %q, expected PATH=METHOD|METHOD", rule)
+		}
+		var methods []string
+		for _, method := range strings.Split(value, "|") {
+			switch method {
+			case webAuthBasic, webAuthBearer:
+				if !containsString(a.configured, method) {
+					return nil, fmt.Errorf("web auth rule %q: no credentials for %s", rule, method)
+				}
+				methods = append(methods, method)
+			case webAuthNone:
+			default:
+				return nil, fmt.Errorf("web auth rule %q: unknown method %q, expected basic, bearer or none", rule, method)
+			}
+		}
+		a.methods[path] = methods
+	}
+	for path := range a.methods {
+		a.paths = append(a.paths, path)
+	}
+	sort.Slice(a.paths, func(i, j int) bool {
+		return len(a.paths[i]) > len(a.paths[j])
+	})
+	return a, nil
+}
+
+// readCredentialLines returns the lines of a credentials file, skipping
+// empty lines and comments
+func readCredentialLines(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("%s: no credentials", path)
+	}
+	return lines, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// everywhere returns a copy of a protecting all paths with every method with
+// credentials, for the gRPC server
+func (a *webAuth) everywhere() *webAuth {
+	return &webAuth{
+		users:      a.users,
+		tokens:     a.tokens,
+		configured: a.configured,
+		paths:      []string{"/"},
+		methods:    map[string][]string{"/": a.configured},
+	}
+}
+
+// required returns the methods accepted for path, none if it is open
+func (a *webAuth) required(path string) []string {
+	for _, prefix := range a.paths {
+		if strings.HasPrefix(path, prefix) {
+			return a.methods[prefix]
+		}
+	}
+	return nil
+}
+
+// authenticated reports whether r carries valid credentials of one of
+// methods
+func (a *webAuth) authenticated(r *http.Request, methods []string) bool {
+	for _, method := range methods {
+		switch method {
+		case webAuthBasic:
+			user, password, ok := r.BasicAuth()
+			if !ok {
+				continue
+			}
+			want, known := a.users[user]
+			got := sha256.Sum256([]byte(password))
+			if subtle.ConstantTimeCompare(got[:], want[:]) == 1 && known {
+				return true
+			}
+		case webAuthBearer:
+			header := r.Header.Get("Authorization")
+			if !strings.HasPrefix(header, "Bearer ") {
+				continue
+			}
+			got := sha256.Sum256([]byte(strings.TrimPrefix(header, "Bearer ")))
+			for _, want := range a.tokens {
+				if subtle.ConstantTimeCompare(got[:], want[:]) == 1 {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Middleware wraps next, rejecting requests to protected paths without
+// valid credentials
+func (a *webAuth) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods := a.required(r.URL.Path)
+		if len(methods) == 0 || a.authenticated(r, methods) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		for _, method := range methods {
+			switch method {
+			case webAuthBasic:
+				w.Header().Add("WWW-Authenticate", `Basic realm="k8s-event-tailer"`)
+			case webAuthBearer:
+				w.Header().Add("WWW-Authenticate", `Bearer realm="k8s-event-tailer"`)
+			}
+		}
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// certificateReloader serves the certificate of a key pair and reloads it
+// once the files change, so that rotated certificates, e.g. by
+// cert-manager, are picked up without a restart
+type certificateReloader struct {
+	certFile, keyFile string
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	modified time.Time
+}
+
+func newCertificateReloader(certFile, keyFile string) (*certificateReloader, error) {
+	r := &certificateReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := r.GetCertificate(nil); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	modified, err := r.lastModified()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil || !modified.After(r.modified) {
+		// keep serving the loaded certificate while the files are replaced
+		if r.cert != nil {
+			return r.cert, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		if r.cert != nil {
+			return r.cert, nil
+		}
+		return nil, err
+	}
+	r.cert, r.modified = &cert, modified
+	return r.cert, nil
+}
+
+// lastModified returns the later modification time of the files
+func (r *certificateReloader) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
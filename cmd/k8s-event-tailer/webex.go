@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const webexMessagesURL = "https://webexapis.com/v1/messages"
+
+// webexOptions configures the Webex sink. Either webhookURL or token and
+// room are set.
+type webexOptions struct {
+	// webhookURL is the URL of an incoming webhook
+	webhookURL string
+	// token is the access token of a bot posting to room
+	token string
+	room  string
+	tls   *tlsPolicy
+}
+
+// webexSink posts markdown summaries of events to a Webex space, either via
+// an incoming webhook or as a bot.
+type webexSink struct {
+	opts   webexOptions
+	client *http.Client
+	url    string
+	header http.Header
+}
+
+func newWebexSink(opts webexOptions) (*webexSink, error) {
+	s := &webexSink{
+		opts:   opts,
+		client: opts.tls.HTTPClient(30 * time.Second),
+		url:    opts.webhookURL,
+		header: http.Header{},
+	}
+	if opts.webhookURL == "" {
+		if opts.token == "" || opts.room == "" {
+			return nil, fmt.Errorf("webex needs either a webhook URL or a bot token and room ID")
+		}
+		s.url = webexMessagesURL
+		s.header.Set("Authorization", "Bearer "+opts.token)
+	}
+	return s, nil
+}
+
+func (s *webexSink) Name() string {
+	return "webex"
+}
+
+func (s *webexSink) Write(event *corev1.Event) error {
+	message := map[string]string{"markdown": eventMarkdown(event)}
+	if s.opts.webhookURL == "" {
+		message["roomId"] = s.opts.room
+	}
+	return doJSON(context.Background(), s.client, http.MethodPost, s.url, s.header, message, nil)
+}
+
+func (s *webexSink) Close() error {
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"text/template"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"k8s-event-tailer/pkg/extension"
+)
+
+const (
+	webhookSinkName         = "webhook"
+	defaultWebhookQueueSize = 1000
+)
+
+// webhookOptions configures the webhook sink
+type webhookOptions struct {
+	url    string
+	header map[string]string
+	// template renders the request body from the JSON event, empty sends
+	// the JSON event itself
+	template string
+	// signer signs the request bodies, nil sends them unsigned
+	signer *payloadSigner
+	tls    *tlsPolicy
+}
+
+// webhookSink posts every event to an HTTP endpoint, by default as JSON
+// event. A template can shape the payload for receivers expecting another
+// format.
+type webhookSink struct {
+	url      string
+	header   http.Header
+	template *template.Template
+	signer   *payloadSigner
+	client   *http.Client
+}
+
+func newWebhookSink(opts webhookOptions) (*webhookSink, error) {
+	if opts.url == "" {
+		return nil, fmt.Errorf("webhook URL missing")
+	}
+	s := &webhookSink{
+		url:    opts.url,
+		header: http.Header{},
+		signer: opts.signer,
+		client: opts.tls.HTTPClient(30 * time.Second),
+	}
+	for key, value := range opts.header {
+		s.header.Set(key, value)
+	}
+	if opts.template != "" {
+		tmpl, err := template.New("payload").Option("missingkey=error").Funcs(template.FuncMap{
+			"json": templateJSON,
+		}).Parse(opts.template)
+		if err != nil {
+			return nil, fmt.Errorf("invalid webhook template: %w", err)
+		}
+		s.template = tmpl
+	}
+	return s, nil
+}
+
+// templateJSON encodes v as JSON, so templates can embed values like the
+// message in JSON payloads safely
+func templateJSON(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	return string(data), err
+}
+
+func (s *webhookSink) Name() string {
+	return webhookSinkName
+}
+
+func (s *webhookSink) Write(event *corev1.Event) error {
+	body, err := s.payload(event)
+	if err != nil {
+		return extension.Permanent(err)
+	}
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return extension.Permanent(err)
+	}
+	for key, values := range s.header {
+		req.Header[key] = values
+	}
+	if req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if s.signer != nil {
+		s.signer.Sign(req, body)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp)
+}
+
+func (s *webhookSink) payload(event *corev1.Event) ([]byte, error) {
+	data := newJSONEvent(event)
+	if s.template == nil {
+		return json.Marshal(data)
+	}
+	var body bytes.Buffer
+	if err := s.template.Execute(&body, data); err != nil {
+		return nil, fmt.Errorf("could not render webhook template: %w", err)
+	}
+	return body.Bytes(), nil
+}
+
+func (s *webhookSink) Close() error {
+	return nil
+}
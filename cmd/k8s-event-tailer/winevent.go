@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const defaultWindowsEventLogSource = "k8s-event-tailer"
+
+// Event IDs of the Windows Event Log records by severity
+const (
+	windowsEventIDInfo    = 100
+	windowsEventIDWarning = 200
+	windowsEventIDError   = 300
+)
+
+// windowsEventLogSeverity returns the event ID for event: errors for
+// warnings with one of errorReasons, warnings for other warnings and
+// information otherwise
+func windowsEventLogSeverity(event *corev1.Event, errorReasons map[string]bool) uint32 {
+	switch {
+	case event.Type != corev1.EventTypeWarning:
+		return windowsEventIDInfo
+	case errorReasons[event.Reason]:
+		return windowsEventIDError
+	default:
+		return windowsEventIDWarning
+	}
+}
+
+// windowsEventLogMessage formats event as a record with one field per line,
+// followed by the message
+func windowsEventLogMessage(event *corev1.Event) string {
+	obj := event.InvolvedObject
+	var b strings.Builder
+	for _, field := range []struct{ name, value string }{
+		{"Type", event.Type},
+		{"Reason", event.Reason},
+		{"Namespace", event.Namespace},
+		{"Object", obj.Kind + "/" + obj.Name},
+		{"Source", event.Source.Component},
+		{"Count", fmt.Sprint(event.Count)},
+		{"LastSeen", eventTime(event).UTC().Format("2006-01-02T15:04:05Z")},
+	} {
+		if field.value != "" {
+			fmt.Fprintf(&b, "%s: %s\r\n", field.name, field.value)
+		}
+	}
+	b.WriteString("\r\n" + event.Message)
+	return b.String()
+}
@@ -0,0 +1,29 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// windowsEventLogSink is only available on Windows
+type windowsEventLogSink struct{}
+
+func newWindowsEventLogSink(source string, errorReasons []string) (*windowsEventLogSink, error) {
+	return nil, fmt.Errorf("the Windows Event Log is only available on Windows")
+}
+
+func (s *windowsEventLogSink) Name() string {
+	return "windows-eventlog"
+}
+
+func (s *windowsEventLogSink) Write(event *corev1.Event) error {
+	return nil
+}
+
+func (s *windowsEventLogSink) Close() error {
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sys/windows/svc/eventlog"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// windowsEventLogSink writes events to the Windows Event Log. The source is
+// registered with the EventCreate message file, which supports event IDs up
+// to 1000.
+type windowsEventLogSink struct {
+	log          *eventlog.Log
+	errorReasons map[string]bool
+}
+
+func newWindowsEventLogSink(source string, errorReasons []string) (*windowsEventLogSink, error) {
+	// registering needs administrative rights, an already registered
+	// source can be used without them
+	if err := eventlog.InstallAsEventCreate(source, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		log.Debug().Err(err).Str("source", source).Msg("Could not register event log source")
+	}
+	l, err := eventlog.Open(source)
+	if err != nil {
+		return nil, err
+	}
+	reasons := map[string]bool{}
+	for _, reason := range errorReasons {
+		reasons[reason] = true
+	}
+	return &windowsEventLogSink{log: l, errorReasons: reasons}, nil
+}
+
+func (s *windowsEventLogSink) Name() string {
+	return "windows-eventlog"
+}
+
+func (s *windowsEventLogSink) Write(event *corev1.Event) error {
+	msg := windowsEventLogMessage(event)
+	switch windowsEventLogSeverity(event, s.errorReasons) {
+	case windowsEventIDError:
+		return s.log.Error(windowsEventIDError, msg)
+	case windowsEventIDWarning:
+		return s.log.Warning(windowsEventIDWarning, msg)
+	default:
+		return s.log.Info(windowsEventIDInfo, msg)
+	}
+}
+
+func (s *windowsEventLogSink) Close() error {
+	return s.log.Close()
+}
@@ -0,0 +1,79 @@
+// Package extension defines the interfaces implemented by out-of-tree
+// extensions of the event tailer.
+//
+// Extensions are built as Go plugins (go build -buildmode=plugin) and placed in
+// the plugins directory. A plugin exports a NewSink or NewFilter function (or
+// both) with the signatures of SinkFactory and FilterFactory.
+package extension
+
+import (
+	"errors"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Sink receives every event which was accepted by all filters.
+type Sink interface {
+	// Name identifies the sink in logs and metrics
+	Name() string
+	// Write delivers a single event. Failed writes are retried unless the
+	// error is marked with Permanent.
+	Write(event *corev1.Event) error
+	// Close flushes pending writes and releases resources on shutdown
+	Close() error
+}
+
+// BatchSink is implemented by sinks which can write several events at once,
+// e.g. with a single HTTP request. Events for such sinks are collected and
+// written in batches instead of one by one.
+type BatchSink interface {
+	Sink
+	// WriteBatch delivers the events in the order they were received. On
+	// error, the whole batch is retried.
+	WriteBatch(events []*corev1.Event) error
+}
+
+// Flusher is implemented by sinks which buffer writes, e.g. in a file. Flush
+// is called periodically, also while events are written, and before Close.
+type Flusher interface {
+	// Flush writes out buffered events
+	Flush() error
+}
+
+// Filter decides whether an event is delivered to the sinks.
+type Filter interface {
+	// Name identifies the filter in logs and metrics
+	Name() string
+	// Allow returns false for events which should be skipped
+	Allow(event *corev1.Event) bool
+}
+
+// SinkFactory is the type of the NewSink symbol exported by sink plugins.
+type SinkFactory = func() (Sink, error)
+
+// FilterFactory is the type of the NewFilter symbol exported by filter plugins.
+type FilterFactory = func() (Filter, error)
+
+// permanentError marks an error which will not go away by retrying.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so that the tailer does not retry the failed operation,
+// e.g. for authentication failures or rejected payloads. Sinks should use it
+// for every error which indicates a misconfiguration.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err or any error it wraps was marked with Permanent.
+func IsPermanent(err error) bool {
+	var pe *permanentError
+	return errors.As(err, &pe)
+}